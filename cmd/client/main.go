@@ -3,11 +3,13 @@ package main
 import (
 	"DB_II/pkg/db"
 	"bufio"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -17,8 +19,17 @@ type Client struct {
 	password string
 }
 
+// maxRedirects bounds how many times a command follows a cluster node
+// to the leader it names.
+const maxRedirects = 3
+
+// NewClient connects to the server at DB_SERVER_ADDR, or localhost:8080.
 func NewClient() (*Client, error) {
-	conn, err := net.Dial("tcp", "localhost:8080")
+	address := os.Getenv("DB_SERVER_ADDR")
+	if address == "" {
+		address = "localhost:8080"
+	}
+	conn, err := net.Dial("tcp", address)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to server: %v", err)
 	}
@@ -82,8 +93,8 @@ func (c *Client) register() error {
 		return err
 	}
 
-	if status, ok := response["status"].(string); ok && status == "error" {
-		return fmt.Errorf("%v", response["error"])
+	if err := responseError(response); err != nil {
+		return err
 	}
 
 	fmt.Printf("User '%s' registered successfully with role %s\n", c.username, role)
@@ -102,10 +113,9 @@ func (c *Client) login() error {
 	c.password = strings.TrimSpace(password)
 
 	cmd := db.Command{
-		Operation: "create_pool",
+		Operation: "login",
 		Username:  c.username,
 		Password:  c.password,
-		Pool:      "",
 	}
 
 	response, err := c.sendCommand(cmd)
@@ -113,33 +123,51 @@ func (c *Client) login() error {
 		return err
 	}
 
-	if status, ok := response["status"].(string); ok && status == "error" {
-		if strings.Contains(response["error"].(string), "authentication failed") {
-			return fmt.Errorf("invalid credentials")
-		}
+	if code, ok := response["code"].(string); ok && db.ErrorCode(code) == db.CodeAuthFailed {
+		return fmt.Errorf("invalid credentials")
 	}
 
-	return nil
+	return responseError(response)
 }
 
 func (c *Client) sendCommand(cmd db.Command) (map[string]interface{}, error) {
-	encoder := json.NewEncoder(c.conn)
-	decoder := json.NewDecoder(c.conn)
-
 	if cmd.Operation != "register" {
 		cmd.Password = c.password
 	}
 
-	if err := encoder.Encode(cmd); err != nil {
-		return nil, fmt.Errorf("failed to send command: %v", err)
-	}
+	for redirects := 0; ; redirects++ {
+		encoder := json.NewEncoder(c.conn)
+		decoder := json.NewDecoder(c.conn)
+
+		if err := encoder.Encode(cmd); err != nil {
+			return nil, fmt.Errorf("failed to send command: %v", err)
+		}
+
+		var response map[string]interface{}
+		if err := decoder.Decode(&response); err != nil {
+			return nil, fmt.Errorf("failed to read response: %v", err)
+		}
 
-	var response map[string]interface{}
-	if err := decoder.Decode(&response); err != nil {
-		return nil, fmt.Errorf("failed to read response: %v", err)
+		// a cluster node that is not the leader names the one that is
+		leader, _ := response["leader"].(string)
+		if code, _ := response["code"].(string); db.ErrorCode(code) != db.CodeNotLeader || leader == "" || redirects == maxRedirects {
+			return response, nil
+		}
+		conn, err := net.Dial("tcp", leader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to leader %s: %v", leader, err)
+		}
+		c.conn.Close()
+		c.conn = conn
+		fmt.Printf("Redirected to leader %s\n", leader)
 	}
+}
 
-	return response, nil
+func responseError(response map[string]interface{}) error {
+	if status, ok := response["status"].(string); ok && status == "error" {
+		return fmt.Errorf("%v: %v", response["code"], response["error"])
+	}
+	return nil
 }
 
 func (c *Client) createPool() error {
@@ -160,8 +188,8 @@ func (c *Client) createPool() error {
 		return err
 	}
 
-	if status, ok := response["status"].(string); ok && status == "error" {
-		return fmt.Errorf("%v", response["error"])
+	if err := responseError(response); err != nil {
+		return err
 	}
 
 	fmt.Printf("Pool '%s' created successfully\n", poolName)
@@ -191,14 +219,48 @@ func (c *Client) createSchema() error {
 		return err
 	}
 
-	if status, ok := response["status"].(string); ok && status == "error" {
-		return fmt.Errorf("%v", response["error"])
+	if err := responseError(response); err != nil {
+		return err
 	}
 
 	fmt.Printf("Schema '%s' created successfully in pool '%s'\n", schemaName, poolName)
 	return nil
 }
 
+// promptOptionalInt asks for a number of at least min. A blank answer
+// returns 0 so the server picks its default.
+func promptOptionalInt(reader *bufio.Reader, prompt string, min int) int {
+	for {
+		fmt.Print(prompt)
+		answer, _ := reader.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		if answer == "" {
+			return 0
+		}
+
+		n, err := strconv.Atoi(answer)
+		if err != nil || n < min {
+			fmt.Printf("Please enter a number of at least %d.\n", min)
+			continue
+		}
+		return n
+	}
+}
+
+// promptList asks for a comma-separated list and drops blank items.
+func promptList(reader *bufio.Reader, prompt string) []string {
+	fmt.Print(prompt)
+	answer, _ := reader.ReadString('\n')
+
+	var items []string
+	for _, item := range strings.Split(answer, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func (c *Client) createCollection() error {
 	reader := bufio.NewReader(os.Stdin)
 
@@ -218,10 +280,15 @@ func (c *Client) createCollection() error {
 	fmt.Println("1. AVL Tree")
 	fmt.Println("2. Red-Black Tree")
 	fmt.Println("3. B-Tree")
+	fmt.Println("4. B+ Tree")
+	fmt.Println("5. Skip List")
+	fmt.Println("6. Hash")
+	fmt.Println("7. LSM Tree (disk)")
+	fmt.Println("8. Paged B-Tree (disk)")
 
 	var treeType db.TreeType
 	for {
-		fmt.Print("Enter choice (1-3): ")
+		fmt.Print("Enter choice (1-8): ")
 		choice, _ := reader.ReadString('\n')
 		choice = strings.TrimSpace(choice)
 
@@ -232,6 +299,16 @@ func (c *Client) createCollection() error {
 			treeType = db.TreeTypeRedBlack
 		case "3":
 			treeType = db.TreeTypeBTree
+		case "4":
+			treeType = db.TreeTypeBPlusTree
+		case "5":
+			treeType = db.TreeTypeSkipList
+		case "6":
+			treeType = db.TreeTypeHash
+		case "7":
+			treeType = db.TreeTypeLSM
+		case "8":
+			treeType = db.TreeTypePagedBTree
 		default:
 			fmt.Println("Invalid choice. Please try again.")
 			continue
@@ -239,6 +316,54 @@ func (c *Client) createCollection() error {
 		break
 	}
 
+	var options db.CollectionOptions
+	switch treeType {
+	case db.TreeTypeBTree, db.TreeTypeBPlusTree:
+		options.BTreeMinDegree = promptOptionalInt(reader,
+			fmt.Sprintf("Enter minimum degree (blank for %d): ", db.DefaultBTreeMinDegree), 2)
+	case db.TreeTypeSkipList:
+		options.SkipListMaxLevel = promptOptionalInt(reader,
+			fmt.Sprintf("Enter max level (blank for %d): ", db.DefaultSkipListMaxLevel), 1)
+	case db.TreeTypeHash:
+		fmt.Print("Allow range queries by full scan? (y/N): ")
+		answer, _ := reader.ReadString('\n')
+		options.HashRangeScan = strings.EqualFold(strings.TrimSpace(answer), "y")
+	case db.TreeTypePagedBTree:
+		options.PageSize = promptOptionalInt(reader,
+			fmt.Sprintf("Enter page size in bytes (blank for %d): ", db.DefaultPageSize), db.MinPageSize)
+		options.BufferPoolPages = promptOptionalInt(reader,
+			fmt.Sprintf("Enter buffer pool pages (blank for %d): ", db.DefaultBufferPoolPages), db.MinBufferPoolPages)
+	}
+
+	switch treeType {
+	case db.TreeTypeAVL, db.TreeTypeRedBlack, db.TreeTypeBTree:
+		fmt.Print("Enter key order (blank for bytewise, numeric, case-insensitive, natural, locale, reverse): ")
+		comparator, _ := reader.ReadString('\n')
+		options.Comparator = db.Comparator(strings.TrimSpace(comparator))
+	}
+
+	fmt.Print("Enter value type (blank for any, int, float, bool, timestamp, bytes, json): ")
+	valueType, _ := reader.ReadString('\n')
+	options.ValueType = db.ValueType(strings.TrimSpace(valueType))
+	if options.ValueType == db.ValueTypeJSON {
+		options.RequiredFields = promptList(reader, "Enter required fields such as $.address.city, comma-separated (blank for none): ")
+		options.Indexes = promptList(reader, "Enter fields to index, comma-separated (blank for none): ")
+	}
+
+	fmt.Print("Keep version history? (y/N): ")
+	answer, _ := reader.ReadString('\n')
+	if strings.EqualFold(strings.TrimSpace(answer), "y") {
+		options.HistoryVersions = promptOptionalInt(reader, "Enter versions of each key to keep (blank for no limit): ", 1)
+		options.HistoryRetentionMillis = 1000 * promptOptionalInt(reader, "Enter seconds to keep replaced versions (blank for no limit): ", 1)
+		if options.HistoryVersions == 0 && options.HistoryRetentionMillis == 0 {
+			options.HistoryVersions = db.MaxHistoryVersions
+		}
+	} else {
+		fmt.Print("Number versions for updates by version? (y/N): ")
+		answer, _ := reader.ReadString('\n')
+		options.Versioned = strings.EqualFold(strings.TrimSpace(answer), "y")
+	}
+
 	cmd := db.Command{
 		Operation:  "create_collection",
 		Username:   c.username,
@@ -246,6 +371,19 @@ func (c *Client) createCollection() error {
 		Schema:     schemaName,
 		Collection: collectionName,
 		TreeType:   treeType,
+		Options:    options,
+	}
+
+	servers := promptList(reader, "Enter servers to shard across, comma-separated (blank to keep on this server): ")
+	if len(servers) > 0 {
+		spec := &db.ShardSpec{Scheme: db.ShardByHash, Servers: servers}
+		fmt.Print("Partition by key range instead of hash? (y/N): ")
+		answer, _ := reader.ReadString('\n')
+		if strings.EqualFold(strings.TrimSpace(answer), "y") {
+			spec.Scheme = db.ShardByRange
+			spec.SplitKeys = promptList(reader, fmt.Sprintf("Enter %d split keys in ascending order, comma-separated: ", len(servers)-1))
+		}
+		cmd.Sharding = spec
 	}
 
 	response, err := c.sendCommand(cmd)
@@ -253,14 +391,182 @@ func (c *Client) createCollection() error {
 		return err
 	}
 
-	if status, ok := response["status"].(string); ok && status == "error" {
-		return fmt.Errorf("%v", response["error"])
+	if err := responseError(response); err != nil {
+		return err
 	}
 
 	fmt.Printf("Collection '%s' created successfully with %s tree type\n", collectionName, treeType)
 	return nil
 }
 
+// promptDump asks what an export or import covers, in which format, and
+// which local file holds it.
+func promptDump(reader *bufio.Reader) (cmd db.Command, path string) {
+	fmt.Print("Enter pool name: ")
+	poolName, _ := reader.ReadString('\n')
+	cmd.Pool = strings.TrimSpace(poolName)
+
+	fmt.Print("Enter schema name (blank for the whole pool): ")
+	schemaName, _ := reader.ReadString('\n')
+	cmd.Schema = strings.TrimSpace(schemaName)
+
+	if cmd.Schema != "" {
+		fmt.Print("Enter collection name (blank for the whole schema): ")
+		collectionName, _ := reader.ReadString('\n')
+		cmd.Collection = strings.TrimSpace(collectionName)
+	}
+
+	fmt.Print("Enter format (csv, jsonl, native): ")
+	format, _ := reader.ReadString('\n')
+	cmd.Format = db.DumpFormat(strings.TrimSpace(format))
+
+	fmt.Print("Enter local file path: ")
+	path, _ = reader.ReadString('\n')
+	return cmd, strings.TrimSpace(path)
+}
+
+func (c *Client) exportData() error {
+	cmd, path := promptDump(bufio.NewReader(os.Stdin))
+	cmd.Operation = "export"
+	cmd.Username = c.username
+
+	response, err := c.sendCommand(cmd)
+	if err != nil {
+		return err
+	}
+	if err := responseError(response); err != nil {
+		return err
+	}
+
+	result, _ := response["response"].(map[string]interface{})
+	encoded, _ := result["data"].(string)
+	data := []byte(encoded)
+	if cmd.Format == db.DumpFormatNative {
+		if data, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			return fmt.Errorf("server sent an invalid dump: %v", err)
+		}
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return err
+	}
+
+	fmt.Printf("Exported %v entries to %s\n", result["count"], path)
+	return nil
+}
+
+func (c *Client) importData() error {
+	reader := bufio.NewReader(os.Stdin)
+	cmd, path := promptDump(reader)
+	cmd.Operation = "import"
+	cmd.Username = c.username
+
+	fmt.Print("Bulk load empty collections? (y/N): ")
+	answer, _ := reader.ReadString('\n')
+	cmd.Bulk = strings.EqualFold(strings.TrimSpace(answer), "y")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	cmd.Data = string(data)
+	if cmd.Format == db.DumpFormatNative {
+		cmd.Data = base64.StdEncoding.EncodeToString(data)
+	}
+
+	response, err := c.sendCommand(cmd)
+	if err != nil {
+		return err
+	}
+	if err := responseError(response); err != nil {
+		return err
+	}
+
+	result, _ := response["response"].(map[string]interface{})
+	fmt.Printf("Imported %v entries from %s\n", result["count"], path)
+	return nil
+}
+
+func (c *Client) backup() error {
+	reader := bufio.NewReader(os.Stdin)
+	cmd := db.Command{Operation: "backup", Username: c.username}
+
+	fmt.Print("Include users? (y/N): ")
+	answer, _ := reader.ReadString('\n')
+	cmd.IncludeUsers = strings.EqualFold(strings.TrimSpace(answer), "y")
+
+	fmt.Print("Enter local file path: ")
+	path, _ := reader.ReadString('\n')
+	path = strings.TrimSpace(path)
+
+	response, err := c.sendCommand(cmd)
+	if err != nil {
+		return err
+	}
+	if err := responseError(response); err != nil {
+		return err
+	}
+
+	result, _ := response["response"].(map[string]interface{})
+	encoded, _ := result["data"].(string)
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("server sent an invalid backup: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return err
+	}
+
+	fmt.Printf("Backed up to %s\n", path)
+	return nil
+}
+
+func (c *Client) restore() error {
+	reader := bufio.NewReader(os.Stdin)
+	cmd := db.Command{Operation: "restore", Username: c.username}
+
+	fmt.Print("Enter local file path: ")
+	path, _ := reader.ReadString('\n')
+	data, err := os.ReadFile(strings.TrimSpace(path))
+	if err != nil {
+		return err
+	}
+	cmd.Data = base64.StdEncoding.EncodeToString(data)
+
+	fmt.Print("Enter pool to restore (blank for all): ")
+	poolName, _ := reader.ReadString('\n')
+	cmd.Pool = strings.TrimSpace(poolName)
+	if cmd.Pool != "" {
+		fmt.Print("Enter schema to restore (blank for the whole pool): ")
+		schemaName, _ := reader.ReadString('\n')
+		cmd.Schema = strings.TrimSpace(schemaName)
+
+		fmt.Print("Restore the pool as (blank to keep its name): ")
+		targetPool, _ := reader.ReadString('\n')
+		cmd.TargetPool = strings.TrimSpace(targetPool)
+	}
+	if cmd.Schema != "" {
+		fmt.Print("Restore the schema as (blank to keep its name): ")
+		targetSchema, _ := reader.ReadString('\n')
+		cmd.TargetSchema = strings.TrimSpace(targetSchema)
+	}
+
+	fmt.Print("Restore users? (y/N): ")
+	answer, _ := reader.ReadString('\n')
+	cmd.IncludeUsers = strings.EqualFold(strings.TrimSpace(answer), "y")
+
+	response, err := c.sendCommand(cmd)
+	if err != nil {
+		return err
+	}
+	if err := responseError(response); err != nil {
+		return err
+	}
+
+	result, _ := response["response"].(map[string]interface{})
+	fmt.Printf("Restored %v collections holding %v entries, and %v users\n", result["collections"], result["entries"], result["users"])
+	return nil
+}
+
 func main() {
 	client, err := NewClient()
 	if err != nil {
@@ -306,9 +612,13 @@ func main() {
 		fmt.Println("1. Create Pool")
 		fmt.Println("2. Create Schema")
 		fmt.Println("3. Create Collection")
-		fmt.Println("4. Exit")
+		fmt.Println("4. Export")
+		fmt.Println("5. Import")
+		fmt.Println("6. Backup")
+		fmt.Println("7. Restore")
+		fmt.Println("8. Exit")
 
-		fmt.Print("\nEnter command (1-4): ")
+		fmt.Print("\nEnter command (1-8): ")
 		choice, _ := reader.ReadString('\n')
 		choice = strings.TrimSpace(choice)
 
@@ -322,6 +632,14 @@ func main() {
 		case "3":
 			err = client.createCollection()
 		case "4":
+			err = client.exportData()
+		case "5":
+			err = client.importData()
+		case "6":
+			err = client.backup()
+		case "7":
+			err = client.restore()
+		case "8":
 			fmt.Println("Goodbye!")
 			return
 		default:
@@ -2,10 +2,19 @@ package main
 
 import (
 	"DB_II/pkg/db"
+	"DB_II/pkg/interfaces"
+	"bufio"
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 var database *db.Database
@@ -18,21 +27,872 @@ func init() {
 
 	authManager := db.NewAuthManager(postgresDB)
 
-	database = db.NewDatabase(authManager)
+	config, err := db.ConfigFromEnv()
+	if err != nil {
+		log.Fatal("Invalid configuration:", err)
+	}
+
+	database = db.NewDatabase(authManager, config)
+
+	if err := database.LoadCollections(); err != nil {
+		log.Fatal("Failed to load collections:", err)
+	}
+}
+
+func writeResponse(encoder *json.Encoder, response interface{}, err error) {
+	if err != nil {
+		failure := map[string]interface{}{
+			"status": "error",
+			"code":   string(db.CodeOf(err)),
+			"error":  err.Error(),
+		}
+		// a failed conditional write also reports the key's current state,
+		// an aborted batch what happened to each item, and a cluster node
+		// that is not the leader where the leader is
+		var conditionErr *db.ConditionError
+		if errors.As(err, &conditionErr) {
+			failure["current"] = conditionErr
+		}
+		var batchErr *db.BatchError
+		if errors.As(err, &batchErr) {
+			failure["results"] = batchErr.Results
+		}
+		var leaderErr *db.LeaderError
+		if errors.As(err, &leaderErr) {
+			failure["leader"] = leaderErr.LeaderAddr
+		}
+		encoder.Encode(failure)
+		return
+	}
+
+	encoder.Encode(map[string]interface{}{
+		"status":   "ok",
+		"response": response,
+	})
+}
+
+// intArgument and floatArgument read a command's numeric arguments, which
+// are absent when nil.
+func intArgument(name string, n *json.Number) (*int64, error) {
+	if n == nil {
+		return nil, nil
+	}
+	v, err := n.Int64()
+	if err != nil {
+		return nil, db.Errorf(db.CodeInvalidArgument, "%s %q is not an integer", name, *n)
+	}
+	return &v, nil
+}
+
+func floatArgument(name string, n *json.Number) (*float64, error) {
+	if n == nil {
+		return nil, nil
+	}
+	v, err := n.Float64()
+	if err != nil {
+		return nil, db.Errorf(db.CodeInvalidArgument, "%s %q is not a number", name, *n)
+	}
+	return &v, nil
+}
+
+// limitedBuffer fails writes that would take it past limit bytes.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.limit {
+		return 0, db.Errorf(db.CodeInvalidArgument, "data is larger than %d bytes; use a file instead", b.limit)
+	}
+	return b.Buffer.Write(p)
+}
+
+// readOnlyOperations are those a follower serves.
+var readOnlyOperations = map[string]bool{
+	"login":              true,
+	"get":                true,
+	"ttl":                true,
+	"get_version":        true,
+	"get_history":        true,
+	"get_as_of":          true,
+	"get_with_version":   true,
+	"mget":               true,
+	"export":             true,
+	"backup":             true,
+	"get_field":          true,
+	"get_range":          true,
+	"scan_prefix":        true,
+	"scan_match":         true,
+	"count":              true,
+	"rank":               true,
+	"select":             true,
+	"min":                true,
+	"max":                true,
+	"open_cursor":        true,
+	"fetch":              true,
+	"close_cursor":       true,
+	"replication_status": true,
+	"cluster_status":     true,
+	"shard_status":       true,
+	"read_entries":       true,
+}
+
+var errNotClustered = db.Errorf(db.CodeUnsupported, "this server is not a cluster node")
+
+// readBarrier makes the reads after it linearizable on a cluster node,
+// which must be the leader.
+func readBarrier() error {
+	if node := database.Raft(); node != nil {
+		return node.ReadIndex()
+	}
+	return nil
+}
+
+// dispatch executes a command, through the router if it is for a sharded
+// collection, or through the cluster's log if the server is a cluster
+// node and the command changes the database.
+func dispatch(cmd db.Command) (interface{}, error) {
+	if router := database.Shards(); router.Routes(cmd) {
+		return router.Execute(cmd)
+	}
+
+	node := database.Raft()
+	if node == nil {
+		return execute(cmd)
+	}
+	if readOnlyOperations[cmd.Operation] {
+		if err := node.ReadIndex(); err != nil {
+			return nil, err
+		}
+		return execute(cmd)
+	}
+
+	// every node applies the command, so what it reads must come with it
+	if cmd.File != "" && (cmd.Operation == "import" || cmd.Operation == "restore") {
+		return nil, db.Errorf(db.CodeUnsupported, "a cluster node cannot %s a file; send the data with the command", cmd.Operation)
+	}
+	cmd.Password = ""
+	command, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return node.Propose(command)
+}
+
+// applyCommand applies a command the cluster committed. The node applies
+// it as of when it was proposed, so a TTL counts from then on every node
+// however late it applies the command.
+func applyCommand(command []byte) (interface{}, error) {
+	var cmd db.Command
+	if err := json.Unmarshal(command, &cmd); err != nil {
+		return nil, err
+	}
+	return execute(cmd)
+}
+
+// execute runs a command against the database. In a cluster, every
+// node executes each command that changes the database once the cluster
+// commits it.
+func execute(cmd db.Command) (interface{}, error) {
+	var response interface{}
+	var responseErr error
+
+	switch cmd.Operation {
+	case "create_pool":
+		responseErr = database.CreatePool(cmd.Username, cmd.Pool)
+
+	case "create_schema":
+		responseErr = database.CreateSchema(cmd.Username, cmd.Pool, cmd.Schema)
+
+	case "create_collection":
+		responseErr = database.CreateCollection(cmd.Username, cmd.Pool, cmd.Schema, cmd.Collection, cmd.TreeType, cmd.Options)
+
+	case "set":
+		collection, err := database.GetCollection(cmd.Username, db.PermWrite, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		if cmd.TTLMillis > 0 {
+			expiring, ok := collection.(interfaces.ExpiringCollection)
+			if !ok {
+				responseErr = unsupported(cmd)
+				break
+			}
+			responseErr = expiring.SetWithTTL(cmd.Key, cmd.SecondaryKey, cmd.Value, time.Duration(cmd.TTLMillis)*time.Millisecond)
+			break
+		}
+		responseErr = collection.Set(cmd.Key, cmd.SecondaryKey, cmd.Value)
+
+	case "update":
+		collection, err := database.GetCollection(cmd.Username, db.PermWrite, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		if cmd.TTLMillis > 0 {
+			expiring, ok := collection.(interfaces.ExpiringCollection)
+			if !ok {
+				responseErr = unsupported(cmd)
+				break
+			}
+			responseErr = expiring.SetWithTTL(cmd.Key, cmd.Key, cmd.Value, time.Duration(cmd.TTLMillis)*time.Millisecond)
+			break
+		}
+		responseErr = collection.Update(cmd.Key, cmd.Value)
+
+	case "get":
+		collection, err := database.GetCollection(cmd.Username, db.PermRead, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+
+		value, err := collection.Get(cmd.Key)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		response = map[string]string{
+			"value": value,
+		}
+
+	case "expire":
+		collection, err := database.GetCollection(cmd.Username, db.PermWrite, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		expiring, ok := collection.(interfaces.ExpiringCollection)
+		if !ok {
+			responseErr = unsupported(cmd)
+			break
+		}
+		responseErr = expiring.Expire(cmd.Key, time.Duration(cmd.TTLMillis)*time.Millisecond)
+
+	case "ttl":
+		collection, err := database.GetCollection(cmd.Username, db.PermRead, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		expiring, ok := collection.(interfaces.ExpiringCollection)
+		if !ok {
+			responseErr = unsupported(cmd)
+			break
+		}
+
+		ttl, expires, err := expiring.TTL(cmd.Key)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		// -1 means the key never expires
+		ttlMillis := int64(-1)
+		if expires {
+			ttlMillis = max(ttl.Milliseconds(), 0)
+		}
+		response = map[string]int64{
+			"ttl_millis": ttlMillis,
+		}
+
+	case "persist":
+		collection, err := database.GetCollection(cmd.Username, db.PermWrite, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		expiring, ok := collection.(interfaces.ExpiringCollection)
+		if !ok {
+			responseErr = unsupported(cmd)
+			break
+		}
+		responseErr = expiring.Persist(cmd.Key)
+
+	case "get_version":
+		collection, err := database.GetCollection(cmd.Username, db.PermRead, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		history, ok := collection.(interfaces.HistoryCollection)
+		if !ok {
+			responseErr = unsupported(cmd)
+			break
+		}
+		response, responseErr = history.GetVersion(cmd.Key, cmd.Version)
+
+	case "get_history":
+		collection, err := database.GetCollection(cmd.Username, db.PermRead, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		history, ok := collection.(interfaces.HistoryCollection)
+		if !ok {
+			responseErr = unsupported(cmd)
+			break
+		}
+
+		versions, err := history.GetHistory(cmd.Key)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		response = map[string]interface{}{
+			"versions": versions,
+		}
+
+	case "get_as_of":
+		collection, err := database.GetCollection(cmd.Username, db.PermRead, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		history, ok := collection.(interfaces.HistoryCollection)
+		if !ok {
+			responseErr = unsupported(cmd)
+			break
+		}
+
+		at, err := time.Parse(time.RFC3339Nano, cmd.AsOf)
+		if err != nil {
+			responseErr = db.Errorf(db.CodeInvalidArgument, "as of time %q is not an RFC 3339 timestamp", cmd.AsOf)
+			break
+		}
+		response, responseErr = history.GetAsOf(cmd.Key, at)
+
+	case "get_with_version":
+		collection, err := database.GetCollection(cmd.Username, db.PermRead, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		conditional, ok := collection.(interfaces.ConditionalCollection)
+		if !ok {
+			responseErr = unsupported(cmd)
+			break
+		}
+
+		value, version, err := conditional.GetVersioned(cmd.Key)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		response = map[string]interface{}{
+			"value":   value,
+			"version": version,
+		}
+
+	case "set_if_absent", "update_if_equals", "update_if_version":
+		collection, err := database.GetCollection(cmd.Username, db.PermWrite, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		conditional, ok := collection.(interfaces.ConditionalCollection)
+		if !ok {
+			responseErr = unsupported(cmd)
+			break
+		}
+
+		var version int64
+		switch cmd.Operation {
+		case "set_if_absent":
+			version, err = conditional.SetIfAbsent(cmd.Key, cmd.Value)
+		case "update_if_equals":
+			version, err = conditional.UpdateIfEquals(cmd.Key, cmd.Expected, cmd.Value)
+		default:
+			version, err = conditional.UpdateIfVersion(cmd.Key, cmd.Version, cmd.Value)
+		}
+		if err != nil {
+			responseErr = err
+			break
+		}
+		response = map[string]int64{
+			"version": version,
+		}
+
+	case "delete_if_equals":
+		collection, err := database.GetCollection(cmd.Username, db.PermWrite, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		conditional, ok := collection.(interfaces.ConditionalCollection)
+		if !ok {
+			responseErr = unsupported(cmd)
+			break
+		}
+		responseErr = conditional.DeleteIfEquals(cmd.Key, cmd.Expected)
+
+	case "incr", "decr", "incr_by":
+		collection, err := database.GetCollection(cmd.Username, db.PermWrite, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		counter, ok := collection.(interfaces.CounterCollection)
+		if !ok {
+			responseErr = unsupported(cmd)
+			break
+		}
+
+		var bounds interfaces.IntBounds
+		by, err := intArgument("delta", cmd.Delta)
+		if err == nil {
+			bounds.Min, err = intArgument("min", cmd.Min)
+		}
+		if err == nil {
+			bounds.Max, err = intArgument("max", cmd.Max)
+		}
+		if err != nil {
+			responseErr = err
+			break
+		}
+
+		delta := int64(1)
+		if cmd.Operation == "decr" {
+			delta = -1
+		}
+		if cmd.Operation == "incr_by" {
+			if by == nil {
+				responseErr = db.Errorf(db.CodeInvalidArgument, "incr_by needs a delta")
+				break
+			}
+			delta = *by
+		}
+
+		value, err := counter.IncrBy(cmd.Key, delta, bounds)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		response = map[string]int64{
+			"value": value,
+		}
+
+	case "incr_by_float":
+		collection, err := database.GetCollection(cmd.Username, db.PermWrite, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		counter, ok := collection.(interfaces.CounterCollection)
+		if !ok {
+			responseErr = unsupported(cmd)
+			break
+		}
+
+		var bounds interfaces.FloatBounds
+		delta, err := floatArgument("delta", cmd.Delta)
+		if err == nil && delta == nil {
+			err = db.Errorf(db.CodeInvalidArgument, "incr_by_float needs a delta")
+		}
+		if err == nil {
+			bounds.Min, err = floatArgument("min", cmd.Min)
+		}
+		if err == nil {
+			bounds.Max, err = floatArgument("max", cmd.Max)
+		}
+		if err != nil {
+			responseErr = err
+			break
+		}
+
+		value, err := counter.IncrByFloat(cmd.Key, *delta, bounds)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		response = map[string]float64{
+			"value": value,
+		}
+
+	case "mget":
+		collection, err := database.GetCollection(cmd.Username, db.PermRead, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		batch, ok := collection.(interfaces.BatchCollection)
+		if !ok {
+			responseErr = unsupported(cmd)
+			break
+		}
+
+		results, err := batch.MultiGet(cmd.Keys)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		response = map[string]interface{}{
+			"results": results,
+		}
+
+	case "mset", "mdelete":
+		collection, err := database.GetCollection(cmd.Username, db.PermWrite, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		batch, ok := collection.(interfaces.BatchCollection)
+		if !ok {
+			responseErr = unsupported(cmd)
+			break
+		}
+
+		var results []interfaces.BatchResult
+		if cmd.Operation == "mset" {
+			results, err = batch.MultiSet(cmd.Entries, cmd.Atomic)
+		} else {
+			results, err = batch.MultiDelete(cmd.Keys, cmd.Atomic)
+		}
+		if err != nil {
+			responseErr = err
+			break
+		}
+		response = map[string]interface{}{
+			"results": results,
+		}
+
+	case "export":
+		// exports go to a file in the dump directory, or come back in
+		// the response, base64-encoded if native
+		if cmd.File != "" {
+			count, err := database.ExportFile(cmd.Username, cmd.File, cmd.Format, cmd.Pool, cmd.Schema, cmd.Collection)
+			if err != nil {
+				responseErr = err
+				break
+			}
+			response = map[string]interface{}{
+				"count": count,
+				"file":  cmd.File,
+			}
+			break
+		}
+
+		data := &limitedBuffer{limit: database.Config.MaxCommandSize}
+		count, err := database.Export(cmd.Username, data, cmd.Format, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		encoded := data.String()
+		if cmd.Format == db.DumpFormatNative {
+			encoded = base64.StdEncoding.EncodeToString(data.Bytes())
+		}
+		response = map[string]interface{}{
+			"count": count,
+			"data":  encoded,
+		}
+
+	case "import":
+		var count int
+		if cmd.File != "" {
+			count, responseErr = database.ImportFile(cmd.Username, cmd.File, cmd.Format, cmd.Pool, cmd.Schema, cmd.Collection, cmd.Bulk)
+		} else {
+			data := []byte(cmd.Data)
+			if cmd.Format == db.DumpFormatNative {
+				var err error
+				if data, err = base64.StdEncoding.DecodeString(cmd.Data); err != nil {
+					responseErr = db.Errorf(db.CodeInvalidArgument, "native dump data is not base64")
+					break
+				}
+			}
+			count, responseErr = database.Import(cmd.Username, bytes.NewReader(data), cmd.Format, cmd.Pool, cmd.Schema, cmd.Collection, cmd.Bulk)
+		}
+		response = map[string]int{
+			"count": count,
+		}
+
+	case "backup":
+		// like exports, backups go to a file or come back base64-encoded
+		if cmd.File != "" {
+			manifest, err := database.BackupFile(cmd.Username, cmd.File, cmd.IncludeUsers)
+			if err != nil {
+				responseErr = err
+				break
+			}
+			response = map[string]interface{}{
+				"manifest": manifest,
+				"file":     cmd.File,
+			}
+			break
+		}
+
+		data := &limitedBuffer{limit: database.Config.MaxCommandSize}
+		manifest, err := database.Backup(cmd.Username, data, cmd.IncludeUsers)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		response = map[string]interface{}{
+			"manifest": manifest,
+			"data":     base64.StdEncoding.EncodeToString(data.Bytes()),
+		}
+
+	case "restore":
+		options := db.RestoreOptions{
+			Pool:         cmd.Pool,
+			Schema:       cmd.Schema,
+			TargetPool:   cmd.TargetPool,
+			TargetSchema: cmd.TargetSchema,
+			Users:        cmd.IncludeUsers,
+		}
+		if cmd.File != "" {
+			response, responseErr = database.RestoreFile(cmd.Username, cmd.File, options)
+			break
+		}
+
+		data, err := base64.StdEncoding.DecodeString(cmd.Data)
+		if err != nil {
+			responseErr = db.Errorf(db.CodeInvalidArgument, "backup data is not base64")
+			break
+		}
+		response, responseErr = database.Restore(cmd.Username, bytes.NewReader(data), int64(len(data)), options)
+
+	case "read_entries":
+		entries, more, err := database.ReadEntries(cmd.Username, cmd.Pool, cmd.Schema, cmd.Collection, cmd.Keys, cmd.LeftBound, cmd.Limit)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		response = map[string]interface{}{
+			"entries": entries,
+			"more":    more,
+		}
+
+	case "write_entries":
+		responseErr = database.WriteEntries(cmd.Username, cmd.Pool, cmd.Schema, cmd.Collection, cmd.Records)
+
+	case "get_field":
+		collection, err := database.GetCollection(cmd.Username, db.PermRead, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		documents, ok := collection.(interfaces.DocumentCollection)
+		if !ok {
+			responseErr = unsupported(cmd)
+			break
+		}
+
+		value, err := documents.GetField(cmd.Key, cmd.Path)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		response = map[string]string{
+			"value": value,
+		}
+
+	case "patch":
+		collection, err := database.GetCollection(cmd.Username, db.PermWrite, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		documents, ok := collection.(interfaces.DocumentCollection)
+		if !ok {
+			responseErr = unsupported(cmd)
+			break
+		}
+		responseErr = documents.Patch(cmd.Key, cmd.Value)
+
+	case "get_range":
+		collection, err := database.GetCollection(cmd.Username, db.PermRead, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+
+		// plain get_range keeps answering with a map; any paging option
+		// switches to an ordered page
+		if !cmd.LeftExclusive && !cmd.RightExclusive && !cmd.Reverse && cmd.Limit == 0 && cmd.Token == "" && cmd.Where == nil {
+			response, responseErr = collection.GetRange(cmd.LeftBound, cmd.RightBound)
+			break
+		}
+
+		page, err := collection.GetRangePage(interfaces.RangeQuery{
+			LeftBound:      cmd.LeftBound,
+			RightBound:     cmd.RightBound,
+			LeftExclusive:  cmd.LeftExclusive,
+			RightExclusive: cmd.RightExclusive,
+			Reverse:        cmd.Reverse,
+			Limit:          cmd.Limit,
+			Token:          cmd.Token,
+			Where:          cmd.Where,
+		})
+		if err != nil {
+			responseErr = err
+			break
+		}
+		response = map[string]interface{}{
+			"entries": page.Entries,
+			"token":   page.Token,
+		}
+
+	case "scan_prefix":
+		collection, err := database.GetCollection(cmd.Username, db.PermRead, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+
+		page, err := collection.ScanPrefix(cmd.Prefix, cmd.Limit, cmd.Token)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		response = map[string]interface{}{
+			"entries": page.Entries,
+			"token":   page.Token,
+		}
+
+	case "scan_match":
+		collection, err := database.GetCollection(cmd.Username, db.PermRead, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+
+		page, err := collection.ScanMatch(interfaces.MatchQuery{
+			Pattern: cmd.Pattern,
+			Regex:   cmd.Regex,
+			Limit:   cmd.Limit,
+			Timeout: time.Duration(cmd.TimeoutMillis) * time.Millisecond,
+			Token:   cmd.Token,
+		})
+		if err != nil {
+			responseErr = err
+			break
+		}
+		response = map[string]interface{}{
+			"entries": page.Entries,
+			"token":   page.Token,
+		}
+
+	case "count":
+		collection, err := database.GetCollection(cmd.Username, db.PermRead, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		statistics, ok := collection.(interfaces.OrderStatisticCollection)
+		if !ok {
+			responseErr = unsupported(cmd)
+			break
+		}
+
+		count, err := statistics.Count(cmd.LeftBound, cmd.RightBound)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		response = map[string]int{
+			"count": count,
+		}
+
+	case "rank":
+		collection, err := database.GetCollection(cmd.Username, db.PermRead, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		statistics, ok := collection.(interfaces.OrderStatisticCollection)
+		if !ok {
+			responseErr = unsupported(cmd)
+			break
+		}
+
+		rank, err := statistics.Rank(cmd.Key)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		response = map[string]int{
+			"rank": rank,
+		}
+
+	case "select":
+		collection, err := database.GetCollection(cmd.Username, db.PermRead, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		statistics, ok := collection.(interfaces.OrderStatisticCollection)
+		if !ok {
+			responseErr = unsupported(cmd)
+			break
+		}
+		response, responseErr = statistics.Select(cmd.Index)
+
+	case "min", "max":
+		collection, err := database.GetCollection(cmd.Username, db.PermRead, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		statistics, ok := collection.(interfaces.OrderStatisticCollection)
+		if !ok {
+			responseErr = unsupported(cmd)
+			break
+		}
+
+		if cmd.Operation == "min" {
+			response, responseErr = statistics.Min()
+		} else {
+			response, responseErr = statistics.Max()
+		}
+
+	case "delete":
+		collection, err := database.GetCollection(cmd.Username, db.PermWrite, cmd.Pool, cmd.Schema, cmd.Collection)
+		if err != nil {
+			responseErr = err
+			break
+		}
+		responseErr = collection.Delete(cmd.Key)
+
+	default:
+		responseErr = db.Errorf(db.CodeInvalidArgument, "unknown operation: %s", cmd.Operation)
+	}
+	return response, responseErr
+}
+
+// unsupported is the error for an operation the collection cmd names does
+// not offer.
+func unsupported(cmd db.Command) error {
+	return db.Errorf(db.CodeUnsupported, "collection %s does not support %s", cmd.Collection, cmd.Operation)
 }
 
 func handleConnection(conn net.Conn) {
 	defer conn.Close()
 
-	decoder := json.NewDecoder(conn)
+	// Commands are newline-delimited JSON; the scanner bounds how much of a
+	// single command is buffered so one client cannot exhaust memory.
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), database.Config.MaxCommandSize)
 	encoder := json.NewEncoder(conn)
+	cursors := database.NewCursorSet()
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
 
-	for {
 		var cmd db.Command
-		err := decoder.Decode(&cmd)
-		if err != nil {
-			log.Printf("Error decoding command: %v", err)
-			return
+		if err := json.Unmarshal(line, &cmd); err != nil {
+			writeResponse(encoder, nil, db.Errorf(db.CodeInvalidArgument, "malformed command: %v", err))
+			continue
+		}
+
+		// followers serve reads only; everything else goes to the leader
+		if leader := database.Following(); leader != "" && !readOnlyOperations[cmd.Operation] {
+			writeResponse(encoder, nil, db.Errorf(db.CodeReadOnly, "this server is a read-only follower of %s", leader))
+			continue
 		}
 
 		var response interface{}
@@ -41,10 +901,9 @@ func handleConnection(conn net.Conn) {
 		if cmd.Operation != "register" {
 			role, err := database.AuthManager.ValidateUser(cmd.Username, cmd.Password)
 			if err != nil {
-				responseErr = fmt.Errorf("authentication failed: %v", err)
-				encoder.Encode(map[string]string{
-					"status": "error",
-					"error":  responseErr.Error(),
+				writeResponse(encoder, nil, &db.Error{
+					Code:    db.CodeOf(err),
+					Message: fmt.Sprintf("authentication failed: %v", err),
 				})
 				continue
 			}
@@ -55,97 +914,117 @@ func handleConnection(conn net.Conn) {
 		case "register":
 			responseErr = database.AuthManager.RegisterUser(cmd.Username, cmd.Password, cmd.Role)
 
-		case "create_pool":
-			responseErr = database.CreatePool(cmd.Username, cmd.Pool)
-
-		case "create_schema":
-			responseErr = database.CreateSchema(cmd.Username, cmd.Pool, cmd.Schema)
-
-		case "create_collection":
-			responseErr = database.CreateCollection(cmd.Username, cmd.Pool, cmd.Schema, cmd.Collection, cmd.TreeType)
-
-		case "set":
-			if !database.AuthManager.HasPermission(cmd.Username, db.PermWrite) {
-				responseErr = db.ErrPermissionDenied
-				break
-			}
-
-			pool, exists := database.Pools[cmd.Pool]
-			if !exists {
-				responseErr = db.ErrPoolNotFound
-				break
+		case "login":
+			response = map[string]db.Role{
+				"role": cmd.Role,
 			}
 
-			schema, exists := pool.Schemas[cmd.Schema]
-			if !exists {
-				responseErr = db.ErrSchemaNotFound
+		case "replicate":
+			// the connection now streams the log to a follower until it
+			// goes away
+			stream, err := database.OpenReplicationStream(cmd.Username, conn.RemoteAddr().String(), cmd.LogID, cmd.Position)
+			if err != nil {
+				responseErr = err
 				break
 			}
-
-			collection, exists := schema.Collections[cmd.Collection]
-			if !exists {
-				responseErr = db.ErrCollectionNotFound
-				break
+			writeResponse(encoder, stream.Handshake(), nil)
+			if err := stream.Serve(scanner, conn); err != nil {
+				log.Printf("Replication to %s stopped: %v", conn.RemoteAddr(), err)
 			}
+			return
 
-			response = collection.Set(cmd.Key, cmd.SecondaryKey, cmd.Value)
+		case "replication_status":
+			response, responseErr = database.ReplicationStatus(cmd.Username)
 
-		case "get":
-			if !database.AuthManager.HasPermission(cmd.Username, db.PermRead) {
-				responseErr = db.ErrPermissionDenied
+		case "cluster_status", "cluster_add", "cluster_remove":
+			node := database.Raft()
+			if node == nil {
+				responseErr = errNotClustered
 				break
 			}
+			switch cmd.Operation {
+			case "cluster_status":
+				response, responseErr = node.Status(cmd.Username)
+			case "cluster_add":
+				responseErr = node.AddMember(cmd.Username, db.RaftMember{ID: cmd.NodeID, RaftAddr: cmd.RaftAddr, ClientAddr: cmd.ClientAddr})
+			default:
+				responseErr = node.RemoveMember(cmd.Username, cmd.NodeID)
+			}
 
-			pool, exists := database.Pools[cmd.Pool]
-			if !exists {
-				responseErr = db.ErrPoolNotFound
+		case "open_cursor":
+			if err := readBarrier(); err != nil {
+				responseErr = err
 				break
 			}
-
-			schema, exists := pool.Schemas[cmd.Schema]
-			if !exists {
-				responseErr = db.ErrSchemaNotFound
+			id, err := cursors.Open(cmd.Username, cmd.Pool, cmd.Schema, cmd.Collection, cmd.LeftBound, cmd.RightBound)
+			if err != nil {
+				responseErr = err
 				break
 			}
+			response = map[string]string{
+				"cursor": id,
+			}
 
-			collection, exists := schema.Collections[cmd.Collection]
-			if !exists {
-				responseErr = db.ErrCollectionNotFound
+		case "fetch":
+			entries, done, err := cursors.Fetch(cmd.Username, cmd.Cursor, cmd.Count)
+			if err != nil {
+				responseErr = err
 				break
 			}
-
-			value, status := collection.Get(cmd.Key)
-			response = map[string]string{
-				"value":  value,
-				"status": status,
+			response = map[string]interface{}{
+				"entries": entries,
+				"done":    done,
 			}
 
+		case "close_cursor":
+			responseErr = cursors.Close(cmd.Username, cmd.Cursor)
+
 		default:
-			responseErr = fmt.Errorf("unknown operation: %s", cmd.Operation)
+			response, responseErr = dispatch(cmd)
 		}
 
-		if responseErr != nil {
-			encoder.Encode(map[string]string{
-				"status": "error",
-				"error":  responseErr.Error(),
-			})
-		} else {
-			encoder.Encode(map[string]interface{}{
-				"status":   "ok",
-				"response": response,
-			})
+		writeResponse(encoder, response, responseErr)
+	}
+
+	if err := scanner.Err(); err != nil {
+		if err == bufio.ErrTooLong {
+			writeResponse(encoder, nil, db.Errorf(db.CodeInvalidArgument, "command exceeds %d bytes", database.Config.MaxCommandSize))
 		}
+		log.Printf("Error reading command: %v", err)
 	}
 }
 
 func main() {
-	listener, err := net.Listen("tcp", ":8080")
+	listener, err := net.Listen("tcp", database.Config.ListenAddr)
 	if err != nil {
 		log.Fatal("Failed to start server:", err)
 	}
 	defer listener.Close()
 
-	log.Println("Server started on :8080")
+	log.Printf("Server started on %s", database.Config.ListenAddr)
+
+	if leader := database.Config.LeaderAddr; leader != "" {
+		database.Follow(leader, database.Config.ReplicationUser, database.Config.ReplicationPassword)
+		log.Printf("Following %s", leader)
+	}
+	if id := database.Config.RaftID; id != "" {
+		if _, err := database.StartRaft(applyCommand); err != nil {
+			log.Fatal("Failed to start cluster node:", err)
+		}
+		log.Printf("Cluster node %s started on %s", id, database.Config.RaftAddr)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		log.Println("Shutting down")
+		listener.Close()
+		if err := database.Close(); err != nil {
+			log.Printf("Error closing collections: %v", err)
+		}
+		os.Exit(0)
+	}()
 
 	for {
 		conn, err := listener.Accept()
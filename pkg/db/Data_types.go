@@ -2,32 +2,43 @@ package db
 
 import (
 	"DB_II/pkg/interfaces"
-	"errors"
+	"encoding/json"
 	"sync"
 )
 
 var (
-	ErrPoolExists         = errors.New("pool already exists")
-	ErrPoolNotFound       = errors.New("pool not found")
-	ErrSchemaExists       = errors.New("schema already exists")
-	ErrSchemaNotFound     = errors.New("schema not found")
-	ErrCollectionExists   = errors.New("collection already exists")
-	ErrCollectionNotFound = errors.New("collection not found")
-	ErrPermissionDenied   = errors.New("permission denied")
+	ErrPoolExists         = NewError(CodeAlreadyExists, "pool already exists")
+	ErrPoolNotFound       = NewError(CodeNotFound, "pool not found")
+	ErrSchemaExists       = NewError(CodeAlreadyExists, "schema already exists")
+	ErrSchemaNotFound     = NewError(CodeNotFound, "schema not found")
+	ErrCollectionExists   = NewError(CodeAlreadyExists, "collection already exists")
+	ErrCollectionNotFound = NewError(CodeNotFound, "collection not found")
+	ErrPermissionDenied   = NewError(CodePermissionDenied, "permission denied")
 )
 
 type Database struct {
 	Pools       map[string]*DataPool
 	AuthManager *AuthManager
+	Config      Config
+	replication *ReplicationLog
+	follower    *Follower
+	raft        *RaftNode
+	shards      *ShardRouter
+	clock       *writeClock
 	mutex       *sync.RWMutex
 }
 
-func NewDatabase(authManager *AuthManager) *Database {
-	return &Database{
+func NewDatabase(authManager *AuthManager, config Config) *Database {
+	db := &Database{
 		Pools:       make(map[string]*DataPool),
 		AuthManager: authManager,
+		Config:      config,
+		replication: newReplicationLog(config.MaxReplicationLogBytes),
+		clock:       newWriteClock(),
 		mutex:       &sync.RWMutex{},
 	}
+	db.shards = newShardRouter(db)
+	return db
 }
 
 type DataPool struct {
@@ -59,10 +70,18 @@ func NewDataSchema(name string) *DataSchema {
 }
 
 func (db *Database) CreatePool(username string, poolName string) error {
-	if !db.AuthManager.HasPermission(username, PermCreatePool) {
+	return db.createPool(db.AuthManager.checker(username), poolName)
+}
+
+func (db *Database) createPool(allowed permissionCheck, poolName string) error {
+	if !allowed(PermCreatePool) {
 		return ErrPermissionDenied
 	}
 
+	if err := db.Config.validateName("pool", poolName); err != nil {
+		return err
+	}
+
 	db.mutex.Lock()
 	defer db.mutex.Unlock()
 
@@ -70,15 +89,30 @@ func (db *Database) CreatePool(username string, poolName string) error {
 		return ErrPoolExists
 	}
 
+	if err := checkCount("pools", "database", len(db.Pools), db.Config.MaxPools); err != nil {
+		return err
+	}
+
 	db.Pools[poolName] = NewDataPool(poolName)
+	// mutations are logged under the lock that orders them, so that
+	// followers see a pool created before anything in it
+	db.replication.append(Mutation{Op: MutationCreatePool, Pool: poolName})
 	return nil
 }
 
 func (db *Database) CreateSchema(username string, poolName, schemaName string) error {
-	if !db.AuthManager.HasPermission(username, PermCreateSchema) {
+	return db.createSchema(db.AuthManager.checker(username), poolName, schemaName)
+}
+
+func (db *Database) createSchema(allowed permissionCheck, poolName, schemaName string) error {
+	if !allowed(PermCreateSchema) {
 		return ErrPermissionDenied
 	}
 
+	if err := db.Config.validateName("schema", schemaName); err != nil {
+		return err
+	}
+
 	db.mutex.RLock()
 	pool, exists := db.Pools[poolName]
 	db.mutex.RUnlock()
@@ -94,15 +128,32 @@ func (db *Database) CreateSchema(username string, poolName, schemaName string) e
 		return ErrSchemaExists
 	}
 
+	if err := checkCount("schemas", "pool "+poolName, len(pool.Schemas), db.Config.MaxSchemasPerPool); err != nil {
+		return err
+	}
+
 	pool.Schemas[schemaName] = NewDataSchema(schemaName)
+	db.replication.append(Mutation{Op: MutationCreateSchema, Pool: poolName, Schema: schemaName})
 	return nil
 }
 
-func (db *Database) CreateCollection(username string, poolName, schemaName, collectionName string, treeType TreeType) error {
-	if !db.AuthManager.HasPermission(username, PermCreateCollection) {
+func (db *Database) CreateCollection(username string, poolName, schemaName, collectionName string, treeType TreeType, options CollectionOptions) error {
+	return db.createCollection(db.AuthManager.checker(username), poolName, schemaName, collectionName, treeType, options)
+}
+
+func (db *Database) createCollection(allowed permissionCheck, poolName, schemaName, collectionName string, treeType TreeType, options CollectionOptions) error {
+	if !allowed(PermCreateCollection) {
 		return ErrPermissionDenied
 	}
 
+	if err := db.Config.validateName("collection", collectionName); err != nil {
+		return err
+	}
+
+	if treeType == "" {
+		treeType = db.Config.DefaultTreeType
+	}
+
 	db.mutex.RLock()
 	pool, exists := db.Pools[poolName]
 	db.mutex.RUnlock()
@@ -126,20 +177,86 @@ func (db *Database) CreateCollection(username string, poolName, schemaName, coll
 		return ErrCollectionExists
 	}
 
-	schema.Collections[collectionName] = NewTreeCollection(treeType)
+	if err := checkCount("collections", "schema "+schemaName, len(schema.Collections), db.Config.MaxCollectionsPerSchema); err != nil {
+		return err
+	}
+
+	dir := db.collectionDir(poolName, schemaName, collectionName)
+	if treeType.persistent() && collectionMetaExists(dir) {
+		return ErrCollectionExists
+	}
+
+	collection, err := NewTreeCollection(treeType, options, db.Config, dir)
+	if err != nil {
+		return err
+	}
+
+	collection.join(db.replication.collection(poolName, schemaName, collectionName), db.clock)
+	schema.Collections[collectionName] = collection
+	db.replication.append(Mutation{
+		Op:         MutationCreateCollection,
+		Pool:       poolName,
+		Schema:     schemaName,
+		Collection: collectionName,
+		TreeType:   treeType,
+		Options:    &collection.Options,
+	})
 	return nil
 }
 
 type Command struct {
-	Username     string
-	Password     string
-	Role         Role
-	Operation    string
-	Pool         string
-	Schema       string
-	Collection   string
-	TreeType     TreeType
-	Key          string
-	Value        string
-	SecondaryKey string
+	Username       string
+	Password       string
+	Role           Role
+	Operation      string
+	Pool           string
+	Schema         string
+	Collection     string
+	TreeType       TreeType
+	Options        CollectionOptions
+	Key            string
+	Value          string
+	SecondaryKey   string
+	LeftBound      string
+	RightBound     string
+	LeftExclusive  bool
+	RightExclusive bool
+	Reverse        bool
+	Limit          int
+	Token          string
+	Prefix         string
+	Pattern        string
+	Regex          bool
+	TimeoutMillis  int
+	Cursor         string
+	Count          int
+	Index          int
+	Path           string
+	Where          *interfaces.FieldPredicate
+	TTLMillis      int
+	Version        int64
+	Expected       string
+	Delta          *json.Number
+	Min            *json.Number
+	Max            *json.Number
+	Keys           []string
+	Entries        []interfaces.Entry
+	Atomic         bool
+	Format         DumpFormat
+	File           string
+	Data           string
+	Bulk           bool
+	IncludeUsers   bool
+	TargetPool     string
+	TargetSchema   string
+	AsOf           string
+	LogID          string
+	Position       uint64
+	NodeID         string
+	RaftAddr       string
+	ClientAddr     string
+	Sharding       *ShardSpec
+	Shard          string
+	Server         string
+	Records        []ShardEntry
 }
@@ -2,14 +2,17 @@ package db
 
 import (
 	"DB_II/pkg/interfaces"
-	"fmt"
 	"unicode"
 )
 
 var (
-	ErrEmptyName        = fmt.Errorf("name cannot be empty")
-	ErrInvalidName      = fmt.Errorf("name contains invalid characters")
-	ErrInvalidOperation = fmt.Errorf("invalid operation")
+	ErrEmptyName        = NewError(CodeInvalidArgument, "name cannot be empty")
+	ErrInvalidName      = NewError(CodeInvalidArgument, "name contains invalid characters")
+	ErrInvalidOperation = NewError(CodeInvalidArgument, "invalid operation")
+	ErrNameTooLong      = NewError(CodeInvalidArgument, "name is too long")
+	ErrEmptyKey         = NewError(CodeInvalidArgument, "key cannot be empty")
+	ErrKeyTooLong       = NewError(CodeInvalidArgument, "key is too long")
+	ErrValueTooLarge    = NewError(CodeInvalidArgument, "value is too large")
 )
 
 func isValidName(name string) error {
@@ -110,3 +113,12 @@ func (db *Database) ListCollections(poolName, schemaName string) ([]string, erro
 	}
 	return collections, nil
 }
+
+// GetCollection checks that username holds permission and returns the
+// named collection.
+func (db *Database) GetCollection(username string, permission Permission, poolName, schemaName, collectionName string) (interfaces.CollectionInterface, error) {
+	if !db.AuthManager.HasPermission(username, permission) {
+		return nil, ErrPermissionDenied
+	}
+	return db.getCollection(poolName, schemaName, collectionName)
+}
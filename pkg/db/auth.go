@@ -1,8 +1,10 @@
 package db
 
-import "errors"
-
-var ErrUserExists = errors.New("user already exists")
+var (
+	ErrUserExists   = NewError(CodeAlreadyExists, "user already exists")
+	ErrUserNotFound = NewError(CodeAuthFailed, "user not found")
+	ErrBadPassword  = NewError(CodeAuthFailed, "invalid password")
+)
 
 type Role string
 
@@ -24,6 +26,10 @@ const (
 	PermDeleteCollection Permission = "delete_collection"
 	PermRead             Permission = "read"
 	PermWrite            Permission = "write"
+	PermBackup           Permission = "backup"
+	PermRestore          Permission = "restore"
+	PermReplicate        Permission = "replicate"
+	PermManageCluster    Permission = "manage_cluster"
 )
 
 var RolePermissions = map[Role][]Permission{
@@ -32,6 +38,8 @@ var RolePermissions = map[Role][]Permission{
 		PermCreateSchema, PermDeleteSchema,
 		PermCreateCollection, PermDeleteCollection,
 		PermRead, PermWrite,
+		PermBackup, PermRestore, PermReplicate,
+		PermManageCluster,
 	},
 	RoleAdmin: {
 		PermCreateSchema, PermDeleteSchema,
@@ -74,6 +82,34 @@ func (am *AuthManager) HasPermission(username string, permission Permission) boo
 	return false
 }
 
+// permissionCheck reports whether whoever is asking holds a permission.
+type permissionCheck func(Permission) bool
+
+// checker checks the permissions of username.
+func (am *AuthManager) checker(username string) permissionCheck {
+	return func(permission Permission) bool {
+		return am.HasPermission(username, permission)
+	}
+}
+
+// trusted allows everything. It is for replaying changes that were
+// checked when they were first made, such as a replica applying its
+// leader's log.
+func trusted(Permission) bool {
+	return true
+}
+
 func (am *AuthManager) ValidateUser(username, password string) (Role, error) {
 	return am.db.ValidateUser(username, password)
 }
+
+func (am *AuthManager) ListUsers() ([]UserRecord, error) {
+	return am.db.ListUsers()
+}
+
+func (am *AuthManager) RestoreUser(user UserRecord) error {
+	if _, ok := RolePermissions[user.Role]; !ok {
+		return Errorf(CodeInvalidArgument, "user %q has unknown role %q", user.Username, user.Role)
+	}
+	return am.db.RestoreUser(user)
+}
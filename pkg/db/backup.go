@@ -0,0 +1,659 @@
+package db
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"time"
+)
+
+// A backup is a tar archive of every pool, schema and collection as of one
+// moment. It starts with a manifest that describes the hierarchy and lists
+// the other files with their sizes and SHA-256 checksums: a native dump of
+// each collection and, if the backup includes them, the users as stored in
+// PostgreSQL, password hashes and all.
+const (
+	backupVersion      = 1
+	backupManifestFile = "manifest.json"
+	backupUsersFile    = "users.json"
+
+	// maxBackupMetadata bounds how much of a manifest or user list a
+	// restore reads into memory.
+	maxBackupMetadata = 64 << 20
+)
+
+var ErrInvalidBackup = NewError(CodeInvalidArgument, "invalid backup")
+
+type BackupManifest struct {
+	Version   int          `json:"version"`
+	CreatedAt time.Time    `json:"created_at"`
+	Pools     []BackupPool `json:"pools"`
+	Users     *BackupUsers `json:"users,omitempty"`
+}
+
+type BackupPool struct {
+	Name    string         `json:"name"`
+	Schemas []BackupSchema `json:"schemas"`
+}
+
+type BackupSchema struct {
+	Name        string             `json:"name"`
+	Collections []BackupCollection `json:"collections"`
+}
+
+type BackupCollection struct {
+	Name     string            `json:"name"`
+	TreeType TreeType          `json:"tree_type"`
+	Options  CollectionOptions `json:"options"`
+	Entries  int               `json:"entries"`
+	File     ArchiveFile       `json:"file"`
+}
+
+type BackupUsers struct {
+	Count int         `json:"count"`
+	File  ArchiveFile `json:"file"`
+}
+
+// ArchiveFile is a file of a backup other than its manifest.
+type ArchiveFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// files lists the files the manifest describes, in archive order.
+func (m *BackupManifest) files() []ArchiveFile {
+	var files []ArchiveFile
+	for _, pool := range m.Pools {
+		for _, schema := range pool.Schemas {
+			for _, collection := range schema.Collections {
+				files = append(files, collection.File)
+			}
+		}
+	}
+	if m.Users != nil {
+		files = append(files, m.Users.File)
+	}
+	return files
+}
+
+// backupCollection is a collection being backed up, and where its dump
+// lies in the spool file.
+type backupCollection struct {
+	manifest *BackupCollection
+	tc       *TreeCollection
+	offset   int64
+}
+
+// backupHierarchy describes every pool, schema and collection, sorted by
+// name, leaving the entries and files to be filled in.
+func (db *Database) backupHierarchy() (*BackupManifest, []backupCollection, error) {
+	manifest := &BackupManifest{Version: backupVersion, Pools: []BackupPool{}}
+	var trees []*TreeCollection
+
+	poolNames := db.ListPools()
+	sort.Strings(poolNames)
+	for _, poolName := range poolNames {
+		pool := BackupPool{Name: poolName, Schemas: []BackupSchema{}}
+		schemaNames, err := db.ListSchemas(poolName)
+		if err != nil {
+			return nil, nil, err
+		}
+		sort.Strings(schemaNames)
+
+		for _, schemaName := range schemaNames {
+			schema := BackupSchema{Name: schemaName, Collections: []BackupCollection{}}
+			collectionNames, err := db.ListCollections(poolName, schemaName)
+			if err != nil {
+				return nil, nil, err
+			}
+			sort.Strings(collectionNames)
+
+			for _, collectionName := range collectionNames {
+				collection, err := db.getCollection(poolName, schemaName, collectionName)
+				if err != nil {
+					return nil, nil, err
+				}
+				tc, ok := collection.(*TreeCollection)
+				if !ok {
+					return nil, nil, Errorf(CodeUnsupported, "collection %s/%s/%s cannot be backed up", poolName, schemaName, collectionName)
+				}
+				schema.Collections = append(schema.Collections, BackupCollection{
+					Name:     collectionName,
+					TreeType: tc.TreeType,
+					Options:  tc.Options,
+					File:     ArchiveFile{Path: path.Join("data", poolName, schemaName, collectionName+".dump")},
+				})
+				trees = append(trees, tc)
+			}
+			pool.Schemas = append(pool.Schemas, schema)
+		}
+		manifest.Pools = append(manifest.Pools, pool)
+	}
+
+	collections := make([]backupCollection, 0, len(trees))
+	for p := range manifest.Pools {
+		for s := range manifest.Pools[p].Schemas {
+			schema := &manifest.Pools[p].Schemas[s]
+			for c := range schema.Collections {
+				collections = append(collections, backupCollection{manifest: &schema.Collections[c], tc: trees[len(collections)]})
+			}
+		}
+	}
+	return manifest, collections, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// snapshot dumps every collection to spool. It read-locks them all at
+// once, so that the dumps agree on a single moment, then copies the live
+// entries of each in turn and releases its lock as soon as they are
+// copied: writes wait only for their collection to be copied, not for the
+// dumps. The locks are taken in the order of the manifest, so that
+// snapshots taken at the same time cannot deadlock.
+func (db *Database) snapshot(manifest *BackupManifest, collections []backupCollection, spool io.Writer) error {
+	for _, c := range collections {
+		c.tc.mutex.RLock()
+	}
+
+	now := db.clock.now()
+	copies := make([][]importEntry, len(collections))
+	for i, c := range collections {
+		err := c.tc.exportAt(now, func(key string, value string, deadline int64) error {
+			copies[i] = append(copies[i], importEntry{key: key, value: value, deadline: deadline})
+			return nil
+		})
+		c.tc.mutex.RUnlock()
+		if err != nil {
+			for _, rest := range collections[i+1:] {
+				rest.tc.mutex.RUnlock()
+			}
+			return err
+		}
+	}
+
+	manifest.CreatedAt = now.UTC()
+	var offset int64
+	for i := range collections {
+		c := &collections[i]
+		hash := sha256.New()
+		counter := &countingWriter{w: io.MultiWriter(spool, hash)}
+
+		// each dump covers just its collection and so names none, which
+		// lets a restore put it under any name
+		writer, err := newDumpWriter(counter, DumpFormatNative, dumpScope{})
+		if err != nil {
+			return err
+		}
+		if err := writer.begin(dumpTarget{}, c.tc); err != nil {
+			return err
+		}
+		for _, entry := range copies[i] {
+			if err := writer.entry(dumpTarget{}, entry.key, entry.value, entry.deadline); err != nil {
+				return err
+			}
+		}
+		if err := writer.end(len(copies[i])); err != nil {
+			return err
+		}
+
+		c.offset = offset
+		c.manifest.Entries = len(copies[i])
+		c.manifest.File.Size = counter.n
+		c.manifest.File.SHA256 = hex.EncodeToString(hash.Sum(nil))
+		offset += counter.n
+		copies[i] = nil
+	}
+	return nil
+}
+
+func writeArchiveFile(archive *tar.Writer, name string, modTime time.Time, size int64, r io.Reader) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0o644,
+		Size:     size,
+		ModTime:  modTime,
+		Format:   tar.FormatPAX,
+	}
+	if err := archive.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.Copy(archive, r)
+	return err
+}
+
+// Backup writes a backup of every pool, schema and collection to w, and
+// of the users too with includeUsers, and returns its manifest. The
+// collections are first dumped to a spool file in the dump directory, so
+// that the archive is written to w only once they are all dumped.
+func (db *Database) Backup(username string, w io.Writer, includeUsers bool) (*BackupManifest, error) {
+	if !db.AuthManager.HasPermission(username, PermBackup) {
+		return nil, ErrPermissionDenied
+	}
+	return db.backup(w, includeUsers)
+}
+
+func (db *Database) backup(w io.Writer, includeUsers bool) (*BackupManifest, error) {
+	manifest, collections, err := db.backupHierarchy()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(db.Config.DumpDir, 0o755); err != nil {
+		return nil, err
+	}
+	spool, err := os.CreateTemp(db.Config.DumpDir, ".spool-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	buffered := bufio.NewWriter(spool)
+	if err := db.snapshot(manifest, collections, buffered); err != nil {
+		return nil, err
+	}
+	if err := buffered.Flush(); err != nil {
+		return nil, err
+	}
+
+	var users []byte
+	if includeUsers {
+		records, err := db.AuthManager.ListUsers()
+		if err != nil {
+			return nil, err
+		}
+		if records == nil {
+			records = []UserRecord{}
+		}
+		if users, err = json.MarshalIndent(records, "", "  "); err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(users)
+		manifest.Users = &BackupUsers{
+			Count: len(records),
+			File:  ArchiveFile{Path: backupUsersFile, Size: int64(len(users)), SHA256: hex.EncodeToString(sum[:])},
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	archive := tar.NewWriter(w)
+	if err := writeArchiveFile(archive, backupManifestFile, manifest.CreatedAt, int64(len(data)), bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	for _, c := range collections {
+		file := c.manifest.File
+		if err := writeArchiveFile(archive, file.Path, manifest.CreatedAt, file.Size, io.NewSectionReader(spool, c.offset, file.Size)); err != nil {
+			return nil, err
+		}
+	}
+	if users != nil {
+		if err := writeArchiveFile(archive, backupUsersFile, manifest.CreatedAt, int64(len(users)), bytes.NewReader(users)); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// BackupFile writes a backup to the named file in the dump directory,
+// replacing it only once the backup is complete.
+func (db *Database) BackupFile(username string, name string, includeUsers bool) (*BackupManifest, error) {
+	path, err := db.Config.dumpPath(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(db.Config.DumpDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.CreateTemp(db.Config.DumpDir, ".backup-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+
+	buffered := bufio.NewWriter(file)
+	manifest, err := db.Backup(username, buffered, includeUsers)
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return manifest, os.Rename(file.Name(), path)
+}
+
+// RestoreOptions chooses what a restore takes from a backup. By default
+// it restores every pool, schema and collection under its own name.
+type RestoreOptions struct {
+	// Pool restores only that pool, and Schema only that schema of it.
+	Pool   string
+	Schema string
+	// TargetPool and TargetSchema restore the chosen pool or schema under
+	// another name.
+	TargetPool   string
+	TargetSchema string
+	// Users restores the backup's users too, leaving any that already
+	// exist as they are.
+	Users bool
+}
+
+// RestoreResult counts what a restore restored.
+type RestoreResult struct {
+	Pools       int `json:"pools"`
+	Schemas     int `json:"schemas"`
+	Collections int `json:"collections"`
+	Entries     int `json:"entries"`
+	Users       int `json:"users"`
+}
+
+// openArchive reads a backup from its start.
+func openArchive(r io.ReaderAt, size int64) *tar.Reader {
+	return tar.NewReader(bufio.NewReader(io.NewSectionReader(r, 0, size)))
+}
+
+func readArchiveMetadata(archive *tar.Reader, name string) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(archive, maxBackupMetadata+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidBackup, name, err)
+	}
+	if len(data) > maxBackupMetadata {
+		return nil, fmt.Errorf("%w: %s is too large", ErrInvalidBackup, name)
+	}
+	return data, nil
+}
+
+// verifyBackup reads the whole backup, checking every file against the
+// manifest, and returns the manifest.
+func verifyBackup(r io.ReaderAt, size int64) (*BackupManifest, error) {
+	archive := openArchive(r, size)
+	header, err := archive.Next()
+	if err != nil || header.Name != backupManifestFile {
+		return nil, fmt.Errorf("%w: archive does not start with a manifest", ErrInvalidBackup)
+	}
+	data, err := readArchiveMetadata(archive, backupManifestFile)
+	if err != nil {
+		return nil, err
+	}
+	var manifest BackupManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("%w: manifest: %v", ErrInvalidBackup, err)
+	}
+	if manifest.Version != backupVersion {
+		return nil, Errorf(CodeUnsupported, "backup version %d is not supported", manifest.Version)
+	}
+
+	expected := make(map[string]ArchiveFile)
+	for _, file := range manifest.files() {
+		if _, ok := expected[file.Path]; ok || file.Path == backupManifestFile {
+			return nil, fmt.Errorf("%w: manifest lists %s twice", ErrInvalidBackup, file.Path)
+		}
+		expected[file.Path] = file
+	}
+
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+		}
+		file, ok := expected[header.Name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is not in the manifest", ErrInvalidBackup, header.Name)
+		}
+		delete(expected, header.Name)
+
+		hash := sha256.New()
+		n, err := io.Copy(hash, archive)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidBackup, header.Name, err)
+		}
+		if n != file.Size || hex.EncodeToString(hash.Sum(nil)) != file.SHA256 {
+			return nil, fmt.Errorf("%w: %s does not match its checksum", ErrInvalidBackup, header.Name)
+		}
+	}
+
+	if len(expected) > 0 {
+		missing := make([]string, 0, len(expected))
+		for name := range expected {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+		return nil, fmt.Errorf("%w: archive is missing %s", ErrInvalidBackup, missing[0])
+	}
+	return &manifest, nil
+}
+
+// restoreTarget is where a restore puts a schema, or a collection of it.
+type restoreTarget struct {
+	pool       string
+	schema     string
+	collection string
+	entries    int
+}
+
+// restorePlan is what a restore creates, under the names it restores
+// them as. collections is keyed by the archive file holding each one.
+type restorePlan struct {
+	pools       []string
+	schemas     []restoreTarget
+	collections map[string]restoreTarget
+}
+
+// planRestore picks what options restore from the backup and checks that none of
+// its collections already exists.
+func (db *Database) planRestore(manifest *BackupManifest, options RestoreOptions) (restorePlan, error) {
+	plan := restorePlan{collections: make(map[string]restoreTarget)}
+	foundPool, foundSchema := false, false
+	for _, pool := range manifest.Pools {
+		if options.Pool != "" && pool.Name != options.Pool {
+			continue
+		}
+		foundPool = true
+		poolName := pool.Name
+		if options.TargetPool != "" {
+			poolName = options.TargetPool
+		}
+		if err := db.Config.validateName("pool", poolName); err != nil {
+			return plan, err
+		}
+		plan.pools = append(plan.pools, poolName)
+
+		for _, schema := range pool.Schemas {
+			if options.Schema != "" && schema.Name != options.Schema {
+				continue
+			}
+			foundSchema = true
+			schemaName := schema.Name
+			if options.TargetSchema != "" {
+				schemaName = options.TargetSchema
+			}
+			if err := db.Config.validateName("schema", schemaName); err != nil {
+				return plan, err
+			}
+			plan.schemas = append(plan.schemas, restoreTarget{pool: poolName, schema: schemaName})
+
+			for _, collection := range schema.Collections {
+				if err := db.Config.validateName("collection", collection.Name); err != nil {
+					return plan, err
+				}
+				if _, err := db.getCollection(poolName, schemaName, collection.Name); err == nil {
+					return plan, fmt.Errorf("%w: %s/%s/%s", ErrCollectionExists, poolName, schemaName, collection.Name)
+				}
+				plan.collections[collection.File.Path] = restoreTarget{
+					pool:       poolName,
+					schema:     schemaName,
+					collection: collection.Name,
+					entries:    collection.Entries,
+				}
+			}
+		}
+	}
+
+	if options.Pool != "" && !foundPool {
+		return plan, Errorf(CodeNotFound, "pool %q is not in the backup", options.Pool)
+	}
+	if options.Schema != "" && !foundSchema {
+		return plan, Errorf(CodeNotFound, "schema %q of pool %q is not in the backup", options.Schema, options.Pool)
+	}
+	return plan, nil
+}
+
+// Restore restores a backup of size bytes read from r. It checks the
+// whole backup against its manifest before restoring any of it, and
+// refuses to restore a collection over one that already exists, but pools
+// and schemas that exist are restored into. Collections restored before
+// a failure stay.
+func (db *Database) Restore(username string, r io.ReaderAt, size int64, options RestoreOptions) (RestoreResult, error) {
+	return db.restore(db.AuthManager.checker(username), r, size, options)
+}
+
+func (db *Database) restore(allowed permissionCheck, r io.ReaderAt, size int64, options RestoreOptions) (RestoreResult, error) {
+	var result RestoreResult
+	if !allowed(PermRestore) {
+		return result, ErrPermissionDenied
+	}
+	if (options.Pool == "" && (options.Schema != "" || options.TargetPool != "")) || (options.Schema == "" && options.TargetSchema != "") {
+		return result, Errorf(CodeInvalidArgument, "a restore can only select a pool, or a schema of it, and rename what it selects")
+	}
+
+	manifest, err := verifyBackup(r, size)
+	if err != nil {
+		return result, err
+	}
+	if options.Users && manifest.Users == nil {
+		return result, Errorf(CodeNotFound, "backup holds no users")
+	}
+	plan, err := db.planRestore(manifest, options)
+	if err != nil {
+		return result, err
+	}
+
+	for _, poolName := range plan.pools {
+		if err := db.createPool(allowed, poolName); err != nil && !errors.Is(err, ErrPoolExists) {
+			return result, err
+		}
+		result.Pools++
+	}
+	for _, schema := range plan.schemas {
+		if err := db.createSchema(allowed, schema.pool, schema.schema); err != nil && !errors.Is(err, ErrSchemaExists) {
+			return result, err
+		}
+		result.Schemas++
+	}
+
+	archive := openArchive(r, size)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return result, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+		}
+
+		if header.Name == backupUsersFile {
+			if !options.Users {
+				continue
+			}
+			restored, err := db.restoreUsers(archive)
+			result.Users += restored
+			if err != nil {
+				return result, err
+			}
+			continue
+		}
+
+		target, ok := plan.collections[header.Name]
+		if !ok {
+			continue
+		}
+		count, err := db.importDump(allowed, archive, DumpFormatNative, target.pool, target.schema, target.collection, true)
+		result.Entries += count
+		if err != nil {
+			return result, fmt.Errorf("%s/%s/%s: %w", target.pool, target.schema, target.collection, err)
+		}
+		if count != target.entries {
+			return result, fmt.Errorf("%w: %s holds %d entries but the manifest says %d", ErrInvalidBackup, header.Name, count, target.entries)
+		}
+		result.Collections++
+	}
+	return result, nil
+}
+
+// restoreUsers adds the users of a backup that do not exist yet and
+// returns how many it added.
+func (db *Database) restoreUsers(archive *tar.Reader) (int, error) {
+	data, err := readArchiveMetadata(archive, backupUsersFile)
+	if err != nil {
+		return 0, err
+	}
+	var users []UserRecord
+	if err := json.Unmarshal(data, &users); err != nil {
+		return 0, fmt.Errorf("%w: %s: %v", ErrInvalidBackup, backupUsersFile, err)
+	}
+
+	restored := 0
+	for _, user := range users {
+		err := db.AuthManager.RestoreUser(user)
+		if errors.Is(err, ErrUserExists) {
+			continue
+		} else if err != nil {
+			return restored, err
+		}
+		restored++
+	}
+	return restored, nil
+}
+
+// RestoreFile restores the named backup file in the dump directory.
+func (db *Database) RestoreFile(username string, name string, options RestoreOptions) (RestoreResult, error) {
+	path, err := db.Config.dumpPath(name)
+	if err != nil {
+		return RestoreResult{}, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return RestoreResult{}, Errorf(CodeNotFound, "backup file %q not found", name)
+	} else if err != nil {
+		return RestoreResult{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return RestoreResult{}, err
+	}
+	return db.Restore(username, file, info.Size(), options)
+}
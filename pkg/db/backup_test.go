@@ -0,0 +1,192 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newBackupTestDatabase returns a database holding p.s.c with five keys
+// and one expiring key, the empty collection p.t.e and q.s.d with a key.
+func newBackupTestDatabase(t *testing.T) *Database {
+	t.Helper()
+	database := newTestDatabase(t, t.TempDir())
+	t.Cleanup(func() { database.Close() })
+	createTestCollection(t, database, TreeTypeRedBlack, CollectionOptions{ValueType: ValueTypeInt})
+	setTestKeys(t, database, 0, 5)
+	if err := testCollection(t, database, "s", "c").SetWithTTL("ttl", "ttl", "5", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range []error{
+		database.createSchema(trusted, "p", "t"),
+		database.createCollection(trusted, "p", "t", "e", TreeTypeSkipList, CollectionOptions{}),
+		database.createPool(trusted, "q"),
+		database.createSchema(trusted, "q", "s"),
+		database.createCollection(trusted, "q", "s", "d", TreeTypeAVL, CollectionOptions{}),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	d, err := database.getCollection("q", "s", "d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Set("a", "a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	return database
+}
+
+func testBackup(t *testing.T, database *Database) *bytes.Reader {
+	t.Helper()
+	var backup bytes.Buffer
+	if _, err := database.Backup("admin", &backup, false); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(backup.Bytes())
+}
+
+func TestBackupRestoresHierarchy(t *testing.T) {
+	source := newBackupTestDatabase(t)
+	var backup bytes.Buffer
+	manifest, err := source.Backup("admin", &backup, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Pools) != 2 || manifest.Pools[0].Name != "p" || manifest.Users != nil {
+		t.Fatalf("manifest is %+v", manifest)
+	}
+	if c := manifest.Pools[0].Schemas[0].Collections[0]; c.Name != "c" || c.TreeType != TreeTypeRedBlack || c.Entries != 6 {
+		t.Errorf("manifest describes p.s.c as %+v", c)
+	}
+
+	target := newTestDatabase(t, t.TempDir())
+	defer target.Close()
+	result, err := target.Restore("admin", bytes.NewReader(backup.Bytes()), int64(backup.Len()), RestoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (RestoreResult{Pools: 2, Schemas: 3, Collections: 3, Entries: 7}); result != want {
+		t.Errorf("restored %+v, want %+v", result, want)
+	}
+
+	if got, want := testContents(target), testContents(source); !reflect.DeepEqual(got, want) {
+		t.Errorf("restored p.s.c holds %v, want %v", got, want)
+	}
+	tc := testCollection(t, target, "s", "c")
+	if tc.TreeType != TreeTypeRedBlack || tc.Options.ValueType != ValueTypeInt {
+		t.Errorf("restored p.s.c is %s with %+v", tc.TreeType, tc.Options)
+	}
+	if _, ok, err := tc.TTL("ttl"); err != nil || !ok {
+		t.Errorf("restored ttl has no time to live: %v", err)
+	}
+	if e := testCollection(t, target, "t", "e"); e.TreeType != TreeTypeSkipList {
+		t.Errorf("restored p.t.e is %s", e.TreeType)
+	}
+	if d, err := target.getCollection("q", "s", "d"); err != nil {
+		t.Error(err)
+	} else if value, err := d.Get("a"); err != nil || value != "1" {
+		t.Errorf("restored q.s.d holds a=%q, %v", value, err)
+	}
+}
+
+func TestRestoreSelectsAndRenames(t *testing.T) {
+	backup := testBackup(t, newBackupTestDatabase(t))
+	target := newTestDatabase(t, t.TempDir())
+	defer target.Close()
+
+	options := RestoreOptions{Pool: "p", Schema: "s", TargetPool: "r", TargetSchema: "x"}
+	result, err := target.Restore("admin", backup, backup.Size(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (RestoreResult{Pools: 1, Schemas: 1, Collections: 1, Entries: 6}); result != want {
+		t.Errorf("restored %+v, want %+v", result, want)
+	}
+	if _, err := target.getCollection("r", "x", "c"); err != nil {
+		t.Error(err)
+	}
+	for _, name := range [][3]string{{"p", "s", "c"}, {"r", "t", "e"}, {"q", "s", "d"}} {
+		if _, err := target.getCollection(name[0], name[1], name[2]); err == nil {
+			t.Errorf("%v was restored", name)
+		}
+	}
+
+	// a collection is never restored over one that exists
+	if _, err := target.Restore("admin", backup, backup.Size(), options); !errors.Is(err, ErrCollectionExists) {
+		t.Errorf("restoring twice returned %v, want ErrCollectionExists", err)
+	}
+
+	for _, options := range []RestoreOptions{
+		{Schema: "s"},
+		{TargetPool: "r"},
+		{Pool: "p", TargetSchema: "x"},
+	} {
+		if _, err := target.Restore("admin", backup, backup.Size(), options); CodeOf(err) != CodeInvalidArgument {
+			t.Errorf("%+v returned %v", options, err)
+		}
+	}
+	for _, options := range []RestoreOptions{
+		{Pool: "missing"},
+		{Pool: "p", Schema: "missing"},
+		{Users: true},
+	} {
+		if _, err := target.Restore("admin", backup, backup.Size(), options); CodeOf(err) != CodeNotFound {
+			t.Errorf("%+v returned %v", options, err)
+		}
+	}
+}
+
+func TestRestoreRejectsCorruptBackup(t *testing.T) {
+	backup := testBackup(t, newBackupTestDatabase(t))
+	data := make([]byte, backup.Size())
+	if _, err := backup.ReadAt(data, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// flip a byte of the first collection's dump
+	corrupt := bytes.Clone(data)
+	start := bytes.Index(corrupt, []byte(dumpMagic))
+	if start < 0 {
+		t.Fatal("backup holds no native dump")
+	}
+	corrupt[start+len(dumpMagic)] ^= 0xff
+
+	target := newTestDatabase(t, t.TempDir())
+	defer target.Close()
+	for name, archive := range map[string][]byte{
+		"corrupt":   corrupt,
+		"truncated": data[:len(data)/2],
+		"not a tar": []byte("garbage"),
+	} {
+		if _, err := target.Restore("admin", bytes.NewReader(archive), int64(len(archive)), RestoreOptions{}); !errors.Is(err, ErrInvalidBackup) {
+			t.Errorf("%s: restore returned %v, want ErrInvalidBackup", name, err)
+		}
+	}
+	// nothing is restored from a backup that does not check out
+	if pools := target.ListPools(); len(pools) != 0 {
+		t.Errorf("target holds pools %v", pools)
+	}
+}
+
+func TestBackupFiles(t *testing.T) {
+	source := newBackupTestDatabase(t)
+	if _, err := source.BackupFile("admin", "all.tar", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.RestoreFile("admin", "all.tar", RestoreOptions{Pool: "q", TargetPool: "copy"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.getCollection("copy", "s", "d"); err != nil {
+		t.Error(err)
+	}
+	if _, err := source.RestoreFile("admin", "missing.tar", RestoreOptions{}); CodeOf(err) != CodeNotFound {
+		t.Errorf("restoring a missing file returned %v", err)
+	}
+	if _, err := source.BackupFile("admin", "../all.tar", false); !errors.Is(err, ErrInvalidDumpFile) {
+		t.Errorf("backing up outside the dump directory returned %v", err)
+	}
+}
@@ -0,0 +1,278 @@
+package db
+
+import (
+	"DB_II/pkg/interfaces"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var ErrBatchAborted = NewError(CodeConditionFailed, "batch aborted")
+
+var _ interfaces.BatchCollection = (*TreeCollection)(nil)
+
+// BatchError is returned by an all-or-nothing batch that was not applied
+// because one of its items would have failed. Results holds that item's
+// error and marks every other item as aborted.
+type BatchError struct {
+	Results []interfaces.BatchResult
+	failed  int
+}
+
+func (e *BatchError) Error() string {
+	item := e.Results[e.failed]
+	return fmt.Sprintf("%s: item %d (%q) failed: %s", ErrBatchAborted.Message, e.failed, item.Key, item.Error)
+}
+
+func (e *BatchError) Unwrap() error {
+	return ErrBatchAborted
+}
+
+func batchResult(key string, err error) interfaces.BatchResult {
+	result := interfaces.BatchResult{Key: key}
+	if err != nil {
+		result.Code = string(CodeOf(err))
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func (tc *TreeCollection) checkBatchSize(n int) error {
+	if limit := tc.config.MaxBatchSize; limit > 0 && n > limit {
+		return Errorf(CodeInvalidArgument, "batch of %d items exceeds the maximum of %d", n, limit)
+	}
+	return nil
+}
+
+// MultiGet looks up every key under one read lock. Missing keys have the
+// NOT_FOUND code.
+func (tc *TreeCollection) MultiGet(keys []string) ([]interfaces.BatchResult, error) {
+	if err := tc.checkBatchSize(len(keys)); err != nil {
+		return nil, err
+	}
+
+	results := make([]interfaces.BatchResult, len(keys))
+	var expired []string
+	tc.mutex.RLock()
+	now := time.Now()
+	for i, key := range keys {
+		if err := tc.config.validateKey(key); err != nil {
+			results[i] = batchResult(key, err)
+			continue
+		}
+		value, found, err := tc.tree.get(key)
+		if found && tc.expiry.expired(key, now) {
+			expired = append(expired, key)
+			found = false
+		}
+		if err == nil && !found {
+			err = ErrKeyNotFound
+		}
+		results[i] = batchResult(key, err)
+		if err == nil {
+			results[i].Value = value
+		}
+	}
+	tc.mutex.RUnlock()
+
+	if len(expired) > 0 {
+		if err := tc.expireKeys(expired); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// MultiSet writes every entry like Set under one write lock.
+func (tc *TreeCollection) MultiSet(entries []interfaces.Entry, atomic bool) ([]interfaces.BatchResult, error) {
+	ops := make([]batchOp, len(entries))
+	for i, entry := range entries {
+		ops[i] = batchOp{key: entry.Key, value: entry.Value}
+	}
+	return tc.batch(ops, atomic)
+}
+
+// MultiDelete deletes every key like Delete under one write lock.
+func (tc *TreeCollection) MultiDelete(keys []string, atomic bool) ([]interfaces.BatchResult, error) {
+	ops := make([]batchOp, len(keys))
+	for i, key := range keys {
+		ops[i] = batchOp{key: key, remove: true}
+	}
+	return tc.batch(ops, atomic)
+}
+
+// batchOp is one item of a batch: a put, or a remove.
+type batchOp struct {
+	key    string
+	value  string
+	remove bool
+}
+
+// batchWriter is implemented by disk-backed trees that can make the puts
+// and removes of a batch durable together, so that a crash keeps all of
+// them or none. The in-memory trees apply them one at a time.
+type batchWriter interface {
+	writeBatch(ops []batchOp) error
+}
+
+// batch applies each item under one write lock, recording a result per
+// item.
+func (tc *TreeCollection) batch(ops []batchOp, atomic bool) ([]interfaces.BatchResult, error) {
+	if err := tc.checkBatchSize(len(ops)); err != nil {
+		return nil, err
+	}
+
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	if atomic {
+		return tc.batchAtomic(ops)
+	}
+	results := make([]interfaces.BatchResult, len(ops))
+	for i, op := range ops {
+		results[i] = batchResult(op.key, tc.applyOp(op))
+	}
+	return results, nil
+}
+
+// applyOp applies one item of a batch that is not atomic. The caller
+// holds the write lock.
+func (tc *TreeCollection) applyOp(op batchOp) error {
+	if op.remove {
+		if err := tc.config.validateKey(op.key); err != nil {
+			return err
+		}
+	} else if err := tc.validateWrite(op.key, op.value); err != nil {
+		return err
+	}
+
+	// an expired key reads as missing, so Delete fails on it
+	if tc.expiry.expired(op.key, tc.clock.now()) {
+		if err := tc.removeExpired(op.key); err != nil {
+			return err
+		}
+	}
+	if op.remove {
+		return tc.remove(op.key)
+	}
+	return tc.write(op.key, op.value, 0)
+}
+
+// plannedOp is an item of an atomic batch together with the state the
+// items before it leave its key in.
+type plannedOp struct {
+	batchOp
+	storedKey string
+	oldValue  string
+	expired   bool
+}
+
+// batchAtomic applies every item of a batch or none. Each item is checked
+// against the collection as the items before it would leave it before
+// anything changes, and the tree then takes the whole batch as one write.
+// The caller holds the write lock.
+func (tc *TreeCollection) batchAtomic(ops []batchOp) ([]interfaces.BatchResult, error) {
+	results := make([]interfaces.BatchResult, len(ops))
+	plan, failed, err := tc.planBatch(ops)
+	if err != nil {
+		results[failed] = batchResult(ops[failed].key, err)
+		for i := range results {
+			if i != failed {
+				results[i] = batchResult(ops[i].key, ErrBatchAborted)
+			}
+		}
+		return nil, &BatchError{Results: results, failed: failed}
+	}
+
+	// keys found expired are deleted first, as any read would
+	for _, step := range plan {
+		if step.expired {
+			if err := tc.removeExpired(step.key); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, step := range plan {
+		if !step.remove {
+			if err := tc.expiry.set(step.key, 0); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if writer, ok := tc.tree.(batchWriter); ok {
+		err = writer.writeBatch(ops)
+	} else {
+		for _, op := range ops {
+			if op.remove {
+				_, err = tc.tree.remove(op.key)
+			} else {
+				err = tc.tree.put(op.key, op.value)
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	at := tc.clock.now().UnixNano()
+	for _, step := range plan {
+		if step.remove {
+			err = tc.recordRemove(step.storedKey, step.oldValue, step.key, at)
+		} else {
+			err = tc.recordPut(step.storedKey, step.oldValue, step.key, step.value, 0, at)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// planBatch checks every item of an atomic batch without changing
+// anything, and returns the items with the state of their keys, or the
+// position of the first that would fail and why.
+func (tc *TreeCollection) planBatch(ops []batchOp) ([]plannedOp, int, error) {
+	now := tc.clock.now()
+	// latest holds the position of the last item on each key so far, in a
+	// tree ordered like the collection so that equal keys share it
+	latest := NewAVLTree()
+	latest.compare = tc.compare
+
+	plan := make([]plannedOp, len(ops))
+	for i, op := range ops {
+		var err error
+		if op.remove {
+			err = tc.config.validateKey(op.key)
+		} else {
+			err = tc.validateWrite(op.key, op.value)
+		}
+		if err != nil {
+			return nil, i, err
+		}
+
+		step := plannedOp{batchOp: op}
+		found := false
+		if raw, seen, _ := latest.get(op.key); seen {
+			j, _ := strconv.Atoi(raw)
+			step.storedKey, found = op.key, !plan[j].remove
+			if found {
+				step.storedKey, step.oldValue = plan[j].storedKey, plan[j].value
+			}
+		} else if tc.expiry.expired(op.key, now) {
+			step.storedKey, step.expired = op.key, true
+		} else if step.storedKey, step.oldValue, found, err = tc.stored(op.key); err != nil {
+			return nil, i, err
+		}
+
+		if op.remove && !found {
+			return nil, i, ErrKeyNotFound
+		}
+		latest.put(op.key, strconv.Itoa(i))
+		plan[i] = step
+	}
+	return plan, 0, nil
+}
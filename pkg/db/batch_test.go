@@ -0,0 +1,195 @@
+package db
+
+import (
+	"DB_II/pkg/interfaces"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var batchTreeTypes = []TreeType{TreeTypeAVL, TreeTypeLSM, TreeTypePagedBTree}
+
+// newBatchTestCollection returns a collection with history holding a and b
+// at their first versions.
+func newBatchTestCollection(t *testing.T, treeType TreeType) *TreeCollection {
+	t.Helper()
+	tc := newTestCollection(t, treeType, CollectionOptions{HistoryVersions: 10}, t.TempDir())
+	for _, key := range []string{"a", "b"} {
+		if err := tc.Set(key, key, key+"1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tc
+}
+
+// checkUntouched fails unless a and b still hold their first versions and
+// c was never written.
+func checkUntouched(t *testing.T, tc *TreeCollection) {
+	t.Helper()
+	contents, err := tc.GetRange("", "\xff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"a": "a1", "b": "b1"}; !reflect.DeepEqual(*contents, want) {
+		t.Errorf("%s: collection holds %v, want %v", tc.TreeType, *contents, want)
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, version, err := tc.GetVersioned(key); err != nil || version != 1 {
+			t.Errorf("%s: %s is at version %d, %v, want 1", tc.TreeType, key, version, err)
+		}
+		if history, err := tc.GetHistory(key); err != nil || len(history) != 1 {
+			t.Errorf("%s: %s has history %v, %v, want one version", tc.TreeType, key, history, err)
+		}
+	}
+	if _, err := tc.GetHistory("c"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("%s: c has history: %v", tc.TreeType, err)
+	}
+}
+
+func checkBatchError(t *testing.T, treeType TreeType, err error, failed int, code ErrorCode) {
+	t.Helper()
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("%s: batch returned %v, want a BatchError", treeType, err)
+	}
+	for i, result := range batchErr.Results {
+		want := string(CodeConditionFailed)
+		if i == failed {
+			want = string(code)
+		}
+		if result.Code != want {
+			t.Errorf("%s: item %d has code %q, want %q", treeType, i, result.Code, want)
+		}
+	}
+}
+
+func TestAtomicMultiSetFailureChangesNothing(t *testing.T) {
+	for _, treeType := range batchTreeTypes {
+		tc := newBatchTestCollection(t, treeType)
+		_, err := tc.MultiSet([]interfaces.Entry{
+			{Key: "a", Value: "a2"},
+			{Key: "c", Value: "c2"},
+			{Key: strings.Repeat("k", tc.config.MaxKeyLength+1), Value: "x"},
+		}, true)
+		checkBatchError(t, treeType, err, 2, CodeInvalidArgument)
+		checkUntouched(t, tc)
+	}
+}
+
+func TestAtomicMultiDeleteFailureChangesNothing(t *testing.T) {
+	for _, treeType := range batchTreeTypes {
+		tc := newBatchTestCollection(t, treeType)
+		_, err := tc.MultiDelete([]string{"a", "c"}, true)
+		checkBatchError(t, treeType, err, 1, CodeNotFound)
+		checkUntouched(t, tc)
+
+		// an item fails if an earlier one deleted its key
+		_, err = tc.MultiDelete([]string{"b", "a", "b"}, true)
+		checkBatchError(t, treeType, err, 2, CodeNotFound)
+		checkUntouched(t, tc)
+	}
+}
+
+func TestAtomicBatchApplies(t *testing.T) {
+	for _, treeType := range batchTreeTypes {
+		tc := newBatchTestCollection(t, treeType)
+		results, err := tc.MultiSet([]interfaces.Entry{
+			{Key: "a", Value: "a2"},
+			{Key: "c", Value: "c1"},
+			{Key: "a", Value: "a3"},
+		}, true)
+		if err != nil {
+			t.Fatalf("%s: %v", treeType, err)
+		}
+		for i, result := range results {
+			if result.Code != "" {
+				t.Errorf("%s: item %d failed: %s", treeType, i, result.Error)
+			}
+		}
+		if _, err := tc.MultiDelete([]string{"b", "c"}, true); err != nil {
+			t.Fatalf("%s: %v", treeType, err)
+		}
+
+		contents, err := tc.GetRange("", "\xff")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := map[string]string{"a": "a3"}; !reflect.DeepEqual(*contents, want) {
+			t.Errorf("%s: collection holds %v, want %v", treeType, *contents, want)
+		}
+		if _, version, err := tc.GetVersioned("a"); err != nil || version != 3 {
+			t.Errorf("%s: a is at version %d, %v, want 3", treeType, version, err)
+		}
+		if history, err := tc.GetHistory("c"); err != nil || len(history) != 2 || !history[1].Deleted {
+			t.Errorf("%s: c has history %v, %v, want a write and a deletion", treeType, history, err)
+		}
+	}
+}
+
+func TestMultiDeleteWithoutAtomicReportsEachItem(t *testing.T) {
+	tc := newBatchTestCollection(t, TreeTypeAVL)
+	results, err := tc.MultiDelete([]string{"a", "c", "a"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes := []string{results[0].Code, results[1].Code, results[2].Code}
+	if want := []string{"", string(CodeNotFound), string(CodeNotFound)}; !reflect.DeepEqual(codes, want) {
+		t.Errorf("items have codes %q, want %q", codes, want)
+	}
+	if _, err := tc.Get("a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("a was not deleted: %v", err)
+	}
+}
+
+func TestMultiGet(t *testing.T) {
+	tc := newBatchTestCollection(t, TreeTypeAVL)
+	tc.clock.set(time.Now().Add(-time.Hour))
+	if err := tc.SetWithTTL("old", "old", "x", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	tc.clock.set(time.Now())
+
+	results, err := tc.MultiGet([]string{"b", "c", "", "old", "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interfaces.BatchResult{
+		{Key: "b", Value: "b1"},
+		{Key: "c", Code: string(CodeNotFound)},
+		{Key: "", Code: string(CodeInvalidArgument)},
+		{Key: "old", Code: string(CodeNotFound)},
+		{Key: "a", Value: "a1"},
+	}
+	for i, result := range results {
+		result.Error = ""
+		if result != want[i] {
+			t.Errorf("item %d is %+v, want %+v", i, result, want[i])
+		}
+	}
+	// the read deletes the expired key, recording its expiry
+	if _, found := tc.expiry.deadline("old"); found {
+		t.Error("old still has a deadline")
+	}
+	if history, err := tc.GetHistory("old"); err != nil || len(history) != 2 || !history[1].Deleted {
+		t.Errorf("old has history %v, %v, want a write and a deletion", history, err)
+	}
+}
+
+func TestBatchSizeLimit(t *testing.T) {
+	tc := newBatchTestCollection(t, TreeTypeAVL)
+	tc.config.MaxBatchSize = 2
+	if _, err := tc.MultiGet([]string{"a", "b", "c"}); CodeOf(err) != CodeInvalidArgument {
+		t.Errorf("an oversized MultiGet returned %v", err)
+	}
+	for _, atomic := range []bool{false, true} {
+		if _, err := tc.MultiSet([]interfaces.Entry{{Key: "a"}, {Key: "b"}, {Key: "c"}}, atomic); CodeOf(err) != CodeInvalidArgument {
+			t.Errorf("an oversized MultiSet returned %v", err)
+		}
+		if _, err := tc.MultiDelete([]string{"a", "b", "c"}, atomic); CodeOf(err) != CodeInvalidArgument {
+			t.Errorf("an oversized MultiDelete returned %v", err)
+		}
+	}
+	checkUntouched(t, tc)
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// bloomFilter is a fixed-size Bloom filter using double hashing over a
+// 64-bit FNV-1a hash, so its encoding is stable across restarts.
+type bloomFilter struct {
+	bits []byte
+	k    int
+}
+
+func newBloomFilter(keys int, bitsPerKey int) *bloomFilter {
+	nbits := keys * bitsPerKey
+	if nbits < 64 {
+		nbits = 64
+	}
+
+	k := int(math.Round(float64(bitsPerKey) * math.Ln2))
+	if k < 1 {
+		k = 1
+	} else if k > 30 {
+		k = 30
+	}
+
+	return &bloomFilter{bits: make([]byte, (nbits+7)/8), k: k}
+}
+
+func bloomHash(key string) (uint32, uint32) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	return uint32(sum), uint32(sum >> 32)
+}
+
+func (f *bloomFilter) add(key string) {
+	h1, h2 := bloomHash(key)
+	nbits := uint32(len(f.bits) * 8)
+	for i := 0; i < f.k; i++ {
+		bit := (h1 + uint32(i)*h2) % nbits
+		f.bits[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+func (f *bloomFilter) mayContain(key string) bool {
+	h1, h2 := bloomHash(key)
+	nbits := uint32(len(f.bits) * 8)
+	for i := 0; i < f.k; i++ {
+		bit := (h1 + uint32(i)*h2) % nbits
+		if f.bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *bloomFilter) encode() []byte {
+	return append([]byte{byte(f.k)}, f.bits...)
+}
+
+func decodeBloomFilter(data []byte) *bloomFilter {
+	if len(data) < 2 {
+		return nil
+	}
+	return &bloomFilter{k: int(data[0]), bits: data[1:]}
+}
@@ -0,0 +1,402 @@
+package db
+
+// BPlusNode is a B+ tree node. Internal nodes hold only separator keys;
+// values live in the leaves, which are linked left to right so ordered
+// scans never have to climb back through the internal nodes.
+type BPlusNode struct {
+	Keys     []string
+	Values   []string
+	Children []*BPlusNode
+	Leaf     bool
+	Next     *BPlusNode
+	Prev     *BPlusNode
+}
+
+type BPlusTree struct {
+	Root   *BPlusNode
+	MinDeg int
+}
+
+func NewBPlusTree(degree int) *BPlusTree {
+	return &BPlusTree{
+		MinDeg: degree,
+		Root:   &BPlusNode{Leaf: true},
+	}
+}
+
+func (t *BPlusTree) maxKeys() int {
+	return 2*t.MinDeg - 1
+}
+
+func (t *BPlusTree) minKeys() int {
+	return t.MinDeg - 1
+}
+
+// lowerBound returns the index of the first key >= key.
+func lowerBound(keys []string, key string) int {
+	lo, hi := 0, len(keys)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if keys[mid] < key {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// upperBound returns the index of the first key > key.
+func upperBound(keys []string, key string) int {
+	lo, hi := 0, len(keys)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if keys[mid] <= key {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// findLeaf descends to the leaf that would hold key.
+func (t *BPlusTree) findLeaf(key string) *BPlusNode {
+	node := t.Root
+	for !node.Leaf {
+		node = node.Children[upperBound(node.Keys, key)]
+	}
+	return node
+}
+
+func (t *BPlusTree) firstLeaf() *BPlusNode {
+	node := t.Root
+	for !node.Leaf {
+		node = node.Children[0]
+	}
+	return node
+}
+
+func (t *BPlusTree) lastLeaf() *BPlusNode {
+	node := t.Root
+	for !node.Leaf {
+		node = node.Children[len(node.Children)-1]
+	}
+	return node
+}
+
+func (t *BPlusTree) Search(key string) (string, bool) {
+	leaf := t.findLeaf(key)
+	i := lowerBound(leaf.Keys, key)
+	if i < len(leaf.Keys) && leaf.Keys[i] == key {
+		return leaf.Values[i], true
+	}
+	return "", false
+}
+
+func (t *BPlusTree) Insert(key string, value string) {
+	separator, right := t.insert(t.Root, key, value)
+	if right != nil {
+		t.Root = &BPlusNode{
+			Keys:     []string{separator},
+			Children: []*BPlusNode{t.Root, right},
+		}
+	}
+}
+
+// insert adds key below node. If node overflows it is split and the new
+// right sibling is returned together with the separator for the parent.
+func (t *BPlusTree) insert(node *BPlusNode, key string, value string) (string, *BPlusNode) {
+	if node.Leaf {
+		i := lowerBound(node.Keys, key)
+		if i < len(node.Keys) && node.Keys[i] == key {
+			node.Values[i] = value
+			return "", nil
+		}
+
+		node.Keys = insertString(node.Keys, i, key)
+		node.Values = insertString(node.Values, i, value)
+
+		if len(node.Keys) > t.maxKeys() {
+			return t.splitLeaf(node)
+		}
+		return "", nil
+	}
+
+	i := upperBound(node.Keys, key)
+	separator, right := t.insert(node.Children[i], key, value)
+	if right == nil {
+		return "", nil
+	}
+
+	node.Keys = insertString(node.Keys, i, separator)
+	node.Children = insertNode(node.Children, i+1, right)
+
+	if len(node.Keys) > t.maxKeys() {
+		return t.splitInternal(node)
+	}
+	return "", nil
+}
+
+func (t *BPlusTree) splitLeaf(node *BPlusNode) (string, *BPlusNode) {
+	mid := len(node.Keys) / 2
+
+	right := &BPlusNode{
+		Leaf:   true,
+		Keys:   append([]string(nil), node.Keys[mid:]...),
+		Values: append([]string(nil), node.Values[mid:]...),
+		Next:   node.Next,
+		Prev:   node,
+	}
+	if node.Next != nil {
+		node.Next.Prev = right
+	}
+	node.Next = right
+
+	node.Keys = node.Keys[:mid:mid]
+	node.Values = node.Values[:mid:mid]
+
+	return right.Keys[0], right
+}
+
+func (t *BPlusTree) splitInternal(node *BPlusNode) (string, *BPlusNode) {
+	mid := len(node.Keys) / 2
+	separator := node.Keys[mid]
+
+	right := &BPlusNode{
+		Keys:     append([]string(nil), node.Keys[mid+1:]...),
+		Children: append([]*BPlusNode(nil), node.Children[mid+1:]...),
+	}
+
+	node.Keys = node.Keys[:mid:mid]
+	node.Children = node.Children[: mid+1 : mid+1]
+
+	return separator, right
+}
+
+func (t *BPlusTree) Delete(key string) bool {
+	found := t.delete(t.Root, key)
+
+	if !t.Root.Leaf && len(t.Root.Keys) == 0 {
+		t.Root = t.Root.Children[0]
+	}
+	return found
+}
+
+func (t *BPlusTree) delete(node *BPlusNode, key string) bool {
+	if node.Leaf {
+		i := lowerBound(node.Keys, key)
+		if i == len(node.Keys) || node.Keys[i] != key {
+			return false
+		}
+		node.Keys = removeString(node.Keys, i)
+		node.Values = removeString(node.Values, i)
+		return true
+	}
+
+	i := upperBound(node.Keys, key)
+	if !t.delete(node.Children[i], key) {
+		return false
+	}
+
+	if len(node.Children[i].Keys) < t.minKeys() {
+		t.rebalance(node, i)
+	}
+	return true
+}
+
+// rebalance restores the minimum fill of parent.Children[i] by borrowing
+// from a sibling or merging with one.
+func (t *BPlusTree) rebalance(parent *BPlusNode, i int) {
+	if i > 0 && len(parent.Children[i-1].Keys) > t.minKeys() {
+		t.borrowFromPrev(parent, i)
+	} else if i < len(parent.Children)-1 && len(parent.Children[i+1].Keys) > t.minKeys() {
+		t.borrowFromNext(parent, i)
+	} else if i > 0 {
+		t.merge(parent, i-1)
+	} else {
+		t.merge(parent, i)
+	}
+}
+
+func (t *BPlusTree) borrowFromPrev(parent *BPlusNode, i int) {
+	child := parent.Children[i]
+	sibling := parent.Children[i-1]
+	last := len(sibling.Keys) - 1
+
+	if child.Leaf {
+		child.Keys = insertString(child.Keys, 0, sibling.Keys[last])
+		child.Values = insertString(child.Values, 0, sibling.Values[last])
+		sibling.Keys = sibling.Keys[:last]
+		sibling.Values = sibling.Values[:last]
+		parent.Keys[i-1] = child.Keys[0]
+		return
+	}
+
+	child.Keys = insertString(child.Keys, 0, parent.Keys[i-1])
+	child.Children = insertNode(child.Children, 0, sibling.Children[last+1])
+	parent.Keys[i-1] = sibling.Keys[last]
+	sibling.Keys = sibling.Keys[:last]
+	sibling.Children = sibling.Children[:last+1]
+}
+
+func (t *BPlusTree) borrowFromNext(parent *BPlusNode, i int) {
+	child := parent.Children[i]
+	sibling := parent.Children[i+1]
+
+	if child.Leaf {
+		child.Keys = append(child.Keys, sibling.Keys[0])
+		child.Values = append(child.Values, sibling.Values[0])
+		sibling.Keys = removeString(sibling.Keys, 0)
+		sibling.Values = removeString(sibling.Values, 0)
+		parent.Keys[i] = sibling.Keys[0]
+		return
+	}
+
+	child.Keys = append(child.Keys, parent.Keys[i])
+	child.Children = append(child.Children, sibling.Children[0])
+	parent.Keys[i] = sibling.Keys[0]
+	sibling.Keys = removeString(sibling.Keys, 0)
+	sibling.Children = removeNode(sibling.Children, 0)
+}
+
+// merge folds parent.Children[i+1] into parent.Children[i].
+func (t *BPlusTree) merge(parent *BPlusNode, i int) {
+	child := parent.Children[i]
+	sibling := parent.Children[i+1]
+
+	if child.Leaf {
+		child.Keys = append(child.Keys, sibling.Keys...)
+		child.Values = append(child.Values, sibling.Values...)
+		child.Next = sibling.Next
+		if sibling.Next != nil {
+			sibling.Next.Prev = child
+		}
+	} else {
+		child.Keys = append(child.Keys, parent.Keys[i])
+		child.Keys = append(child.Keys, sibling.Keys...)
+		child.Children = append(child.Children, sibling.Children...)
+	}
+
+	parent.Keys = removeString(parent.Keys, i)
+	parent.Children = removeNode(parent.Children, i+1)
+}
+
+// searchRange walks the leaf chain from the first key >= leftBound.
+func (t *BPlusTree) searchRange(leftBound, rightBound string, result *map[string]string) {
+	leaf := t.findLeaf(leftBound)
+	i := lowerBound(leaf.Keys, leftBound)
+
+	for leaf != nil {
+		for ; i < len(leaf.Keys); i++ {
+			if leaf.Keys[i] > rightBound {
+				return
+			}
+			(*result)[leaf.Keys[i]] = leaf.Values[i]
+		}
+		leaf = leaf.Next
+		i = 0
+	}
+}
+
+func (t *BPlusTree) put(key string, value string) error {
+	t.Insert(key, value)
+	return nil
+}
+
+func (t *BPlusTree) get(key string) (string, bool, error) {
+	value, found := t.Search(key)
+	return value, found, nil
+}
+
+func (t *BPlusTree) remove(key string) (bool, error) {
+	return t.Delete(key), nil
+}
+
+func (t *BPlusTree) rangeInto(leftBound string, rightBound string, result *map[string]string) error {
+	t.searchRange(leftBound, rightBound, result)
+	return nil
+}
+
+func (t *BPlusTree) cursor() (treeCursor, error) {
+	return &bplusCursor{t: t}, nil
+}
+
+// bplusCursor follows the leaf links; it is invalid once leaf is nil.
+type bplusCursor struct {
+	t    *BPlusTree
+	leaf *BPlusNode
+	i    int
+}
+
+func (c *bplusCursor) seek(key string) error {
+	c.leaf = c.t.findLeaf(key)
+	c.i = lowerBound(c.leaf.Keys, key)
+	for c.leaf != nil && c.i == len(c.leaf.Keys) {
+		c.leaf, c.i = c.leaf.Next, 0
+	}
+	return nil
+}
+
+func (c *bplusCursor) last() error {
+	c.leaf = c.t.lastLeaf()
+	c.i = len(c.leaf.Keys) - 1
+	if c.i < 0 {
+		c.leaf = nil
+	}
+	return nil
+}
+
+func (c *bplusCursor) next() error {
+	if !c.valid() {
+		return nil
+	}
+	c.i++
+	if c.i == len(c.leaf.Keys) {
+		c.leaf, c.i = c.leaf.Next, 0
+	}
+	return nil
+}
+
+func (c *bplusCursor) prev() error {
+	if !c.valid() {
+		return nil
+	}
+	c.i--
+	if c.i < 0 {
+		c.leaf = c.leaf.Prev
+		if c.leaf != nil {
+			c.i = len(c.leaf.Keys) - 1
+		}
+	}
+	return nil
+}
+
+func (c *bplusCursor) valid() bool   { return c.leaf != nil }
+func (c *bplusCursor) key() string   { return c.leaf.Keys[c.i] }
+func (c *bplusCursor) value() string { return c.leaf.Values[c.i] }
+
+func insertString(s []string, i int, v string) []string {
+	s = append(s, "")
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+func removeString(s []string, i int) []string {
+	copy(s[i:], s[i+1:])
+	return s[:len(s)-1]
+}
+
+func insertNode(s []*BPlusNode, i int, v *BPlusNode) []*BPlusNode {
+	s = append(s, nil)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+func removeNode(s []*BPlusNode, i int) []*BPlusNode {
+	copy(s[i:], s[i+1:])
+	s[len(s)-1] = nil
+	return s[:len(s)-1]
+}
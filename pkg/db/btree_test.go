@@ -0,0 +1,46 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestBTreeInsertReplacesExistingKey(t *testing.T) {
+	tree := NewBTree(2)
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 100; i++ {
+			tree.Insert(fmt.Sprintf("k%03d", i), fmt.Sprint(round))
+		}
+	}
+	if tree.count() != 100 {
+		t.Fatalf("tree counts %d keys, want 100", tree.count())
+	}
+	if value, found := tree.Search("k050"); !found || value != "2" {
+		t.Fatalf("k050 is %q, %v, want the latest value", value, found)
+	}
+	if !tree.Delete("k050") {
+		t.Fatal("deleting k050 failed")
+	}
+	if _, found := tree.Search("k050"); found {
+		t.Fatal("k050 is still there after being deleted once")
+	}
+}
+
+func TestBTreeSearchRangeCoversEveryChild(t *testing.T) {
+	tree := NewBTree(2)
+	for i := 0; i < 500; i++ {
+		tree.Insert(fmt.Sprintf("k%03d", i), fmt.Sprint(i))
+	}
+	for _, bounds := range [][2]int{{0, 499}, {17, 342}, {250, 251}, {498, 499}} {
+		want := make(map[string]string)
+		for i := bounds[0]; i <= bounds[1]; i++ {
+			want[fmt.Sprintf("k%03d", i)] = fmt.Sprint(i)
+		}
+		got := make(map[string]string)
+		tree.Root.searchRange(fmt.Sprintf("k%03d", bounds[0]), fmt.Sprintf("k%03d", bounds[1]), tree.compare, &got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("range %v holds %d keys, want %d", bounds, len(got), len(want))
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package db
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"sort"
+)
+
+var ErrCorruptPage = NewError(CodeInternal, "corrupt page")
+
+// Every page starts with a CRC-32 of the rest of the page and a type byte.
+const (
+	pageChecksumSize = 4
+	pageTypeOffset   = 4
+
+	pageTypeHeader   = 1
+	pageTypeNode     = 2
+	pageTypeOverflow = 3
+	pageTypeFree     = 4
+
+	// pagerCheckpointBytes is how large the write-ahead log of a pager
+	// grows before the file is fsynced and the log emptied.
+	pagerCheckpointBytes = 16 << 20
+)
+
+// pager reads and writes fixed-size, checksummed pages of a single file.
+// Written pages are held back until commit, which logs them all to a
+// write-ahead log before any of them overwrites the file, so that a crash
+// leaves the file as of one commit or the next and never in between.
+//
+// Log records are a CRC-32 and length followed by the pages of one
+// commit, each as its id and its bytes. Replay stops at the first torn or
+// corrupt record, which belongs to a commit that never returned.
+type pager struct {
+	file     *os.File
+	pageSize int
+	log      *os.File
+	logSize  int64
+	pending  map[uint32][]byte
+}
+
+func openPager(path string, logPath string, pageSize int) (*pager, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	p := &pager{file: file, pageSize: pageSize, log: logFile, pending: make(map[uint32][]byte)}
+	if err := p.replay(); err != nil {
+		p.close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// replay writes the pages of every complete commit in the log to the file
+// and empties the log.
+func (p *pager) replay() error {
+	data, err := io.ReadAll(p.log)
+	if err != nil {
+		return err
+	}
+
+	good := 0
+	for len(data)-good >= 8 {
+		checksum := binary.LittleEndian.Uint32(data[good:])
+		length := int(binary.LittleEndian.Uint32(data[good+4:]))
+		if len(data)-good-8 < length || length%(4+p.pageSize) != 0 {
+			break
+		}
+		payload := data[good+8 : good+8+length]
+		if crc32.ChecksumIEEE(payload) != checksum {
+			break
+		}
+		for len(payload) > 0 {
+			id := binary.LittleEndian.Uint32(payload)
+			if _, err := p.file.WriteAt(payload[4:4+p.pageSize], int64(id)*int64(p.pageSize)); err != nil {
+				return err
+			}
+			payload = payload[4+p.pageSize:]
+		}
+		good += 8 + length
+	}
+	if good < len(data) {
+		log.Printf("pager %s: discarding %d bytes of torn write-ahead log", p.file.Name(), len(data)-good)
+	}
+	return p.checkpoint()
+}
+
+func (p *pager) readPage(id uint32, pageType byte) ([]byte, error) {
+	page, ok := p.pending[id]
+	if !ok {
+		page = make([]byte, p.pageSize)
+		if _, err := p.file.ReadAt(page, int64(id)*int64(p.pageSize)); err != nil {
+			return nil, err
+		}
+	}
+
+	checksum := binary.LittleEndian.Uint32(page)
+	if crc32.ChecksumIEEE(page[pageChecksumSize:]) != checksum {
+		return nil, fmt.Errorf("page %d: %w (checksum mismatch)", id, ErrCorruptPage)
+	}
+	if page[pageTypeOffset] != pageType {
+		return nil, fmt.Errorf("page %d: %w (type %d, expected %d)", id, ErrCorruptPage, page[pageTypeOffset], pageType)
+	}
+	return page, nil
+}
+
+// writePage holds page back until the next commit, reading it back
+// meanwhile to whoever asks for id.
+func (p *pager) writePage(id uint32, page []byte) error {
+	binary.LittleEndian.PutUint32(page, crc32.ChecksumIEEE(page[pageChecksumSize:]))
+	p.pending[id] = page
+	return nil
+}
+
+// dirty reports whether pages were written since the last commit.
+func (p *pager) dirty() bool {
+	return len(p.pending) > 0
+}
+
+// commit makes the pages written since the last commit durable as one
+// unit: it logs and fsyncs them, then writes them to the file. The file
+// is only fsynced at a checkpoint, once the log has grown large enough.
+func (p *pager) commit() error {
+	if len(p.pending) == 0 {
+		return nil
+	}
+	ids := make([]uint32, 0, len(p.pending))
+	for id := range p.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	record := make([]byte, 8, 8+len(ids)*(4+p.pageSize))
+	for _, id := range ids {
+		record = binary.LittleEndian.AppendUint32(record, id)
+		record = append(record, p.pending[id]...)
+	}
+	binary.LittleEndian.PutUint32(record[0:], crc32.ChecksumIEEE(record[8:]))
+	binary.LittleEndian.PutUint32(record[4:], uint32(len(record)-8))
+	if _, err := p.log.Write(record); err != nil {
+		return err
+	}
+	if err := p.log.Sync(); err != nil {
+		return err
+	}
+	p.logSize += int64(len(record))
+
+	for _, id := range ids {
+		if _, err := p.file.WriteAt(p.pending[id], int64(id)*int64(p.pageSize)); err != nil {
+			return err
+		}
+	}
+	p.pending = make(map[uint32][]byte)
+
+	if p.logSize >= pagerCheckpointBytes {
+		return p.checkpoint()
+	}
+	return nil
+}
+
+// checkpoint fsyncs the file, which then holds every commit, and empties
+// the log.
+func (p *pager) checkpoint() error {
+	if err := p.file.Sync(); err != nil {
+		return err
+	}
+	if err := p.log.Truncate(0); err != nil {
+		return err
+	}
+	p.logSize = 0
+	return p.log.Sync()
+}
+
+func (p *pager) close() error {
+	err := p.file.Close()
+	if logErr := p.log.Close(); err == nil {
+		err = logErr
+	}
+	return err
+}
+
+// bufferPool is an LRU cache of decoded B-tree nodes. Modified nodes are
+// only written back when evicted or flushed. Nodes touched by an operation
+// stay cached until release is called at its end, so the tree never holds
+// a node that has been evicted underneath it.
+type bufferPool struct {
+	capacity int
+	frames   map[uint32]*list.Element
+	lru      *list.List
+	load     func(id uint32) (*pagedNode, error)
+	store    func(node *pagedNode) error
+}
+
+func newBufferPool(capacity int, load func(uint32) (*pagedNode, error), store func(*pagedNode) error) *bufferPool {
+	return &bufferPool{
+		capacity: capacity,
+		frames:   make(map[uint32]*list.Element),
+		lru:      list.New(),
+		load:     load,
+		store:    store,
+	}
+}
+
+func (b *bufferPool) get(id uint32) (*pagedNode, error) {
+	if elem, ok := b.frames[id]; ok {
+		b.lru.MoveToFront(elem)
+		return elem.Value.(*pagedNode), nil
+	}
+
+	node, err := b.load(id)
+	if err != nil {
+		return nil, err
+	}
+	b.frames[id] = b.lru.PushFront(node)
+	return node, nil
+}
+
+// add caches a newly allocated node.
+func (b *bufferPool) add(node *pagedNode) {
+	node.dirty = true
+	b.frames[node.id] = b.lru.PushFront(node)
+}
+
+// drop forgets a node whose page has been freed.
+func (b *bufferPool) drop(id uint32) {
+	if elem, ok := b.frames[id]; ok {
+		b.lru.Remove(elem)
+		delete(b.frames, id)
+	}
+}
+
+// release evicts least recently used nodes, writing back dirty ones, until
+// the pool is within capacity again.
+func (b *bufferPool) release() error {
+	for b.lru.Len() > b.capacity {
+		elem := b.lru.Back()
+		node := elem.Value.(*pagedNode)
+		if node.dirty {
+			if err := b.store(node); err != nil {
+				return err
+			}
+			node.dirty = false
+		}
+		b.lru.Remove(elem)
+		delete(b.frames, node.id)
+	}
+	return nil
+}
+
+// flush writes back every dirty node without evicting anything.
+func (b *bufferPool) flush() error {
+	for elem := b.lru.Front(); elem != nil; elem = elem.Next() {
+		node := elem.Value.(*pagedNode)
+		if node.dirty {
+			if err := b.store(node); err != nil {
+				return err
+			}
+			node.dirty = false
+		}
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package db
+
+import "fmt"
+
+// bulkLoader is implemented by the in-memory balanced trees, which can be
+// built directly from sorted, distinct keys in O(n) instead of by n
+// inserts. bulkLoad replaces the whole tree.
+type bulkLoader interface {
+	bulkLoad(keys []string, values []string)
+}
+
+func (t *AVLTree) bulkLoad(keys []string, values []string) {
+	var build func(lo, hi int) *AVLNode
+	build = func(lo, hi int) *AVLNode {
+		if lo >= hi {
+			return nil
+		}
+		mid := lo + (hi-lo)/2
+		node := &AVLNode{Key: keys[mid], Value: values[mid]}
+		node.Left = build(lo, mid)
+		node.Right = build(mid+1, hi)
+		node.update()
+		return node
+	}
+	t.Root = build(0, len(keys))
+}
+
+// bulkLoad builds a perfectly balanced tree, whose leaves all lie on the
+// last two levels. Colouring the nodes of the last level red, when it is
+// not the root's, gives every path the same number of black nodes.
+func (t *RedBlackTree) bulkLoad(keys []string, values []string) {
+	depth := 0
+	for n := len(keys); n > 1; n /= 2 {
+		depth++
+	}
+
+	var build func(lo, hi, level int, parent *RBNode) *RBNode
+	build = func(lo, hi, level int, parent *RBNode) *RBNode {
+		if lo >= hi {
+			return t.NIL
+		}
+		mid := lo + (hi-lo)/2
+		node := &RBNode{Key: keys[mid], Value: values[mid], Color: BLACK, Size: hi - lo, Parent: parent}
+		if level == depth && level > 0 {
+			node.Color = RED
+		}
+		node.Left = build(lo, mid, level+1, node)
+		node.Right = build(mid+1, hi, level+1, node)
+		return node
+	}
+	t.Root = build(0, len(keys), 0, t.NIL)
+}
+
+// bulkLoad builds the tree a level at a time from the leaves up. Each
+// level splits its keys evenly between as few nodes as hold them, moving
+// one key between each pair of nodes up to the next level, which keeps
+// every node but the root at least half full.
+func (t *BTree) bulkLoad(keys []string, values []string) {
+	var children []*BTreeNode
+	for {
+		nodes, upKeys, upValues := t.buildLevel(keys, values, children)
+		if len(nodes) == 1 {
+			t.Root = nodes[0]
+			return
+		}
+		keys, values, children = upKeys, upValues, nodes
+	}
+}
+
+// buildLevel packs keys into nodes holding children, one more per node
+// than it has keys, and returns the nodes and the keys that separate them.
+func (t *BTree) buildLevel(keys []string, values []string, children []*BTreeNode) ([]*BTreeNode, []string, []string) {
+	maxKeys := 2*t.MinDeg - 1
+	nodes := (len(keys) + 1 + maxKeys) / (maxKeys + 1)
+	perNode := (len(keys) - (nodes - 1)) / nodes
+	extra := (len(keys) - (nodes - 1)) % nodes
+
+	level := make([]*BTreeNode, 0, nodes)
+	var upKeys, upValues []string
+	k, c := 0, 0
+	for i := 0; i < nodes; i++ {
+		node := NewBTreeNode(t.MinDeg, children == nil)
+		node.n = perNode
+		if i < extra {
+			node.n++
+		}
+		copy(node.Keys, keys[k:k+node.n])
+		copy(node.Values, values[k:k+node.n])
+		k += node.n
+		if children != nil {
+			copy(node.Children, children[c:c+node.n+1])
+			c += node.n + 1
+		}
+		node.recount()
+		level = append(level, node)
+
+		if i < nodes-1 {
+			upKeys = append(upKeys, keys[k])
+			upValues = append(upValues, values[k])
+			k++
+		}
+	}
+	return level, upKeys, upValues
+}
+
+// importEntry is one key of an import. deadline is when it expires, in
+// Unix nanoseconds, or 0.
+type importEntry struct {
+	key      string
+	value    string
+	deadline int64
+}
+
+// load writes entries under one write lock, after validating all of
+// them. Entries that have already expired are skipped.
+func (tc *TreeCollection) load(entries []importEntry) error {
+	for _, entry := range entries {
+		if err := tc.validateWrite(entry.key, entry.value); err != nil {
+			return fmt.Errorf("key %q: %w", entry.key, err)
+		}
+	}
+
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	now := tc.clock.now().UnixNano()
+	for _, entry := range entries {
+		if entry.deadline != 0 && entry.deadline <= now {
+			continue
+		}
+		if err := tc.write(entry.key, entry.value, entry.deadline); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importer writes the entries of an import to a collection as they are
+// read, in batches of importBatchSize. With bulk set, an empty collection
+// whose tree can be bulk loaded instead collects the keys and values of
+// entries that arrive in key order, as exported ones do, and is built
+// from them at the end; an entry with the same key as the one before it
+// replaces it. The first entry out of order ends the collecting: the tree
+// is built from what came before, and it and the rest are written in
+// batches.
+type importer struct {
+	tc        *TreeCollection
+	sorted    bool
+	keys      []string
+	values    []string
+	deadlines []int64
+	pending   []importEntry
+}
+
+func (tc *TreeCollection) newImporter(bulk bool) *importer {
+	im := &importer{tc: tc}
+	if _, ok := tc.tree.(bulkLoader); ok && bulk {
+		tc.mutex.RLock()
+		im.sorted = tc.tree.(orderStatistics).count() == 0
+		tc.mutex.RUnlock()
+	}
+	return im
+}
+
+// add takes the next entry of the import.
+func (im *importer) add(entry importEntry) error {
+	if err := im.tc.validateWrite(entry.key, entry.value); err != nil {
+		return fmt.Errorf("key %q: %w", entry.key, err)
+	}
+
+	if im.sorted {
+		last := len(im.keys) - 1
+		order := 1
+		if last >= 0 {
+			order = im.tc.compare(entry.key, im.keys[last])
+		}
+		if order > 0 {
+			im.keys = append(im.keys, entry.key)
+			im.values = append(im.values, entry.value)
+			im.deadlines = append(im.deadlines, entry.deadline)
+			return nil
+		}
+		if order == 0 {
+			im.keys[last], im.values[last], im.deadlines[last] = entry.key, entry.value, entry.deadline
+			return nil
+		}
+		if err := im.bulkLoad(); err != nil {
+			return err
+		}
+	}
+
+	im.pending = append(im.pending, entry)
+	if len(im.pending) >= importBatchSize {
+		return im.flush()
+	}
+	return nil
+}
+
+// flush writes whatever add has held back.
+func (im *importer) flush() error {
+	if im.sorted {
+		return im.bulkLoad()
+	}
+	err := im.tc.load(im.pending)
+	im.pending = im.pending[:0]
+	return err
+}
+
+// bulkLoad ends the collecting and builds the tree from the entries
+// collected, or writes them one by one if the collection is no longer
+// empty.
+func (im *importer) bulkLoad() error {
+	tc := im.tc
+	keys, values, deadlines := im.keys, im.values, im.deadlines
+	im.sorted, im.keys, im.values, im.deadlines = false, nil, nil, nil
+	if len(keys) == 0 {
+		return nil
+	}
+
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	now := tc.clock.now().UnixNano()
+	if tc.tree.(orderStatistics).count() > 0 {
+		for i, key := range keys {
+			if deadlines[i] != 0 && deadlines[i] <= now {
+				continue
+			}
+			if err := tc.write(key, values[i], deadlines[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	live := 0
+	for i, key := range keys {
+		if deadlines[i] != 0 && deadlines[i] <= now {
+			continue
+		}
+		keys[live], values[live] = key, values[i]
+		live++
+		if deadlines[i] != 0 {
+			if err := tc.expiry.set(key, deadlines[i]); err != nil {
+				return err
+			}
+			tc.startSweeper()
+		}
+		if err := tc.versions.record(key, values[i], false, now); err != nil {
+			return err
+		}
+		tc.changes.record(Mutation{Op: MutationPut, Key: key, Value: values[i], Deadline: deadlines[i], Time: now})
+	}
+	tc.tree.(bulkLoader).bulkLoad(keys[:live], values[:live])
+	return tc.buildIndexes()
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"DB_II/pkg/interfaces"
+	"fmt"
+)
+
+var ErrConditionFailed = NewError(CodeConditionFailed, "condition failed")
+
+var _ interfaces.ConditionalCollection = (*TreeCollection)(nil)
+
+// ConditionError is returned by a conditional write whose condition did
+// not hold. It carries the key's current state, so the caller can retry
+// without another read; Version is 0 in a collection that does not number
+// versions.
+type ConditionError struct {
+	Found   bool   `json:"found"`
+	Value   string `json:"value"`
+	Version int64  `json:"version"`
+}
+
+func (e *ConditionError) Error() string {
+	if !e.Found {
+		return fmt.Sprintf("%s: key does not exist", ErrConditionFailed.Message)
+	}
+	return fmt.Sprintf("%s: key is at version %d", ErrConditionFailed.Message, e.Version)
+}
+
+func (e *ConditionError) Unwrap() error {
+	return ErrConditionFailed
+}
+
+// current returns key's value and version, deleting it first if it has
+// expired. The caller holds the write lock.
+func (tc *TreeCollection) current(key string) (*ConditionError, error) {
+	if tc.expiry.expired(key, tc.clock.now()) {
+		if err := tc.removeExpired(key); err != nil {
+			return nil, err
+		}
+	}
+
+	_, value, found, err := tc.stored(key)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return &ConditionError{}, nil
+	}
+	return &ConditionError{Found: true, Value: value, Version: tc.versions.current(key)}, nil
+}
+
+// GetVersioned returns key's value and the number of its version.
+func (tc *TreeCollection) GetVersioned(key string) (string, int64, error) {
+	if !tc.versions.numbered() {
+		return "", 0, ErrVersionsDisabled
+	}
+	if err := tc.config.validateKey(key); err != nil {
+		return "", 0, err
+	}
+
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	state, err := tc.current(key)
+	if err != nil {
+		return "", 0, err
+	}
+	if !state.Found {
+		return "", 0, ErrKeyNotFound
+	}
+	return state.Value, state.Version, nil
+}
+
+// SetIfAbsent writes key only if it does not exist and returns the number
+// of the version it wrote, which is 0 in a collection that does not number
+// versions.
+func (tc *TreeCollection) SetIfAbsent(key string, value string) (int64, error) {
+	return tc.writeIf(key, value, func(state *ConditionError) bool {
+		return !state.Found
+	})
+}
+
+// UpdateIfEquals replaces key's value only if it is currently expected.
+func (tc *TreeCollection) UpdateIfEquals(key string, expected string, value string) (int64, error) {
+	return tc.writeIf(key, value, func(state *ConditionError) bool {
+		return state.Found && state.Value == expected
+	})
+}
+
+// UpdateIfVersion replaces key's value only if it is currently at the
+// given version.
+func (tc *TreeCollection) UpdateIfVersion(key string, version int64, value string) (int64, error) {
+	if !tc.versions.numbered() {
+		return 0, ErrVersionsDisabled
+	}
+	return tc.writeIf(key, value, func(state *ConditionError) bool {
+		return state.Found && state.Version == version
+	})
+}
+
+// writeIf writes key like Set if holds accepts its current state, and
+// otherwise fails with that state.
+func (tc *TreeCollection) writeIf(key string, value string, holds func(state *ConditionError) bool) (int64, error) {
+	if err := tc.validateWrite(key, value); err != nil {
+		return 0, err
+	}
+
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	state, err := tc.current(key)
+	if err != nil {
+		return 0, err
+	}
+	if !holds(state) {
+		return 0, state
+	}
+	if err := tc.write(key, value, 0); err != nil {
+		return 0, err
+	}
+	return tc.versions.current(key), nil
+}
+
+// DeleteIfEquals deletes key only if its value is currently expected.
+func (tc *TreeCollection) DeleteIfEquals(key string, expected string) error {
+	if err := tc.config.validateKey(key); err != nil {
+		return err
+	}
+
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	state, err := tc.current(key)
+	if err != nil {
+		return err
+	}
+	if !state.Found || state.Value != expected {
+		return state
+	}
+	return tc.remove(key)
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConditionalWrites(t *testing.T) {
+	tc := newTestCollection(t, TreeTypeAVL, CollectionOptions{Versioned: true}, "")
+	if version, err := tc.SetIfAbsent("a", "1"); err != nil || version != 1 {
+		t.Fatalf("SetIfAbsent wrote version %d, %v, want 1", version, err)
+	}
+
+	// a failed condition reports the key's current state
+	var state *ConditionError
+	if _, err := tc.SetIfAbsent("a", "2"); !errors.As(err, &state) || !errors.Is(err, ErrConditionFailed) {
+		t.Fatalf("SetIfAbsent of an existing key returned %v", err)
+	}
+	if *state != (ConditionError{Found: true, Value: "1", Version: 1}) {
+		t.Errorf("failed condition carries %+v", *state)
+	}
+
+	if version, err := tc.UpdateIfEquals("a", "1", "2"); err != nil || version != 2 {
+		t.Fatalf("UpdateIfEquals wrote version %d, %v, want 2", version, err)
+	}
+	if _, err := tc.UpdateIfEquals("a", "1", "3"); !errors.As(err, &state) || state.Value != "2" {
+		t.Errorf("UpdateIfEquals of a stale value returned %v", err)
+	}
+	if version, err := tc.UpdateIfVersion("a", 2, "3"); err != nil || version != 3 {
+		t.Fatalf("UpdateIfVersion wrote version %d, %v, want 3", version, err)
+	}
+	if _, err := tc.UpdateIfVersion("a", 2, "4"); !errors.As(err, &state) || state.Version != 3 {
+		t.Errorf("UpdateIfVersion of a stale version returned %v", err)
+	}
+
+	if err := tc.DeleteIfEquals("a", "2"); !errors.Is(err, ErrConditionFailed) {
+		t.Errorf("DeleteIfEquals of a stale value returned %v", err)
+	}
+	if err := tc.DeleteIfEquals("a", "3"); err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range []error{
+		tc.DeleteIfEquals("a", "3"),
+		func() error { _, err := tc.UpdateIfEquals("a", "3", "4"); return err }(),
+		func() error { _, err := tc.UpdateIfVersion("a", 3, "4"); return err }(),
+	} {
+		if !errors.As(err, &state) || state.Found {
+			t.Errorf("a condition on a deleted key returned %v", err)
+		}
+	}
+	if _, err := tc.SetIfAbsent("a", "again"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tc.SetIfAbsent("", "x"); CodeOf(err) != CodeInvalidArgument {
+		t.Errorf("SetIfAbsent of an empty key returned %v", err)
+	}
+}
+
+func TestSetIfAbsentReplacesExpiredKey(t *testing.T) {
+	tc := newTestCollection(t, TreeTypeAVL, CollectionOptions{}, "")
+	tc.clock.set(time.Now().Add(-time.Hour))
+	if err := tc.SetWithTTL("a", "a", "old", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	tc.clock.set(time.Now())
+	if version, err := tc.SetIfAbsent("a", "new"); err != nil || version != 0 {
+		t.Fatalf("SetIfAbsent of an expired key wrote version %d, %v", version, err)
+	}
+	if value, err := tc.Get("a"); err != nil || value != "new" {
+		t.Errorf("a is %q, %v, want new", value, err)
+	}
+}
+
+func TestUpdateIfVersionSerializesWriters(t *testing.T) {
+	tc := newTestCollection(t, TreeTypeAVL, CollectionOptions{Versioned: true}, "")
+	if _, err := tc.SetIfAbsent("n", "0"); err != nil {
+		t.Fatal(err)
+	}
+
+	// every writer retries on conflict, so no increment is lost
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				for {
+					value, version, err := tc.GetVersioned("n")
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					n, _ := strconv.Atoi(value)
+					if _, err := tc.UpdateIfVersion("n", version, strconv.Itoa(n+1)); err == nil {
+						break
+					} else if !errors.Is(err, ErrConditionFailed) {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if value, version, err := tc.GetVersioned("n"); err != nil || value != "400" || version != 401 {
+		t.Errorf("n is %s at version %d, %v, want 400 at 401", value, version, err)
+	}
+}
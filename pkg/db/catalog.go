@@ -0,0 +1,125 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Disk-backed collections live in <DataDir>/<pool>/<schema>/<collection>
+// next to a metadata file, which is enough to rebuild the hierarchy that
+// holds them after a restart.
+const collectionMetaFile = "collection.json"
+
+type collectionMeta struct {
+	TreeType TreeType
+	Options  CollectionOptions
+}
+
+func (db *Database) collectionDir(poolName, schemaName, collectionName string) string {
+	return filepath.Join(db.Config.DataDir, poolName, schemaName, collectionName)
+}
+
+func collectionMetaExists(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, collectionMetaFile))
+	return err == nil
+}
+
+func writeCollectionMeta(dir string, tc *TreeCollection) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(collectionMeta{TreeType: tc.TreeType, Options: tc.Options}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, collectionMetaFile), data)
+}
+
+// LoadCollections reopens every disk-backed collection found in the data
+// directory, creating the pools and schemas that contain them, and reads
+// the catalog of sharded collections.
+func (db *Database) LoadCollections() error {
+	paths, err := filepath.Glob(filepath.Join(db.Config.DataDir, "*", "*", "*", collectionMetaFile))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		dir := filepath.Dir(path)
+		collectionName := filepath.Base(dir)
+		schemaName := filepath.Base(filepath.Dir(dir))
+		poolName := filepath.Base(filepath.Dir(filepath.Dir(dir)))
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var meta collectionMeta
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+
+		collection, err := NewTreeCollection(meta.TreeType, meta.Options, db.Config, dir)
+		if err != nil {
+			return fmt.Errorf("%s: %w", dir, err)
+		}
+
+		db.mutex.Lock()
+		pool, exists := db.Pools[poolName]
+		if !exists {
+			pool = NewDataPool(poolName)
+			db.Pools[poolName] = pool
+		}
+		db.mutex.Unlock()
+
+		pool.mutex.Lock()
+		schema, exists := pool.Schemas[schemaName]
+		if !exists {
+			schema = NewDataSchema(schemaName)
+			pool.Schemas[schemaName] = schema
+		}
+		pool.mutex.Unlock()
+
+		collection.join(db.replication.collection(poolName, schemaName, collectionName), db.clock)
+		schema.mutex.Lock()
+		schema.Collections[collectionName] = collection
+		schema.mutex.Unlock()
+	}
+	return db.shards.load()
+}
+
+// Close stops following any leader and closes every collection,
+// returning the first error.
+func (db *Database) Close() error {
+	if follower := db.following(); follower != nil {
+		follower.Stop()
+	}
+	db.shards.close()
+	var firstErr error
+	if node := db.Raft(); node != nil {
+		firstErr = node.Close()
+	}
+
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	for _, pool := range db.Pools {
+		pool.mutex.RLock()
+		for _, schema := range pool.Schemas {
+			schema.mutex.RLock()
+			for _, collection := range schema.Collections {
+				if closer, ok := collection.(interface{ Close() error }); ok {
+					if err := closer.Close(); err != nil && firstErr == nil {
+						firstErr = err
+					}
+				}
+			}
+			schema.mutex.RUnlock()
+		}
+		pool.mutex.RUnlock()
+	}
+	return firstErr
+}
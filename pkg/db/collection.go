@@ -0,0 +1,560 @@
+package db
+
+import (
+	"DB_II/pkg/interfaces"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+type TreeType string
+
+const (
+	TreeTypeAVL        TreeType = "avl"
+	TreeTypeRedBlack   TreeType = "redblack"
+	TreeTypeBTree      TreeType = "btree"
+	TreeTypeBPlusTree  TreeType = "bplustree"
+	TreeTypeSkipList   TreeType = "skiplist"
+	TreeTypeHash       TreeType = "hash"
+	TreeTypeLSM        TreeType = "lsm"
+	TreeTypePagedBTree TreeType = "pagedbtree"
+)
+
+var (
+	ErrKeyNotFound      = NewError(CodeNotFound, "key not found")
+	ErrUnknownTreeType  = NewError(CodeInvalidArgument, "unknown tree type")
+	ErrInvalidOptions   = NewError(CodeInvalidArgument, "invalid collection options")
+	ErrRangeUnsupported = NewError(CodeUnsupported, "range queries are not supported by this collection")
+)
+
+var treeTypes = []TreeType{TreeTypeAVL, TreeTypeRedBlack, TreeTypeBTree, TreeTypeBPlusTree, TreeTypeSkipList, TreeTypeHash, TreeTypeLSM, TreeTypePagedBTree}
+
+func (t TreeType) validate() error {
+	for _, known := range treeTypes {
+		if t == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %q (expected one of %v)", ErrUnknownTreeType, string(t), treeTypes)
+}
+
+// persistent reports whether collections of this type live in the data
+// directory and survive restarts.
+func (t TreeType) persistent() bool {
+	return t == TreeTypeLSM || t == TreeTypePagedBTree
+}
+
+const (
+	DefaultBTreeMinDegree   = 3
+	MaxBTreeMinDegree       = 1024
+	DefaultSkipListMaxLevel = 16
+	MaxSkipListMaxLevel     = 64
+	DefaultLSMMemtableSize  = 4 << 20
+	MinLSMMemtableSize      = 64 << 10
+	MinBufferPoolPages      = 16
+)
+
+// CollectionOptions are per-collection settings chosen at creation time.
+// Zero values select the defaults.
+type CollectionOptions struct {
+	// BTreeMinDegree applies to btree and bplustree collections.
+	BTreeMinDegree int
+	// SkipListMaxLevel applies to skiplist collections.
+	SkipListMaxLevel int
+	// HashRangeScan lets GetRange on a hash collection fall back to a full
+	// scan instead of failing with ErrRangeUnsupported.
+	HashRangeScan bool
+	// LSMMemtableSize is the number of bytes an lsm collection buffers in
+	// memory before flushing them to a table file.
+	LSMMemtableSize int
+	// PageSize and BufferPoolPages apply to pagedbtree collections: the
+	// size of each page of the file and how many pages are cached.
+	PageSize        int
+	BufferPoolPages int
+	// Comparator orders the keys. Only avl, redblack and btree collections
+	// can order them other than bytewise.
+	Comparator Comparator
+	// ValueType is the type Set and Update require values to have. A json
+	// collection holds documents: RequiredFields lists the field paths
+	// every document must hold and Indexes the paths to keep secondary
+	// indexes on.
+	ValueType      ValueType
+	RequiredFields []string
+	Indexes        []string
+	// HistoryVersions and HistoryRetentionMillis make the collection keep
+	// past versions of each key: at most that many, or those replaced
+	// within that many milliseconds. Either may be 0 for no bound, and
+	// both are 0 for no history.
+	HistoryVersions        int
+	HistoryRetentionMillis int
+	// Versioned makes the collection number the versions of its keys, for
+	// GetVersioned and UpdateIfVersion, without keeping history.
+	// Collections with history always number them.
+	Versioned bool
+}
+
+func (o *CollectionOptions) normalize(treeType TreeType) error {
+	if treeType == TreeTypeBTree || treeType == TreeTypeBPlusTree {
+		if o.BTreeMinDegree == 0 {
+			o.BTreeMinDegree = DefaultBTreeMinDegree
+		}
+		if o.BTreeMinDegree < 2 || o.BTreeMinDegree > MaxBTreeMinDegree {
+			return fmt.Errorf("%w: minimum degree %d must be between 2 and %d", ErrInvalidOptions, o.BTreeMinDegree, MaxBTreeMinDegree)
+		}
+	} else if o.BTreeMinDegree != 0 {
+		return fmt.Errorf("%w: only btree and bplustree collections have a minimum degree", ErrInvalidOptions)
+	}
+
+	if treeType == TreeTypeSkipList {
+		if o.SkipListMaxLevel == 0 {
+			o.SkipListMaxLevel = DefaultSkipListMaxLevel
+		}
+		if o.SkipListMaxLevel < 1 || o.SkipListMaxLevel > MaxSkipListMaxLevel {
+			return fmt.Errorf("%w: skip list max level %d must be between 1 and %d", ErrInvalidOptions, o.SkipListMaxLevel, MaxSkipListMaxLevel)
+		}
+	} else if o.SkipListMaxLevel != 0 {
+		return fmt.Errorf("%w: only skiplist collections have a max level", ErrInvalidOptions)
+	}
+
+	if treeType != TreeTypeHash && o.HashRangeScan {
+		return fmt.Errorf("%w: range scan fallback only applies to hash collections", ErrInvalidOptions)
+	}
+
+	if treeType == TreeTypeLSM {
+		if o.LSMMemtableSize == 0 {
+			o.LSMMemtableSize = DefaultLSMMemtableSize
+		}
+		if o.LSMMemtableSize < MinLSMMemtableSize {
+			return fmt.Errorf("%w: memtable size %d must be at least %d bytes", ErrInvalidOptions, o.LSMMemtableSize, MinLSMMemtableSize)
+		}
+	} else if o.LSMMemtableSize != 0 {
+		return fmt.Errorf("%w: only lsm collections have a memtable", ErrInvalidOptions)
+	}
+
+	if treeType == TreeTypePagedBTree {
+		if o.PageSize == 0 {
+			o.PageSize = DefaultPageSize
+		}
+		if o.PageSize < MinPageSize || o.PageSize > MaxPageSize {
+			return fmt.Errorf("%w: page size %d must be between %d and %d", ErrInvalidOptions, o.PageSize, MinPageSize, MaxPageSize)
+		}
+		if o.BufferPoolPages == 0 {
+			o.BufferPoolPages = DefaultBufferPoolPages
+		}
+		if o.BufferPoolPages < MinBufferPoolPages {
+			return fmt.Errorf("%w: buffer pool of %d pages must hold at least %d", ErrInvalidOptions, o.BufferPoolPages, MinBufferPoolPages)
+		}
+	} else if o.PageSize != 0 || o.BufferPoolPages != 0 {
+		return fmt.Errorf("%w: only pagedbtree collections have pages", ErrInvalidOptions)
+	}
+
+	if o.Comparator == "" {
+		o.Comparator = ComparatorBytewise
+	}
+	if err := o.Comparator.validate(); err != nil {
+		return err
+	}
+	if o.Comparator != ComparatorBytewise && treeType != TreeTypeAVL && treeType != TreeTypeRedBlack && treeType != TreeTypeBTree {
+		return fmt.Errorf("%w: only avl, redblack and btree collections can use the %s comparator", ErrInvalidOptions, o.Comparator)
+	}
+
+	if err := o.ValueType.validate(); err != nil {
+		return err
+	}
+	if o.ValueType != ValueTypeJSON && (len(o.RequiredFields) > 0 || len(o.Indexes) > 0) {
+		return fmt.Errorf("%w: only json values have fields", ErrInvalidOptions)
+	}
+	for _, path := range append(o.RequiredFields, o.Indexes...) {
+		if _, err := parseFieldPath(path); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidOptions, err)
+		}
+	}
+
+	if o.HistoryVersions < 0 || o.HistoryVersions > MaxHistoryVersions {
+		return fmt.Errorf("%w: history of %d versions must be between 0 and %d", ErrInvalidOptions, o.HistoryVersions, MaxHistoryVersions)
+	}
+	if o.HistoryRetentionMillis < 0 {
+		return fmt.Errorf("%w: history retention %dms cannot be negative", ErrInvalidOptions, o.HistoryRetentionMillis)
+	}
+
+	return nil
+}
+
+// orderedTree is the index structure behind a TreeCollection. The
+// collection serializes put and remove, but get, rangeInto and cursors
+// run concurrently under its read lock, so they must not modify the tree
+// unless it does its own locking. The hash index keeps no order and
+// answers rangeInto with a full scan. Only disk-backed trees ever return
+// errors.
+type orderedTree interface {
+	put(key string, value string) error
+	get(key string) (string, bool, error)
+	remove(key string) (bool, error)
+	rangeInto(leftBound string, rightBound string, result *map[string]string) error
+	cursor() (treeCursor, error)
+}
+
+func newOrderedTree(treeType TreeType, options CollectionOptions, config Config, dir string) (orderedTree, error) {
+	switch treeType {
+	case TreeTypeAVL:
+		tree := NewAVLTree()
+		tree.compare = options.Comparator.compareFunc()
+		return tree, nil
+	case TreeTypeRedBlack:
+		tree := NewRedBlackTree()
+		tree.compare = options.Comparator.compareFunc()
+		return tree, nil
+	case TreeTypeBTree:
+		tree := NewBTree(options.BTreeMinDegree)
+		tree.compare = options.Comparator.compareFunc()
+		return tree, nil
+	case TreeTypeBPlusTree:
+		return NewBPlusTree(options.BTreeMinDegree), nil
+	case TreeTypeSkipList:
+		return NewSkipList(options.SkipListMaxLevel), nil
+	case TreeTypeHash:
+		return NewHashIndex(), nil
+	case TreeTypeLSM:
+		return OpenLSMTree(dir, options.LSMMemtableSize)
+	case TreeTypePagedBTree:
+		return OpenPagedBTree(dir, options.PageSize, options.BufferPoolPages, config.MaxKeyLength)
+	}
+	return nil, ErrUnknownTreeType
+}
+
+type TreeCollection struct {
+	TreeType TreeType
+	Options  CollectionOptions
+	tree     orderedTree
+	compare  compareFunc
+	indexes  map[string]*fieldIndex
+	expiry   *expiryTable
+	versions *versionTable
+	sweeper  expirySweeper
+	// changes records the collection's mutations for replication; it is
+	// nil until the collection joins a database.
+	changes *collectionChanges
+	// clock stamps the collection's writes and expires its keys.
+	clock  *writeClock
+	config Config
+	mutex  *sync.RWMutex
+}
+
+// writeClock is the time by which collections stamp their versions, set
+// deadlines and expire keys. It follows the wall clock until it is fixed:
+// a cluster node fixes it at the time each command it applies was
+// proposed, so that every node, and every replay of the log, stamps and
+// expires alike.
+type writeClock struct {
+	mutex sync.Mutex
+	fixed bool
+	at    time.Time
+}
+
+func newWriteClock() *writeClock {
+	return &writeClock{}
+}
+
+func (c *writeClock) now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.fixed {
+		return time.Now()
+	}
+	return c.at
+}
+
+// set fixes the clock at at until it is set again.
+func (c *writeClock) set(at time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.fixed, c.at = true, at
+}
+
+// join attaches the collection to a database, which records its
+// mutations in changes and keeps its time by clock.
+func (tc *TreeCollection) join(changes *collectionChanges, clock *writeClock) {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+	tc.changes, tc.clock = changes, clock
+}
+
+// NewTreeCollection creates a collection of the given type. Disk-backed
+// types keep their files in dir, reopening any data already there; the
+// other types ignore it.
+func NewTreeCollection(treeType TreeType, options CollectionOptions, config Config, dir string) (*TreeCollection, error) {
+	if err := treeType.validate(); err != nil {
+		return nil, err
+	}
+	if err := options.normalize(treeType); err != nil {
+		return nil, err
+	}
+
+	tc := &TreeCollection{
+		TreeType: treeType,
+		Options:  options,
+		compare:  options.Comparator.compareFunc(),
+		config:   config,
+		clock:    newWriteClock(),
+		mutex:    &sync.RWMutex{},
+		sweeper:  expirySweeper{done: make(chan struct{})},
+	}
+
+	if treeType.persistent() {
+		if err := writeCollectionMeta(dir, tc); err != nil {
+			return nil, err
+		}
+	}
+
+	tree, err := newOrderedTree(treeType, options, config, dir)
+	if err != nil {
+		return nil, err
+	}
+	tc.tree = tree
+	if tc.expiry, err = openExpiryTable(tc.compare, dir, treeType.persistent()); err != nil {
+		tc.Close()
+		return nil, err
+	}
+	if tc.expiry.deadlines.Root != nil {
+		tc.startSweeper()
+	}
+	if tc.versions, err = openVersionTable(tc.Options, dir, treeType.persistent()); err != nil {
+		tc.Close()
+		return nil, err
+	}
+	if err := tc.buildIndexes(); err != nil {
+		tc.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// Set writes key without a time to live, making it persistent again if it
+// had one.
+func (tc *TreeCollection) Set(key string, secondaryKey string, value string) error {
+	return tc.set(key, value, 0)
+}
+
+// set writes key with a time to live if ttl is positive.
+func (tc *TreeCollection) set(key string, value string, ttl time.Duration) error {
+	if err := tc.validateWrite(key, value); err != nil {
+		return err
+	}
+
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	var deadline int64
+	if ttl > 0 {
+		deadline = tc.clock.now().Add(ttl).UnixNano()
+	}
+	return tc.write(key, value, deadline)
+}
+
+func (tc *TreeCollection) validateWrite(key string, value string) error {
+	if err := tc.config.validateKey(key); err != nil {
+		return err
+	}
+	if err := tc.config.validateValue(value); err != nil {
+		return err
+	}
+	return tc.Options.ValueType.check(value, tc.Options.RequiredFields)
+}
+
+// write stores a validated value under key, to expire at deadline unless
+// it is 0. The caller holds the write lock.
+func (tc *TreeCollection) write(key string, value string, deadline int64) error {
+	// the deadline is logged first so that a crash never leaves a value
+	// that should expire without its deadline
+	if deadline != 0 {
+		tc.startSweeper()
+	}
+	if err := tc.expiry.set(key, deadline); err != nil {
+		return err
+	}
+
+	storedKey, oldValue := key, ""
+	if len(tc.indexes) > 0 {
+		var err error
+		if storedKey, oldValue, _, err = tc.stored(key); err != nil {
+			return err
+		}
+	}
+	if err := tc.tree.put(key, value); err != nil {
+		return err
+	}
+	return tc.recordPut(storedKey, oldValue, key, value, deadline, tc.clock.now().UnixNano())
+}
+
+// recordPut brings the indexes, the history and the replication log up to
+// date with a value the tree now holds under key in place of oldValue,
+// which it held under storedKey. The caller holds the write lock.
+func (tc *TreeCollection) recordPut(storedKey string, oldValue string, key string, value string, deadline int64, at int64) error {
+	tc.reindex(storedKey, oldValue, value)
+	if err := tc.versions.record(key, value, false, at); err != nil {
+		return err
+	}
+	tc.changes.record(Mutation{Op: MutationPut, Key: key, Value: value, Deadline: deadline, Time: at})
+	return nil
+}
+
+func (tc *TreeCollection) Update(key string, value string) error {
+	return tc.Set(key, key, value)
+}
+
+func (tc *TreeCollection) Get(key string) (string, error) {
+	if err := tc.config.validateKey(key); err != nil {
+		return "", err
+	}
+
+	tc.mutex.RLock()
+	value, found, err := tc.tree.get(key)
+	expired := found && tc.expiry.expired(key, time.Now())
+	tc.mutex.RUnlock()
+
+	if err != nil {
+		return "", err
+	}
+	if expired {
+		if err := tc.expireKeys([]string{key}); err != nil {
+			return "", err
+		}
+		return "", ErrKeyNotFound
+	}
+	if !found {
+		return "", ErrKeyNotFound
+	}
+	return value, nil
+}
+
+func (tc *TreeCollection) GetRange(leftBound string, rightBound string) (*map[string]string, error) {
+	if tc.TreeType == TreeTypeHash && !tc.Options.HashRangeScan {
+		return nil, ErrRangeUnsupported
+	}
+
+	tc.mutex.RLock()
+	result := make(map[string]string)
+	err := tc.tree.rangeInto(leftBound, rightBound, &result)
+	var expired []string
+	if tc.expiry.deadlines.Root != nil {
+		now := time.Now()
+		for key := range result {
+			if tc.expiry.expired(key, now) {
+				expired = append(expired, key)
+				delete(result, key)
+			}
+		}
+	}
+	tc.mutex.RUnlock()
+
+	if err != nil {
+		return nil, err
+	}
+	if len(expired) > 0 {
+		if err := tc.expireKeys(expired); err != nil {
+			return nil, err
+		}
+	}
+	return &result, nil
+}
+
+// Iterator returns an iterator over the collection that holds its read
+// lock until closed. Writers wait for it meanwhile, so the caller must not
+// write to the collection before closing it.
+func (tc *TreeCollection) Iterator() (interfaces.Iterator, error) {
+	if tc.TreeType == TreeTypeHash && !tc.Options.HashRangeScan {
+		return nil, ErrRangeUnsupported
+	}
+
+	tc.mutex.RLock()
+	cursor, err := tc.tree.cursor()
+	if err != nil {
+		tc.mutex.RUnlock()
+		return nil, err
+	}
+	it := &TreeIterator{cursor: cursor, unlock: tc.mutex.RUnlock}
+	if tc.expiry.deadlines.Root != nil {
+		now := time.Now()
+		it.skip = func(key string) bool { return tc.expiry.expired(key, now) }
+	}
+	return it, nil
+}
+
+func (tc *TreeCollection) Delete(key string) error {
+	if err := tc.config.validateKey(key); err != nil {
+		return err
+	}
+
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	if tc.expiry.expired(key, tc.clock.now()) {
+		if err := tc.removeExpired(key); err != nil {
+			return err
+		}
+		return ErrKeyNotFound
+	}
+	return tc.remove(key)
+}
+
+// remove deletes key, which has not expired. The caller holds the write
+// lock.
+func (tc *TreeCollection) remove(key string) error {
+	storedKey, oldValue := key, ""
+	if len(tc.indexes) > 0 {
+		var err error
+		if storedKey, oldValue, _, err = tc.stored(key); err != nil {
+			return err
+		}
+	}
+
+	found, err := tc.tree.remove(key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrKeyNotFound
+	}
+	return tc.recordRemove(storedKey, oldValue, key, tc.clock.now().UnixNano())
+}
+
+// recordRemove brings the indexes, the history, the deadlines and the
+// replication log up to date with the tree no longer holding key, which
+// held oldValue under storedKey. The caller holds the write lock.
+func (tc *TreeCollection) recordRemove(storedKey string, oldValue string, key string, at int64) error {
+	tc.reindex(storedKey, oldValue, "")
+	if err := tc.versions.record(key, "", true, at); err != nil {
+		return err
+	}
+	if err := tc.expiry.set(key, 0); err != nil {
+		return err
+	}
+	tc.changes.record(Mutation{Op: MutationDelete, Key: key, Time: at})
+	return nil
+}
+
+// Close stops the expiry sweeper and releases the files of disk-backed
+// collections.
+func (tc *TreeCollection) Close() error {
+	tc.stopSweeper()
+
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	var err error
+	if closer, ok := tc.tree.(io.Closer); ok {
+		err = closer.Close()
+	}
+	if tc.expiry != nil {
+		if expiryErr := tc.expiry.close(); err == nil {
+			err = expiryErr
+		}
+	}
+	if tc.versions != nil {
+		if versionsErr := tc.versions.close(); err == nil {
+			err = versionsErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,123 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// testOptions returns the options a collection of treeType needs to answer
+// every query the tests make.
+func testOptions(treeType TreeType) CollectionOptions {
+	if treeType == TreeTypeHash {
+		return CollectionOptions{HashRangeScan: true}
+	}
+	return CollectionOptions{}
+}
+
+func TestTreeTypesHoldTheSameContents(t *testing.T) {
+	for _, treeType := range treeTypes {
+		tc := newTestCollection(t, treeType, testOptions(treeType), t.TempDir())
+		want := make(map[string]string)
+		// 7 and 500 are coprime, so this writes every key once out of order
+		for i := 0; i < 500; i++ {
+			key := fmt.Sprintf("k%03d", i*7%500)
+			if err := tc.Set(key, key, key); err != nil {
+				t.Fatalf("%s: %v", treeType, err)
+			}
+			want[key] = key
+		}
+		for i := 0; i < 500; i += 3 {
+			key := fmt.Sprintf("k%03d", i)
+			if err := tc.Update(key, "updated"); err != nil {
+				t.Fatalf("%s: %v", treeType, err)
+			}
+			want[key] = "updated"
+		}
+		for i := 0; i < 500; i += 5 {
+			key := fmt.Sprintf("k%03d", i)
+			if err := tc.Delete(key); err != nil {
+				t.Fatalf("%s: %v", treeType, err)
+			}
+			delete(want, key)
+		}
+
+		contents, err := tc.GetRange("", "\xff")
+		if err != nil {
+			t.Fatalf("%s: %v", treeType, err)
+		}
+		if !reflect.DeepEqual(*contents, want) {
+			t.Errorf("%s: collection holds %d keys, want %d", treeType, len(*contents), len(want))
+		}
+		if value, err := tc.Get("k003"); err != nil || value != "updated" {
+			t.Errorf("%s: k003 is %q, %v, want updated", treeType, value, err)
+		}
+		if _, err := tc.Get("k005"); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("%s: deleted k005 is still found: %v", treeType, err)
+		}
+		contents, err = tc.GetRange("k100", "k109")
+		if err != nil {
+			t.Fatalf("%s: %v", treeType, err)
+		}
+		if len(*contents) != 8 {
+			t.Errorf("%s: k100 to k109 holds %v, want 8 keys", treeType, *contents)
+		}
+	}
+}
+
+func TestUnknownTreeTypeIsRejected(t *testing.T) {
+	if _, err := NewTreeCollection("splay", CollectionOptions{}, DefaultConfig(), ""); !errors.Is(err, ErrUnknownTreeType) {
+		t.Errorf("NewTreeCollection returned %v, want ErrUnknownTreeType", err)
+	}
+
+	database := newTestDatabase(t, t.TempDir())
+	defer database.Close()
+	createTestCollection(t, database, "", CollectionOptions{})
+	if err := database.createCollection(trusted, "p", "s", "d", "splay", CollectionOptions{}); !errors.Is(err, ErrUnknownTreeType) {
+		t.Errorf("createCollection returned %v, want ErrUnknownTreeType", err)
+	}
+	if _, err := database.getCollection("p", "s", "d"); !errors.Is(err, ErrCollectionNotFound) {
+		t.Errorf("a collection of an unknown type was created: %v", err)
+	}
+
+	// no tree type selects the default
+	collection, err := database.getCollection("p", "s", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if treeType := collection.(*TreeCollection).TreeType; treeType != database.Config.DefaultTreeType {
+		t.Errorf("collection is a %s, want the default %s", treeType, database.Config.DefaultTreeType)
+	}
+}
+
+func TestInvalidOptionsAreRejected(t *testing.T) {
+	tests := []struct {
+		treeType TreeType
+		options  CollectionOptions
+	}{
+		{TreeTypeBTree, CollectionOptions{BTreeMinDegree: 1}},
+		{TreeTypeBPlusTree, CollectionOptions{BTreeMinDegree: MaxBTreeMinDegree + 1}},
+		{TreeTypeAVL, CollectionOptions{BTreeMinDegree: 3}},
+		{TreeTypeSkipList, CollectionOptions{SkipListMaxLevel: MaxSkipListMaxLevel + 1}},
+		{TreeTypeRedBlack, CollectionOptions{SkipListMaxLevel: 4}},
+		{TreeTypeAVL, CollectionOptions{HashRangeScan: true}},
+		{TreeTypeLSM, CollectionOptions{LSMMemtableSize: MinLSMMemtableSize - 1}},
+		{TreeTypeBTree, CollectionOptions{LSMMemtableSize: MinLSMMemtableSize}},
+		{TreeTypePagedBTree, CollectionOptions{BufferPoolPages: MinBufferPoolPages - 1}},
+		{TreeTypeAVL, CollectionOptions{PageSize: 4096}},
+		{TreeTypeAVL, CollectionOptions{HistoryVersions: -1}},
+	}
+	for _, test := range tests {
+		options := test.options
+		if err := options.normalize(test.treeType); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("%s with %+v: got %v, want ErrInvalidOptions", test.treeType, test.options, err)
+		}
+	}
+
+	// zero values select the defaults
+	options := CollectionOptions{}
+	if err := options.normalize(TreeTypeBTree); err != nil || options.BTreeMinDegree != DefaultBTreeMinDegree {
+		t.Errorf("btree defaults to minimum degree %d, %v, want %d", options.BTreeMinDegree, err, DefaultBTreeMinDegree)
+	}
+}
@@ -0,0 +1,253 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// compareFunc orders keys the way strings.Compare does. Keys it reports
+// as equal are the same key.
+type compareFunc func(a, b string) int
+
+// Comparator names the key order of a collection.
+type Comparator string
+
+const (
+	ComparatorBytewise        Comparator = "bytewise"
+	ComparatorNumeric         Comparator = "numeric"
+	ComparatorCaseInsensitive Comparator = "case-insensitive"
+	ComparatorNatural         Comparator = "natural"
+	ComparatorLocale          Comparator = "locale"
+	ComparatorReverse         Comparator = "reverse"
+)
+
+var comparatorNames = []Comparator{ComparatorBytewise, ComparatorNumeric, ComparatorCaseInsensitive, ComparatorNatural, ComparatorLocale, ComparatorReverse}
+
+var comparators = map[Comparator]compareFunc{
+	ComparatorBytewise:        strings.Compare,
+	ComparatorNumeric:         compareNumeric,
+	ComparatorCaseInsensitive: compareFold,
+	ComparatorNatural:         compareNatural,
+	ComparatorLocale:          compareLocale,
+	ComparatorReverse:         compareReverse,
+}
+
+func (c Comparator) validate() error {
+	if _, ok := comparators[c]; !ok {
+		return fmt.Errorf("%w: unknown comparator %q (expected one of %v)", ErrInvalidOptions, string(c), comparatorNames)
+	}
+	return nil
+}
+
+func (c Comparator) compareFunc() compareFunc {
+	if compare, ok := comparators[c]; ok {
+		return compare
+	}
+	return strings.Compare
+}
+
+// parseNumericKey parses a key written as a finite decimal number.
+func parseNumericKey(key string) (float64, bool) {
+	if key == "" || !strings.ContainsRune("0123456789+-.", rune(key[0])) {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(key, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0, false
+	}
+	return f, true
+}
+
+// compareNumeric puts the empty key first, then the keys that are numbers
+// in order of value, then all other keys bytewise. Numbers of equal value
+// such as "7" and "07" remain distinct keys and are ordered bytewise.
+func compareNumeric(a, b string) int {
+	if a == "" || b == "" {
+		return strings.Compare(a, b)
+	}
+
+	x, aNumeric := parseNumericKey(a)
+	y, bNumeric := parseNumericKey(b)
+	switch {
+	case aNumeric && !bNumeric:
+		return -1
+	case !aNumeric && bNumeric:
+		return 1
+	case !aNumeric:
+		return strings.Compare(a, b)
+	}
+
+	// integers too large for a float64 mantissa still compare exactly
+	if i, err := strconv.ParseInt(a, 10, 64); err == nil {
+		if j, err := strconv.ParseInt(b, 10, 64); err == nil && i != j {
+			if i < j {
+				return -1
+			}
+			return 1
+		}
+	}
+	if x != y {
+		if x < y {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func foldRune(r rune) rune {
+	return unicode.ToLower(unicode.ToUpper(r))
+}
+
+// compareFold ignores case, so keys that differ only in case are the same
+// key and the collection keeps whichever spelling was stored first.
+func compareFold(a, b string) int {
+	for a != "" && b != "" {
+		r, n := utf8.DecodeRuneInString(a)
+		s, m := utf8.DecodeRuneInString(b)
+		if r, s = foldRune(r), foldRune(s); r != s {
+			if r < s {
+				return -1
+			}
+			return 1
+		}
+		a, b = a[n:], b[m:]
+	}
+	return strings.Compare(a, b)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// compareNatural compares runs of digits by their value and everything
+// else bytewise, so "file2" sorts before "file10". Keys that differ only
+// in leading zeros are ordered bytewise.
+func compareNatural(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if !isDigit(a[i]) || !isDigit(b[j]) {
+			if a[i] != b[j] {
+				if a[i] < b[j] {
+					return -1
+				}
+				return 1
+			}
+			i++
+			j++
+			continue
+		}
+
+		start := i
+		for i < len(a) && isDigit(a[i]) {
+			i++
+		}
+		x := strings.TrimLeft(a[start:i], "0")
+		start = j
+		for j < len(b) && isDigit(b[j]) {
+			j++
+		}
+		y := strings.TrimLeft(b[start:j], "0")
+
+		if len(x) != len(y) {
+			if len(x) < len(y) {
+				return -1
+			}
+			return 1
+		}
+		if c := strings.Compare(x, y); c != 0 {
+			return c
+		}
+	}
+
+	switch {
+	case i < len(a):
+		return 1
+	case j < len(b):
+		return -1
+	}
+	return strings.Compare(a, b)
+}
+
+// latinLetters lists the accented Latin letters, in lower case, that sort
+// with each base letter.
+var latinLetters = map[string]string{
+	"a":  "àáâãäåāăą",
+	"ae": "æ",
+	"c":  "çćĉċč",
+	"d":  "ðďđ",
+	"e":  "èéêëēĕėęě",
+	"g":  "ĝğġģ",
+	"h":  "ĥħ",
+	"i":  "ìíîïĩīĭįı",
+	"ij": "ĳ",
+	"j":  "ĵ",
+	"k":  "ķ",
+	"l":  "ĺļľŀł",
+	"n":  "ñńņňŉŋ",
+	"o":  "òóôõöøōŏő",
+	"oe": "œ",
+	"r":  "ŕŗř",
+	"s":  "śŝşšſ",
+	"ss": "ß",
+	"t":  "ţťŧ",
+	"th": "þ",
+	"u":  "ùúûüũūŭůűų",
+	"w":  "ŵ",
+	"y":  "ýÿŷ",
+	"z":  "źżž",
+}
+
+var latinBase = make(map[rune]string)
+
+func init() {
+	for base, letters := range latinLetters {
+		for _, r := range letters {
+			latinBase[r] = base
+		}
+	}
+}
+
+// collationKey returns the key in lower case with accents removed when
+// base is true, or in lower case only when it is false.
+func collationKey(key string, base bool) string {
+	var b strings.Builder
+	b.Grow(len(key))
+	for _, r := range key {
+		r = unicode.ToLower(r)
+		if plain, ok := latinBase[r]; ok && base {
+			b.WriteString(plain)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// compareLocale approximates the Unicode root collation for Latin text.
+// Keys compare by their letters first, ignoring accents and case, then by
+// accents, then by case with lower case first, so "cote" < "Cote" <
+// "côte" < "cotes".
+func compareLocale(a, b string) int {
+	if c := strings.Compare(collationKey(a, true), collationKey(b, true)); c != 0 {
+		return c
+	}
+	if c := strings.Compare(collationKey(a, false), collationKey(b, false)); c != 0 {
+		return c
+	}
+	return strings.Compare(b, a)
+}
+
+// compareReverse orders keys bytewise from last to first, except that the
+// empty key still sorts first so an empty left bound keeps meaning the
+// start of the collection.
+func compareReverse(a, b string) int {
+	if a == "" || b == "" {
+		return strings.Compare(a, b)
+	}
+	return strings.Compare(b, a)
+}
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+// checkOrder fails unless compare sorts keys into the order given, and
+// every key only equals itself.
+func checkOrder(t *testing.T, comparator Comparator, keys []string) {
+	t.Helper()
+	compare := comparator.compareFunc()
+	for i := range keys {
+		for j := range keys {
+			want := 0
+			if i < j {
+				want = -1
+			} else if i > j {
+				want = 1
+			}
+			if got := compare(keys[i], keys[j]); got != want {
+				t.Errorf("%s: compare(%q, %q) is %d, want %d", comparator, keys[i], keys[j], got, want)
+			}
+		}
+	}
+}
+
+func TestNumericOrder(t *testing.T) {
+	checkOrder(t, ComparatorNumeric, []string{"", "-10", "-2.5", "0", "007", "7", "7.0", "9007199254740992", "9007199254740993", "1e20", "+", "a", "b10", "b2"})
+}
+
+func TestNumericCollectionRanges(t *testing.T) {
+	tc := newTestCollection(t, TreeTypeBTree, CollectionOptions{Comparator: ComparatorNumeric}, "")
+	for _, i := range []int{100, 5, 20, 1, 3} {
+		key := fmt.Sprint(i)
+		if err := tc.Set(key, key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	contents, err := tc.GetRange("2", "50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for key := range *contents {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if got := fmt.Sprint(keys); got != "[20 3 5]" {
+		t.Errorf("2 to 50 holds %s, want 3, 5 and 20", got)
+	}
+	if entry, err := tc.Max(); err != nil || entry.Key != "100" {
+		t.Errorf("Max is %v, %v, want 100", entry, err)
+	}
+}
+
+func TestComparatorOrders(t *testing.T) {
+	checkOrder(t, ComparatorCaseInsensitive, []string{"", "a", "AB", "abc", "b", "É"})
+	checkOrder(t, ComparatorNatural, []string{"", "file01", "file1", "file2", "file10", "file10a", "fileb"})
+	checkOrder(t, ComparatorLocale, []string{"", "cote", "Cote", "côte", "Côte", "cotes", "zebra"})
+	checkOrder(t, ComparatorReverse, []string{"", "z", "b", "ab", "a"})
+}
+
+func TestCaseInsensitiveCollection(t *testing.T) {
+	tc := newTestCollection(t, TreeTypeAVL, CollectionOptions{Comparator: ComparatorCaseInsensitive}, "")
+	for _, key := range []string{"User:1", "user:2", "USER:3", "users"} {
+		if err := tc.Set(key, key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// keys that differ only in case are the same key
+	if err := tc.Set("user:1", "user:1", "again"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := tc.Get("USER:1"); err != nil || value != "again" {
+		t.Errorf("USER:1 is %q, %v, want again", value, err)
+	}
+
+	page, err := tc.ScanPrefix("user:", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entryKeys(page.Entries); got != "[User:1 user:2 USER:3]" {
+		t.Errorf("prefix user: holds %s", got)
+	}
+}
+
+func TestReverseCollectionPrefixScan(t *testing.T) {
+	tc := newTestCollection(t, TreeTypeRedBlack, CollectionOptions{Comparator: ComparatorReverse}, "")
+	for _, key := range []string{"a", "b1", "b2", "b3", "c"} {
+		if err := tc.Set(key, key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	page, err := tc.ScanPrefix("b", 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entryKeys(page.Entries); got != "[b3 b2]" {
+		t.Fatalf("first page of prefix b is %s", got)
+	}
+	if page, err = tc.ScanPrefix("b", 2, page.Token); err != nil {
+		t.Fatal(err)
+	}
+	if got := entryKeys(page.Entries); got != "[b1]" || page.Token != "" {
+		t.Errorf("second page of prefix b is %s with token %q", got, page.Token)
+	}
+	if entry, err := tc.Min(); err != nil || entry.Key != "c" {
+		t.Errorf("Min is %v, %v, want c", entry, err)
+	}
+}
+
+func TestComparatorOptions(t *testing.T) {
+	tc := newTestCollection(t, TreeTypeBTree, CollectionOptions{Comparator: ComparatorNatural}, "")
+	if _, err := tc.ScanPrefix("file", 0, ""); CodeOf(err) != CodeUnsupported {
+		t.Errorf("a natural order prefix scan returned %v", err)
+	}
+
+	for _, test := range []struct {
+		treeType   TreeType
+		comparator Comparator
+	}{
+		{TreeTypeAVL, "random"},
+		{TreeTypeSkipList, ComparatorReverse},
+		{TreeTypeLSM, ComparatorNatural},
+	} {
+		options := CollectionOptions{Comparator: test.comparator}
+		if err := options.normalize(test.treeType); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("%s with the %s comparator returned %v", test.treeType, test.comparator, err)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Config holds the server-wide limits. A zero limit means "unlimited".
+type Config struct {
+	MaxNameLength           int
+	MaxKeyLength            int
+	MaxValueSize            int
+	MaxCommandSize          int
+	MaxPools                int
+	MaxSchemasPerPool       int
+	MaxCollectionsPerSchema int
+	MaxCursors              int
+	MaxFetchCount           int
+	MaxScanMillis           int
+	MaxBatchSize            int
+	MaxReplicationLogBytes  int
+	DefaultTreeType         TreeType
+	DataDir                 string
+	DumpDir                 string
+	// ListenAddr is the address the server listens on. A server with a
+	// LeaderAddr follows the leader there, logging in to it as
+	// ReplicationUser, and serves only reads.
+	ListenAddr          string
+	LeaderAddr          string
+	ReplicationUser     string
+	ReplicationPassword string
+	// A server with a RaftID is a node of a Raft cluster instead. The
+	// nodes reach each other at their RaftAddr, authenticating with
+	// RaftSecret, and each keeps its log in RaftDir, compacting it every
+	// RaftSnapshotEntries entries. RaftPeers are the members a new
+	// cluster starts with, the same for each of them; a node joining a
+	// running cluster starts with none and is added by its leader.
+	RaftID              string
+	RaftAddr            string
+	RaftSecret          string
+	RaftDir             string
+	RaftPeers           []RaftMember
+	RaftSnapshotEntries int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MaxNameLength:           64,
+		MaxKeyLength:            1024,
+		MaxValueSize:            1 << 20,
+		MaxCommandSize:          4 << 20,
+		MaxPools:                1024,
+		MaxSchemasPerPool:       1024,
+		MaxCollectionsPerSchema: 1024,
+		MaxCursors:              64,
+		MaxFetchCount:           1000,
+		MaxScanMillis:           5000,
+		MaxBatchSize:            10000,
+		MaxReplicationLogBytes:  64 << 20,
+		DefaultTreeType:         TreeTypeAVL,
+		DataDir:                 "data",
+		DumpDir:                 "dumps",
+		ListenAddr:              ":8080",
+		RaftDir:                 "raft",
+		RaftSnapshotEntries:     8192,
+	}
+}
+
+// ConfigFromEnv returns DefaultConfig with any limits overridden by the
+// DB_MAX_* environment variables, the default tree type overridden by
+// DB_DEFAULT_TREE_TYPE and the data and dump directories overridden by
+// DB_DATA_DIR and DB_DUMP_DIR. DB_LISTEN_ADDR sets the address to listen
+// on, and DB_LEADER_ADDR, DB_REPLICATION_USER and DB_REPLICATION_PASSWORD
+// make the server a follower. DB_RAFT_ID, DB_RAFT_ADDR, DB_RAFT_SECRET,
+// DB_RAFT_DIR, DB_RAFT_PEERS and DB_RAFT_SNAPSHOT_ENTRIES make it a node
+// of a Raft cluster.
+func ConfigFromEnv() (Config, error) {
+	config := DefaultConfig()
+
+	vars := []struct {
+		name  string
+		field *int
+	}{
+		{"DB_MAX_NAME_LENGTH", &config.MaxNameLength},
+		{"DB_MAX_KEY_LENGTH", &config.MaxKeyLength},
+		{"DB_MAX_VALUE_SIZE", &config.MaxValueSize},
+		{"DB_MAX_COMMAND_SIZE", &config.MaxCommandSize},
+		{"DB_MAX_POOLS", &config.MaxPools},
+		{"DB_MAX_SCHEMAS_PER_POOL", &config.MaxSchemasPerPool},
+		{"DB_MAX_COLLECTIONS_PER_SCHEMA", &config.MaxCollectionsPerSchema},
+		{"DB_MAX_CURSORS", &config.MaxCursors},
+		{"DB_MAX_FETCH_COUNT", &config.MaxFetchCount},
+		{"DB_MAX_SCAN_MILLIS", &config.MaxScanMillis},
+		{"DB_MAX_BATCH_SIZE", &config.MaxBatchSize},
+		{"DB_MAX_REPLICATION_LOG_BYTES", &config.MaxReplicationLogBytes},
+	}
+
+	for _, v := range vars {
+		raw := os.Getenv(v.name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return config, fmt.Errorf("invalid value for %s: %q", v.name, raw)
+		}
+		*v.field = n
+	}
+
+	if treeType := os.Getenv("DB_DEFAULT_TREE_TYPE"); treeType != "" {
+		config.DefaultTreeType = TreeType(treeType)
+	}
+	if err := config.DefaultTreeType.validate(); err != nil {
+		return config, fmt.Errorf("invalid value for DB_DEFAULT_TREE_TYPE: %v", err)
+	}
+
+	if dataDir := os.Getenv("DB_DATA_DIR"); dataDir != "" {
+		config.DataDir = dataDir
+	}
+	if dumpDir := os.Getenv("DB_DUMP_DIR"); dumpDir != "" {
+		config.DumpDir = dumpDir
+	}
+
+	if listenAddr := os.Getenv("DB_LISTEN_ADDR"); listenAddr != "" {
+		config.ListenAddr = listenAddr
+	}
+	config.LeaderAddr = os.Getenv("DB_LEADER_ADDR")
+	config.ReplicationUser = os.Getenv("DB_REPLICATION_USER")
+	config.ReplicationPassword = os.Getenv("DB_REPLICATION_PASSWORD")
+	if config.LeaderAddr != "" && config.ReplicationUser == "" {
+		return config, fmt.Errorf("DB_LEADER_ADDR requires DB_REPLICATION_USER")
+	}
+
+	config.RaftID = os.Getenv("DB_RAFT_ID")
+	config.RaftAddr = os.Getenv("DB_RAFT_ADDR")
+	config.RaftSecret = os.Getenv("DB_RAFT_SECRET")
+	if raftDir := os.Getenv("DB_RAFT_DIR"); raftDir != "" {
+		config.RaftDir = raftDir
+	}
+	if raw := os.Getenv("DB_RAFT_SNAPSHOT_ENTRIES"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return config, fmt.Errorf("invalid value for DB_RAFT_SNAPSHOT_ENTRIES: %q", raw)
+		}
+		config.RaftSnapshotEntries = n
+	}
+	peers, err := ParseRaftMembers(os.Getenv("DB_RAFT_PEERS"))
+	if err != nil {
+		return config, fmt.Errorf("invalid value for DB_RAFT_PEERS: %v", err)
+	}
+	config.RaftPeers = peers
+	if config.RaftID != "" {
+		if config.RaftAddr == "" || config.RaftSecret == "" {
+			return config, fmt.Errorf("DB_RAFT_ID requires DB_RAFT_ADDR and DB_RAFT_SECRET")
+		}
+		if config.LeaderAddr != "" {
+			return config, fmt.Errorf("DB_RAFT_ID and DB_LEADER_ADDR cannot both be set")
+		}
+		found := len(peers) == 0
+		for _, peer := range peers {
+			found = found || peer.ID == config.RaftID
+		}
+		if !found {
+			return config, fmt.Errorf("DB_RAFT_PEERS must include DB_RAFT_ID %q", config.RaftID)
+		}
+	}
+
+	if config.MaxCommandSize == 0 {
+		return config, fmt.Errorf("DB_MAX_COMMAND_SIZE cannot be unlimited")
+	}
+
+	return config, nil
+}
+
+func (c Config) validateName(kind, name string) error {
+	if err := isValidName(name); err != nil {
+		return fmt.Errorf("invalid %s name %q: %w", kind, name, err)
+	}
+	if c.MaxNameLength > 0 && len(name) > c.MaxNameLength {
+		return fmt.Errorf("invalid %s name %q: %w (%d > %d bytes)", kind, name, ErrNameTooLong, len(name), c.MaxNameLength)
+	}
+	return nil
+}
+
+func (c Config) validateKey(key string) error {
+	if key == "" {
+		return ErrEmptyKey
+	}
+	if c.MaxKeyLength > 0 && len(key) > c.MaxKeyLength {
+		return fmt.Errorf("%w (%d > %d bytes)", ErrKeyTooLong, len(key), c.MaxKeyLength)
+	}
+	return nil
+}
+
+func (c Config) validateValue(value string) error {
+	if c.MaxValueSize > 0 && len(value) > c.MaxValueSize {
+		return fmt.Errorf("%w (%d > %d bytes)", ErrValueTooLarge, len(value), c.MaxValueSize)
+	}
+	return nil
+}
+
+func checkCount(kind, parent string, count, limit int) error {
+	if limit > 0 && count >= limit {
+		return Errorf(CodeInvalidArgument, "%s already holds the maximum of %d %s", parent, limit, kind)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNamesKeysAndValuesAreValidated(t *testing.T) {
+	database := newTestDatabase(t, t.TempDir())
+	defer database.Close()
+	database.Config.MaxNameLength = 8
+	database.Config.MaxKeyLength = 4
+	database.Config.MaxValueSize = 6
+	database.Config.MaxSchemasPerPool = 1
+	createTestCollection(t, database, TreeTypeAVL, CollectionOptions{})
+	collection, err := database.getCollection("p", "s", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"empty name", database.createPool(trusted, ""), ErrEmptyName},
+		{"name with a space", database.createPool(trusted, "a pool"), ErrInvalidName},
+		{"name with a dot", database.createCollection(trusted, "p", "s", "c.d", TreeTypeAVL, CollectionOptions{}), ErrInvalidName},
+		{"long name", database.createPool(trusted, "ninechars"), ErrNameTooLong},
+		{"empty key", collection.Set("", "", "v"), ErrEmptyKey},
+		{"long key", collection.Set("abcde", "abcde", "v"), ErrKeyTooLong},
+		{"long key read", func() error { _, err := collection.Get("abcde"); return err }(), ErrKeyTooLong},
+		{"large value", collection.Set("k", "k", "1234567"), ErrValueTooLarge},
+	}
+	for _, test := range tests {
+		if !errors.Is(test.err, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, test.err, test.want)
+		}
+	}
+
+	if err := database.createSchema(trusted, "p", "t"); CodeOf(err) != CodeInvalidArgument || !strings.Contains(err.Error(), "maximum") {
+		t.Errorf("a schema beyond the maximum was created: %v", err)
+	}
+	if err := database.createPool(trusted, "eightchr"); err != nil {
+		t.Errorf("a name at the limit was rejected: %v", err)
+	}
+	if err := collection.Set("abcd", "abcd", "123456"); err != nil {
+		t.Errorf("a key and value at the limits were rejected: %v", err)
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("DB_MAX_KEY_LENGTH", "16")
+	t.Setenv("DB_MAX_POOLS", "0")
+	config, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.MaxKeyLength != 16 || config.MaxPools != 0 {
+		t.Errorf("limits are %d and %d, want 16 and 0", config.MaxKeyLength, config.MaxPools)
+	}
+	if config.MaxValueSize != DefaultConfig().MaxValueSize {
+		t.Errorf("an unset limit is %d, want the default", config.MaxValueSize)
+	}
+
+	for _, raw := range []string{"-1", "many"} {
+		t.Setenv("DB_MAX_VALUE_SIZE", raw)
+		if _, err := ConfigFromEnv(); err == nil {
+			t.Errorf("DB_MAX_VALUE_SIZE=%s was accepted", raw)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"DB_II/pkg/interfaces"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+var (
+	ErrNotNumeric    = NewError(CodeInvalidArgument, "value is not a number")
+	ErrCounterBounds = NewError(CodeInvalidArgument, "minimum is above maximum")
+	ErrOverflow      = NewError(CodeInvalidArgument, "increment overflows")
+)
+
+var _ interfaces.CounterCollection = (*TreeCollection)(nil)
+
+// IncrBy adds delta to the integer stored under key, treating a missing
+// key as 0, clamps the sum into bounds and stores it, all under one lock.
+// A sum beyond the int64 range saturates at the bound on that side, or
+// fails with ErrOverflow if there is none. The key keeps its time to live.
+func (tc *TreeCollection) IncrBy(key string, delta int64, bounds interfaces.IntBounds) (int64, error) {
+	if bounds.Min != nil && bounds.Max != nil && *bounds.Min > *bounds.Max {
+		return 0, ErrCounterBounds
+	}
+
+	var sum int64
+	err := tc.updateNumber(key, func(value string, found bool) (string, error) {
+		var n int64
+		if found {
+			var err error
+			if n, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return "", fmt.Errorf("%w: %q is not an integer", ErrNotNumeric, value)
+			}
+		}
+
+		sum = n + delta
+		if overflow := (delta > 0 && sum < n) || (delta < 0 && sum > n); overflow {
+			switch {
+			case delta > 0 && bounds.Max != nil:
+				sum = *bounds.Max
+			case delta < 0 && bounds.Min != nil:
+				sum = *bounds.Min
+			default:
+				return "", ErrOverflow
+			}
+		}
+		if bounds.Min != nil && sum < *bounds.Min {
+			sum = *bounds.Min
+		}
+		if bounds.Max != nil && sum > *bounds.Max {
+			sum = *bounds.Max
+		}
+		return strconv.FormatInt(sum, 10), nil
+	})
+	return sum, err
+}
+
+// IncrByFloat is IncrBy for floating-point numbers. The sum is stored in
+// the shortest decimal form without an exponent, and must be finite.
+func (tc *TreeCollection) IncrByFloat(key string, delta float64, bounds interfaces.FloatBounds) (float64, error) {
+	if math.IsInf(delta, 0) || math.IsNaN(delta) {
+		return 0, fmt.Errorf("%w: increment must be finite", ErrNotNumeric)
+	}
+	if bounds.Min != nil && bounds.Max != nil && *bounds.Min > *bounds.Max {
+		return 0, ErrCounterBounds
+	}
+
+	var sum float64
+	err := tc.updateNumber(key, func(value string, found bool) (string, error) {
+		var n float64
+		if found {
+			var err error
+			if n, err = strconv.ParseFloat(value, 64); err != nil || math.IsInf(n, 0) || math.IsNaN(n) {
+				return "", fmt.Errorf("%w: %q is not a finite number", ErrNotNumeric, value)
+			}
+		}
+
+		sum = n + delta
+		if math.IsInf(sum, 0) {
+			return "", ErrOverflow
+		}
+		if bounds.Min != nil && sum < *bounds.Min {
+			sum = *bounds.Min
+		}
+		if bounds.Max != nil && sum > *bounds.Max {
+			sum = *bounds.Max
+		}
+		return strconv.FormatFloat(sum, 'f', -1, 64), nil
+	})
+	return sum, err
+}
+
+// updateNumber replaces key's value with what apply computes from it,
+// keeping the key's time to live.
+func (tc *TreeCollection) updateNumber(key string, apply func(value string, found bool) (string, error)) error {
+	if err := tc.config.validateKey(key); err != nil {
+		return err
+	}
+
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	state, err := tc.current(key)
+	if err != nil {
+		return err
+	}
+	value, err := apply(state.Value, state.Found)
+	if err != nil {
+		return err
+	}
+	if err := tc.validateWrite(key, value); err != nil {
+		return err
+	}
+	deadline, _ := tc.expiry.deadline(key)
+	return tc.write(key, value, deadline)
+}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"DB_II/pkg/interfaces"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestIncrBy(t *testing.T) {
+	tc := newTestCollection(t, TreeTypeAVL, CollectionOptions{}, "")
+	low, high := int64(-5), int64(10)
+	bounds := interfaces.IntBounds{Min: &low, Max: &high}
+	tests := []struct {
+		delta  int64
+		bounds interfaces.IntBounds
+		want   int64
+	}{
+		// a missing key counts from 0
+		{3, interfaces.IntBounds{}, 3},
+		{-1, interfaces.IntBounds{}, 2},
+		{20, bounds, 10},
+		{-100, bounds, -5},
+		{0, interfaces.IntBounds{}, -5},
+	}
+	for _, test := range tests {
+		if n, err := tc.IncrBy("n", test.delta, test.bounds); err != nil || n != test.want {
+			t.Fatalf("IncrBy(%d) is %d, %v, want %d", test.delta, n, err, test.want)
+		}
+	}
+	if value, err := tc.Get("n"); err != nil || value != "-5" {
+		t.Errorf("n holds %q, %v, want -5", value, err)
+	}
+
+	// a sum beyond int64 saturates at a bound on its side, or fails
+	if _, err := tc.IncrBy("n", math.MinInt64, interfaces.IntBounds{}); !errors.Is(err, ErrOverflow) {
+		t.Errorf("an overflowing decrement returned %v, want ErrOverflow", err)
+	}
+	floor := int64(-7)
+	if n, err := tc.IncrBy("n", math.MinInt64, interfaces.IntBounds{Min: &floor}); err != nil || n != floor {
+		t.Errorf("a bounded overflowing decrement is %d, %v, want %d", n, err, floor)
+	}
+
+	if _, err := tc.IncrBy("n", 1, interfaces.IntBounds{Min: &high, Max: &low}); !errors.Is(err, ErrCounterBounds) {
+		t.Errorf("crossed bounds returned %v, want ErrCounterBounds", err)
+	}
+	if err := tc.Set("s", "s", "1.5"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tc.IncrBy("s", 1, interfaces.IntBounds{}); !errors.Is(err, ErrNotNumeric) {
+		t.Errorf("incrementing a float by an integer returned %v, want ErrNotNumeric", err)
+	}
+	if value, err := tc.Get("s"); err != nil || value != "1.5" {
+		t.Errorf("a failed increment changed s to %q, %v", value, err)
+	}
+}
+
+func TestIncrByFloat(t *testing.T) {
+	tc := newTestCollection(t, TreeTypeAVL, CollectionOptions{}, "")
+	if _, err := tc.IncrByFloat("f", 0.1, interfaces.FloatBounds{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tc.IncrByFloat("f", 0.2, interfaces.FloatBounds{}); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := tc.Get("f"); err != nil || value != "0.30000000000000004" {
+		t.Fatalf("0.1 + 0.2 is stored as %q, %v", value, err)
+	}
+	if _, err := tc.IncrByFloat("f", 1e21, interfaces.FloatBounds{}); err != nil {
+		t.Fatal(err)
+	}
+	// the sum is stored without an exponent
+	if value, err := tc.Get("f"); err != nil || value != "1000000000000000000000" {
+		t.Errorf("f holds %q, %v", value, err)
+	}
+
+	ceiling := 2.5
+	if n, err := tc.IncrByFloat("g", 3, interfaces.FloatBounds{Max: &ceiling}); err != nil || n != ceiling {
+		t.Errorf("a bounded increment is %v, %v, want %v", n, err, ceiling)
+	}
+	if _, err := tc.IncrByFloat("g", math.MaxFloat64, interfaces.FloatBounds{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tc.IncrByFloat("g", math.MaxFloat64, interfaces.FloatBounds{}); !errors.Is(err, ErrOverflow) {
+		t.Errorf("an infinite sum returned %v, want ErrOverflow", err)
+	}
+	if _, err := tc.IncrByFloat("g", math.NaN(), interfaces.FloatBounds{}); !errors.Is(err, ErrNotNumeric) {
+		t.Errorf("a NaN increment returned %v, want ErrNotNumeric", err)
+	}
+	if err := tc.Set("s", "s", "NaN"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tc.IncrByFloat("s", 1, interfaces.FloatBounds{}); !errors.Is(err, ErrNotNumeric) {
+		t.Errorf("incrementing NaN returned %v, want ErrNotNumeric", err)
+	}
+}
+
+func TestCountersKeepTheirTTLAndValueType(t *testing.T) {
+	tc := newTestCollection(t, TreeTypeAVL, CollectionOptions{ValueType: ValueTypeInt}, "")
+	if err := tc.SetWithTTL("n", "n", "1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tc.IncrBy("n", 1, interfaces.IntBounds{}); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, ok, err := tc.TTL("n"); err != nil || !ok || ttl <= 59*time.Minute {
+		t.Errorf("n expires in %v, %v, %v, want about an hour", ttl, ok, err)
+	}
+
+	// a float sum is checked against the collection's value type
+	if _, err := tc.IncrByFloat("n", 0.5, interfaces.FloatBounds{}); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("a float sum in an int collection returned %v, want ErrInvalidValue", err)
+	}
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"DB_II/pkg/interfaces"
+	"strconv"
+)
+
+var ErrCursorNotFound = NewError(CodeNotFound, "cursor not found")
+
+// CursorSet holds the server-side cursors of one connection. A cursor
+// only remembers where it stopped: every Fetch reads a page of the range
+// starting just past the last key it returned, so no lock is held between
+// fetches and writes made in between are seen.
+type CursorSet struct {
+	db      *Database
+	cursors map[string]*cursorState
+	nextID  int
+}
+
+type cursorState struct {
+	username   string
+	pool       string
+	schema     string
+	collection string
+	from       string
+	started    bool
+	rightBound string
+	done       bool
+}
+
+func (db *Database) NewCursorSet() *CursorSet {
+	return &CursorSet{
+		db:      db,
+		cursors: make(map[string]*cursorState),
+	}
+}
+
+// Open starts a cursor at the first key >= leftBound. A non-empty
+// rightBound ends it after the last key <= rightBound.
+func (s *CursorSet) Open(username, poolName, schemaName, collectionName, leftBound, rightBound string) (string, error) {
+	if _, err := s.db.GetCollection(username, PermRead, poolName, schemaName, collectionName); err != nil {
+		return "", err
+	}
+	if err := checkCount("cursors", "connection", len(s.cursors), s.db.Config.MaxCursors); err != nil {
+		return "", err
+	}
+
+	s.nextID++
+	id := strconv.Itoa(s.nextID)
+	s.cursors[id] = &cursorState{
+		username:   username,
+		pool:       poolName,
+		schema:     schemaName,
+		collection: collectionName,
+		from:       leftBound,
+		rightBound: rightBound,
+	}
+	return id, nil
+}
+
+// Fetch returns up to count entries and whether the cursor is exhausted.
+func (s *CursorSet) Fetch(username, id string, count int) ([]interfaces.Entry, bool, error) {
+	cursor, exists := s.cursors[id]
+	if !exists || cursor.username != username {
+		return nil, false, ErrCursorNotFound
+	}
+
+	if count < 1 {
+		return nil, false, Errorf(CodeInvalidArgument, "fetch count %d must be positive", count)
+	}
+	if limit := s.db.Config.MaxFetchCount; limit > 0 && count > limit {
+		return nil, false, Errorf(CodeInvalidArgument, "fetch count %d exceeds the maximum of %d", count, limit)
+	}
+
+	if cursor.done {
+		return make([]interfaces.Entry, 0), true, nil
+	}
+
+	collection, err := s.db.GetCollection(username, PermRead, cursor.pool, cursor.schema, cursor.collection)
+	if err != nil {
+		return nil, false, err
+	}
+	page, err := collection.GetRangePage(interfaces.RangeQuery{
+		LeftBound:     cursor.from,
+		LeftExclusive: cursor.started,
+		RightBound:    cursor.rightBound,
+		Limit:         count,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	// a page only carries a token when more of the range is left
+	cursor.done = page.Token == ""
+	if len(page.Entries) > 0 {
+		cursor.from = page.Entries[len(page.Entries)-1].Key
+		cursor.started = true
+	}
+	return page.Entries, cursor.done, nil
+}
+
+func (s *CursorSet) Close(username, id string) error {
+	cursor, exists := s.cursors[id]
+	if !exists || cursor.username != username {
+		return ErrCursorNotFound
+	}
+	delete(s.cursors, id)
+	return nil
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// superuserDriver is a database/sql driver whose every query answers one
+// row holding the superuser role, so that tests can run a Database
+// without PostgreSQL and every user may do anything.
+type superuserDriver struct{}
+
+type superuserConn struct{}
+
+type superuserStmt struct{}
+
+type superuserRows struct{ done bool }
+
+func (superuserDriver) Open(string) (driver.Conn, error) { return superuserConn{}, nil }
+
+func (superuserConn) Prepare(string) (driver.Stmt, error) { return superuserStmt{}, nil }
+func (superuserConn) Close() error                        { return nil }
+func (superuserConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+
+func (superuserStmt) Close() error  { return nil }
+func (superuserStmt) NumInput() int { return -1 }
+func (superuserStmt) Exec([]driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(1), nil
+}
+func (superuserStmt) Query([]driver.Value) (driver.Rows, error) { return &superuserRows{}, nil }
+
+func (*superuserRows) Columns() []string { return []string{"role"} }
+func (*superuserRows) Close() error      { return nil }
+func (r *superuserRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = string(RoleSuperUser)
+	return nil
+}
+
+var registerSuperuserDriver sync.Once
+
+// newTestDatabase returns a database that keeps its files under dir.
+func newTestDatabase(t *testing.T, dir string) *Database {
+	t.Helper()
+	registerSuperuserDriver.Do(func() { sql.Register("superuser", superuserDriver{}) })
+	conn, err := sql.Open("superuser", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := DefaultConfig()
+	config.DataDir = filepath.Join(dir, "data")
+	config.DumpDir = filepath.Join(dir, "dumps")
+	return NewDatabase(NewAuthManager(&PostgresDB{db: conn}), config)
+}
+
+// createTestCollection creates pool p, schema s and collection c.
+func createTestCollection(t *testing.T, database *Database, treeType TreeType, options CollectionOptions) {
+	t.Helper()
+	if err := database.createPool(trusted, "p"); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.createSchema(trusted, "p", "s"); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.createCollection(trusted, "p", "s", "c", treeType, options); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testContents returns everything collection p.s.c holds, or nil if the
+// database has no such collection.
+func testContents(database *Database) map[string]string {
+	collection, err := database.getCollection("p", "s", "c")
+	if err != nil {
+		return nil
+	}
+	contents, err := collection.GetRange("", "\xff")
+	if err != nil {
+		return nil
+	}
+	return *contents
+}
+
+// newTestCollection returns a collection on its own, closed when the test
+// ends. Disk-backed types keep their files in dir.
+func newTestCollection(t *testing.T, treeType TreeType, options CollectionOptions, dir string) *TreeCollection {
+	t.Helper()
+	tc, err := NewTreeCollection(treeType, options, DefaultConfig(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tc.Close() })
+	return tc
+}
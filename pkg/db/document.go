@@ -0,0 +1,496 @@
+package db
+
+import (
+	"DB_II/pkg/interfaces"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotDocument      = NewError(CodeUnsupported, "collection does not hold JSON documents")
+	ErrFieldNotFound    = NewError(CodeNotFound, "field not found")
+	ErrInvalidPath      = NewError(CodeInvalidArgument, "invalid field path")
+	ErrInvalidPatch     = NewError(CodeInvalidArgument, "invalid patch")
+	ErrInvalidPredicate = NewError(CodeInvalidArgument, "invalid field predicate")
+)
+
+var _ interfaces.DocumentCollection = (*TreeCollection)(nil)
+
+// pathStep is one step of a field path: an object member, or an array
+// element when index is not negative.
+type pathStep struct {
+	name  string
+	index int
+}
+
+// fieldPath locates a value inside a document.
+type fieldPath []pathStep
+
+// parseFieldPath parses paths such as $.address.city or $.tags[0]. The
+// leading $ may be left out, so address.city is the same path.
+func parseFieldPath(raw string) (fieldPath, error) {
+	rest := strings.TrimPrefix(raw, "$")
+	if rest != "" && rest[0] != '.' && rest[0] != '[' {
+		rest = "." + rest
+	}
+	if rest == "" {
+		return nil, fmt.Errorf("%w %q: the path names no field", ErrInvalidPath, raw)
+	}
+
+	var path fieldPath
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[") + 1
+			if end == 0 {
+				end = len(rest)
+			}
+			if end == 1 {
+				return nil, fmt.Errorf("%w %q: empty field name", ErrInvalidPath, raw)
+			}
+			path = append(path, pathStep{name: rest[1:end], index: -1})
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("%w %q: unclosed [", ErrInvalidPath, raw)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("%w %q: %q is not an array index", ErrInvalidPath, raw, rest[1:end])
+			}
+			path = append(path, pathStep{index: index})
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("%w %q", ErrInvalidPath, raw)
+		}
+	}
+	return path, nil
+}
+
+func (p fieldPath) String() string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, step := range p {
+		if step.index >= 0 {
+			fmt.Fprintf(&b, "[%d]", step.index)
+		} else {
+			b.WriteString(".")
+			b.WriteString(step.name)
+		}
+	}
+	return b.String()
+}
+
+// lookup returns the value at the path, or false if there is none. A JSON
+// null counts as no value.
+func (p fieldPath) lookup(document interface{}) (interface{}, bool) {
+	field := document
+	for _, step := range p {
+		if step.index >= 0 {
+			array, ok := field.([]interface{})
+			if !ok || step.index >= len(array) {
+				return nil, false
+			}
+			field = array[step.index]
+		} else {
+			object, ok := field.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			field = object[step.name]
+		}
+	}
+	return field, field != nil
+}
+
+// parseDocument decodes a stored value, which must be a JSON object.
+func parseDocument(value string) (map[string]interface{}, error) {
+	var document map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &document); err != nil || document == nil {
+		return nil, fmt.Errorf("%w: expected a JSON object", ErrInvalidValue)
+	}
+	return document, nil
+}
+
+// decodeObject decodes a JSON object keeping its numbers as written, so
+// that a document can be rewritten without rounding them through float64.
+func decodeObject(value string) (map[string]interface{}, bool) {
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.UseNumber()
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil || object == nil {
+		return nil, false
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, false
+	}
+	return object, true
+}
+
+// canonicalJSON encodes a decoded value so that equal values encode the
+// same way: numbers are float64 and object members are sorted by name.
+func canonicalJSON(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// mergePatch applies an RFC 7396 merge patch: members of the patch replace
+// those of the target, nested objects are merged, and null removes a
+// member.
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+		} else {
+			targetObject[name] = mergePatch(targetObject[name], value)
+		}
+	}
+	return targetObject
+}
+
+// fieldFilter is a compiled interfaces.FieldPredicate.
+type fieldFilter struct {
+	path  fieldPath
+	op    string
+	value interface{}
+}
+
+func compileFieldPredicate(predicate interfaces.FieldPredicate) (*fieldFilter, error) {
+	path, err := parseFieldPath(predicate.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := &fieldFilter{path: path, op: predicate.Op}
+	switch predicate.Op {
+	case "exists":
+		return filter, nil
+	case "eq", "ne", "lt", "le", "gt", "ge":
+	default:
+		return nil, fmt.Errorf("%w: unknown operator %q (expected eq, ne, lt, le, gt, ge or exists)", ErrInvalidPredicate, predicate.Op)
+	}
+
+	if err := json.Unmarshal([]byte(predicate.Value), &filter.value); err != nil {
+		return nil, fmt.Errorf("%w: value is not JSON: %v", ErrInvalidPredicate, err)
+	}
+	return filter, nil
+}
+
+// matches reports whether the document's field satisfies the predicate.
+// Only exists looks at documents lacking the field, and the ordering
+// operators only compare two numbers or two strings.
+func (f *fieldFilter) matches(document interface{}) bool {
+	field, found := f.path.lookup(document)
+	switch f.op {
+	case "exists":
+		return found
+	case "eq":
+		return found && reflect.DeepEqual(field, f.value)
+	case "ne":
+		return found && !reflect.DeepEqual(field, f.value)
+	}
+	if !found {
+		return false
+	}
+
+	var cmp int
+	switch x := field.(type) {
+	case float64:
+		y, ok := f.value.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case x < y:
+			cmp = -1
+		case x > y:
+			cmp = 1
+		}
+	case string:
+		y, ok := f.value.(string)
+		if !ok {
+			return false
+		}
+		cmp = strings.Compare(x, y)
+	default:
+		return false
+	}
+
+	switch f.op {
+	case "lt":
+		return cmp < 0
+	case "le":
+		return cmp <= 0
+	case "gt":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
+
+func (f *fieldFilter) matchesValue(value string) bool {
+	document, err := parseDocument(value)
+	return err == nil && f.matches(document)
+}
+
+// fieldIndex maps the canonical JSON of the value at a path to the keys
+// of the documents holding it, which answers eq predicates without a scan.
+type fieldIndex struct {
+	path fieldPath
+	keys map[string]map[string]struct{}
+}
+
+func newFieldIndex(path fieldPath) *fieldIndex {
+	return &fieldIndex{path: path, keys: make(map[string]map[string]struct{})}
+}
+
+func (x *fieldIndex) add(key string, document interface{}) {
+	field, found := x.path.lookup(document)
+	if !found {
+		return
+	}
+	value := canonicalJSON(field)
+	if x.keys[value] == nil {
+		x.keys[value] = make(map[string]struct{})
+	}
+	x.keys[value][key] = struct{}{}
+}
+
+func (x *fieldIndex) remove(key string, document interface{}) {
+	field, found := x.path.lookup(document)
+	if !found {
+		return
+	}
+	value := canonicalJSON(field)
+	delete(x.keys[value], key)
+	if len(x.keys[value]) == 0 {
+		delete(x.keys, value)
+	}
+}
+
+func (x *fieldIndex) lookup(value interface{}) []string {
+	keys := make([]string, 0, len(x.keys[canonicalJSON(value)]))
+	for key := range x.keys[canonicalJSON(value)] {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// isDocument reports whether the collection is in document mode, which is
+// whether its values are JSON objects.
+func (tc *TreeCollection) isDocument() bool {
+	return tc.Options.ValueType == ValueTypeJSON
+}
+
+// buildIndexes creates the collection's field indexes over whatever the
+// tree already holds.
+func (tc *TreeCollection) buildIndexes() error {
+	if len(tc.Options.Indexes) == 0 {
+		return nil
+	}
+
+	tc.indexes = make(map[string]*fieldIndex)
+	for _, raw := range tc.Options.Indexes {
+		path, err := parseFieldPath(raw)
+		if err != nil {
+			return err
+		}
+		tc.indexes[path.String()] = newFieldIndex(path)
+	}
+
+	cursor, err := tc.tree.cursor()
+	if err != nil {
+		return err
+	}
+	for err = cursor.seek(""); err == nil && cursor.valid(); err = cursor.next() {
+		document, parseErr := parseDocument(cursor.value())
+		if parseErr != nil {
+			continue
+		}
+		for _, index := range tc.indexes {
+			index.add(cursor.key(), document)
+		}
+	}
+	if closer, ok := cursor.(io.Closer); ok {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// stored returns the spelling under which the tree holds key, which a
+// case-insensitive comparator lets differ from key, and its value. A key
+// that is not there comes back unchanged.
+func (tc *TreeCollection) stored(key string) (string, string, bool, error) {
+	if tc.Options.Comparator != ComparatorCaseInsensitive {
+		value, found, err := tc.tree.get(key)
+		return key, value, found, err
+	}
+
+	cursor, err := tc.tree.cursor()
+	if err != nil {
+		return "", "", false, err
+	}
+	storedKey, value, found := key, "", false
+	err = cursor.seek(key)
+	if err == nil && cursor.valid() && tc.compare(cursor.key(), key) == 0 {
+		storedKey, value, found = cursor.key(), cursor.value(), true
+	}
+	if closer, ok := cursor.(io.Closer); ok {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return storedKey, value, found, err
+}
+
+// reindex moves key's index entries from the old document to the new one.
+// Either may be empty. The caller holds the write lock.
+func (tc *TreeCollection) reindex(key string, oldValue string, newValue string) {
+	if len(tc.indexes) == 0 {
+		return
+	}
+	if oldValue != "" {
+		if document, err := parseDocument(oldValue); err == nil {
+			for _, index := range tc.indexes {
+				index.remove(key, document)
+			}
+		}
+	}
+	if newValue != "" {
+		if document, err := parseDocument(newValue); err == nil {
+			for _, index := range tc.indexes {
+				index.add(key, document)
+			}
+		}
+	}
+}
+
+// GetField returns the JSON encoding of the value at path in the document
+// stored under key.
+func (tc *TreeCollection) GetField(key string, path string) (string, error) {
+	if !tc.isDocument() {
+		return "", ErrNotDocument
+	}
+	parsed, err := parseFieldPath(path)
+	if err != nil {
+		return "", err
+	}
+
+	value, err := tc.Get(key)
+	if err != nil {
+		return "", err
+	}
+	document, err := parseDocument(value)
+	if err != nil {
+		return "", err
+	}
+
+	field, found := parsed.lookup(document)
+	if !found {
+		return "", fmt.Errorf("%w: %s", ErrFieldNotFound, parsed)
+	}
+	return canonicalJSON(field), nil
+}
+
+// Patch applies a JSON merge patch to the document stored under key. The
+// result must still satisfy the collection's value type.
+func (tc *TreeCollection) Patch(key string, patch string) error {
+	if !tc.isDocument() {
+		return ErrNotDocument
+	}
+	if err := tc.config.validateKey(key); err != nil {
+		return err
+	}
+
+	changes, ok := decodeObject(patch)
+	if !ok {
+		return fmt.Errorf("%w: expected a JSON object", ErrInvalidPatch)
+	}
+
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	if err := tc.checkLive(key); err != nil {
+		return err
+	}
+	storedKey, oldValue, found, err := tc.stored(key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrKeyNotFound
+	}
+	document, ok := decodeObject(oldValue)
+	if !ok {
+		return fmt.Errorf("%w: expected a JSON object", ErrInvalidValue)
+	}
+
+	newValue := canonicalJSON(mergePatch(document, changes))
+	if err := tc.config.validateValue(newValue); err != nil {
+		return err
+	}
+	if err := tc.Options.ValueType.check(newValue, tc.Options.RequiredFields); err != nil {
+		return err
+	}
+	// a patch keeps the key's time to live
+	deadline, _ := tc.expiry.deadline(storedKey)
+	return tc.write(key, newValue, deadline)
+}
+
+// indexedRangePage answers a range query whose predicate is an eq on an
+// indexed path from the index, fetching only the matching documents.
+func (tc *TreeCollection) indexedRangePage(query interfaces.RangeQuery, index *fieldIndex, filter *fieldFilter, inRange func(key string) bool) (*interfaces.RangePage, error) {
+	if tc.TreeType == TreeTypeHash && !tc.Options.HashRangeScan {
+		return nil, ErrRangeUnsupported
+	}
+
+	tc.mutex.RLock()
+	defer tc.mutex.RUnlock()
+
+	now := time.Now()
+	var keys []string
+	for _, key := range index.lookup(filter.value) {
+		if inRange(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if query.Reverse {
+			return tc.compare(keys[i], keys[j]) > 0
+		}
+		return tc.compare(keys[i], keys[j]) < 0
+	})
+
+	page := &interfaces.RangePage{Entries: make([]interfaces.Entry, 0)}
+	for _, key := range keys {
+		value, found, err := tc.tree.get(key)
+		if err != nil {
+			return nil, err
+		}
+		if !found || tc.expiry.expired(key, now) {
+			continue
+		}
+		if query.Limit > 0 && len(page.Entries) == query.Limit {
+			page.Token = encodeRangeToken(query.Reverse, page.Entries[len(page.Entries)-1].Key)
+			break
+		}
+		page.Entries = append(page.Entries, interfaces.Entry{Key: key, Value: value})
+	}
+	return page, nil
+}
@@ -0,0 +1,153 @@
+package db
+
+import (
+	"DB_II/pkg/interfaces"
+	"errors"
+	"testing"
+)
+
+func TestParseFieldPath(t *testing.T) {
+	for raw, want := range map[string]string{
+		"$.address.city": "$.address.city",
+		"address.city":   "$.address.city",
+		"$.tags[0]":      "$.tags[0]",
+		"[2].name":       "$[2].name",
+	} {
+		path, err := parseFieldPath(raw)
+		if err != nil || path.String() != want {
+			t.Errorf("%q parses as %v, %v, want %s", raw, path, err, want)
+		}
+	}
+	for _, raw := range []string{"", "$", "a..b", "a[", "a[-1]", "a[x]"} {
+		if _, err := parseFieldPath(raw); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("%q returned %v, want ErrInvalidPath", raw, err)
+		}
+	}
+}
+
+func TestGetFieldAndPatch(t *testing.T) {
+	tc := newTestCollection(t, TreeTypeAVL, CollectionOptions{ValueType: ValueTypeJSON, RequiredFields: []string{"name"}}, "")
+	if err := tc.Set("ann", "ann", `{"name":"Ann","address":{"city":"Oslo","zip":"0150"},"tags":["a","b"]}`); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]string{
+		"$.address.city": `"Oslo"`,
+		"tags[1]":        `"b"`,
+		"address":        `{"city":"Oslo","zip":"0150"}`,
+	} {
+		if got, err := tc.GetField("ann", path); err != nil || got != want {
+			t.Errorf("%s is %s, %v, want %s", path, got, err, want)
+		}
+	}
+	for _, path := range []string{"age", "tags[2]", "name.first"} {
+		if _, err := tc.GetField("ann", path); !errors.Is(err, ErrFieldNotFound) {
+			t.Errorf("%s returned %v, want ErrFieldNotFound", path, err)
+		}
+	}
+
+	// a merge patch adds and replaces members, recursing into objects,
+	// and null removes them
+	if err := tc.Patch("ann", `{"age":30,"address":{"city":"Bergen","zip":null},"tags":["c"]}`); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := tc.Get("ann"); err != nil || value != `{"address":{"city":"Bergen"},"age":30,"name":"Ann","tags":["c"]}` {
+		t.Errorf("patched document is %s, %v", value, err)
+	}
+
+	if err := tc.Patch("ann", `{"name":null}`); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("patching away a required field returned %v", err)
+	}
+	if err := tc.Patch("ann", `[1]`); !errors.Is(err, ErrInvalidPatch) {
+		t.Errorf("a patch that is not an object returned %v", err)
+	}
+	if err := tc.Patch("bob", `{"age":1}`); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("patching a missing key returned %v", err)
+	}
+
+	plain := newTestCollection(t, TreeTypeAVL, CollectionOptions{}, "")
+	if _, err := plain.GetField("ann", "name"); !errors.Is(err, ErrNotDocument) {
+		t.Errorf("GetField on plain values returned %v", err)
+	}
+	if err := plain.Patch("ann", `{}`); !errors.Is(err, ErrNotDocument) {
+		t.Errorf("Patch on plain values returned %v", err)
+	}
+}
+
+func TestWherePredicates(t *testing.T) {
+	documents := map[string]string{
+		"a": `{"city":"Oslo","age":30}`,
+		"b": `{"city":"Bergen","age":25}`,
+		"c": `{"city":"Oslo","age":41}`,
+		"d": `{"city":"Oslo"}`,
+		"e": `{"age":"old"}`,
+	}
+	tests := []struct {
+		predicate interfaces.FieldPredicate
+		want      string
+	}{
+		{interfaces.FieldPredicate{Path: "city", Op: "eq", Value: `"Oslo"`}, "[a c d]"},
+		{interfaces.FieldPredicate{Path: "city", Op: "ne", Value: `"Oslo"`}, "[b]"},
+		{interfaces.FieldPredicate{Path: "age", Op: "gt", Value: `29`}, "[a c]"},
+		{interfaces.FieldPredicate{Path: "age", Op: "le", Value: `30`}, "[a b]"},
+		{interfaces.FieldPredicate{Path: "age", Op: "ge", Value: `"a"`}, "[e]"},
+		{interfaces.FieldPredicate{Path: "age", Op: "exists"}, "[a b c e]"},
+	}
+
+	// an index answers eq without a scan, and must agree with one
+	for _, indexes := range [][]string{nil, {"city", "age"}} {
+		tc := newTestCollection(t, TreeTypeAVL, CollectionOptions{ValueType: ValueTypeJSON, Indexes: indexes}, "")
+		for key, document := range documents {
+			if err := tc.Set(key, key, document); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, test := range tests {
+			predicate := test.predicate
+			page, err := tc.GetRangePage(interfaces.RangeQuery{Where: &predicate})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := entryKeys(page.Entries); got != test.want {
+				t.Errorf("indexes %v: %+v matches %s, want %s", indexes, predicate, got, test.want)
+			}
+		}
+
+		// the index follows writes, patches and deletes
+		if err := tc.Patch("a", `{"city":"Bergen"}`); err != nil {
+			t.Fatal(err)
+		}
+		if err := tc.Delete("c"); err != nil {
+			t.Fatal(err)
+		}
+		if err := tc.Set("f", "f", `{"city":"Oslo"}`); err != nil {
+			t.Fatal(err)
+		}
+		where := interfaces.FieldPredicate{Path: "city", Op: "eq", Value: `"Oslo"`}
+		page, err := tc.GetRangePage(interfaces.RangeQuery{Where: &where, Reverse: true, Limit: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := entryKeys(page.Entries); got != "[f]" || page.Token == "" {
+			t.Errorf("indexes %v: last Oslo document is %s with token %q", indexes, got, page.Token)
+		}
+		page, err = tc.GetRangePage(interfaces.RangeQuery{Where: &where, Reverse: true, Token: page.Token})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := entryKeys(page.Entries); got != "[d]" {
+			t.Errorf("indexes %v: Oslo documents before f are %s, want d", indexes, got)
+		}
+	}
+
+	tc := newTestCollection(t, TreeTypeAVL, CollectionOptions{ValueType: ValueTypeJSON}, "")
+	for _, predicate := range []interfaces.FieldPredicate{
+		{Path: "city", Op: "like", Value: `"O%"`},
+		{Path: "city", Op: "eq", Value: `Oslo`},
+		{Path: "", Op: "exists"},
+	} {
+		if _, err := tc.GetRangePage(interfaces.RangeQuery{Where: &predicate}); CodeOf(err) != CodeInvalidArgument {
+			t.Errorf("%+v returned %v", predicate, err)
+		}
+	}
+}
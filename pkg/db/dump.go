@@ -0,0 +1,708 @@
+package db
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DumpFormat is the format of an export or import. CSV and JSON lines
+// hold keys and values, and JSON lines also expiry times; native dumps
+// also hold each collection's tree type and options, so an import can
+// recreate it as it was.
+type DumpFormat string
+
+const (
+	DumpFormatCSV       DumpFormat = "csv"
+	DumpFormatJSONLines DumpFormat = "jsonl"
+	DumpFormatNative    DumpFormat = "native"
+)
+
+var dumpFormats = []DumpFormat{DumpFormatCSV, DumpFormatJSONLines, DumpFormatNative}
+
+var (
+	ErrInvalidDump       = NewError(CodeInvalidArgument, "invalid dump")
+	ErrInvalidDumpFormat = NewError(CodeInvalidArgument, "unknown dump format")
+	ErrInvalidDumpFile   = NewError(CodeInvalidArgument, "invalid dump file name")
+)
+
+const (
+	// dumpMagic starts every native dump; its last byte is the version.
+	dumpMagic = "DBIIDUMP\x01"
+
+	// Native dump records are framed like write-ahead log records and
+	// start with one of these kinds.
+	dumpRecordCollection = 'c'
+	dumpRecordEntry      = 'e'
+	dumpRecordEnd        = 'z'
+
+	// importBatchSize is how many entries an import holds back for a
+	// collection and writes under one lock, unless it bulk loads them.
+	importBatchSize = 1024
+)
+
+func (f DumpFormat) validate() error {
+	for _, known := range dumpFormats {
+		if f == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %q (expected one of %v)", ErrInvalidDumpFormat, string(f), dumpFormats)
+}
+
+// dumpScope is what an export or import covers: a collection, every
+// collection of a schema, or every collection of a pool. Dumps name
+// collections relative to their scope, so a dump can be imported under
+// different names than it was exported from.
+type dumpScope struct {
+	pool       string
+	schema     string
+	collection string
+}
+
+func newDumpScope(poolName, schemaName, collectionName string) (dumpScope, error) {
+	if poolName == "" || (schemaName == "" && collectionName != "") {
+		return dumpScope{}, Errorf(CodeInvalidArgument, "a dump covers a pool, a schema of it or a collection of that")
+	}
+	return dumpScope{pool: poolName, schema: schemaName, collection: collectionName}, nil
+}
+
+// columns lists the names each entry of a text dump carries.
+func (s dumpScope) columns() []string {
+	switch {
+	case s.collection != "":
+		return nil
+	case s.schema != "":
+		return []string{"collection"}
+	}
+	return []string{"schema", "collection"}
+}
+
+// dumpTarget is a collection within a dump's scope, named relative to it.
+type dumpTarget struct {
+	schema     string
+	collection string
+}
+
+// resolve returns the full schema and collection names of target, after
+// checking that it names exactly what the scope leaves open.
+func (s dumpScope) resolve(target dumpTarget, config Config) (string, string, error) {
+	schemaName, collectionName := s.schema, s.collection
+	if s.schema == "" {
+		schemaName = target.schema
+	} else if target.schema != "" {
+		return "", "", fmt.Errorf("%w: entry names a schema inside schema %q", ErrInvalidDump, s.schema)
+	}
+	if s.collection == "" {
+		collectionName = target.collection
+	} else if target.collection != "" {
+		return "", "", fmt.Errorf("%w: entry names a collection inside collection %q", ErrInvalidDump, s.collection)
+	}
+
+	if err := config.validateName("schema", schemaName); err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrInvalidDump, err)
+	}
+	if err := config.validateName("collection", collectionName); err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrInvalidDump, err)
+	}
+	return schemaName, collectionName, nil
+}
+
+// dumpPath returns where the dump file called name lives. Names are
+// valid names that may also hold dots, though not first, so they cannot
+// leave the dump directory.
+func (c Config) dumpPath(name string) (string, error) {
+	if strings.HasPrefix(name, ".") || isValidName(strings.ReplaceAll(name, ".", "_")) != nil {
+		return "", fmt.Errorf("%w %q", ErrInvalidDumpFile, name)
+	}
+	if c.MaxNameLength > 0 && len(name) > c.MaxNameLength {
+		return "", fmt.Errorf("%w %q: %w (%d > %d bytes)", ErrInvalidDumpFile, name, ErrNameTooLong, len(name), c.MaxNameLength)
+	}
+	return filepath.Join(c.DumpDir, name), nil
+}
+
+// exportCollection is a collection in the order an export writes them.
+type exportCollection struct {
+	target     dumpTarget
+	collection *TreeCollection
+}
+
+func (db *Database) exportCollections(scope dumpScope) ([]exportCollection, error) {
+	var schemaNames []string
+	if scope.schema != "" {
+		schemaNames = []string{scope.schema}
+	} else {
+		var err error
+		if schemaNames, err = db.ListSchemas(scope.pool); err != nil {
+			return nil, err
+		}
+		sort.Strings(schemaNames)
+	}
+
+	var collections []exportCollection
+	for _, schemaName := range schemaNames {
+		collectionNames := []string{scope.collection}
+		if scope.collection == "" {
+			var err error
+			if collectionNames, err = db.ListCollections(scope.pool, schemaName); err != nil {
+				return nil, err
+			}
+			sort.Strings(collectionNames)
+		}
+
+		for _, collectionName := range collectionNames {
+			collection, err := db.getCollection(scope.pool, schemaName, collectionName)
+			if err != nil {
+				return nil, err
+			}
+			tc, ok := collection.(*TreeCollection)
+			if !ok {
+				return nil, Errorf(CodeUnsupported, "collection %s/%s cannot be exported", schemaName, collectionName)
+			}
+
+			var target dumpTarget
+			if scope.schema == "" {
+				target.schema = schemaName
+			}
+			if scope.collection == "" {
+				target.collection = collectionName
+			}
+			collections = append(collections, exportCollection{target: target, collection: tc})
+		}
+	}
+	return collections, nil
+}
+
+// Export writes a collection, or every collection of a schema or pool,
+// to w in the given format and returns how many entries it wrote. Each
+// collection is read under its read lock, so it is exported as of one
+// moment, but different collections may be read at different moments.
+func (db *Database) Export(username string, w io.Writer, format DumpFormat, poolName, schemaName, collectionName string) (int, error) {
+	if !db.AuthManager.HasPermission(username, PermRead) {
+		return 0, ErrPermissionDenied
+	}
+	if err := format.validate(); err != nil {
+		return 0, err
+	}
+	scope, err := newDumpScope(poolName, schemaName, collectionName)
+	if err != nil {
+		return 0, err
+	}
+	collections, err := db.exportCollections(scope)
+	if err != nil {
+		return 0, err
+	}
+
+	writer, err := newDumpWriter(w, format, scope)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, c := range collections {
+		if err := writer.begin(c.target, c.collection); err != nil {
+			return count, err
+		}
+		err := c.collection.export(func(key string, value string, deadline int64) error {
+			count++
+			return writer.entry(c.target, key, value, deadline)
+		})
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, writer.end(count)
+}
+
+// ExportFile exports to the named file in the dump directory, replacing
+// it only once the export is complete.
+func (db *Database) ExportFile(username string, name string, format DumpFormat, poolName, schemaName, collectionName string) (int, error) {
+	path, err := db.Config.dumpPath(name)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(db.Config.DumpDir, 0o755); err != nil {
+		return 0, err
+	}
+
+	file, err := os.CreateTemp(db.Config.DumpDir, ".export-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(file.Name())
+
+	buffered := bufio.NewWriter(file)
+	count, err := db.Export(username, buffered, format, poolName, schemaName, collectionName)
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return count, os.Rename(file.Name(), path)
+}
+
+// export calls fn with every live entry in key order, and its deadline or
+// 0, under the read lock.
+func (tc *TreeCollection) export(fn func(key string, value string, deadline int64) error) error {
+	tc.mutex.RLock()
+	defer tc.mutex.RUnlock()
+
+	return tc.exportAt(time.Now(), fn)
+}
+
+// exportAt calls fn with every entry live at now. The caller holds the
+// lock.
+func (tc *TreeCollection) exportAt(now time.Time, fn func(key string, value string, deadline int64) error) error {
+	cursor, err := tc.tree.cursor()
+	if err != nil {
+		return err
+	}
+	for err = cursor.seek(""); err == nil && cursor.valid(); err = cursor.next() {
+		if tc.expiry.expired(cursor.key(), now) {
+			continue
+		}
+		deadline, _ := tc.expiry.deadline(cursor.key())
+		if err = fn(cursor.key(), cursor.value(), deadline); err != nil {
+			break
+		}
+	}
+	if closer, ok := cursor.(io.Closer); ok {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Import reads a dump in the given format from r into a collection, or
+// into the collections of a schema or pool, and returns how many entries
+// it read. Missing pools, schemas and collections are created, the
+// collections of a native dump as they were and others with the default
+// tree type. Entries are written in batches as they are read. With bulk
+// set, avl, redblack and btree collections that start out empty are
+// instead built in O(n) from the entries that arrive in key order, as
+// exported ones do. Entries already imported stay when a later one fails.
+func (db *Database) Import(username string, r io.Reader, format DumpFormat, poolName, schemaName, collectionName string, bulk bool) (int, error) {
+	return db.importDump(db.AuthManager.checker(username), r, format, poolName, schemaName, collectionName, bulk)
+}
+
+func (db *Database) importDump(allowed permissionCheck, r io.Reader, format DumpFormat, poolName, schemaName, collectionName string, bulk bool) (int, error) {
+	if !allowed(PermWrite) {
+		return 0, ErrPermissionDenied
+	}
+	if err := format.validate(); err != nil {
+		return 0, err
+	}
+	scope, err := newDumpScope(poolName, schemaName, collectionName)
+	if err != nil {
+		return 0, err
+	}
+
+	reader, err := newDumpReader(r, format, scope, db.Config)
+	if err != nil {
+		return 0, err
+	}
+
+	importers := make(map[dumpTarget]*importer)
+	var order []dumpTarget
+	open := func(target dumpTarget, meta collectionMeta) error {
+		if _, ok := importers[target]; ok {
+			return nil
+		}
+		tc, err := db.importCollection(allowed, scope, target, meta)
+		if err != nil {
+			return err
+		}
+		importers[target] = tc.newImporter(bulk)
+		order = append(order, target)
+		return nil
+	}
+
+	count := 0
+	read := func() error {
+		for {
+			record, err := reader.next()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if record.meta != nil {
+				// a native dump describes each collection, even an empty
+				// one, before its entries
+				if err := open(record.target, *record.meta); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := open(record.target, collectionMeta{}); err != nil {
+				return err
+			}
+			if err := importers[record.target].add(record.entry); err != nil {
+				return err
+			}
+			count++
+		}
+	}
+
+	// what was read before a failure is still written
+	err = read()
+	for _, target := range order {
+		if flushErr := importers[target].flush(); err == nil {
+			err = flushErr
+		}
+	}
+	return count, err
+}
+
+// ImportFile imports the named file in the dump directory.
+func (db *Database) ImportFile(username string, name string, format DumpFormat, poolName, schemaName, collectionName string, bulk bool) (int, error) {
+	path, err := db.Config.dumpPath(name)
+	if err != nil {
+		return 0, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, Errorf(CodeNotFound, "dump file %q not found", name)
+	} else if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	return db.Import(username, bufio.NewReader(file), format, poolName, schemaName, collectionName, bulk)
+}
+
+// importCollection returns the collection an import writes target to,
+// creating it and whatever holds it if need be.
+func (db *Database) importCollection(allowed permissionCheck, scope dumpScope, target dumpTarget, meta collectionMeta) (*TreeCollection, error) {
+	schemaName, collectionName, err := scope.resolve(target, db.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	collection, err := db.getCollection(scope.pool, schemaName, collectionName)
+	if errors.Is(err, ErrPoolNotFound) {
+		if err = db.createPool(allowed, scope.pool); err == nil || errors.Is(err, ErrPoolExists) {
+			err = ErrSchemaNotFound
+		}
+	}
+	if errors.Is(err, ErrSchemaNotFound) {
+		if err = db.createSchema(allowed, scope.pool, schemaName); err == nil || errors.Is(err, ErrSchemaExists) {
+			err = ErrCollectionNotFound
+		}
+	}
+	if errors.Is(err, ErrCollectionNotFound) {
+		if err = db.createCollection(allowed, scope.pool, schemaName, collectionName, meta.TreeType, meta.Options); err == nil || errors.Is(err, ErrCollectionExists) {
+			collection, err = db.getCollection(scope.pool, schemaName, collectionName)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tc, ok := collection.(*TreeCollection)
+	if !ok {
+		return nil, Errorf(CodeUnsupported, "collection %s/%s cannot be imported into", schemaName, collectionName)
+	}
+	return tc, nil
+}
+
+type dumpWriter interface {
+	// begin starts the entries of a collection.
+	begin(target dumpTarget, tc *TreeCollection) error
+	entry(target dumpTarget, key string, value string, deadline int64) error
+	// end finishes the dump after count entries.
+	end(count int) error
+}
+
+// dumpRecord is an entry of a dump, or the description of a collection
+// that precedes its entries in a native dump.
+type dumpRecord struct {
+	target dumpTarget
+	entry  importEntry
+	meta   *collectionMeta
+}
+
+type dumpReader interface {
+	// next returns the next record, or io.EOF after the last.
+	next() (dumpRecord, error)
+}
+
+func newDumpWriter(w io.Writer, format DumpFormat, scope dumpScope) (dumpWriter, error) {
+	switch format {
+	case DumpFormatCSV:
+		writer := &csvDumpWriter{csv: csv.NewWriter(w), scope: scope}
+		return writer, writer.csv.Write(append(scope.columns(), "key", "value"))
+	case DumpFormatJSONLines:
+		return &jsonDumpWriter{encoder: json.NewEncoder(w)}, nil
+	}
+	_, err := io.WriteString(w, dumpMagic)
+	return &nativeDumpWriter{w: w}, err
+}
+
+func newDumpReader(r io.Reader, format DumpFormat, scope dumpScope, config Config) (dumpReader, error) {
+	switch format {
+	case DumpFormatCSV:
+		reader := &csvDumpReader{csv: csv.NewReader(r), scope: scope}
+		reader.csv.FieldsPerRecord = len(scope.columns()) + 2
+		reader.csv.ReuseRecord = true
+		header, err := reader.csv.Read()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: missing CSV header", ErrInvalidDump)
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidDump, err)
+		}
+		if want := strings.Join(append(scope.columns(), "key", "value"), ","); strings.Join(header, ",") != want {
+			return nil, fmt.Errorf("%w: CSV header %q does not match %q", ErrInvalidDump, strings.Join(header, ","), want)
+		}
+		return reader, nil
+	case DumpFormatJSONLines:
+		return &jsonDumpReader{decoder: json.NewDecoder(r)}, nil
+	}
+
+	magic := make([]byte, len(dumpMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != dumpMagic {
+		return nil, fmt.Errorf("%w: not a native dump", ErrInvalidDump)
+	}
+	reader := &nativeDumpReader{r: r}
+	if config.MaxKeyLength > 0 && config.MaxValueSize > 0 {
+		// room for a key, a value and their framing, or for a collection
+		// record
+		reader.maxRecord = config.MaxKeyLength + config.MaxValueSize + 64<<10
+	}
+	return reader, nil
+}
+
+type csvDumpWriter struct {
+	csv   *csv.Writer
+	scope dumpScope
+}
+
+func (w *csvDumpWriter) begin(target dumpTarget, tc *TreeCollection) error {
+	return nil
+}
+
+func (w *csvDumpWriter) entry(target dumpTarget, key string, value string, deadline int64) error {
+	record := make([]string, 0, 4)
+	if w.scope.schema == "" {
+		record = append(record, target.schema)
+	}
+	if w.scope.collection == "" {
+		record = append(record, target.collection)
+	}
+	return w.csv.Write(append(record, key, value))
+}
+
+func (w *csvDumpWriter) end(count int) error {
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+type csvDumpReader struct {
+	csv   *csv.Reader
+	scope dumpScope
+}
+
+func (r *csvDumpReader) next() (dumpRecord, error) {
+	fields, err := r.csv.Read()
+	if err == io.EOF {
+		return dumpRecord{}, err
+	} else if err != nil {
+		return dumpRecord{}, fmt.Errorf("%w: %v", ErrInvalidDump, err)
+	}
+
+	var record dumpRecord
+	if r.scope.schema == "" {
+		record.target.schema, fields = fields[0], fields[1:]
+	}
+	if r.scope.collection == "" {
+		record.target.collection, fields = fields[0], fields[1:]
+	}
+	record.entry = importEntry{key: fields[0], value: fields[1]}
+	return record, nil
+}
+
+// jsonDumpEntry is a line of a JSON lines dump. ExpiresAt is an RFC 3339
+// time.
+type jsonDumpEntry struct {
+	Schema     string `json:"schema,omitempty"`
+	Collection string `json:"collection,omitempty"`
+	Key        string `json:"key"`
+	Value      string `json:"value"`
+	ExpiresAt  string `json:"expires_at,omitempty"`
+}
+
+type jsonDumpWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *jsonDumpWriter) begin(target dumpTarget, tc *TreeCollection) error {
+	return nil
+}
+
+func (w *jsonDumpWriter) entry(target dumpTarget, key string, value string, deadline int64) error {
+	line := jsonDumpEntry{Schema: target.schema, Collection: target.collection, Key: key, Value: value}
+	if deadline != 0 {
+		line.ExpiresAt = time.Unix(0, deadline).UTC().Format(time.RFC3339Nano)
+	}
+	return w.encoder.Encode(line)
+}
+
+func (w *jsonDumpWriter) end(count int) error {
+	return nil
+}
+
+type jsonDumpReader struct {
+	decoder *json.Decoder
+	line    int
+}
+
+func (r *jsonDumpReader) next() (dumpRecord, error) {
+	var line jsonDumpEntry
+	if err := r.decoder.Decode(&line); err == io.EOF {
+		return dumpRecord{}, err
+	} else if err != nil {
+		return dumpRecord{}, fmt.Errorf("%w: entry %d: %v", ErrInvalidDump, r.line+1, err)
+	}
+	r.line++
+
+	record := dumpRecord{
+		target: dumpTarget{schema: line.Schema, collection: line.Collection},
+		entry:  importEntry{key: line.Key, value: line.Value},
+	}
+	if line.ExpiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339Nano, line.ExpiresAt)
+		if err != nil {
+			return dumpRecord{}, fmt.Errorf("%w: entry %d: expires_at %q is not an RFC 3339 time", ErrInvalidDump, r.line, line.ExpiresAt)
+		}
+		record.entry.deadline = expiresAt.UnixNano()
+	}
+	return record, nil
+}
+
+type nativeDumpWriter struct {
+	w io.Writer
+}
+
+func (w *nativeDumpWriter) record(payload []byte) error {
+	var header [8]byte
+	binary.LittleEndian.PutUint32(header[0:], crc32.ChecksumIEEE(payload))
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(payload)))
+	if _, err := w.w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.w.Write(payload)
+	return err
+}
+
+func appendDumpString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func (w *nativeDumpWriter) begin(target dumpTarget, tc *TreeCollection) error {
+	options, err := json.Marshal(tc.Options)
+	if err != nil {
+		return err
+	}
+	payload := []byte{dumpRecordCollection}
+	payload = appendDumpString(payload, target.schema)
+	payload = appendDumpString(payload, target.collection)
+	payload = appendDumpString(payload, string(tc.TreeType))
+	payload = appendDumpString(payload, string(options))
+	return w.record(payload)
+}
+
+func (w *nativeDumpWriter) entry(target dumpTarget, key string, value string, deadline int64) error {
+	payload := []byte{dumpRecordEntry}
+	payload = appendDumpString(payload, key)
+	payload = appendDumpString(payload, value)
+	payload = binary.AppendVarint(payload, deadline)
+	return w.record(payload)
+}
+
+func (w *nativeDumpWriter) end(count int) error {
+	return w.record(binary.AppendUvarint([]byte{dumpRecordEnd}, uint64(count)))
+}
+
+type nativeDumpReader struct {
+	r         io.Reader
+	maxRecord int
+	target    *dumpTarget
+	entries   uint64
+	done      bool
+}
+
+func (r *nativeDumpReader) next() (dumpRecord, error) {
+	if r.done {
+		return dumpRecord{}, io.EOF
+	}
+
+	var header [8]byte
+	if _, err := io.ReadFull(r.r, header[:]); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return dumpRecord{}, fmt.Errorf("%w: dump is truncated", ErrInvalidDump)
+	} else if err != nil {
+		return dumpRecord{}, err
+	}
+	length := binary.LittleEndian.Uint32(header[4:])
+	if r.maxRecord > 0 && int64(length) > int64(r.maxRecord) {
+		return dumpRecord{}, fmt.Errorf("%w: record of %d bytes after %d entries is too large", ErrInvalidDump, length, r.entries)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r.r, payload); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return dumpRecord{}, fmt.Errorf("%w: dump is truncated", ErrInvalidDump)
+	} else if err != nil {
+		return dumpRecord{}, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[0:]) || len(payload) == 0 {
+		return dumpRecord{}, fmt.Errorf("%w: corrupt record after %d entries", ErrInvalidDump, r.entries)
+	}
+
+	br := &byteReader{buf: payload[1:]}
+	switch payload[0] {
+	case dumpRecordCollection:
+		target := dumpTarget{schema: br.string(), collection: br.string()}
+		meta := collectionMeta{TreeType: TreeType(br.string())}
+		options := br.string()
+		if br.err != nil || json.Unmarshal([]byte(options), &meta.Options) != nil {
+			return dumpRecord{}, fmt.Errorf("%w: corrupt collection record", ErrInvalidDump)
+		}
+		r.target = &target
+		return dumpRecord{target: target, meta: &meta}, nil
+
+	case dumpRecordEntry:
+		if r.target == nil {
+			return dumpRecord{}, fmt.Errorf("%w: entry before any collection", ErrInvalidDump)
+		}
+		entry := importEntry{key: br.string(), value: br.string(), deadline: br.varint()}
+		if br.err != nil {
+			return dumpRecord{}, fmt.Errorf("%w: corrupt entry record", ErrInvalidDump)
+		}
+		r.entries++
+		return dumpRecord{target: *r.target, entry: entry}, nil
+
+	case dumpRecordEnd:
+		if count := br.uvarint(); br.err != nil || count != r.entries {
+			return dumpRecord{}, fmt.Errorf("%w: dump holds %d entries but says %d", ErrInvalidDump, r.entries, count)
+		}
+		r.done = true
+		return dumpRecord{}, io.EOF
+	}
+	return dumpRecord{}, fmt.Errorf("%w: unknown record kind %q", ErrInvalidDump, payload[0])
+}
@@ -0,0 +1,253 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBulkImportRoundTrip(t *testing.T) {
+	source := newTestDatabase(t, t.TempDir())
+	defer source.Close()
+	createTestCollection(t, source, TreeTypeAVL, CollectionOptions{})
+	setTestKeys(t, source, 0, 3*importBatchSize)
+
+	var dump bytes.Buffer
+	if _, err := source.Export("admin", &dump, DumpFormatNative, "p", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	target := newTestDatabase(t, t.TempDir())
+	defer target.Close()
+	count, err := target.Import("admin", &dump, DumpFormatNative, "p", "", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3*importBatchSize {
+		t.Errorf("imported %d entries, want %d", count, 3*importBatchSize)
+	}
+	if got, want := testContents(target), testContents(source); !reflect.DeepEqual(got, want) {
+		t.Fatalf("imported collection holds %d keys, want %d", len(got), len(want))
+	}
+}
+
+func TestBulkImportStreamsSortedEntries(t *testing.T) {
+	tc := newTestCollection(t, TreeTypeAVL, CollectionOptions{}, "")
+	im := tc.newImporter(true)
+	want := make(map[string]string)
+	add := func(key, value string) {
+		t.Helper()
+		if err := im.add(importEntry{key: key, value: value}); err != nil {
+			t.Fatal(err)
+		}
+		want[key] = value
+	}
+
+	// entries in key order are only collected, a repeated key replacing
+	// the one before it, and nothing is written until the tree is built
+	for i := 0; i < 2*importBatchSize; i++ {
+		add(fmt.Sprintf("k%05d", i), "1")
+	}
+	add(fmt.Sprintf("k%05d", 2*importBatchSize-1), "2")
+	if !im.sorted || len(im.keys) != 2*importBatchSize || len(im.pending) != 0 {
+		t.Fatalf("importer holds %d sorted keys and %d pending, want %d and none", len(im.keys), len(im.pending), 2*importBatchSize)
+	}
+	if n, err := tc.Count("", ""); err != nil || n != 0 {
+		t.Fatalf("collection holds %d keys, %v, before the import ends", n, err)
+	}
+
+	// the first entry out of order builds the tree from those before it,
+	// and it and the rest are written in batches
+	for i := 0; i < 2*importBatchSize; i += 2 {
+		add(fmt.Sprintf("j%05d", i), "3")
+	}
+	if im.sorted || im.keys != nil {
+		t.Fatal("importer still collects sorted keys after one out of order")
+	}
+	if len(im.pending) >= importBatchSize {
+		t.Fatalf("importer holds back %d entries", len(im.pending))
+	}
+	if err := im.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := tc.GetRange("", "\xff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*contents, want) {
+		t.Fatalf("collection holds %d keys, want %d", len(*contents), len(want))
+	}
+	if height, limit := tc.tree.(*AVLTree).Root.Height, 14; height > limit {
+		t.Errorf("tree is %d high, want at most %d", height, limit)
+	}
+}
+
+// testCollection returns the collection pool p holds under schema and name.
+func testCollection(t *testing.T, database *Database, schemaName, collectionName string) *TreeCollection {
+	t.Helper()
+	collection, err := database.getCollection("p", schemaName, collectionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return collection.(*TreeCollection)
+}
+
+func TestDumpRoundTrip(t *testing.T) {
+	source := newTestDatabase(t, t.TempDir())
+	defer source.Close()
+	createTestCollection(t, source, TreeTypeRedBlack, CollectionOptions{ValueType: ValueTypeInt})
+	setTestKeys(t, source, 0, 5)
+	if err := testCollection(t, source, "s", "c").SetWithTTL("ttl", "ttl", "5", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.createSchema(trusted, "p", "t"); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.createCollection(trusted, "p", "t", "empty", TreeTypeSkipList, CollectionOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range dumpFormats {
+		var dump bytes.Buffer
+		if count, err := source.Export("admin", &dump, format, "p", "", ""); err != nil || count != 6 {
+			t.Fatalf("%s: exported %d entries, %v, want 6", format, count, err)
+		}
+		target := newTestDatabase(t, t.TempDir())
+		defer target.Close()
+		if count, err := target.Import("admin", &dump, format, "p", "", "", false); err != nil || count != 6 {
+			t.Fatalf("%s: imported %d entries, %v, want 6", format, count, err)
+		}
+		if got, want := testContents(target), testContents(source); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: imported collection holds %v, want %v", format, got, want)
+		}
+
+		// only CSV leaves out expiry times, and only native dumps describe
+		// collections
+		tc := testCollection(t, target, "s", "c")
+		if _, ok, err := tc.TTL("ttl"); err != nil || ok == (format == DumpFormatCSV) {
+			t.Errorf("%s: imported ttl has a time to live: %v, %v", format, ok, err)
+		}
+		source := testCollection(t, source, "s", "c")
+		native := tc.TreeType == source.TreeType && reflect.DeepEqual(tc.Options, source.Options)
+		if native != (format == DumpFormatNative) {
+			t.Errorf("%s: imported collection is %s with %+v", format, tc.TreeType, tc.Options)
+		}
+		if _, err := target.getCollection("p", "t", "empty"); (err == nil) != (format == DumpFormatNative) {
+			t.Errorf("%s: empty collection imported: %v", format, err)
+		}
+	}
+}
+
+func TestDumpScopeNamesCollections(t *testing.T) {
+	database := newTestDatabase(t, t.TempDir())
+	defer database.Close()
+	createTestCollection(t, database, TreeTypeAVL, CollectionOptions{})
+	setTestKeys(t, database, 0, 2)
+
+	var dump bytes.Buffer
+	if _, err := database.Export("admin", &dump, DumpFormatCSV, "p", "s", ""); err != nil {
+		t.Fatal(err)
+	}
+	if want := "collection,key,value\nc,k000,0\nc,k001,1\n"; dump.String() != want {
+		t.Errorf("schema dump is %q, want %q", dump.String(), want)
+	}
+
+	// a dump names collections relative to its scope, so it can be
+	// imported under other names
+	dump.Reset()
+	if _, err := database.Export("admin", &dump, DumpFormatJSONLines, "p", "s", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.Import("admin", &dump, DumpFormatJSONLines, "p", "copy", "d", false); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := testContents(database), map[string]string{"k000": "0", "k001": "1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("source holds %v", got)
+	}
+	if contents, err := testCollection(t, database, "copy", "d").GetRange("", "\xff"); err != nil || len(*contents) != 2 {
+		t.Errorf("copy holds %v, %v", contents, err)
+	}
+}
+
+func TestDumpFiles(t *testing.T) {
+	database := newTestDatabase(t, t.TempDir())
+	defer database.Close()
+	createTestCollection(t, database, TreeTypeAVL, CollectionOptions{})
+	setTestKeys(t, database, 0, 3)
+
+	if count, err := database.ExportFile("admin", "p.native", DumpFormatNative, "p", "", ""); err != nil || count != 3 {
+		t.Fatalf("exported %d entries, %v", count, err)
+	}
+	if count, err := database.ImportFile("admin", "p.native", DumpFormatNative, "q", "", "", false); err != nil || count != 3 {
+		t.Fatalf("imported %d entries, %v", count, err)
+	}
+	for _, name := range []string{"../p", ".hidden", "a/b", ""} {
+		if _, err := database.ExportFile("admin", name, DumpFormatNative, "p", "", ""); !errors.Is(err, ErrInvalidDumpFile) {
+			t.Errorf("exporting to %q returned %v, want ErrInvalidDumpFile", name, err)
+		}
+	}
+	if _, err := database.ImportFile("admin", "missing", DumpFormatNative, "p", "", "", false); CodeOf(err) != CodeNotFound {
+		t.Errorf("importing a missing file returned %v", err)
+	}
+	if _, err := database.Export("admin", &bytes.Buffer{}, "xml", "p", "", ""); !errors.Is(err, ErrInvalidDumpFormat) {
+		t.Errorf("an unknown format returned %v, want ErrInvalidDumpFormat", err)
+	}
+	if _, err := database.Export("admin", &bytes.Buffer{}, DumpFormatCSV, "p", "", "c"); CodeOf(err) != CodeInvalidArgument {
+		t.Errorf("a collection without a schema returned %v", err)
+	}
+}
+
+func TestImportRejectsInvalidDumps(t *testing.T) {
+	var orphan bytes.Buffer
+	orphan.WriteString(dumpMagic)
+	if err := (&nativeDumpWriter{w: &orphan}).entry(dumpTarget{}, "a", "1", 0); err != nil {
+		t.Fatal(err)
+	}
+	var corrupt bytes.Buffer
+	corrupt.WriteString(dumpMagic)
+	writer := &nativeDumpWriter{w: &corrupt}
+	if err := writer.begin(dumpTarget{}, newTestCollection(t, TreeTypeAVL, CollectionOptions{}, "")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.end(1); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		format DumpFormat
+		dump   string
+	}{
+		{"no header", DumpFormatCSV, ""},
+		{"wrong header", DumpFormatCSV, "k,v\n"},
+		{"missing field", DumpFormatCSV, "key,value\na\n"},
+		{"broken JSON", DumpFormatJSONLines, `{"key":`},
+		{"bad expiry", DumpFormatJSONLines, `{"key":"a","value":"1","expires_at":"soon"}`},
+		{"collection inside collection", DumpFormatJSONLines, `{"collection":"d","key":"a","value":"1"}`},
+		{"not native", DumpFormatNative, "garbage"},
+		{"truncated", DumpFormatNative, dumpMagic},
+		{"entry before collection", DumpFormatNative, orphan.String()},
+		{"wrong count", DumpFormatNative, corrupt.String()},
+		{"flipped byte", DumpFormatNative, corrupt.String()[:len(dumpMagic)+9] + "x" + corrupt.String()[len(dumpMagic)+10:]},
+	}
+	database := newTestDatabase(t, t.TempDir())
+	defer database.Close()
+	for _, test := range tests {
+		if _, err := database.Import("admin", strings.NewReader(test.dump), test.format, "p", "s", "c", false); !errors.Is(err, ErrInvalidDump) {
+			t.Errorf("%s: import returned %v, want ErrInvalidDump", test.name, err)
+		}
+	}
+
+	// entries before the one that fails stay imported
+	dump := `{"key":"a","value":"1"}` + "\n" + `{"key":"b",`
+	if count, err := database.Import("admin", strings.NewReader(dump), DumpFormatJSONLines, "p", "s", "c", false); !errors.Is(err, ErrInvalidDump) || count != 1 {
+		t.Fatalf("import returned %d, %v, want 1 and ErrInvalidDump", count, err)
+	}
+	if got := testContents(database); !reflect.DeepEqual(got, map[string]string{"a": "1"}) {
+		t.Errorf("collection holds %v, want a", got)
+	}
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+)
+
+type ErrorCode string
+
+const (
+	CodeNotFound         ErrorCode = "NOT_FOUND"
+	CodeAlreadyExists    ErrorCode = "ALREADY_EXISTS"
+	CodePermissionDenied ErrorCode = "PERMISSION_DENIED"
+	CodeAuthFailed       ErrorCode = "AUTH_FAILED"
+	CodeInvalidArgument  ErrorCode = "INVALID_ARGUMENT"
+	CodeUnsupported      ErrorCode = "UNSUPPORTED"
+	CodeConditionFailed  ErrorCode = "CONDITION_FAILED"
+	CodeReadOnly         ErrorCode = "READ_ONLY"
+	CodeNotLeader        ErrorCode = "NOT_LEADER"
+	CodeUnavailable      ErrorCode = "UNAVAILABLE"
+	CodeInternal         ErrorCode = "INTERNAL"
+)
+
+// Error is an error carrying a stable code that is sent to clients
+// alongside the human-readable message.
+type Error struct {
+	Code    ErrorCode
+	Message string
+}
+
+func NewError(code ErrorCode, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func Errorf(code ErrorCode, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// CodeOf returns the code of the first *Error in err's chain, or
+// CodeInternal if there is none.
+func CodeOf(err error) ErrorCode {
+	if err == nil {
+		return ""
+	}
+
+	var dbErr *Error
+	if errors.As(err, &dbErr) {
+		return dbErr.Code
+	}
+	return CodeInternal
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCodeOf(t *testing.T) {
+	tests := []struct {
+		err  error
+		want ErrorCode
+	}{
+		{nil, ""},
+		{errors.New("disk on fire"), CodeInternal},
+		{ErrKeyNotFound, CodeNotFound},
+		{fmt.Errorf("key %q: %w", "k", ErrPoolExists), CodeAlreadyExists},
+		{Errorf(CodeUnsupported, "no %s", "ranges"), CodeUnsupported},
+	}
+	for _, test := range tests {
+		if got := CodeOf(test.err); got != test.want {
+			t.Errorf("CodeOf(%v) is %q, want %q", test.err, got, test.want)
+		}
+	}
+}
+
+func TestDatabaseErrorCodes(t *testing.T) {
+	database := newTestDatabase(t, t.TempDir())
+	defer database.Close()
+	createTestCollection(t, database, TreeTypeAVL, CollectionOptions{})
+	denied := func(Permission) bool { return false }
+
+	collection, err := database.getCollection("p", "s", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, missingPool := database.getCollection("q", "s", "c")
+	_, missingSchema := database.getCollection("p", "t", "c")
+	_, missingCollection := database.getCollection("p", "s", "d")
+	_, missingKey := collection.Get("k")
+
+	tests := []struct {
+		name string
+		err  error
+		want ErrorCode
+	}{
+		{"existing pool", database.createPool(trusted, "p"), CodeAlreadyExists},
+		{"existing schema", database.createSchema(trusted, "p", "s"), CodeAlreadyExists},
+		{"existing collection", database.createCollection(trusted, "p", "s", "c", TreeTypeAVL, CollectionOptions{}), CodeAlreadyExists},
+		{"missing pool", missingPool, CodeNotFound},
+		{"missing schema", missingSchema, CodeNotFound},
+		{"missing collection", missingCollection, CodeNotFound},
+		{"missing key", missingKey, CodeNotFound},
+		{"deleting a missing key", collection.Delete("k"), CodeNotFound},
+		{"denied", database.createPool(denied, "q"), CodePermissionDenied},
+	}
+	for _, test := range tests {
+		if got := CodeOf(test.err); got != test.want {
+			t.Errorf("%s: %v has code %q, want %q", test.name, test.err, got, test.want)
+		}
+	}
+}
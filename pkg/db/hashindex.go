@@ -0,0 +1,280 @@
+package db
+
+import (
+	"hash/maphash"
+	"sort"
+)
+
+const (
+	hashInitialBuckets = 8
+	// hashRehashSteps is how many buckets each operation migrates while a
+	// resize is in progress, so no single Set pays for the whole rehash.
+	hashRehashSteps = 4
+)
+
+type hashEntry struct {
+	Key   string
+	Value string
+	next  *hashEntry
+}
+
+type hashTable struct {
+	buckets []*hashEntry
+	size    int
+}
+
+func newHashTable(buckets int) *hashTable {
+	return &hashTable{buckets: make([]*hashEntry, buckets)}
+}
+
+// HashIndex is a chained hash table that grows and shrinks incrementally:
+// while resizing, entries live in two tables and every operation moves a
+// few buckets from the old table to the new one.
+type HashIndex struct {
+	tables    [2]*hashTable
+	rehashIdx int
+	seed      maphash.Seed
+}
+
+func NewHashIndex() *HashIndex {
+	return &HashIndex{
+		tables:    [2]*hashTable{newHashTable(hashInitialBuckets), nil},
+		rehashIdx: -1,
+		seed:      maphash.MakeSeed(),
+	}
+}
+
+func (h *HashIndex) rehashing() bool {
+	return h.rehashIdx >= 0
+}
+
+func (h *HashIndex) bucket(table *hashTable, key string) int {
+	return int(maphash.String(h.seed, key) % uint64(len(table.buckets)))
+}
+
+func (h *HashIndex) Len() int {
+	n := h.tables[0].size
+	if h.rehashing() {
+		n += h.tables[1].size
+	}
+	return n
+}
+
+func (h *HashIndex) startResize(buckets int) {
+	h.tables[1] = newHashTable(buckets)
+	h.rehashIdx = 0
+}
+
+// rehashStep moves up to n non-empty buckets into the new table, visiting
+// at most 10*n empty ones so a sparse table cannot stall the caller.
+func (h *HashIndex) rehashStep(n int) {
+	if !h.rehashing() {
+		return
+	}
+
+	from, to := h.tables[0], h.tables[1]
+	emptyVisits := n * 10
+
+	for n > 0 && h.rehashIdx < len(from.buckets) {
+		entry := from.buckets[h.rehashIdx]
+		if entry == nil {
+			h.rehashIdx++
+			emptyVisits--
+			if emptyVisits == 0 {
+				return
+			}
+			continue
+		}
+
+		for entry != nil {
+			next := entry.next
+			i := h.bucket(to, entry.Key)
+			entry.next = to.buckets[i]
+			to.buckets[i] = entry
+			from.size--
+			to.size++
+			entry = next
+		}
+		from.buckets[h.rehashIdx] = nil
+		h.rehashIdx++
+		n--
+	}
+
+	if h.rehashIdx >= len(from.buckets) {
+		h.tables[0] = to
+		h.tables[1] = nil
+		h.rehashIdx = -1
+	}
+}
+
+func (h *HashIndex) maybeResize() {
+	if h.rehashing() {
+		return
+	}
+
+	table := h.tables[0]
+	if table.size >= len(table.buckets) {
+		h.startResize(len(table.buckets) * 2)
+	} else if len(table.buckets) > hashInitialBuckets && table.size < len(table.buckets)/8 {
+		h.startResize(len(table.buckets) / 2)
+	}
+}
+
+func (h *HashIndex) find(key string) *hashEntry {
+	for t := 0; t < 2; t++ {
+		table := h.tables[t]
+		if table == nil {
+			break
+		}
+		for entry := table.buckets[h.bucket(table, key)]; entry != nil; entry = entry.next {
+			if entry.Key == key {
+				return entry
+			}
+		}
+	}
+	return nil
+}
+
+// Search does not advance a resize: it runs under the collection's read
+// lock alongside other readers, so it must not modify the index.
+func (h *HashIndex) Search(key string) (string, bool) {
+	if entry := h.find(key); entry != nil {
+		return entry.Value, true
+	}
+	return "", false
+}
+
+func (h *HashIndex) Insert(key string, value string) {
+	h.rehashStep(hashRehashSteps)
+
+	if entry := h.find(key); entry != nil {
+		entry.Value = value
+		return
+	}
+
+	// new entries go to the new table so the old one only ever drains
+	table := h.tables[0]
+	if h.rehashing() {
+		table = h.tables[1]
+	}
+	i := h.bucket(table, key)
+	table.buckets[i] = &hashEntry{Key: key, Value: value, next: table.buckets[i]}
+	table.size++
+
+	h.maybeResize()
+}
+
+func (h *HashIndex) Delete(key string) bool {
+	h.rehashStep(hashRehashSteps)
+
+	for t := 0; t < 2; t++ {
+		table := h.tables[t]
+		if table == nil {
+			break
+		}
+
+		i := h.bucket(table, key)
+		for prev, entry := (*hashEntry)(nil), table.buckets[i]; entry != nil; prev, entry = entry, entry.next {
+			if entry.Key != key {
+				continue
+			}
+			if prev == nil {
+				table.buckets[i] = entry.next
+			} else {
+				prev.next = entry.next
+			}
+			table.size--
+			h.maybeResize()
+			return true
+		}
+	}
+	return false
+}
+
+// searchRange has no ordering to exploit and scans every entry.
+func (h *HashIndex) searchRange(leftBound, rightBound string, result *map[string]string) {
+	for t := 0; t < 2; t++ {
+		table := h.tables[t]
+		if table == nil {
+			break
+		}
+		for _, entry := range table.buckets {
+			for ; entry != nil; entry = entry.next {
+				if leftBound <= entry.Key && entry.Key <= rightBound {
+					(*result)[entry.Key] = entry.Value
+				}
+			}
+		}
+	}
+}
+
+func (h *HashIndex) put(key string, value string) error {
+	h.Insert(key, value)
+	return nil
+}
+
+func (h *HashIndex) get(key string) (string, bool, error) {
+	value, found := h.Search(key)
+	return value, found, nil
+}
+
+func (h *HashIndex) remove(key string) (bool, error) {
+	return h.Delete(key), nil
+}
+
+func (h *HashIndex) rangeInto(leftBound string, rightBound string, result *map[string]string) error {
+	h.searchRange(leftBound, rightBound, result)
+	return nil
+}
+
+// cursor sorts a snapshot of every entry, which is what ordered access
+// to a hash index costs.
+func (h *HashIndex) cursor() (treeCursor, error) {
+	entries := make([]*hashEntry, 0, h.Len())
+	for t := 0; t < 2; t++ {
+		table := h.tables[t]
+		if table == nil {
+			break
+		}
+		for _, entry := range table.buckets {
+			for ; entry != nil; entry = entry.next {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return &hashCursor{entries: entries, pos: len(entries)}, nil
+}
+
+type hashCursor struct {
+	entries []*hashEntry
+	pos     int
+}
+
+func (c *hashCursor) seek(key string) error {
+	c.pos = sort.Search(len(c.entries), func(i int) bool { return c.entries[i].Key >= key })
+	return nil
+}
+
+func (c *hashCursor) last() error {
+	c.pos = len(c.entries) - 1
+	return nil
+}
+
+func (c *hashCursor) next() error {
+	if c.valid() {
+		c.pos++
+	}
+	return nil
+}
+
+func (c *hashCursor) prev() error {
+	if c.valid() {
+		c.pos--
+	}
+	return nil
+}
+
+func (c *hashCursor) valid() bool   { return c.pos >= 0 && c.pos < len(c.entries) }
+func (c *hashCursor) key() string   { return c.entries[c.pos].Key }
+func (c *hashCursor) value() string { return c.entries[c.pos].Value }
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestHashIndexResizesIncrementally(t *testing.T) {
+	h := NewHashIndex()
+	sawRehash := false
+	for i := 0; i < 5000; i++ {
+		h.Insert(fmt.Sprint(i), fmt.Sprint(i))
+		sawRehash = sawRehash || h.rehashing()
+		// every key stays reachable while entries are split between tables
+		if i%97 == 0 {
+			for j := 0; j <= i; j += 13 {
+				if value, found := h.Search(fmt.Sprint(j)); !found || value != fmt.Sprint(j) {
+					t.Fatalf("after %d inserts, %d is %q, %v", i+1, j, value, found)
+				}
+			}
+		}
+	}
+	if !sawRehash {
+		t.Fatal("5000 inserts never resized the table")
+	}
+	if h.Len() != 5000 {
+		t.Fatalf("index holds %d entries, want 5000", h.Len())
+	}
+	grown := len(h.tables[0].buckets)
+	if grown < 5000/2 {
+		t.Fatalf("5000 entries live in %d buckets", grown)
+	}
+
+	for i := 0; i < 5000; i++ {
+		if i%10 != 0 && !h.Delete(fmt.Sprint(i)) {
+			t.Fatalf("deleting %d failed", i)
+		}
+	}
+	if h.Delete("1") {
+		t.Fatal("deleted 1 twice")
+	}
+	if h.Len() != 500 {
+		t.Fatalf("index holds %d entries, want 500", h.Len())
+	}
+	for i := 0; i < 5000; i += 10 {
+		if _, found := h.Search(fmt.Sprint(i)); !found {
+			t.Fatalf("%d was lost while shrinking", i)
+		}
+	}
+	if shrunk := len(h.tables[0].buckets); shrunk >= grown {
+		t.Fatalf("table kept %d buckets after most entries were deleted", shrunk)
+	}
+}
+
+func TestHashCollectionRanges(t *testing.T) {
+	tc := newTestCollection(t, TreeTypeHash, CollectionOptions{}, "")
+	if _, err := tc.GetRange("a", "z"); !errors.Is(err, ErrRangeUnsupported) {
+		t.Errorf("GetRange returned %v, want ErrRangeUnsupported", err)
+	}
+
+	tc = newTestCollection(t, TreeTypeHash, CollectionOptions{HashRangeScan: true}, "")
+	for _, key := range []string{"a", "m", "n", "z"} {
+		if err := tc.Set(key, key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	contents, err := tc.GetRange("b", "n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*contents) != 2 || (*contents)["m"] != "m" || (*contents)["n"] != "n" {
+		t.Errorf("b to n holds %v, want m and n", *contents)
+	}
+}
@@ -0,0 +1,370 @@
+package db
+
+import (
+	"DB_II/pkg/interfaces"
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	historyLogFile = "history.log"
+
+	MaxHistoryVersions = 1 << 16
+)
+
+var (
+	ErrHistoryDisabled  = NewError(CodeUnsupported, "collection does not keep version history")
+	ErrVersionsDisabled = NewError(CodeUnsupported, "collection does not number versions")
+	ErrVersionNotFound  = NewError(CodeNotFound, "version is not in the key's history")
+)
+
+var _ interfaces.HistoryCollection = (*TreeCollection)(nil)
+
+// keyVersion is one value a key has held, or its deletion. time is when
+// it was written, in Unix nanoseconds.
+type keyVersion struct {
+	version int64
+	time    int64
+	value   string
+	deleted bool
+}
+
+func (v keyVersion) entry() interfaces.Version {
+	return interfaces.Version{
+		Version: v.version,
+		Time:    time.Unix(0, v.time).UTC(),
+		Value:   v.value,
+		Deleted: v.deleted,
+	}
+}
+
+// versionTable numbers the versions of every key for collections that are
+// versioned or keep history, and keeps the recent ones, oldest first, for
+// those created with a history limit or retention window. Other
+// collections have an empty table that records nothing. A version is dropped once limit
+// newer ones exist, or once it was replaced longer than retention ago, so
+// reads as of any time inside the window stay exact. A deleted key is
+// forgotten once its deletion leaves the window, or at once without
+// history, after which its version numbers start again from 1.
+//
+// Disk-backed collections append every version to a history log, which is
+// compacted when the collection is opened. The collection's lock guards
+// the table.
+type versionTable struct {
+	keys      map[string][]keyVersion
+	limit     int
+	retention time.Duration
+	versioned bool
+	// fold makes keys that differ only in case share a history, as they
+	// do in case-insensitive collections.
+	fold   bool
+	file   *os.File
+	writer *bufio.Writer
+}
+
+func openVersionTable(options CollectionOptions, dir string, persistent bool) (*versionTable, error) {
+	t := &versionTable{
+		keys:      make(map[string][]keyVersion),
+		limit:     options.HistoryVersions,
+		retention: time.Duration(options.HistoryRetentionMillis) * time.Millisecond,
+		versioned: options.Versioned,
+		fold:      options.Comparator == ComparatorCaseInsensitive,
+	}
+	if !persistent || !t.numbered() {
+		return t, nil
+	}
+
+	path := filepath.Join(dir, historyLogFile)
+	if err := t.replay(path); err != nil {
+		return nil, err
+	}
+
+	// rewrite the log with only the versions still kept
+	now := time.Now().UnixNano()
+	var compacted []byte
+	for key := range t.keys {
+		t.prune(key, now)
+		for _, v := range t.keys[key] {
+			compacted = appendVersionRecord(compacted, key, v)
+		}
+	}
+	if err := writeFileAtomic(path, compacted); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	t.file = file
+	t.writer = bufio.NewWriter(file)
+	return t, nil
+}
+
+// History log records are framed like write-ahead log records: a CRC-32
+// and length, then the key, version number, time, a deletion flag and the
+// value.
+func appendVersionRecord(buf []byte, key string, v keyVersion) []byte {
+	var payload []byte
+	payload = binary.AppendUvarint(payload, uint64(len(key)))
+	payload = append(payload, key...)
+	payload = binary.AppendVarint(payload, v.version)
+	payload = binary.AppendVarint(payload, v.time)
+	if v.deleted {
+		payload = append(payload, 1)
+	} else {
+		payload = append(payload, 0)
+	}
+	payload = binary.AppendUvarint(payload, uint64(len(v.value)))
+	payload = append(payload, v.value...)
+
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(payload))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(payload)))
+	return append(buf, payload...)
+}
+
+// replay loads the log, stopping at the first torn or corrupt record.
+func (t *versionTable) replay(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	good := 0
+	for len(data)-good >= 8 {
+		checksum := binary.LittleEndian.Uint32(data[good:])
+		length := int(binary.LittleEndian.Uint32(data[good+4:]))
+		if len(data)-good-8 < length {
+			break
+		}
+		payload := data[good+8 : good+8+length]
+		if crc32.ChecksumIEEE(payload) != checksum {
+			break
+		}
+
+		r := &byteReader{buf: payload}
+		key := r.string()
+		var v keyVersion
+		v.version = r.varint()
+		v.time = r.varint()
+		v.deleted = r.byte() == 1
+		v.value = r.string()
+		if r.err != nil {
+			break
+		}
+		t.apply(key, v)
+		good += 8 + length
+	}
+
+	if good < len(data) {
+		log.Printf("history log %s: discarding %d bytes of torn records", path, len(data)-good)
+	}
+	return nil
+}
+
+func (t *versionTable) enabled() bool {
+	return t.limit > 0 || t.retention > 0
+}
+
+func (t *versionTable) numbered() bool {
+	return t.versioned || t.enabled()
+}
+
+func (t *versionTable) key(key string) string {
+	if t.fold {
+		return strings.Map(foldRune, key)
+	}
+	return key
+}
+
+func (t *versionTable) apply(key string, v keyVersion) {
+	if !t.enabled() {
+		// without history only the number of a live key's version is kept
+		if v.deleted {
+			delete(t.keys, key)
+		} else {
+			v.value = ""
+			t.keys[key] = []keyVersion{v}
+		}
+		return
+	}
+
+	versions := append(t.keys[key], v)
+	if t.limit > 0 && len(versions) > t.limit {
+		versions = versions[len(versions)-t.limit:]
+	}
+	t.keys[key] = versions
+}
+
+// record adds the next version of key, written at the given time, if the
+// table numbers versions.
+func (t *versionTable) record(key string, value string, deleted bool, at int64) error {
+	if !t.numbered() {
+		return nil
+	}
+	key = t.key(key)
+	v := keyVersion{version: 1, time: at, value: value, deleted: deleted}
+	if versions := t.keys[key]; len(versions) > 0 {
+		v.version = versions[len(versions)-1].version + 1
+	}
+	if t.writer != nil {
+		logged := v
+		if !t.enabled() {
+			logged.value = ""
+		}
+		if _, err := t.writer.Write(appendVersionRecord(nil, key, logged)); err != nil {
+			return err
+		}
+		if err := t.writer.Flush(); err != nil {
+			return err
+		}
+	}
+	t.apply(key, v)
+	t.prune(key, at)
+	return nil
+}
+
+// prune drops the versions of an already folded key that were replaced
+// before the retention window.
+func (t *versionTable) prune(key string, now int64) {
+	if t.retention <= 0 {
+		return
+	}
+	cutoff := now - t.retention.Nanoseconds()
+	versions := t.keys[key]
+	drop := 0
+	for drop+1 < len(versions) && versions[drop+1].time <= cutoff {
+		drop++
+	}
+	versions = versions[drop:]
+	if len(versions) == 1 && versions[0].deleted && versions[0].time <= cutoff {
+		delete(t.keys, key)
+		return
+	}
+	t.keys[key] = versions
+}
+
+// current returns the number of key's live version, or 0 if it was
+// deleted or the table does not number versions.
+func (t *versionTable) current(key string) int64 {
+	versions := t.keys[t.key(key)]
+	if len(versions) == 0 || versions[len(versions)-1].deleted {
+		return 0
+	}
+	return versions[len(versions)-1].version
+}
+
+// kept returns the kept versions of key, oldest first.
+func (t *versionTable) kept(key string, now int64) []keyVersion {
+	key = t.key(key)
+	t.prune(key, now)
+	return t.keys[key]
+}
+
+func (t *versionTable) close() error {
+	if t.file == nil {
+		return nil
+	}
+	if err := t.writer.Flush(); err != nil {
+		t.file.Close()
+		return err
+	}
+	return t.file.Close()
+}
+
+// history returns the kept versions of key after deleting it if it has
+// expired, so its expiry shows up as a deletion.
+func (tc *TreeCollection) history(key string) ([]keyVersion, error) {
+	if !tc.versions.enabled() {
+		return nil, ErrHistoryDisabled
+	}
+	if err := tc.config.validateKey(key); err != nil {
+		return nil, err
+	}
+
+	now := tc.clock.now()
+	if tc.expiry.expired(key, now) {
+		if err := tc.removeExpired(key); err != nil {
+			return nil, err
+		}
+	}
+	return tc.versions.kept(key, now.UnixNano()), nil
+}
+
+// GetHistory returns every kept version of key, oldest first, including
+// its deletions.
+func (tc *TreeCollection) GetHistory(key string) ([]interfaces.Version, error) {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	versions, err := tc.history(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, ErrKeyNotFound
+	}
+
+	entries := make([]interfaces.Version, len(versions))
+	for i, v := range versions {
+		entries[i] = v.entry()
+	}
+	return entries, nil
+}
+
+// GetVersion returns the given version of key, which may be a deletion.
+func (tc *TreeCollection) GetVersion(key string, version int64) (interfaces.Version, error) {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	versions, err := tc.history(key)
+	if err != nil {
+		return interfaces.Version{}, err
+	}
+	i := sort.Search(len(versions), func(i int) bool { return versions[i].version >= version })
+	if i == len(versions) || versions[i].version != version {
+		return interfaces.Version{}, ErrVersionNotFound
+	}
+	return versions[i].entry(), nil
+}
+
+// GetAsOf returns the version of key that was current at the given time.
+// It fails with ErrKeyNotFound if the key did not exist then, and with
+// ErrVersionNotFound if that time is older than the history kept.
+func (tc *TreeCollection) GetAsOf(key string, at time.Time) (interfaces.Version, error) {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	versions, err := tc.history(key)
+	if err != nil {
+		return interfaces.Version{}, err
+	}
+	if len(versions) == 0 {
+		return interfaces.Version{}, ErrKeyNotFound
+	}
+
+	// versions are in the order they were written, which a clock stepping
+	// back could leave out of time order, so search from the newest
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].time > at.UnixNano() {
+			continue
+		}
+		if versions[i].deleted {
+			return interfaces.Version{}, ErrKeyNotFound
+		}
+		return versions[i].entry(), nil
+	}
+	if versions[0].version == 1 {
+		return interfaces.Version{}, ErrKeyNotFound
+	}
+	return interfaces.Version{}, ErrVersionNotFound
+}
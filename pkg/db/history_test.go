@@ -0,0 +1,231 @@
+package db
+
+import (
+	"DB_II/pkg/interfaces"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUnversionedCollectionKeepsNoVersions(t *testing.T) {
+	dir := t.TempDir()
+	tc := newTestCollection(t, TreeTypeLSM, CollectionOptions{}, dir)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := tc.Set(key, key, "1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := tc.Set(key, key, "2"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tc.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tc.versions.keys) != 0 {
+		t.Errorf("version table holds %d keys", len(tc.versions.keys))
+	}
+	if _, err := os.Stat(filepath.Join(dir, historyLogFile)); !os.IsNotExist(err) {
+		t.Errorf("history log exists: %v", err)
+	}
+	if _, _, err := tc.GetVersioned("a"); !errors.Is(err, ErrVersionsDisabled) {
+		t.Errorf("GetVersioned returned %v, want ErrVersionsDisabled", err)
+	}
+	if _, err := tc.UpdateIfVersion("a", 2, "3"); !errors.Is(err, ErrVersionsDisabled) {
+		t.Errorf("UpdateIfVersion returned %v, want ErrVersionsDisabled", err)
+	}
+	if _, err := tc.GetHistory("a"); !errors.Is(err, ErrHistoryDisabled) {
+		t.Errorf("GetHistory returned %v, want ErrHistoryDisabled", err)
+	}
+
+	// conditions on values need no versions
+	if _, err := tc.UpdateIfEquals("a", "2", "3"); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := tc.Get("a"); err != nil || value != "3" {
+		t.Fatalf("a is %q, %v, want 3", value, err)
+	}
+}
+
+func TestVersionedCollectionNumbersWithoutHistory(t *testing.T) {
+	dir := t.TempDir()
+	tc, err := NewTreeCollection(TreeTypeLSM, CollectionOptions{Versioned: true}, DefaultConfig(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := tc.Set("a", "a", "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, version, err := tc.GetVersioned("a"); err != nil || version != 3 {
+		t.Fatalf("a is at version %d, %v, want 3", version, err)
+	}
+	if _, err := tc.GetHistory("a"); !errors.Is(err, ErrHistoryDisabled) {
+		t.Errorf("GetHistory returned %v, want ErrHistoryDisabled", err)
+	}
+	if len(tc.versions.keys["a"]) != 1 || tc.versions.keys["a"][0].value != "" {
+		t.Errorf("version table keeps %v for a, want only its number", tc.versions.keys["a"])
+	}
+
+	// numbers survive reopening the collection
+	if err := tc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tc = newTestCollection(t, TreeTypeLSM, CollectionOptions{Versioned: true}, dir)
+	if version, err := tc.UpdateIfVersion("a", 3, "w"); err != nil || version != 4 {
+		t.Fatalf("UpdateIfVersion wrote version %d, %v, want 4", version, err)
+	}
+	if _, err := tc.UpdateIfVersion("a", 3, "x"); !errors.Is(err, ErrConditionFailed) {
+		t.Fatalf("UpdateIfVersion of a stale version returned %v", err)
+	}
+}
+
+func versionNumbers(versions []interfaces.Version) string {
+	numbers := make([]string, len(versions))
+	for i, v := range versions {
+		numbers[i] = fmt.Sprint(v.Version)
+		if v.Deleted {
+			numbers[i] += "x"
+		}
+	}
+	return fmt.Sprint(numbers)
+}
+
+func TestHistoryKeepsRecentVersions(t *testing.T) {
+	tc := newTestCollection(t, TreeTypeAVL, CollectionOptions{HistoryVersions: 3}, "")
+	start := time.Now().Truncate(time.Second)
+	for i := 1; i <= 5; i++ {
+		tc.clock.set(start.Add(time.Duration(i) * time.Minute))
+		if err := tc.Set("a", "a", fmt.Sprint("v", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions, err := tc.GetHistory("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versionNumbers(versions); got != "[3 4 5]" {
+		t.Fatalf("a keeps versions %s, want the last 3", got)
+	}
+	if v := versions[0]; v.Value != "v3" || !v.Time.Equal(start.Add(3*time.Minute)) {
+		t.Errorf("version 3 is %+v", v)
+	}
+	if v, err := tc.GetVersion("a", 4); err != nil || v.Value != "v4" {
+		t.Errorf("version 4 is %+v, %v", v, err)
+	}
+	for _, version := range []int64{2, 6} {
+		if _, err := tc.GetVersion("a", version); !errors.Is(err, ErrVersionNotFound) {
+			t.Errorf("version %d returned %v, want ErrVersionNotFound", version, err)
+		}
+	}
+
+	// a deletion is a version, and numbering goes on after it
+	tc.clock.set(start.Add(6 * time.Minute))
+	if err := tc.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	tc.clock.set(start.Add(7 * time.Minute))
+	if err := tc.Set("a", "a", "v7"); err != nil {
+		t.Fatal(err)
+	}
+	if versions, err := tc.GetHistory("a"); err != nil || versionNumbers(versions) != "[5 6x 7]" {
+		t.Errorf("a keeps versions %s, %v, want 5, the deletion and 7", versionNumbers(versions), err)
+	}
+
+	if v, err := tc.GetAsOf("a", start.Add(5*time.Minute+30*time.Second)); err != nil || v.Version != 5 {
+		t.Errorf("a as of 5m30s is %+v, %v, want version 5", v, err)
+	}
+	if _, err := tc.GetAsOf("a", start.Add(6*time.Minute+30*time.Second)); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("a as of its deletion returned %v, want ErrKeyNotFound", err)
+	}
+	if _, err := tc.GetAsOf("a", start.Add(4*time.Minute)); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("a before its kept history returned %v, want ErrVersionNotFound", err)
+	}
+
+	// before its first version a key did not exist
+	if err := tc.Set("b", "b", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tc.GetAsOf("b", start); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("b before it was written returned %v, want ErrKeyNotFound", err)
+	}
+	if _, err := tc.GetHistory("c"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("history of a missing key returned %v, want ErrKeyNotFound", err)
+	}
+}
+
+func TestHistoryRetention(t *testing.T) {
+	tc := newTestCollection(t, TreeTypeAVL, CollectionOptions{HistoryRetentionMillis: 60000}, "")
+	start := time.Now().Truncate(time.Second)
+	for _, at := range []time.Duration{0, 30 * time.Second, 2 * time.Minute} {
+		tc.clock.set(start.Add(at))
+		if err := tc.Set("a", "a", fmt.Sprint(at)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// version 1 was replaced more than a minute ago, version 2 was not
+	versions, err := tc.GetHistory("a")
+	if err != nil || versionNumbers(versions) != "[2 3]" {
+		t.Fatalf("a keeps versions %s, %v, want 2 and 3", versionNumbers(versions), err)
+	}
+	if v, err := tc.GetAsOf("a", start.Add(90*time.Second)); err != nil || v.Value != "30s" {
+		t.Errorf("a as of 1m30s is %+v, %v, want 30s", v, err)
+	}
+	if _, err := tc.GetAsOf("a", start.Add(10*time.Second)); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("a before the window returned %v, want ErrVersionNotFound", err)
+	}
+
+	// a deletion that leaves the window takes the key's history with it
+	tc.clock.set(start.Add(3 * time.Minute))
+	if err := tc.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	tc.clock.set(start.Add(5 * time.Minute))
+	if _, err := tc.GetHistory("a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("history of a long deleted key returned %v, want ErrKeyNotFound", err)
+	}
+	if err := tc.Set("a", "a", "again"); err != nil {
+		t.Fatal(err)
+	}
+	if versions, err := tc.GetHistory("a"); err != nil || versionNumbers(versions) != "[1]" {
+		t.Errorf("a keeps versions %s, %v, want to start again from 1", versionNumbers(versions), err)
+	}
+}
+
+func TestHistorySurvivesReopening(t *testing.T) {
+	dir := t.TempDir()
+	options := CollectionOptions{HistoryVersions: 2}
+	tc, err := NewTreeCollection(TreeTypeLSM, options, DefaultConfig(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{"1", "2", "3"} {
+		if err := tc.Set("a", "a", value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tc.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tc = newTestCollection(t, TreeTypeLSM, options, dir)
+	versions, err := tc.GetHistory("a")
+	if err != nil || versionNumbers(versions) != "[3 4x]" || versions[0].Value != "3" {
+		t.Fatalf("reopened a keeps %+v, %v, want 3 and the deletion", versions, err)
+	}
+	if err := tc.Set("a", "a", "5"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := tc.GetVersion("a", 5); err != nil || v.Value != "5" {
+		t.Errorf("version 5 is %+v, %v", v, err)
+	}
+}
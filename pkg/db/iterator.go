@@ -0,0 +1,116 @@
+package db
+
+import "io"
+
+// treeCursor is a position in an orderedTree. seek moves to the first key
+// at or after key and last to the largest key; next and prev step from
+// there. A cursor that has moved past either end is invalid and stays so
+// until the next seek or last. It may only be used while the tree is not
+// modified. Cursors that hold resources implement io.Closer.
+type treeCursor interface {
+	seek(key string) error
+	last() error
+	next() error
+	prev() error
+	valid() bool
+	key() string
+	value() string
+}
+
+// TreeIterator is the interfaces.Iterator of a TreeCollection. It steps
+// over the keys skip reports, which are those that have expired but not
+// yet been deleted.
+type TreeIterator struct {
+	cursor treeCursor
+	unlock func()
+	skip   func(key string) bool
+	err    error
+}
+
+// move records the error of a cursor operation and reports whether the
+// iterator is still positioned on an entry.
+func (it *TreeIterator) move(err error) bool {
+	if err != nil && it.err == nil {
+		it.err = err
+	}
+	return it.valid()
+}
+
+func (it *TreeIterator) valid() bool {
+	return it.err == nil && it.unlock != nil && it.cursor.valid()
+}
+
+// settle moves on in the given direction past any skipped keys.
+func (it *TreeIterator) settle(ok bool, forward bool) bool {
+	for ok && it.skip != nil && it.skip(it.cursor.key()) {
+		if forward {
+			ok = it.move(it.cursor.next())
+		} else {
+			ok = it.move(it.cursor.prev())
+		}
+	}
+	return ok
+}
+
+func (it *TreeIterator) Seek(key string) bool {
+	if it.err != nil || it.unlock == nil {
+		return false
+	}
+	return it.settle(it.move(it.cursor.seek(key)), true)
+}
+
+func (it *TreeIterator) SeekLast() bool {
+	if it.err != nil || it.unlock == nil {
+		return false
+	}
+	return it.settle(it.move(it.cursor.last()), false)
+}
+
+func (it *TreeIterator) Next() bool {
+	if !it.valid() {
+		return false
+	}
+	return it.settle(it.move(it.cursor.next()), true)
+}
+
+func (it *TreeIterator) Prev() bool {
+	if !it.valid() {
+		return false
+	}
+	return it.settle(it.move(it.cursor.prev()), false)
+}
+
+func (it *TreeIterator) Key() string {
+	if !it.valid() {
+		return ""
+	}
+	return it.cursor.key()
+}
+
+func (it *TreeIterator) Value() string {
+	if !it.valid() {
+		return ""
+	}
+	return it.cursor.value()
+}
+
+func (it *TreeIterator) Err() error {
+	return it.err
+}
+
+// Close releases the collection's read lock. It is safe to call more
+// than once.
+func (it *TreeIterator) Close() error {
+	if it.unlock == nil {
+		return it.err
+	}
+
+	if closer, ok := it.cursor.(io.Closer); ok {
+		if err := closer.Close(); err != nil && it.err == nil {
+			it.err = err
+		}
+	}
+	it.unlock()
+	it.unlock = nil
+	return it.err
+}
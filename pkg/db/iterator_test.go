@@ -0,0 +1,128 @@
+package db
+
+import (
+	"DB_II/pkg/interfaces"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// walk returns the keys it visits moving it with step until it is
+// invalid, starting from where it is if ok.
+func walk(it interfaces.Iterator, ok bool, step func() bool) []string {
+	var keys []string
+	for ; ok; ok = step() {
+		keys = append(keys, it.Key())
+	}
+	return keys
+}
+
+func TestIteratorWalksInOrder(t *testing.T) {
+	for _, treeType := range treeTypes {
+		tc := newTestCollection(t, treeType, testOptions(treeType), t.TempDir())
+		for _, i := range []int{5, 1, 9, 3, 7} {
+			key := fmt.Sprint(i)
+			if err := tc.Set(key, key, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		it, err := tc.Iterator()
+		if err != nil {
+			t.Fatalf("%s: %v", treeType, err)
+		}
+		if got := fmt.Sprint(walk(it, it.Seek(""), it.Next)); got != "[1 3 5 7 9]" {
+			t.Errorf("%s: forwards visits %s", treeType, got)
+		}
+		// an iterator past the end stays there until the next seek
+		if it.Prev() || it.Key() != "" {
+			t.Errorf("%s: Prev moved an exhausted iterator", treeType)
+		}
+		if got := fmt.Sprint(walk(it, it.SeekLast(), it.Prev)); got != "[9 7 5 3 1]" {
+			t.Errorf("%s: backwards visits %s", treeType, got)
+		}
+		if !it.Seek("4") || it.Key() != "5" || it.Value() != "5" {
+			t.Errorf("%s: Seek(4) is on %q", treeType, it.Key())
+		}
+		if !it.Prev() || it.Key() != "3" {
+			t.Errorf("%s: Prev from 5 is on %q", treeType, it.Key())
+		}
+		if it.Seek("91") {
+			t.Errorf("%s: Seek past the last key is on %q", treeType, it.Key())
+		}
+		if err := it.Close(); err != nil {
+			t.Errorf("%s: %v", treeType, err)
+		}
+		if err := it.Close(); err != nil {
+			t.Errorf("%s: closing twice: %v", treeType, err)
+		}
+		if it.Seek("") {
+			t.Errorf("%s: a closed iterator moved", treeType)
+		}
+
+		// closing released the read lock
+		if err := tc.Set("2", "2", "2"); err != nil {
+			t.Fatalf("%s: %v", treeType, err)
+		}
+	}
+
+	tc := newTestCollection(t, TreeTypeHash, CollectionOptions{}, "")
+	if _, err := tc.Iterator(); !errors.Is(err, ErrRangeUnsupported) {
+		t.Errorf("hash Iterator returned %v, want ErrRangeUnsupported", err)
+	}
+}
+
+func TestCursorFetchesPages(t *testing.T) {
+	database := newTestDatabase(t, t.TempDir())
+	defer database.Close()
+	createTestCollection(t, database, TreeTypeAVL, CollectionOptions{})
+	setTestKeys(t, database, 0, 10)
+	cursors := database.NewCursorSet()
+
+	id, err := cursors.Open("alice", "p", "s", "c", "k002", "k008")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := cursors.Fetch("bob", id, 3); !errors.Is(err, ErrCursorNotFound) {
+		t.Errorf("another user fetched from the cursor: %v", err)
+	}
+	if _, _, err := cursors.Fetch("alice", id, 0); CodeOf(err) != CodeInvalidArgument {
+		t.Errorf("fetching 0 entries returned %v", err)
+	}
+
+	var keys []string
+	fetch := func() bool {
+		t.Helper()
+		entries, done, err := cursors.Fetch("alice", id, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			keys = append(keys, entry.Key)
+		}
+		return done
+	}
+	if fetch() {
+		t.Fatal("cursor is done after one page")
+	}
+	// writes between fetches are seen
+	collection, err := database.getCollection("p", "s", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := collection.Set("k005a", "k005a", "new"); err != nil {
+		t.Fatal(err)
+	}
+	for !fetch() {
+	}
+	if got := fmt.Sprint(keys); got != "[k002 k003 k004 k005 k005a k006 k007 k008]" {
+		t.Errorf("cursor returned %s", got)
+	}
+
+	if err := cursors.Close("alice", id); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := cursors.Fetch("alice", id, 3); !errors.Is(err, ErrCursorNotFound) {
+		t.Errorf("fetching from a closed cursor returned %v", err)
+	}
+}
@@ -0,0 +1,899 @@
+package db
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	lsmL0Trigger = 4
+	lsmMaxLevels = 7
+	lsmLevelBase = 10 << 20
+
+	lsmManifestFile = "MANIFEST"
+	lsmWALFile      = "wal.log"
+)
+
+// Memtable, log and table values carry a one-byte prefix so deletes can be
+// recorded as tombstones that shadow older tables until compaction.
+const (
+	lsmLive      = "v"
+	lsmTombstone = "d"
+)
+
+func lsmDecode(encoded string) (string, bool) {
+	if strings.HasPrefix(encoded, lsmLive) {
+		return encoded[len(lsmLive):], true
+	}
+	return "", false
+}
+
+// LSMTree is a log-structured merge tree stored in dir. Writes go to a
+// write-ahead log and a red-black memtable; full memtables are flushed to
+// level-0 tables, and a background goroutine compacts levels so that every
+// level below 0 holds non-overlapping tables.
+type LSMTree struct {
+	dir       string
+	memtable  *RedBlackTree
+	memBytes  int
+	memLimit  int
+	wal       *os.File
+	walWriter *bufio.Writer
+	// levels[0] is ordered oldest to newest; deeper levels by smallest key.
+	levels   [][]*sstable
+	nextFile uint64
+
+	mutex     sync.RWMutex
+	compactCh chan struct{}
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+type lsmManifest struct {
+	NextFile uint64
+	Levels   [][]uint64
+}
+
+func OpenLSMTree(dir string, memtableSize int) (*LSMTree, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	t := &LSMTree{
+		dir:       dir,
+		memtable:  NewRedBlackTree(),
+		memLimit:  memtableSize,
+		levels:    make([][]*sstable, lsmMaxLevels),
+		nextFile:  1,
+		compactCh: make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+
+	if err := t.loadManifest(); err != nil {
+		t.closeTables()
+		return nil, err
+	}
+	if err := t.replayWAL(); err != nil {
+		t.closeTables()
+		return nil, err
+	}
+
+	wal, err := os.OpenFile(filepath.Join(dir, lsmWALFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.closeTables()
+		return nil, err
+	}
+	t.wal = wal
+	t.walWriter = bufio.NewWriter(wal)
+
+	t.wg.Add(1)
+	go t.compactLoop()
+	t.scheduleCompaction()
+
+	return t, nil
+}
+
+func (t *LSMTree) tablePath(num uint64) string {
+	return filepath.Join(t.dir, fmt.Sprintf("%06d.sst", num))
+}
+
+func (t *LSMTree) loadManifest() error {
+	data, err := os.ReadFile(filepath.Join(t.dir, lsmManifestFile))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var manifest lsmManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("%s: %w", lsmManifestFile, err)
+	}
+
+	live := make(map[uint64]bool)
+	t.nextFile = manifest.NextFile
+	for level, nums := range manifest.Levels {
+		if level >= lsmMaxLevels {
+			return fmt.Errorf("%s: too many levels", lsmManifestFile)
+		}
+		for _, num := range nums {
+			table, err := openSSTable(t.tablePath(num), num)
+			if err != nil {
+				return err
+			}
+			t.levels[level] = append(t.levels[level], table)
+			live[num] = true
+		}
+	}
+
+	// tables left behind by an interrupted flush or compaction
+	entries, err := os.ReadDir(t.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			os.Remove(filepath.Join(t.dir, name))
+			continue
+		}
+		if num, err := strconv.ParseUint(strings.TrimSuffix(name, ".sst"), 10, 64); err == nil && strings.HasSuffix(name, ".sst") && !live[num] {
+			os.Remove(filepath.Join(t.dir, name))
+		}
+	}
+	return nil
+}
+
+// writeManifest must be called with the write lock held.
+func (t *LSMTree) writeManifest() error {
+	manifest := lsmManifest{NextFile: t.nextFile, Levels: make([][]uint64, len(t.levels))}
+	for level, tables := range t.levels {
+		manifest.Levels[level] = []uint64{}
+		for _, table := range tables {
+			manifest.Levels[level] = append(manifest.Levels[level], table.num)
+		}
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(t.dir, lsmManifestFile), data)
+}
+
+// WAL records are a CRC-32 and length followed by one or more keys, each
+// with its encoded value, that were written together. Replay stops at the
+// first torn or corrupt record.
+func (t *LSMTree) replayWAL() error {
+	path := filepath.Join(t.dir, lsmWALFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	good := 0
+	for len(data)-good >= 8 {
+		checksum := binary.LittleEndian.Uint32(data[good:])
+		length := int(binary.LittleEndian.Uint32(data[good+4:]))
+		if len(data)-good-8 < length {
+			break
+		}
+		payload := data[good+8 : good+8+length]
+		if crc32.ChecksumIEEE(payload) != checksum {
+			break
+		}
+
+		r := &byteReader{buf: payload}
+		var keys, values []string
+		for len(r.buf) > 0 && r.err == nil {
+			keys = append(keys, r.string())
+			values = append(values, r.string())
+		}
+		if r.err != nil || len(keys) == 0 {
+			break
+		}
+		for i := range keys {
+			t.applyMemtable(keys[i], values[i])
+		}
+		good += 8 + length
+	}
+
+	if good < len(data) {
+		log.Printf("lsm %s: discarding %d bytes of torn write-ahead log", t.dir, len(data)-good)
+		return os.Truncate(path, int64(good))
+	}
+	return nil
+}
+
+func (t *LSMTree) appendWAL(keys []string, encoded []string) error {
+	var payload []byte
+	for i, key := range keys {
+		payload = binary.AppendUvarint(payload, uint64(len(key)))
+		payload = append(payload, key...)
+		payload = binary.AppendUvarint(payload, uint64(len(encoded[i])))
+		payload = append(payload, encoded[i]...)
+	}
+
+	var header [8]byte
+	binary.LittleEndian.PutUint32(header[0:], crc32.ChecksumIEEE(payload))
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(payload)))
+
+	if _, err := t.walWriter.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := t.walWriter.Write(payload); err != nil {
+		return err
+	}
+	if err := t.walWriter.Flush(); err != nil {
+		return err
+	}
+	// a write is acknowledged only once it would survive a power failure
+	return t.wal.Sync()
+}
+
+func (t *LSMTree) applyMemtable(key string, encoded string) {
+	t.memtable.insert(key, encoded)
+	t.memBytes += len(key) + len(encoded)
+}
+
+// write logs keys with their encoded values in one record and then
+// applies them to the memtable.
+func (t *LSMTree) write(keys []string, encoded []string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if err := t.appendWAL(keys, encoded); err != nil {
+		return err
+	}
+	for i, key := range keys {
+		t.applyMemtable(key, encoded[i])
+	}
+
+	if t.memBytes >= t.memLimit {
+		return t.flush()
+	}
+	return nil
+}
+
+// flush writes the memtable to a new level-0 table and starts a fresh
+// log. It must be called with the write lock held.
+func (t *LSMTree) flush() error {
+	if t.memtable.Root == t.memtable.NIL {
+		return nil
+	}
+
+	num := t.nextFile
+	t.nextFile++
+
+	w, err := newSSTWriter(t.tablePath(num))
+	if err != nil {
+		return err
+	}
+
+	t.memtable.ascend("", func(node *RBNode) bool {
+		err = w.add(node.Key, node.Value)
+		return err == nil
+	})
+	if err != nil {
+		w.abort()
+		return err
+	}
+	if err := w.finish(); err != nil {
+		return err
+	}
+
+	table, err := openSSTable(t.tablePath(num), num)
+	if err != nil {
+		return err
+	}
+	t.levels[0] = append(t.levels[0], table)
+	if err := t.writeManifest(); err != nil {
+		return err
+	}
+
+	// the log only covers the memtable that was just flushed
+	if err := t.wal.Truncate(0); err != nil {
+		return err
+	}
+	t.walWriter.Reset(t.wal)
+	t.memtable = NewRedBlackTree()
+	t.memBytes = 0
+
+	t.scheduleCompaction()
+	return nil
+}
+
+func (t *LSMTree) Search(key string) (string, bool, error) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	encoded, found, err := t.lookup(key)
+	if err != nil || !found {
+		return "", false, err
+	}
+	value, live := lsmDecode(encoded)
+	return value, live, nil
+}
+
+// lookup returns the newest encoded value for key, tombstones included.
+func (t *LSMTree) lookup(key string) (string, bool, error) {
+	if node := t.memtable.search(key); node != nil {
+		return node.Value, true, nil
+	}
+
+	for i := len(t.levels[0]) - 1; i >= 0; i-- {
+		encoded, found, err := t.levels[0][i].get(key)
+		if err != nil || found {
+			return encoded, found, err
+		}
+	}
+
+	for _, tables := range t.levels[1:] {
+		i := sort.Search(len(tables), func(i int) bool { return tables[i].largest >= key })
+		if i < len(tables) {
+			encoded, found, err := tables[i].get(key)
+			if err != nil || found {
+				return encoded, found, err
+			}
+		}
+	}
+	return "", false, nil
+}
+
+func (t *LSMTree) Insert(key string, value string) error {
+	return t.write([]string{key}, []string{lsmLive + value})
+}
+
+func (t *LSMTree) Delete(key string) (bool, error) {
+	_, found, err := t.Search(key)
+	if err != nil || !found {
+		return false, err
+	}
+	return true, t.write([]string{key}, []string{lsmTombstone})
+}
+
+// writeBatch logs every put and remove of a batch in one record, so that
+// replaying the log after a crash applies all of them or none.
+func (t *LSMTree) writeBatch(ops []batchOp) error {
+	keys := make([]string, len(ops))
+	encoded := make([]string, len(ops))
+	for i, op := range ops {
+		keys[i] = op.key
+		if op.remove {
+			encoded[i] = lsmTombstone
+		} else {
+			encoded[i] = lsmLive + op.value
+		}
+	}
+	return t.write(keys, encoded)
+}
+
+// lsmSource is one sorted input of a merge. The memtable takes part
+// through an rbCursor.
+type lsmSource interface {
+	valid() bool
+	key() string
+	value() string
+	next() error
+}
+
+// mergeIterator yields each key once, taking the value from the
+// lowest-numbered source that has it; sources are ordered newest first.
+type mergeIterator struct {
+	sources []lsmSource
+}
+
+func (m *mergeIterator) next() (string, string, bool, error) {
+	best := -1
+	for i, s := range m.sources {
+		if s.valid() && (best < 0 || s.key() < m.sources[best].key()) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return "", "", false, nil
+	}
+
+	key, value := m.sources[best].key(), m.sources[best].value()
+	for _, s := range m.sources {
+		for s.valid() && s.key() == key {
+			if err := s.next(); err != nil {
+				return "", "", false, err
+			}
+		}
+	}
+	return key, value, true, nil
+}
+
+// tablesNewestFirst lists every table so that a key's newest version is
+// found in the first table holding it.
+func (t *LSMTree) tablesNewestFirst() []*sstable {
+	tables := make([]*sstable, 0)
+	for i := len(t.levels[0]) - 1; i >= 0; i-- {
+		tables = append(tables, t.levels[0][i])
+	}
+	for _, level := range t.levels[1:] {
+		tables = append(tables, level...)
+	}
+	return tables
+}
+
+// newMergeIterator must be called with at least the read lock held, and
+// the iterator may only be used while it is held.
+func (t *LSMTree) newMergeIterator(leftBound string) (*mergeIterator, error) {
+	mem := &rbCursor{t: t.memtable}
+	mem.seek(leftBound)
+
+	sources := []lsmSource{mem}
+	for _, table := range t.tablesNewestFirst() {
+		if table.largest < leftBound {
+			continue
+		}
+		it := &sstIterator{t: table}
+		if err := it.seek(leftBound); err != nil {
+			return nil, err
+		}
+		sources = append(sources, it)
+	}
+	return &mergeIterator{sources: sources}, nil
+}
+
+func (t *LSMTree) searchRange(leftBound, rightBound string, result *map[string]string) error {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	it, err := t.newMergeIterator(leftBound)
+	if err != nil {
+		return err
+	}
+
+	for {
+		key, encoded, ok, err := it.next()
+		if err != nil {
+			return err
+		}
+		if !ok || key > rightBound {
+			return nil
+		}
+		if value, live := lsmDecode(encoded); live {
+			(*result)[key] = value
+		}
+	}
+}
+
+// before returns the largest live key below key, or the largest live key
+// of all when bounded is false. It must be called with at least the read
+// lock held.
+func (t *LSMTree) before(key string, bounded bool) (string, string, bool, error) {
+	for {
+		var best, bestValue string
+		found := false
+
+		mem := &rbCursor{t: t.memtable, node: t.memtable.NIL}
+		if bounded {
+			mem.seek(key)
+		}
+		if mem.valid() {
+			mem.prev()
+		} else {
+			mem.last()
+		}
+		if mem.valid() {
+			best, bestValue, found = mem.key(), mem.value(), true
+		}
+
+		// sources are newest first, so only a strictly larger key wins
+		for _, table := range t.tablesNewestFirst() {
+			k, v, ok, err := table.before(key, bounded)
+			if err != nil {
+				return "", "", false, err
+			}
+			if ok && (!found || k > best) {
+				best, bestValue, found = k, v, true
+			}
+		}
+
+		if !found {
+			return "", "", false, nil
+		}
+		if value, live := lsmDecode(bestValue); live {
+			return best, value, true, nil
+		}
+		key, bounded = best, true
+	}
+}
+
+func (t *LSMTree) cursor() (treeCursor, error) {
+	t.mutex.RLock()
+	return &lsmCursor{t: t}, nil
+}
+
+// lsmCursor moves forwards with a merge iterator and backwards by asking
+// every source for its predecessor. It holds the tree's read lock until
+// closed, so compaction cannot remove the tables it reads.
+type lsmCursor struct {
+	t      *LSMTree
+	it     *mergeIterator
+	k, v   string
+	ok     bool
+	closed bool
+}
+
+// advance moves to the next live entry of the merge iterator.
+func (c *lsmCursor) advance() error {
+	for {
+		key, encoded, ok, err := c.it.next()
+		if err != nil || !ok {
+			c.ok = false
+			return err
+		}
+		if value, live := lsmDecode(encoded); live {
+			c.k, c.v, c.ok = key, value, true
+			return nil
+		}
+	}
+}
+
+func (c *lsmCursor) seek(key string) error {
+	it, err := c.t.newMergeIterator(key)
+	if err != nil {
+		c.ok = false
+		return err
+	}
+	c.it = it
+	return c.advance()
+}
+
+func (c *lsmCursor) last() error {
+	c.it = nil
+	k, v, ok, err := c.t.before("", false)
+	c.k, c.v, c.ok = k, v, ok
+	return err
+}
+
+func (c *lsmCursor) next() error {
+	if !c.ok {
+		return nil
+	}
+	if c.it == nil {
+		// moving backwards dropped the iterator; the smallest key after
+		// c.k is c.k followed by a zero byte
+		return c.seek(c.k + "\x00")
+	}
+	return c.advance()
+}
+
+func (c *lsmCursor) prev() error {
+	if !c.ok {
+		return nil
+	}
+	c.it = nil
+	k, v, ok, err := c.t.before(c.k, true)
+	c.k, c.v, c.ok = k, v, ok
+	return err
+}
+
+func (c *lsmCursor) valid() bool   { return c.ok }
+func (c *lsmCursor) key() string   { return c.k }
+func (c *lsmCursor) value() string { return c.v }
+
+func (c *lsmCursor) Close() error {
+	if !c.closed {
+		c.closed = true
+		c.t.mutex.RUnlock()
+	}
+	return nil
+}
+
+func (t *LSMTree) scheduleCompaction() {
+	select {
+	case t.compactCh <- struct{}{}:
+	default:
+	}
+}
+
+func (t *LSMTree) compactLoop() {
+	defer t.wg.Done()
+
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-t.compactCh:
+		}
+
+		for {
+			select {
+			case <-t.done:
+				return
+			default:
+			}
+
+			compacted, err := t.compactOnce()
+			if err != nil {
+				log.Printf("lsm %s: compaction failed: %v", t.dir, err)
+				break
+			}
+			if !compacted {
+				break
+			}
+		}
+	}
+}
+
+func levelBytes(tables []*sstable) int64 {
+	var total int64
+	for _, table := range tables {
+		total += table.size
+	}
+	return total
+}
+
+func levelLimit(level int) int64 {
+	limit := int64(lsmLevelBase)
+	for i := 1; i < level; i++ {
+		limit *= 10
+	}
+	return limit
+}
+
+// pickCompaction chooses the input tables of the next compaction: all of
+// level 0 once it has lsmL0Trigger tables, otherwise the first table of
+// the shallowest level over its size limit. Tables of the next level that
+// overlap the inputs are merged too.
+func (t *LSMTree) pickCompaction() (int, []*sstable, []*sstable) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	level := -1
+	var inputs []*sstable
+	if len(t.levels[0]) >= lsmL0Trigger {
+		level = 0
+		inputs = append(inputs, t.levels[0]...)
+	} else {
+		for i := 1; i < lsmMaxLevels-1; i++ {
+			if len(t.levels[i]) > 0 && levelBytes(t.levels[i]) > levelLimit(i) {
+				level = i
+				inputs = []*sstable{t.levels[i][0]}
+				break
+			}
+		}
+	}
+	if level < 0 {
+		return -1, nil, nil
+	}
+
+	smallest, largest := inputs[0].smallest, inputs[0].largest
+	for _, table := range inputs[1:] {
+		if table.smallest < smallest {
+			smallest = table.smallest
+		}
+		if table.largest > largest {
+			largest = table.largest
+		}
+	}
+
+	var overlaps []*sstable
+	for _, table := range t.levels[level+1] {
+		if table.overlaps(smallest, largest) {
+			overlaps = append(overlaps, table)
+		}
+	}
+	return level, inputs, overlaps
+}
+
+func (t *LSMTree) compactOnce() (bool, error) {
+	level, inputs, overlaps := t.pickCompaction()
+	if level < 0 {
+		return false, nil
+	}
+
+	// newest first: level 0 is stored oldest to newest
+	var sources []lsmSource
+	for i := len(inputs) - 1; i >= 0; i-- {
+		sources = append(sources, &sstIterator{t: inputs[i]})
+	}
+	for _, table := range overlaps {
+		sources = append(sources, &sstIterator{t: table})
+	}
+	for _, s := range sources {
+		if err := s.(*sstIterator).loadBlock(0); err != nil {
+			return false, err
+		}
+	}
+
+	t.mutex.RLock()
+	bottom := true
+	for _, deeper := range t.levels[level+2:] {
+		if len(deeper) > 0 {
+			bottom = false
+		}
+	}
+	t.mutex.RUnlock()
+
+	outputs, err := t.writeMerged(&mergeIterator{sources: sources}, bottom)
+	if err != nil {
+		return false, err
+	}
+
+	t.mutex.Lock()
+	removed := make(map[*sstable]bool)
+	for _, table := range append(inputs, overlaps...) {
+		removed[table] = true
+	}
+	for _, l := range []int{level, level + 1} {
+		kept := t.levels[l][:0]
+		for _, table := range t.levels[l] {
+			if !removed[table] {
+				kept = append(kept, table)
+			}
+		}
+		t.levels[l] = kept
+	}
+	next := append(t.levels[level+1], outputs...)
+	sort.Slice(next, func(i, j int) bool { return next[i].smallest < next[j].smallest })
+	t.levels[level+1] = next
+	err = t.writeManifest()
+	t.mutex.Unlock()
+
+	if err != nil {
+		return false, err
+	}
+
+	for table := range removed {
+		table.close()
+		os.Remove(table.path)
+	}
+	return true, nil
+}
+
+// writeMerged drains it into tables of roughly sstTargetBytes each.
+// Tombstones are dropped when nothing older can lie underneath them.
+func (t *LSMTree) writeMerged(it *mergeIterator, dropTombstones bool) ([]*sstable, error) {
+	var outputs []*sstable
+	var w *sstWriter
+	var num uint64
+
+	abort := func(err error) ([]*sstable, error) {
+		if w != nil {
+			w.abort()
+		}
+		for _, table := range outputs {
+			table.close()
+			os.Remove(table.path)
+		}
+		return nil, err
+	}
+
+	finish := func() error {
+		if err := w.finish(); err != nil {
+			w = nil
+			return err
+		}
+		w = nil
+		table, err := openSSTable(t.tablePath(num), num)
+		if err != nil {
+			return err
+		}
+		outputs = append(outputs, table)
+		return nil
+	}
+
+	for {
+		key, encoded, ok, err := it.next()
+		if err != nil {
+			return abort(err)
+		}
+		if !ok {
+			break
+		}
+		if dropTombstones && encoded == lsmTombstone {
+			continue
+		}
+
+		if w == nil {
+			t.mutex.Lock()
+			num = t.nextFile
+			t.nextFile++
+			t.mutex.Unlock()
+
+			if w, err = newSSTWriter(t.tablePath(num)); err != nil {
+				w = nil
+				return abort(err)
+			}
+		}
+		if err := w.add(key, encoded); err != nil {
+			return abort(err)
+		}
+		if w.size() >= sstTargetBytes {
+			if err := finish(); err != nil {
+				return abort(err)
+			}
+		}
+	}
+
+	if w != nil {
+		if err := finish(); err != nil {
+			return abort(err)
+		}
+	}
+	return outputs, nil
+}
+
+func (t *LSMTree) closeTables() {
+	for _, tables := range t.levels {
+		for _, table := range tables {
+			table.close()
+		}
+	}
+}
+
+// Close stops background compaction and releases the files. The memtable
+// is not flushed: its contents are already in the write-ahead log.
+func (t *LSMTree) Close() error {
+	close(t.done)
+	t.wg.Wait()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.closeTables()
+	if err := t.walWriter.Flush(); err != nil {
+		t.wal.Close()
+		return err
+	}
+	return t.wal.Close()
+}
+
+func (t *LSMTree) put(key string, value string) error {
+	return t.Insert(key, value)
+}
+
+func (t *LSMTree) get(key string) (string, bool, error) {
+	return t.Search(key)
+}
+
+func (t *LSMTree) remove(key string) (bool, error) {
+	return t.Delete(key)
+}
+
+func (t *LSMTree) rangeInto(leftBound string, rightBound string, result *map[string]string) error {
+	return t.searchRange(leftBound, rightBound, result)
+}
+
+// writeFileAtomic replaces path with data via a synced temporary file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,194 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeLSMTestData inserts n keys, overwrites every fifth and deletes
+// every seventh, and returns what the tree should then hold.
+func writeLSMTestData(t *testing.T, tree *LSMTree, n int) map[string]string {
+	t.Helper()
+	want := make(map[string]string)
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key%05d", i)
+		value := strings.Repeat(fmt.Sprint(i), 8)
+		if err := tree.Insert(key, value); err != nil {
+			t.Fatal(err)
+		}
+		want[key] = value
+	}
+	for i := 0; i < n; i += 5 {
+		key := fmt.Sprintf("key%05d", i)
+		if err := tree.Insert(key, "new"); err != nil {
+			t.Fatal(err)
+		}
+		want[key] = "new"
+	}
+	for i := 0; i < n; i += 7 {
+		key := fmt.Sprintf("key%05d", i)
+		if found, err := tree.Delete(key); err != nil || !found {
+			t.Fatalf("deleting %s: %v, %v", key, found, err)
+		}
+		delete(want, key)
+	}
+	return want
+}
+
+func checkLSMContents(t *testing.T, tree *LSMTree, want map[string]string) {
+	t.Helper()
+	got := make(map[string]string)
+	if err := tree.rangeInto("", "\xff", &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("tree holds %d keys, want %d", len(got), len(want))
+	}
+	for _, key := range []string{"key00000", "key00005", "key00007", "key00012"} {
+		value, found, err := tree.Search(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wantValue, wantFound := want[key]; found != wantFound || value != wantValue {
+			t.Fatalf("%s is %q, %v, want %q, %v", key, value, found, wantValue, wantFound)
+		}
+	}
+}
+
+func TestLSMFlushCompactReopen(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := OpenLSMTree(dir, 4<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := writeLSMTestData(t, tree, 3000)
+
+	// the memtable was flushed many times over, and compaction brings
+	// level 0 back under its trigger
+	var levels []int
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		tree.mutex.RLock()
+		levels = levels[:0]
+		for _, tables := range tree.levels {
+			levels = append(levels, len(tables))
+		}
+		tree.mutex.RUnlock()
+		if levels[0] < lsmL0Trigger && levels[1] > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("levels hold %v tables, never compacted", levels)
+		}
+	}
+	checkLSMContents(t, tree, want)
+
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tree, err = OpenLSMTree(dir, 4<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	checkLSMContents(t, tree, want)
+
+	// the tables are read back from the manifest rather than rebuilt from
+	// the log
+	tree.mutex.RLock()
+	tables := 0
+	for _, level := range tree.levels {
+		tables += len(level)
+	}
+	tree.mutex.RUnlock()
+	if tables == 0 {
+		t.Fatal("reopened tree has no tables")
+	}
+}
+
+func TestLSMReopenDiscardsTornLog(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := OpenLSMTree(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := writeLSMTestData(t, tree, 100)
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a write that was cut off half way through
+	path := filepath.Join(dir, lsmWALFile)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte{1, 2, 3, 4, 200, 0, 0, 0, 'k'}); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	tree, err = OpenLSMTree(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	checkLSMContents(t, tree, want)
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() != info.Size() {
+		t.Fatalf("log holds %d bytes, want it truncated back to %d", after.Size(), info.Size())
+	}
+}
+
+func TestLSMWriteBatchSurvivesWholeOrNotAtAll(t *testing.T) {
+	dir := t.TempDir()
+	tree, err := OpenLSMTree(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if err := tree.Insert("a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.writeBatch([]batchOp{{key: "b", value: "2"}, {key: "a", remove: true}, {key: "c", value: "3"}}); err != nil {
+		t.Fatal(err)
+	}
+	wal, err := os.ReadFile(filepath.Join(dir, lsmWALFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a crash that cut the batch's record short loses the whole batch, and
+	// one that did not keeps all of it
+	for _, tc := range []struct {
+		cut  int
+		want map[string]string
+	}{
+		{1, map[string]string{"a": "1"}},
+		{0, map[string]string{"b": "2", "c": "3"}},
+	} {
+		crashed := t.TempDir()
+		if err := os.WriteFile(filepath.Join(crashed, lsmWALFile), wal[:len(wal)-tc.cut], 0o644); err != nil {
+			t.Fatal(err)
+		}
+		reopened, err := OpenLSMTree(crashed, 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkLSMContents(t, reopened, tc.want)
+		if err := reopened.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
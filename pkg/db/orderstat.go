@@ -0,0 +1,184 @@
+package db
+
+import (
+	"DB_II/pkg/interfaces"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrCollectionEmpty    = NewError(CodeNotFound, "collection is empty")
+	ErrPositionOutOfRange = NewError(CodeNotFound, "position out of range")
+)
+
+var _ interfaces.OrderStatisticCollection = (*TreeCollection)(nil)
+
+// orderStatistics is implemented by trees whose nodes track subtree sizes
+// and can therefore count and select by position in O(log n). The other
+// trees answer the same queries by walking a cursor.
+type orderStatistics interface {
+	count() int
+	// rank is the number of keys below key, or at most key if inclusive.
+	rank(key string, inclusive bool) int
+	// selectKth returns the entry that has k smaller keys.
+	selectKth(k int) (string, string, bool)
+}
+
+// readOrdered runs fn under the collection's read lock with a cursor over
+// the tree, for queries that depend on key order. Expired keys that are
+// still in the tree would be counted and selected like live ones, so while
+// any key has a deadline, fn runs under the write lock instead, after
+// those keys are deleted.
+func (tc *TreeCollection) readOrdered(fn func(cursor treeCursor) error) error {
+	if tc.TreeType == TreeTypeHash && !tc.Options.HashRangeScan {
+		return ErrRangeUnsupported
+	}
+
+	tc.mutex.RLock()
+	if tc.expiry.deadlines.Root == nil {
+		defer tc.mutex.RUnlock()
+	} else {
+		tc.mutex.RUnlock()
+		tc.mutex.Lock()
+		defer tc.mutex.Unlock()
+		if err := tc.expireAll(); err != nil {
+			return err
+		}
+	}
+
+	cursor, err := tc.tree.cursor()
+	if err != nil {
+		return err
+	}
+	err = fn(cursor)
+	if closer, ok := cursor.(io.Closer); ok {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// countFrom counts the keys from the cursor's position up to rightBound,
+// or to the end if rightBound is empty.
+func countFrom(cursor treeCursor, rightBound string, compare compareFunc) (int, error) {
+	n := 0
+	for cursor.valid() && (rightBound == "" || compare(cursor.key(), rightBound) <= 0) {
+		n++
+		if err := cursor.next(); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// Count returns the number of keys between leftBound and rightBound
+// inclusive. An empty rightBound counts to the last key.
+func (tc *TreeCollection) Count(leftBound string, rightBound string) (int, error) {
+	if rightBound != "" && tc.compare(leftBound, rightBound) > 0 {
+		return 0, nil
+	}
+
+	var n int
+	err := tc.readOrdered(func(cursor treeCursor) error {
+		if stats, ok := tc.tree.(orderStatistics); ok {
+			upper := stats.count()
+			if rightBound != "" {
+				upper = stats.rank(rightBound, true)
+			}
+			n = upper - stats.rank(leftBound, false)
+			return nil
+		}
+
+		if err := cursor.seek(leftBound); err != nil {
+			return err
+		}
+		var err error
+		n, err = countFrom(cursor, rightBound, tc.compare)
+		return err
+	})
+	return n, err
+}
+
+// Rank returns the number of keys smaller than key, which is the position
+// key has or would have in the collection.
+func (tc *TreeCollection) Rank(key string) (int, error) {
+	var n int
+	err := tc.readOrdered(func(cursor treeCursor) error {
+		if stats, ok := tc.tree.(orderStatistics); ok {
+			n = stats.rank(key, false)
+			return nil
+		}
+
+		if err := cursor.seek(""); err != nil {
+			return err
+		}
+		for cursor.valid() && tc.compare(cursor.key(), key) < 0 {
+			n++
+			if err := cursor.next(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Select returns the entry at position k, counting from 0 in key order.
+func (tc *TreeCollection) Select(k int) (interfaces.Entry, error) {
+	var entry interfaces.Entry
+	err := tc.readOrdered(func(cursor treeCursor) error {
+		if k < 0 {
+			return fmt.Errorf("%w: %d is negative", ErrPositionOutOfRange, k)
+		}
+
+		if stats, ok := tc.tree.(orderStatistics); ok {
+			key, value, found := stats.selectKth(k)
+			if !found {
+				return fmt.Errorf("%w: %d (collection holds %d keys)", ErrPositionOutOfRange, k, stats.count())
+			}
+			entry = interfaces.Entry{Key: key, Value: value}
+			return nil
+		}
+
+		if err := cursor.seek(""); err != nil {
+			return err
+		}
+		for i := 0; i < k && cursor.valid(); i++ {
+			if err := cursor.next(); err != nil {
+				return err
+			}
+		}
+		if !cursor.valid() {
+			return fmt.Errorf("%w: %d", ErrPositionOutOfRange, k)
+		}
+		entry = interfaces.Entry{Key: cursor.key(), Value: cursor.value()}
+		return nil
+	})
+	return entry, err
+}
+
+// Min returns the entry with the smallest key.
+func (tc *TreeCollection) Min() (interfaces.Entry, error) {
+	return tc.edge(func(cursor treeCursor) error { return cursor.seek("") })
+}
+
+// Max returns the entry with the largest key.
+func (tc *TreeCollection) Max() (interfaces.Entry, error) {
+	return tc.edge(func(cursor treeCursor) error { return cursor.last() })
+}
+
+func (tc *TreeCollection) edge(position func(cursor treeCursor) error) (interfaces.Entry, error) {
+	var entry interfaces.Entry
+	err := tc.readOrdered(func(cursor treeCursor) error {
+		if err := position(cursor); err != nil {
+			return err
+		}
+		if !cursor.valid() {
+			return ErrCollectionEmpty
+		}
+		entry = interfaces.Entry{Key: cursor.key(), Value: cursor.value()}
+		return nil
+	})
+	return entry, err
+}
@@ -0,0 +1,167 @@
+package db
+
+import (
+	"DB_II/pkg/interfaces"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// orderStatTreeTypes are a tree that tracks subtree sizes and one that
+// answers order statistics by walking a cursor.
+var orderStatTreeTypes = []TreeType{TreeTypeAVL, TreeTypeSkipList}
+
+// newExpiredTestCollection returns a collection holding keys a to e, of
+// which a, c and e have expired but are still in the tree.
+func newExpiredTestCollection(t *testing.T, treeType TreeType) *TreeCollection {
+	t.Helper()
+	tc, err := NewTreeCollection(treeType, CollectionOptions{}, DefaultConfig(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tc.Close() })
+
+	start := time.Now()
+	tc.clock.set(start)
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		var err error
+		if key == "b" || key == "d" {
+			err = tc.Set(key, key, key+"1")
+		} else {
+			err = tc.SetWithTTL(key, key, key+"1", time.Minute)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	tc.clock.set(start.Add(time.Hour))
+	return tc
+}
+
+func TestCountSkipsExpiredKeys(t *testing.T) {
+	for _, treeType := range orderStatTreeTypes {
+		tc := newExpiredTestCollection(t, treeType)
+		if n, err := tc.Count("a", "e"); err != nil || n != 2 {
+			t.Errorf("%s: Count is %d, %v, want 2", treeType, n, err)
+		}
+		if n, err := tc.Count("", ""); err != nil || n != 2 {
+			t.Errorf("%s: Count to the end is %d, %v, want 2", treeType, n, err)
+		}
+	}
+}
+
+func TestRankSkipsExpiredKeys(t *testing.T) {
+	for _, treeType := range orderStatTreeTypes {
+		tc := newExpiredTestCollection(t, treeType)
+		if n, err := tc.Rank("d"); err != nil || n != 1 {
+			t.Errorf("%s: Rank(d) is %d, %v, want 1", treeType, n, err)
+		}
+	}
+}
+
+func TestSelectSkipsExpiredKeys(t *testing.T) {
+	for _, treeType := range orderStatTreeTypes {
+		tc := newExpiredTestCollection(t, treeType)
+		want := interfaces.Entry{Key: "d", Value: "d1"}
+		if entry, err := tc.Select(1); err != nil || entry != want {
+			t.Errorf("%s: Select(1) is %v, %v, want %v", treeType, entry, err, want)
+		}
+		if _, err := tc.Select(2); CodeOf(err) != CodeNotFound {
+			t.Errorf("%s: Select(2) returned %v, want position out of range", treeType, err)
+		}
+	}
+}
+
+func TestMinSkipsExpiredKeys(t *testing.T) {
+	for _, treeType := range orderStatTreeTypes {
+		tc := newExpiredTestCollection(t, treeType)
+		want := interfaces.Entry{Key: "b", Value: "b1"}
+		if entry, err := tc.Min(); err != nil || entry != want {
+			t.Errorf("%s: Min is %v, %v, want %v", treeType, entry, err, want)
+		}
+	}
+}
+
+func TestMaxSkipsExpiredKeys(t *testing.T) {
+	for _, treeType := range orderStatTreeTypes {
+		tc := newExpiredTestCollection(t, treeType)
+		want := interfaces.Entry{Key: "d", Value: "d1"}
+		if entry, err := tc.Max(); err != nil || entry != want {
+			t.Errorf("%s: Max is %v, %v, want %v", treeType, entry, err, want)
+		}
+	}
+}
+
+func TestOrderStatistics(t *testing.T) {
+	for _, treeType := range treeTypes {
+		tc := newTestCollection(t, treeType, testOptions(treeType), t.TempDir())
+		if _, err := tc.Min(); !errors.Is(err, ErrCollectionEmpty) {
+			t.Errorf("%s: Min of an empty collection returned %v", treeType, err)
+		}
+		if _, err := tc.Select(0); !errors.Is(err, ErrPositionOutOfRange) {
+			t.Errorf("%s: Select(0) of an empty collection returned %v", treeType, err)
+		}
+
+		// keys k000 to k198 stepping by 2, written out of order, then every
+		// fourth of them deleted again, so size-tracking trees rebalance
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("k%03d", (i*37%100)*2)
+			if err := tc.Set(key, key, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var keys []string
+		for i := 0; i < 200; i += 2 {
+			key := fmt.Sprintf("k%03d", i)
+			if i%8 == 0 {
+				if err := tc.Delete(key); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			keys = append(keys, key)
+		}
+
+		for k, key := range keys {
+			if entry, err := tc.Select(k); err != nil || entry.Key != key {
+				t.Fatalf("%s: Select(%d) is %v, %v, want %s", treeType, k, entry, err, key)
+			}
+			if rank, err := tc.Rank(key); err != nil || rank != k {
+				t.Fatalf("%s: Rank(%s) is %d, %v, want %d", treeType, key, rank, err, k)
+			}
+		}
+		if rank, err := tc.Rank("k003"); err != nil || rank != 1 {
+			t.Errorf("%s: Rank of missing k003 is %d, %v, want 1", treeType, rank, err)
+		}
+		if _, err := tc.Select(len(keys)); !errors.Is(err, ErrPositionOutOfRange) {
+			t.Errorf("%s: Select past the end returned %v", treeType, err)
+		}
+		if _, err := tc.Select(-1); !errors.Is(err, ErrPositionOutOfRange) {
+			t.Errorf("%s: Select(-1) returned %v", treeType, err)
+		}
+
+		counts := []struct {
+			left, right string
+			want        int
+		}{
+			{"", "", len(keys)},
+			{"k010", "k020", 5},
+			{"k011", "k019", 3},
+			{"k100", "", 38},
+			{"k020", "k010", 0},
+		}
+		for _, c := range counts {
+			if n, err := tc.Count(c.left, c.right); err != nil || n != c.want {
+				t.Errorf("%s: Count(%q, %q) is %d, %v, want %d", treeType, c.left, c.right, n, err, c.want)
+			}
+		}
+
+		if entry, err := tc.Min(); err != nil || entry.Key != keys[0] {
+			t.Errorf("%s: Min is %v, %v, want %s", treeType, entry, err, keys[0])
+		}
+		if entry, err := tc.Max(); err != nil || entry.Key != keys[len(keys)-1] {
+			t.Errorf("%s: Max is %v, %v, want %s", treeType, entry, err, keys[len(keys)-1])
+		}
+	}
+}
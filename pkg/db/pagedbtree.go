@@ -0,0 +1,971 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Page layouts, after the checksum and type byte:
+//
+//	header    magic u64, page size u32, minimum degree u32, max key length
+//	          u32, root u32, page count u32, free list head u32
+//	node      leaf flag u8, key count u16, child page ids u32 (internal
+//	          nodes only), then per entry: key length u16, key, and either
+//	          0 + value length u16 + value, or 1 + first overflow page u32
+//	          + value length u32
+//	overflow  next page u32, bytes used u32, data
+//	free      next free page u32
+const (
+	pagedMagic           = 0x4442494950414745 // "DBIIPAGE"
+	pagedFile            = "btree.db"
+	pagedLogFile         = "btree.wal"
+	pagedNodeHeader      = 8
+	pagedOverflowHeader  = 13
+	pagedInlineValueSize = 64
+
+	DefaultPageSize        = 8192
+	MinPageSize            = 1024
+	MaxPageSize            = 65536
+	DefaultBufferPoolPages = 256
+	// pagedDefaultMaxKey bounds keys when the server allows any length,
+	// since every node has to fit its worst case into one page.
+	pagedDefaultMaxKey = 1024
+)
+
+type pagedValue struct {
+	overflow bool
+	inline   string
+	first    uint32
+	length   uint32
+}
+
+type pagedNode struct {
+	id       uint32
+	leaf     bool
+	keys     []string
+	values   []pagedValue
+	children []uint32
+	dirty    bool
+}
+
+// PagedBTree is a B-tree whose nodes are pages of a file, cached by a
+// buffer pool. Values longer than pagedInlineValueSize are stored in
+// chains of overflow pages. Every Insert and Delete commits the pages it
+// changed, with the header, through the pager's write-ahead log before it
+// returns, so a page freed by one is only reused once nothing on disk
+// points to it any more.
+type PagedBTree struct {
+	pager     *pager
+	pool      *bufferPool
+	MinDeg    int
+	maxKey    int
+	root      uint32
+	pageCount uint32
+	freeHead  uint32
+	mutex     sync.Mutex
+}
+
+// pagedMinDegree is the largest degree whose fullest node, with every key
+// at maxKey bytes, still fits into one page.
+func pagedMinDegree(pageSize, maxKey int) int {
+	entry := 2 + maxKey + 1 + max(2+pagedInlineValueSize, 8)
+	return (pageSize - pagedNodeHeader + entry) / (2 * (entry + 4))
+}
+
+func OpenPagedBTree(dir string, pageSize, poolPages, maxKey int) (*PagedBTree, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	p, err := openPager(dir+string(os.PathSeparator)+pagedFile, dir+string(os.PathSeparator)+pagedLogFile, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	info, err := p.file.Stat()
+	if err != nil {
+		p.close()
+		return nil, err
+	}
+
+	t := &PagedBTree{pager: p}
+	t.pool = newBufferPool(poolPages, t.loadNode, t.storeNode)
+
+	if info.Size() == 0 {
+		err = t.init(maxKey)
+	} else {
+		err = t.readHeader()
+	}
+	if err != nil {
+		p.close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *PagedBTree) init(maxKey int) error {
+	if maxKey <= 0 {
+		maxKey = pagedDefaultMaxKey
+	}
+	t.maxKey = maxKey
+	t.MinDeg = pagedMinDegree(t.pager.pageSize, maxKey)
+	if t.MinDeg < 2 {
+		return fmt.Errorf("%w: %d-byte pages cannot hold %d-byte keys", ErrInvalidOptions, t.pager.pageSize, maxKey)
+	}
+
+	t.pageCount = 1
+	root, err := t.newNode(true)
+	if err != nil {
+		return err
+	}
+	t.root = root.id
+	return t.commit()
+}
+
+func (t *PagedBTree) readHeader() error {
+	page, err := t.pager.readPage(0, pageTypeHeader)
+	if err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint64(page[5:]) != pagedMagic {
+		return fmt.Errorf("%w: not a paged B-tree file", ErrCorruptPage)
+	}
+	if pageSize := int(binary.LittleEndian.Uint32(page[13:])); pageSize != t.pager.pageSize {
+		return fmt.Errorf("%w: file uses %d-byte pages, expected %d", ErrCorruptPage, pageSize, t.pager.pageSize)
+	}
+
+	t.MinDeg = int(binary.LittleEndian.Uint32(page[17:]))
+	t.maxKey = int(binary.LittleEndian.Uint32(page[21:]))
+	t.root = binary.LittleEndian.Uint32(page[25:])
+	t.pageCount = binary.LittleEndian.Uint32(page[29:])
+	t.freeHead = binary.LittleEndian.Uint32(page[33:])
+	return nil
+}
+
+func (t *PagedBTree) writeHeader() error {
+	page := make([]byte, t.pager.pageSize)
+	page[pageTypeOffset] = pageTypeHeader
+	binary.LittleEndian.PutUint64(page[5:], pagedMagic)
+	binary.LittleEndian.PutUint32(page[13:], uint32(t.pager.pageSize))
+	binary.LittleEndian.PutUint32(page[17:], uint32(t.MinDeg))
+	binary.LittleEndian.PutUint32(page[21:], uint32(t.maxKey))
+	binary.LittleEndian.PutUint32(page[25:], t.root)
+	binary.LittleEndian.PutUint32(page[29:], t.pageCount)
+	binary.LittleEndian.PutUint32(page[33:], t.freeHead)
+	return t.pager.writePage(0, page)
+}
+
+// commit writes back the dirty nodes and, if anything changed, the
+// header, and commits them all at once.
+func (t *PagedBTree) commit() error {
+	if err := t.pool.flush(); err != nil {
+		return err
+	}
+	if !t.pager.dirty() {
+		return nil
+	}
+	if err := t.writeHeader(); err != nil {
+		return err
+	}
+	return t.pager.commit()
+}
+
+func (t *PagedBTree) allocPage() (uint32, error) {
+	if t.freeHead == 0 {
+		id := t.pageCount
+		t.pageCount++
+		return id, nil
+	}
+
+	page, err := t.pager.readPage(t.freeHead, pageTypeFree)
+	if err != nil {
+		return 0, err
+	}
+	id := t.freeHead
+	t.freeHead = binary.LittleEndian.Uint32(page[5:])
+	return id, nil
+}
+
+func (t *PagedBTree) freePage(id uint32) error {
+	t.pool.drop(id)
+
+	page := make([]byte, t.pager.pageSize)
+	page[pageTypeOffset] = pageTypeFree
+	binary.LittleEndian.PutUint32(page[5:], t.freeHead)
+	if err := t.pager.writePage(id, page); err != nil {
+		return err
+	}
+	t.freeHead = id
+	return nil
+}
+
+func (t *PagedBTree) newNode(leaf bool) (*pagedNode, error) {
+	id, err := t.allocPage()
+	if err != nil {
+		return nil, err
+	}
+	node := &pagedNode{id: id, leaf: leaf}
+	t.pool.add(node)
+	return node, nil
+}
+
+func (t *PagedBTree) node(id uint32) (*pagedNode, error) {
+	return t.pool.get(id)
+}
+
+func (t *PagedBTree) loadNode(id uint32) (*pagedNode, error) {
+	page, err := t.pager.readPage(id, pageTypeNode)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &pagedNode{id: id, leaf: page[5] == 1}
+	n := int(binary.LittleEndian.Uint16(page[6:]))
+	pos := pagedNodeHeader
+
+	corrupt := func() (*pagedNode, error) {
+		return nil, fmt.Errorf("page %d: %w (bad node layout)", id, ErrCorruptPage)
+	}
+
+	if !node.leaf {
+		if pos+(n+1)*4 > len(page) {
+			return corrupt()
+		}
+		node.children = make([]uint32, n+1)
+		for i := range node.children {
+			node.children[i] = binary.LittleEndian.Uint32(page[pos:])
+			pos += 4
+		}
+	}
+
+	node.keys = make([]string, n)
+	node.values = make([]pagedValue, n)
+	for i := 0; i < n; i++ {
+		if pos+2 > len(page) {
+			return corrupt()
+		}
+		keyLen := int(binary.LittleEndian.Uint16(page[pos:]))
+		pos += 2
+		if pos+keyLen+1 > len(page) {
+			return corrupt()
+		}
+		node.keys[i] = string(page[pos : pos+keyLen])
+		pos += keyLen
+
+		overflow := page[pos] == 1
+		pos++
+		if overflow {
+			if pos+8 > len(page) {
+				return corrupt()
+			}
+			node.values[i] = pagedValue{
+				overflow: true,
+				first:    binary.LittleEndian.Uint32(page[pos:]),
+				length:   binary.LittleEndian.Uint32(page[pos+4:]),
+			}
+			pos += 8
+		} else {
+			if pos+2 > len(page) {
+				return corrupt()
+			}
+			valueLen := int(binary.LittleEndian.Uint16(page[pos:]))
+			pos += 2
+			if pos+valueLen > len(page) {
+				return corrupt()
+			}
+			node.values[i] = pagedValue{inline: string(page[pos : pos+valueLen])}
+			pos += valueLen
+		}
+	}
+	return node, nil
+}
+
+func (t *PagedBTree) storeNode(node *pagedNode) error {
+	page := make([]byte, 0, t.pager.pageSize)
+	page = append(page, 0, 0, 0, 0, pageTypeNode)
+	if node.leaf {
+		page = append(page, 1)
+	} else {
+		page = append(page, 0)
+	}
+	page = binary.LittleEndian.AppendUint16(page, uint16(len(node.keys)))
+
+	if !node.leaf {
+		for _, child := range node.children {
+			page = binary.LittleEndian.AppendUint32(page, child)
+		}
+	}
+
+	for i, key := range node.keys {
+		page = binary.LittleEndian.AppendUint16(page, uint16(len(key)))
+		page = append(page, key...)
+
+		value := node.values[i]
+		if value.overflow {
+			page = append(page, 1)
+			page = binary.LittleEndian.AppendUint32(page, value.first)
+			page = binary.LittleEndian.AppendUint32(page, value.length)
+		} else {
+			page = append(page, 0)
+			page = binary.LittleEndian.AppendUint16(page, uint16(len(value.inline)))
+			page = append(page, value.inline...)
+		}
+	}
+
+	if len(page) > t.pager.pageSize {
+		return fmt.Errorf("page %d: node needs %d bytes", node.id, len(page))
+	}
+	return t.pager.writePage(node.id, page[:t.pager.pageSize])
+}
+
+func (t *PagedBTree) writeValue(value string) (pagedValue, error) {
+	if len(value) <= pagedInlineValueSize {
+		return pagedValue{inline: value}, nil
+	}
+
+	capacity := t.pager.pageSize - pagedOverflowHeader
+	count := (len(value) + capacity - 1) / capacity
+	ids := make([]uint32, count)
+	for i := range ids {
+		id, err := t.allocPage()
+		if err != nil {
+			return pagedValue{}, err
+		}
+		ids[i] = id
+	}
+
+	for i, id := range ids {
+		chunk := value[i*capacity:]
+		if len(chunk) > capacity {
+			chunk = chunk[:capacity]
+		}
+
+		page := make([]byte, t.pager.pageSize)
+		page[pageTypeOffset] = pageTypeOverflow
+		if i+1 < len(ids) {
+			binary.LittleEndian.PutUint32(page[5:], ids[i+1])
+		}
+		binary.LittleEndian.PutUint32(page[9:], uint32(len(chunk)))
+		copy(page[pagedOverflowHeader:], chunk)
+		if err := t.pager.writePage(id, page); err != nil {
+			return pagedValue{}, err
+		}
+	}
+	return pagedValue{overflow: true, first: ids[0], length: uint32(len(value))}, nil
+}
+
+func (t *PagedBTree) readValue(value pagedValue) (string, error) {
+	if !value.overflow {
+		return value.inline, nil
+	}
+
+	data := make([]byte, 0, value.length)
+	for id := value.first; id != 0; {
+		page, err := t.pager.readPage(id, pageTypeOverflow)
+		if err != nil {
+			return "", err
+		}
+		used := int(binary.LittleEndian.Uint32(page[9:]))
+		if pagedOverflowHeader+used > len(page) {
+			return "", fmt.Errorf("page %d: %w (bad overflow length)", id, ErrCorruptPage)
+		}
+		data = append(data, page[pagedOverflowHeader:pagedOverflowHeader+used]...)
+		id = binary.LittleEndian.Uint32(page[5:])
+	}
+	if len(data) != int(value.length) {
+		return "", fmt.Errorf("%w: overflow chain holds %d of %d bytes", ErrCorruptPage, len(data), value.length)
+	}
+	return string(data), nil
+}
+
+func (t *PagedBTree) freeValue(value pagedValue) error {
+	for id := value.first; value.overflow && id != 0; {
+		page, err := t.pager.readPage(id, pageTypeOverflow)
+		if err != nil {
+			return err
+		}
+		next := binary.LittleEndian.Uint32(page[5:])
+		if err := t.freePage(id); err != nil {
+			return err
+		}
+		id = next
+	}
+	return nil
+}
+
+// finish ends an operation by letting the buffer pool evict back down to
+// its capacity.
+func (t *PagedBTree) finish(err error) error {
+	if releaseErr := t.pool.release(); err == nil {
+		err = releaseErr
+	}
+	return err
+}
+
+func (t *PagedBTree) Search(key string) (string, bool, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	value, found, err := t.search(key)
+	return value, found, t.finish(err)
+}
+
+func (t *PagedBTree) search(key string) (string, bool, error) {
+	node, err := t.node(t.root)
+	for err == nil {
+		i := lowerBound(node.keys, key)
+		if i < len(node.keys) && node.keys[i] == key {
+			value, err := t.readValue(node.values[i])
+			return value, err == nil, err
+		}
+		if node.leaf {
+			return "", false, nil
+		}
+		node, err = t.node(node.children[i])
+	}
+	return "", false, err
+}
+
+func (t *PagedBTree) Insert(key string, value string) error {
+	if len(key) > t.maxKey {
+		return fmt.Errorf("%w (%d > %d bytes supported by this paged B-tree)", ErrKeyTooLong, len(key), t.maxKey)
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	err := t.insert(key, value)
+	if err == nil {
+		err = t.commit()
+	}
+	return t.finish(err)
+}
+
+func (t *PagedBTree) insert(key string, value string) error {
+	stored, err := t.writeValue(value)
+	if err != nil {
+		return err
+	}
+
+	replaced, err := t.replace(key, stored)
+	if err != nil || replaced {
+		return err
+	}
+
+	root, err := t.node(t.root)
+	if err != nil {
+		return err
+	}
+
+	if len(root.keys) == 2*t.MinDeg-1 {
+		newRoot, err := t.newNode(false)
+		if err != nil {
+			return err
+		}
+		newRoot.children = []uint32{root.id}
+		t.root = newRoot.id
+		if err := t.splitChild(newRoot, 0); err != nil {
+			return err
+		}
+		return t.insertNonFull(newRoot, key, stored)
+	}
+	return t.insertNonFull(root, key, stored)
+}
+
+func (t *PagedBTree) replace(key string, value pagedValue) (bool, error) {
+	node, err := t.node(t.root)
+	for err == nil {
+		i := lowerBound(node.keys, key)
+		if i < len(node.keys) && node.keys[i] == key {
+			if err := t.freeValue(node.values[i]); err != nil {
+				return false, err
+			}
+			node.values[i] = value
+			node.dirty = true
+			return true, nil
+		}
+		if node.leaf {
+			return false, nil
+		}
+		node, err = t.node(node.children[i])
+	}
+	return false, err
+}
+
+func (t *PagedBTree) insertNonFull(node *pagedNode, key string, value pagedValue) error {
+	for {
+		i := lowerBound(node.keys, key)
+
+		if node.leaf {
+			node.keys = insertString(node.keys, i, key)
+			node.values = insertPagedValue(node.values, i, value)
+			node.dirty = true
+			return nil
+		}
+
+		child, err := t.node(node.children[i])
+		if err != nil {
+			return err
+		}
+
+		if len(child.keys) == 2*t.MinDeg-1 {
+			if err := t.splitChild(node, i); err != nil {
+				return err
+			}
+			if key > node.keys[i] {
+				i++
+			}
+			if child, err = t.node(node.children[i]); err != nil {
+				return err
+			}
+		}
+		node = child
+	}
+}
+
+func (t *PagedBTree) splitChild(parent *pagedNode, i int) error {
+	minDeg := t.MinDeg
+	child, err := t.node(parent.children[i])
+	if err != nil {
+		return err
+	}
+	right, err := t.newNode(child.leaf)
+	if err != nil {
+		return err
+	}
+
+	right.keys = append([]string(nil), child.keys[minDeg:]...)
+	right.values = append([]pagedValue(nil), child.values[minDeg:]...)
+	if !child.leaf {
+		right.children = append([]uint32(nil), child.children[minDeg:]...)
+		child.children = child.children[:minDeg:minDeg]
+	}
+
+	parent.keys = insertString(parent.keys, i, child.keys[minDeg-1])
+	parent.values = insertPagedValue(parent.values, i, child.values[minDeg-1])
+	parent.children = insertPageID(parent.children, i+1, right.id)
+
+	child.keys = child.keys[: minDeg-1 : minDeg-1]
+	child.values = child.values[: minDeg-1 : minDeg-1]
+
+	parent.dirty = true
+	child.dirty = true
+	return nil
+}
+
+func (t *PagedBTree) Delete(key string) (bool, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	found, err := t.delete(key)
+	if err == nil {
+		err = t.commit()
+	}
+	return found, t.finish(err)
+}
+
+// writeBatch makes every put and remove of a batch and then commits them
+// together, so that a crash keeps all of them or none.
+func (t *PagedBTree) writeBatch(ops []batchOp) error {
+	for _, op := range ops {
+		if len(op.key) > t.maxKey {
+			return fmt.Errorf("%w (%d > %d bytes supported by this paged B-tree)", ErrKeyTooLong, len(op.key), t.maxKey)
+		}
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var err error
+	for _, op := range ops {
+		if op.remove {
+			_, err = t.delete(op.key)
+		} else {
+			err = t.insert(op.key, op.value)
+		}
+		if err == nil {
+			err = t.pool.release()
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = t.commit()
+	}
+	return t.finish(err)
+}
+
+func (t *PagedBTree) delete(key string) (bool, error) {
+	root, err := t.node(t.root)
+	if err != nil {
+		return false, err
+	}
+
+	found, err := t.deleteFrom(root, key, true)
+	if err != nil {
+		return false, err
+	}
+
+	if len(root.keys) == 0 && !root.leaf {
+		t.root = root.children[0]
+		if err := t.freePage(root.id); err != nil {
+			return false, err
+		}
+	}
+	return found, nil
+}
+
+// deleteFrom removes key from the subtree rooted at node. freeValue is
+// false when the entry has already been moved up to replace a deleted
+// key, so its overflow pages must be kept.
+func (t *PagedBTree) deleteFrom(node *pagedNode, key string, freeValue bool) (bool, error) {
+	idx := lowerBound(node.keys, key)
+
+	if idx < len(node.keys) && node.keys[idx] == key {
+		if freeValue {
+			if err := t.freeValue(node.values[idx]); err != nil {
+				return false, err
+			}
+		}
+
+		if node.leaf {
+			node.keys = removeString(node.keys, idx)
+			node.values = removePagedValue(node.values, idx)
+			node.dirty = true
+			return true, nil
+		}
+
+		left, err := t.node(node.children[idx])
+		if err != nil {
+			return false, err
+		}
+		if len(left.keys) >= t.MinDeg {
+			pred, err := t.edgeLeaf(left, false)
+			if err != nil {
+				return false, err
+			}
+			last := len(pred.keys) - 1
+			node.keys[idx], node.values[idx] = pred.keys[last], pred.values[last]
+			node.dirty = true
+			return true, t.deleteExisting(left, node.keys[idx])
+		}
+
+		right, err := t.node(node.children[idx+1])
+		if err != nil {
+			return false, err
+		}
+		if len(right.keys) >= t.MinDeg {
+			succ, err := t.edgeLeaf(right, true)
+			if err != nil {
+				return false, err
+			}
+			node.keys[idx], node.values[idx] = succ.keys[0], succ.values[0]
+			node.dirty = true
+			return true, t.deleteExisting(right, node.keys[idx])
+		}
+
+		// the value is already freed; merge pulls the key down into left
+		node.values[idx] = pagedValue{}
+		if err := t.merge(node, idx); err != nil {
+			return false, err
+		}
+		return t.deleteFrom(left, key, false)
+	}
+
+	if node.leaf {
+		return false, nil
+	}
+
+	last := idx == len(node.keys)
+	child, err := t.node(node.children[idx])
+	if err != nil {
+		return false, err
+	}
+	if len(child.keys) < t.MinDeg {
+		if err := t.fill(node, idx); err != nil {
+			return false, err
+		}
+	}
+
+	if last && idx > len(node.keys) {
+		idx--
+	}
+	if child, err = t.node(node.children[idx]); err != nil {
+		return false, err
+	}
+	return t.deleteFrom(child, key, freeValue)
+}
+
+func (t *PagedBTree) deleteExisting(node *pagedNode, key string) error {
+	_, err := t.deleteFrom(node, key, false)
+	return err
+}
+
+// edgeLeaf descends to the leftmost (first) or rightmost leaf below node.
+func (t *PagedBTree) edgeLeaf(node *pagedNode, first bool) (*pagedNode, error) {
+	var err error
+	for !node.leaf {
+		i := len(node.children) - 1
+		if first {
+			i = 0
+		}
+		if node, err = t.node(node.children[i]); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+func (t *PagedBTree) fill(node *pagedNode, idx int) error {
+	if idx != 0 {
+		prev, err := t.node(node.children[idx-1])
+		if err != nil {
+			return err
+		}
+		if len(prev.keys) >= t.MinDeg {
+			return t.borrowFromPrev(node, idx)
+		}
+	}
+	if idx != len(node.keys) {
+		next, err := t.node(node.children[idx+1])
+		if err != nil {
+			return err
+		}
+		if len(next.keys) >= t.MinDeg {
+			return t.borrowFromNext(node, idx)
+		}
+		return t.merge(node, idx)
+	}
+	return t.merge(node, idx-1)
+}
+
+func (t *PagedBTree) borrowFromPrev(node *pagedNode, idx int) error {
+	child, err := t.node(node.children[idx])
+	if err != nil {
+		return err
+	}
+	sibling, err := t.node(node.children[idx-1])
+	if err != nil {
+		return err
+	}
+	last := len(sibling.keys) - 1
+
+	child.keys = insertString(child.keys, 0, node.keys[idx-1])
+	child.values = insertPagedValue(child.values, 0, node.values[idx-1])
+	if !child.leaf {
+		child.children = insertPageID(child.children, 0, sibling.children[last+1])
+		sibling.children = sibling.children[:last+1]
+	}
+
+	node.keys[idx-1] = sibling.keys[last]
+	node.values[idx-1] = sibling.values[last]
+	sibling.keys = sibling.keys[:last]
+	sibling.values = sibling.values[:last]
+
+	node.dirty, child.dirty, sibling.dirty = true, true, true
+	return nil
+}
+
+func (t *PagedBTree) borrowFromNext(node *pagedNode, idx int) error {
+	child, err := t.node(node.children[idx])
+	if err != nil {
+		return err
+	}
+	sibling, err := t.node(node.children[idx+1])
+	if err != nil {
+		return err
+	}
+
+	child.keys = append(child.keys, node.keys[idx])
+	child.values = append(child.values, node.values[idx])
+	if !child.leaf {
+		child.children = append(child.children, sibling.children[0])
+		sibling.children = removePageID(sibling.children, 0)
+	}
+
+	node.keys[idx] = sibling.keys[0]
+	node.values[idx] = sibling.values[0]
+	sibling.keys = removeString(sibling.keys, 0)
+	sibling.values = removePagedValue(sibling.values, 0)
+
+	node.dirty, child.dirty, sibling.dirty = true, true, true
+	return nil
+}
+
+// merge pulls node.keys[idx] down into its left child, appends the right
+// child to it and frees the right child's page.
+func (t *PagedBTree) merge(node *pagedNode, idx int) error {
+	child, err := t.node(node.children[idx])
+	if err != nil {
+		return err
+	}
+	sibling, err := t.node(node.children[idx+1])
+	if err != nil {
+		return err
+	}
+
+	child.keys = append(child.keys, node.keys[idx])
+	child.keys = append(child.keys, sibling.keys...)
+	child.values = append(child.values, node.values[idx])
+	child.values = append(child.values, sibling.values...)
+	if !child.leaf {
+		child.children = append(child.children, sibling.children...)
+	}
+
+	node.keys = removeString(node.keys, idx)
+	node.values = removePagedValue(node.values, idx)
+	node.children = removePageID(node.children, idx+1)
+
+	node.dirty, child.dirty = true, true
+	return t.freePage(sibling.id)
+}
+
+func (t *PagedBTree) searchRange(leftBound, rightBound string, result *map[string]string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	root, err := t.node(t.root)
+	if err == nil {
+		err = t.searchRangeFrom(root, leftBound, rightBound, result)
+	}
+	return t.finish(err)
+}
+
+func (t *PagedBTree) searchRangeFrom(node *pagedNode, leftBound, rightBound string, result *map[string]string) error {
+	for i := 0; i <= len(node.keys); i++ {
+		if !node.leaf && (i == 0 || node.keys[i-1] <= rightBound) && (i == len(node.keys) || node.keys[i] >= leftBound) {
+			child, err := t.node(node.children[i])
+			if err != nil {
+				return err
+			}
+			if err := t.searchRangeFrom(child, leftBound, rightBound, result); err != nil {
+				return err
+			}
+		}
+
+		if i == len(node.keys) || node.keys[i] > rightBound {
+			return nil
+		}
+		if node.keys[i] >= leftBound {
+			value, err := t.readValue(node.values[i])
+			if err != nil {
+				return err
+			}
+			(*result)[node.keys[i]] = value
+		}
+	}
+	return nil
+}
+
+// Close commits anything left, checkpoints the log and closes the files.
+func (t *PagedBTree) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	err := t.commit()
+	if err == nil {
+		err = t.pager.checkpoint()
+	}
+	if closeErr := t.pager.close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (t *PagedBTree) put(key string, value string) error {
+	return t.Insert(key, value)
+}
+
+func (t *PagedBTree) get(key string) (string, bool, error) {
+	return t.Search(key)
+}
+
+func (t *PagedBTree) remove(key string) (bool, error) {
+	return t.Delete(key)
+}
+
+func (t *PagedBTree) rangeInto(leftBound string, rightBound string, result *map[string]string) error {
+	return t.searchRange(leftBound, rightBound, result)
+}
+
+func (t *PagedBTree) cursor() (treeCursor, error) {
+	return &pagedCursor{
+		t: t,
+		btreeCursor: &btreeCursor{
+			compare: strings.Compare,
+			root: func() (btreeCursorNode, error) {
+				return t.node(t.root)
+			},
+			child: func(node btreeCursorNode, i int) (btreeCursorNode, error) {
+				return t.node(node.(*pagedNode).children[i])
+			},
+			entry: func(node btreeCursorNode, i int) (string, error) {
+				return t.readValue(node.(*pagedNode).values[i])
+			},
+		},
+	}, nil
+}
+
+func (node *pagedNode) size() int          { return len(node.keys) }
+func (node *pagedNode) keyAt(i int) string { return node.keys[i] }
+func (node *pagedNode) isLeaf() bool       { return node.leaf }
+
+// pagedCursor takes the tree's lock around every move, since loading
+// pages changes the buffer pool. Nodes the cursor holds on to may be
+// evicted meanwhile, but they stay correct because the collection's read
+// lock keeps the tree from changing.
+type pagedCursor struct {
+	*btreeCursor
+	t *PagedBTree
+}
+
+func (c *pagedCursor) seek(key string) error {
+	c.t.mutex.Lock()
+	defer c.t.mutex.Unlock()
+	return c.t.finish(c.btreeCursor.seek(key))
+}
+
+func (c *pagedCursor) last() error {
+	c.t.mutex.Lock()
+	defer c.t.mutex.Unlock()
+	return c.t.finish(c.btreeCursor.last())
+}
+
+func (c *pagedCursor) next() error {
+	c.t.mutex.Lock()
+	defer c.t.mutex.Unlock()
+	return c.t.finish(c.btreeCursor.next())
+}
+
+func (c *pagedCursor) prev() error {
+	c.t.mutex.Lock()
+	defer c.t.mutex.Unlock()
+	return c.t.finish(c.btreeCursor.prev())
+}
+
+func insertPagedValue(s []pagedValue, i int, v pagedValue) []pagedValue {
+	s = append(s, pagedValue{})
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+func removePagedValue(s []pagedValue, i int) []pagedValue {
+	copy(s[i:], s[i+1:])
+	return s[:len(s)-1]
+}
+
+func insertPageID(s []uint32, i int, v uint32) []uint32 {
+	s = append(s, 0)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+func removePageID(s []uint32, i int) []uint32 {
+	copy(s[i:], s[i+1:])
+	return s[:len(s)-1]
+}
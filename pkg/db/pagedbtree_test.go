@@ -0,0 +1,167 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	testPageSize  = 1024
+	testPoolPages = 16
+	testMaxKey    = 64
+)
+
+func openTestPagedBTree(t *testing.T, dir string) *PagedBTree {
+	t.Helper()
+	tree, err := OpenPagedBTree(dir, testPageSize, testPoolPages, testMaxKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+// writePagedTestData inserts keys from to to, every seventh with a value
+// long enough for overflow pages, then deletes every third of them, and
+// records in want what the tree should then hold.
+func writePagedTestData(t *testing.T, tree *PagedBTree, from, to int, want map[string]string) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		key := fmt.Sprintf("key%05d", i)
+		value := fmt.Sprint(i)
+		if i%7 == 0 {
+			value = strings.Repeat(value, 1000)
+		}
+		if err := tree.Insert(key, value); err != nil {
+			t.Fatal(err)
+		}
+		want[key] = value
+	}
+	for i := from; i < to; i += 3 {
+		key := fmt.Sprintf("key%05d", i)
+		if found, err := tree.Delete(key); err != nil || !found {
+			t.Fatalf("deleting %s: %v, %v", key, found, err)
+		}
+		delete(want, key)
+	}
+}
+
+func checkPagedContents(t *testing.T, tree *PagedBTree, want map[string]string) {
+	t.Helper()
+	got := make(map[string]string)
+	if err := tree.rangeInto("", "\xff", &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("tree holds %d keys, want %d", len(got), len(want))
+	}
+}
+
+// crash drops the tree the way a crash would, without committing or
+// checkpointing anything.
+func crash(tree *PagedBTree) {
+	tree.pager.close()
+}
+
+func TestPagedBTreeReopen(t *testing.T) {
+	dir := t.TempDir()
+	tree := openTestPagedBTree(t, dir)
+	want := make(map[string]string)
+	writePagedTestData(t, tree, 0, 2000, want)
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree = openTestPagedBTree(t, dir)
+	checkPagedContents(t, tree, want)
+
+	// pages freed before are reused rather than the file growing
+	tree.mutex.Lock()
+	pages := tree.pageCount
+	tree.mutex.Unlock()
+	for i := 0; i < 2000; i += 3 {
+		key := fmt.Sprintf("key%05d", i)
+		if err := tree.Insert(key, "back"); err != nil {
+			t.Fatal(err)
+		}
+		want[key] = "back"
+	}
+	tree.mutex.Lock()
+	grown := tree.pageCount - pages
+	tree.mutex.Unlock()
+	if grown > pages/10 {
+		t.Fatalf("file grew by %d pages of %d instead of reusing freed ones", grown, pages)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree = openTestPagedBTree(t, dir)
+	defer tree.Close()
+	checkPagedContents(t, tree, want)
+}
+
+func TestPagedBTreeRecoversCommittedWrites(t *testing.T) {
+	dir := t.TempDir()
+	tree := openTestPagedBTree(t, dir)
+	want := make(map[string]string)
+	writePagedTestData(t, tree, 0, 500, want)
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, pagedFile)
+	checkpointed, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every write returns once committed to the log, so a crash loses
+	// none of them, even if none of their pages reached the file
+	tree = openTestPagedBTree(t, dir)
+	writePagedTestData(t, tree, 500, 1500, want)
+	crash(tree)
+	if err := os.WriteFile(path, checkpointed, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tree = openTestPagedBTree(t, dir)
+	checkPagedContents(t, tree, want)
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(dir, pagedLogFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 {
+		t.Fatalf("log holds %d bytes after a checkpoint", info.Size())
+	}
+}
+
+func TestPagedBTreeDiscardsTornCommit(t *testing.T) {
+	dir := t.TempDir()
+	tree := openTestPagedBTree(t, dir)
+	want := make(map[string]string)
+	writePagedTestData(t, tree, 0, 300, want)
+	crash(tree)
+
+	// a commit that was cut off half way through never returned, and
+	// none of its pages may be applied
+	logFile, err := os.OpenFile(filepath.Join(dir, pagedLogFile), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	torn := make([]byte, 8+2*(4+testPageSize))
+	copy(torn[8:], "garbage")
+	if _, err := logFile.Write(torn[:len(torn)/2]); err != nil {
+		t.Fatal(err)
+	}
+	logFile.Close()
+
+	tree = openTestPagedBTree(t, dir)
+	defer tree.Close()
+	checkPagedContents(t, tree, want)
+}
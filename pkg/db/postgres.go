@@ -87,14 +87,14 @@ func (p *PostgresDB) ValidateUser(username, password string) (Role, error) {
 	).Scan(&hashedPassword, &role)
 
 	if err == sql.ErrNoRows {
-		return "", fmt.Errorf("user not found")
+		return "", ErrUserNotFound
 	} else if err != nil {
 		return "", fmt.Errorf("error querying user: %v", err)
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 	if err != nil {
-		return "", fmt.Errorf("invalid password")
+		return "", ErrBadPassword
 	}
 
 	return role, nil
@@ -111,10 +111,70 @@ func (p *PostgresDB) GetUserRole(username string) (Role, error) {
 	).Scan(&role)
 
 	if err == sql.ErrNoRows {
-		return "", fmt.Errorf("user not found")
+		return "", ErrUserNotFound
 	} else if err != nil {
 		return "", fmt.Errorf("error querying user role: %v", err)
 	}
 
 	return role, nil
 }
+
+// UserRecord is a user as stored, with the bcrypt hash of its password
+// rather than the password.
+type UserRecord struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+	Role         Role   `json:"role"`
+}
+
+func (p *PostgresDB) ListUsers() ([]UserRecord, error) {
+	p.RLock()
+	defer p.RUnlock()
+
+	rows, err := p.db.Query("SELECT username, password_hash, role FROM users ORDER BY username")
+	if err != nil {
+		return nil, fmt.Errorf("error listing users: %v", err)
+	}
+	defer rows.Close()
+
+	var users []UserRecord
+	for rows.Next() {
+		var user UserRecord
+		if err := rows.Scan(&user.Username, &user.PasswordHash, &user.Role); err != nil {
+			return nil, fmt.Errorf("error reading user: %v", err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error listing users: %v", err)
+	}
+	return users, nil
+}
+
+// RestoreUser adds a user with an already hashed password, as a backup
+// recorded it.
+func (p *PostgresDB) RestoreUser(user UserRecord) error {
+	p.Lock()
+	defer p.Unlock()
+
+	var exists bool
+	err := p.db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)", user.Username).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("error checking user existence: %v", err)
+	}
+	if exists {
+		return ErrUserExists
+	}
+
+	_, err = p.db.Exec(
+		"INSERT INTO users (username, password_hash, role) VALUES ($1, $2, $3)",
+		user.Username,
+		user.PasswordHash,
+		user.Role,
+	)
+	if err != nil {
+		return fmt.Errorf("error restoring user: %v", err)
+	}
+
+	return nil
+}
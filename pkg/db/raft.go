@@ -0,0 +1,1369 @@
+package db
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// A node that hears nothing from a leader for an election timeout,
+	// picked anew each time between raftElectionTimeout and twice that,
+	// stands for election. A leader sends a heartbeat every raftHeartbeat.
+	raftTick            = 10 * time.Millisecond
+	raftHeartbeat       = 50 * time.Millisecond
+	raftElectionTimeout = 300 * time.Millisecond
+
+	raftDialTimeout     = time.Second
+	raftCallTimeout     = time.Second
+	raftSnapshotTimeout = time.Minute
+
+	// A leader sends entries in batches of about raftBatchBytes, and a
+	// snapshot in pieces of raftSnapshotChunk.
+	raftBatchBytes    = 1 << 20
+	raftSnapshotChunk = 1 << 20
+
+	raftCommitTimeout = 10 * time.Second
+	raftReadTimeout   = 5 * time.Second
+)
+
+const (
+	raftFollower  = "follower"
+	raftCandidate = "candidate"
+	raftLeader    = "leader"
+)
+
+var (
+	ErrNotClusterLeader   = NewError(CodeNotLeader, "this node is not the cluster leader")
+	ErrNoQuorum           = NewError(CodeUnavailable, "the cluster did not reach a majority in time")
+	ErrLeadershipLost     = NewError(CodeUnavailable, "leadership changed before the command was committed; it may or may not have been applied")
+	ErrCommitTimeout      = NewError(CodeUnavailable, "the command was not committed in time; it may still be applied")
+	ErrMembershipChanging = NewError(CodeConditionFailed, "a membership change is already in progress")
+	errRaftStopped        = NewError(CodeUnavailable, "the node is shutting down")
+)
+
+// LeaderError is returned by a node that is asked to do what only the
+// leader of its cluster can. It names the leader, so the client can ask
+// it instead, unless no leader is known, as during an election.
+type LeaderError struct {
+	LeaderID   string
+	LeaderAddr string
+}
+
+func (e *LeaderError) Error() string {
+	if e.LeaderAddr == "" {
+		return fmt.Sprintf("%s; no leader is known", ErrNotClusterLeader.Message)
+	}
+	return fmt.Sprintf("%s; the leader is %s at %s", ErrNotClusterLeader.Message, e.LeaderID, e.LeaderAddr)
+}
+
+func (e *LeaderError) Unwrap() error {
+	return ErrNotClusterLeader
+}
+
+// RaftMember is a node of a cluster: RaftAddr is where the other nodes
+// reach it and ClientAddr where clients do.
+type RaftMember struct {
+	ID         string `json:"id"`
+	RaftAddr   string `json:"raft_addr"`
+	ClientAddr string `json:"client_addr"`
+}
+
+// ParseRaftMembers parses a comma-separated list of members, each written
+// as id@raft_addr/client_addr.
+func ParseRaftMembers(s string) ([]RaftMember, error) {
+	var members []RaftMember
+	seen := make(map[string]bool)
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, addrs, ok := strings.Cut(field, "@")
+		raftAddr, clientAddr, ok2 := strings.Cut(addrs, "/")
+		if !ok || !ok2 || id == "" || raftAddr == "" || clientAddr == "" {
+			return nil, fmt.Errorf("member %q is not id@raft_addr/client_addr", field)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("member %q is listed twice", id)
+		}
+		seen[id] = true
+		members = append(members, RaftMember{ID: id, RaftAddr: raftAddr, ClientAddr: clientAddr})
+	}
+	return members, nil
+}
+
+// RaftNode makes a database one node of a cluster that agrees, by Raft,
+// on the order of the commands that change it. The leader appends each
+// command to its log and replicates it; once a majority of the members
+// hold it, it is committed and every node applies it, in order, to its
+// own database. The log is compacted into a backup of the database every
+// Config.RaftSnapshotEntries entries, and a node too far behind for the
+// log to catch it up is sent the backup instead.
+//
+// Members are added and removed one at a time, through entries of the
+// log that take effect as soon as a node appends them.
+type RaftNode struct {
+	db         *Database
+	id         string
+	secret     string
+	maxMessage int
+	apply      func(command []byte) (interface{}, error)
+	storage    *raftStorage
+	listener   net.Listener
+
+	// applying is held while the database changes to follow the log,
+	// by applying an entry or loading a snapshot.
+	applying sync.Mutex
+
+	mutex    sync.Mutex
+	role     string
+	term     uint64
+	votedFor string
+	leader   string
+	// leaderContact is when the node last heard from its leader, and
+	// deadline when it stands for election unless it hears again.
+	leaderContact time.Time
+	deadline      time.Time
+	installing    bool
+
+	snapshot raftSnapshotMeta
+	// entries are the entries after the snapshot; the first has index
+	// snapshot.Index+1.
+	entries      []raftEntry
+	members      []RaftMember
+	membersIndex uint64
+	commitIndex  uint64
+	lastApplied  uint64
+
+	// what a leader keeps: one replicator per other member, the entries
+	// it appended whose callers wait for them to be applied, and the
+	// rounds of heartbeats that confirm it still leads for reads
+	replicators map[string]*raftReplicator
+	pending     map[uint64]*raftProposal
+	readRound   uint64
+
+	incoming *raftIncoming
+	conns    map[string]*raftPeer
+	accepted map[net.Conn]struct{}
+	// changed is closed, and replaced, whenever the node's state moves
+	// on in a way someone may be waiting for.
+	changed chan struct{}
+	stopped bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// raftReplicator sends the leader's log to another member.
+type raftReplicator struct {
+	member RaftMember
+	peer   *raftPeer
+	next   uint64
+	match  uint64
+	// acked is the latest round of heartbeats the member answered.
+	acked   uint64
+	trigger chan struct{}
+	stop    chan struct{}
+}
+
+type raftProposal struct {
+	term uint64
+	done chan raftResult
+}
+
+type raftResult struct {
+	response interface{}
+	err      error
+}
+
+// raftIncoming is a snapshot a follower is being sent.
+type raftIncoming struct {
+	file *os.File
+	meta raftSnapshotMeta
+	size int64
+}
+
+// StartRaft makes the database a node of the cluster Config describes.
+// apply applies a committed command to the database and returns what the
+// node that proposed it answers its caller. The database is emptied and
+// rebuilt from the node's snapshot and log, so it holds only what the
+// cluster agreed on. Its collections keep time by the log from then on:
+// each command is applied as of when it was proposed.
+func (db *Database) StartRaft(apply func(command []byte) (interface{}, error)) (*RaftNode, error) {
+	config := db.Config
+	storage, err := openRaftStorage(config.RaftDir)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &RaftNode{
+		db:          db,
+		id:          config.RaftID,
+		secret:      config.RaftSecret,
+		maxMessage:  2*config.MaxCommandSize + 4*raftBatchBytes,
+		apply:       apply,
+		storage:     storage,
+		role:        raftFollower,
+		replicators: make(map[string]*raftReplicator),
+		pending:     make(map[uint64]*raftProposal),
+		conns:       make(map[string]*raftPeer),
+		accepted:    make(map[net.Conn]struct{}),
+		changed:     make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if err := n.recover(config.RaftPeers); err != nil {
+		storage.close()
+		return nil, err
+	}
+
+	n.listener, err = net.Listen("tcp", config.RaftAddr)
+	if err != nil {
+		storage.close()
+		return nil, err
+	}
+
+	db.mutex.Lock()
+	db.raft = n
+	db.mutex.Unlock()
+
+	n.resetDeadline()
+	n.wg.Add(3)
+	go n.serve()
+	go n.tick()
+	go n.applyLoop()
+	return n, nil
+}
+
+// recover loads the node's state, snapshot and log. A node starting for
+// the first time with peers bootstraps a new cluster with them as its
+// members, through a first entry every one of them writes alike.
+func (n *RaftNode) recover(peers []RaftMember) error {
+	state, err := n.storage.loadState()
+	if err != nil {
+		return err
+	}
+	n.term, n.votedFor = state.Term, state.VotedFor
+
+	meta, err := n.storage.loadSnapshot()
+	if err != nil {
+		return err
+	}
+	if meta != nil {
+		n.snapshot = *meta
+	}
+	n.entries, err = n.storage.loadLog(n.snapshot.Index)
+	if err != nil {
+		return err
+	}
+
+	if meta == nil && len(n.entries) == 0 && len(peers) > 0 {
+		data, err := json.Marshal(peers)
+		if err != nil {
+			return err
+		}
+		if err := n.appendEntries([]raftEntry{{Index: 1, Kind: raftEntryMembers, Data: data}}); err != nil {
+			return err
+		}
+	}
+	n.resetMembers()
+
+	n.db.clock.set(time.Unix(0, n.snapshot.Time))
+	if err := n.db.drop(); err != nil {
+		return err
+	}
+	if meta != nil {
+		if err := n.load(n.storage.snapshotPath(*meta), *meta); err != nil {
+			return fmt.Errorf("loading raft snapshot: %w", err)
+		}
+	}
+	n.commitIndex, n.lastApplied = n.snapshot.Index, n.snapshot.Index
+	return nil
+}
+
+// load replaces the database with the snapshot meta describes, which is
+// at path, as of the time of the snapshot's last entry.
+func (n *RaftNode) load(path string, meta raftSnapshotMeta) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := n.db.drop(); err != nil {
+		return err
+	}
+	n.db.clock.set(time.Unix(0, meta.Time))
+	_, err = n.db.restore(trusted, file, meta.Size, RestoreOptions{})
+	return err
+}
+
+// Raft returns the node the database is part of, or nil.
+func (db *Database) Raft() *RaftNode {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return db.raft
+}
+
+// Close stops the node and waits until it is done applying.
+func (n *RaftNode) Close() error {
+	n.mutex.Lock()
+	if n.stopped {
+		n.mutex.Unlock()
+		return nil
+	}
+	n.stopped = true
+	close(n.done)
+	n.stopReplicators()
+	n.notify()
+	for conn := range n.accepted {
+		conn.Close()
+	}
+	for _, p := range n.conns {
+		p.close()
+	}
+	n.mutex.Unlock()
+
+	n.listener.Close()
+	n.wg.Wait()
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.incoming != nil {
+		n.incoming.file.Close()
+		os.Remove(n.incoming.file.Name())
+	}
+	return n.storage.close()
+}
+
+// The rest of the node's methods hold its lock unless they say otherwise.
+
+func (n *RaftNode) notify() {
+	close(n.changed)
+	n.changed = make(chan struct{})
+}
+
+// wait waits until cond holds or the deadline passes, and reports whether
+// cond holds.
+func (n *RaftNode) wait(deadline time.Time, cond func() bool) bool {
+	for !cond() {
+		if n.stopped {
+			return false
+		}
+		changed := n.changed
+		n.mutex.Unlock()
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+			n.mutex.Lock()
+			return cond()
+		}
+		n.mutex.Lock()
+	}
+	return true
+}
+
+func (n *RaftNode) lastIndex() uint64 {
+	return n.snapshot.Index + uint64(len(n.entries))
+}
+
+// termAt returns the term of the entry at index, or 0 if the log no
+// longer or does not yet hold it.
+func (n *RaftNode) termAt(index uint64) uint64 {
+	if index == n.snapshot.Index {
+		return n.snapshot.Term
+	}
+	if index < n.snapshot.Index || index > n.lastIndex() {
+		return 0
+	}
+	return n.entries[index-n.snapshot.Index-1].Term
+}
+
+func (n *RaftNode) entry(index uint64) raftEntry {
+	return n.entries[index-n.snapshot.Index-1]
+}
+
+// membersAt returns the members as of the entry at index.
+func (n *RaftNode) membersAt(index uint64) []RaftMember {
+	for i := index; i > n.snapshot.Index; i-- {
+		if e := n.entry(i); e.Kind == raftEntryMembers {
+			var members []RaftMember
+			if err := json.Unmarshal(e.Data, &members); err == nil {
+				return members
+			}
+		}
+	}
+	return n.snapshot.Members
+}
+
+// resetMembers takes the members from the latest members entry of the
+// log, or from the snapshot if it holds none.
+func (n *RaftNode) resetMembers() {
+	n.members, n.membersIndex = n.snapshot.Members, n.snapshot.Index
+	for i := n.lastIndex(); i > n.snapshot.Index; i-- {
+		if e := n.entry(i); e.Kind == raftEntryMembers {
+			var members []RaftMember
+			if err := json.Unmarshal(e.Data, &members); err != nil {
+				log.Printf("raft: members entry %d: %v", i, err)
+				continue
+			}
+			n.members, n.membersIndex = members, i
+			break
+		}
+	}
+	if n.role == raftLeader {
+		n.syncReplicators()
+	}
+}
+
+func (n *RaftNode) member(id string) (RaftMember, bool) {
+	for _, m := range n.members {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	return RaftMember{}, false
+}
+
+// quorum reports whether the members for which has returns true are a
+// majority.
+func (n *RaftNode) quorum(has func(id string) bool) bool {
+	count := 0
+	for _, m := range n.members {
+		if has(m.ID) {
+			count++
+		}
+	}
+	return count*2 > len(n.members)
+}
+
+func (n *RaftNode) saveState() error {
+	return n.storage.saveState(raftState{Term: n.term, VotedFor: n.votedFor})
+}
+
+// appendEntries appends entries to the end of the log.
+func (n *RaftNode) appendEntries(entries []raftEntry) error {
+	if err := n.storage.append(entries); err != nil {
+		return err
+	}
+	n.entries = append(n.entries, entries...)
+	for _, e := range entries {
+		if e.Kind == raftEntryMembers {
+			n.resetMembers()
+			break
+		}
+	}
+	return nil
+}
+
+// truncate drops the entries from index on.
+func (n *RaftNode) truncate(index uint64) error {
+	entries := append([]raftEntry(nil), n.entries[:index-n.snapshot.Index-1]...)
+	if err := n.storage.rewrite(entries); err != nil {
+		return err
+	}
+	n.entries = entries
+	n.resetMembers()
+	return nil
+}
+
+// compact makes the backup at path, which reflects the log up to
+// meta.Index, the node's snapshot, and drops the entries it reflects.
+func (n *RaftNode) compact(path string, meta raftSnapshotMeta) error {
+	var rest []raftEntry
+	if meta.Index < n.lastIndex() && n.termAt(meta.Index) == meta.Term {
+		rest = append(rest, n.entries[meta.Index-n.snapshot.Index:]...)
+	}
+	if err := n.storage.saveSnapshot(path, &meta); err != nil {
+		return err
+	}
+	n.snapshot, n.entries = meta, rest
+	if err := n.storage.rewrite(rest); err != nil {
+		return err
+	}
+	n.resetMembers()
+	return nil
+}
+
+func (n *RaftNode) resetDeadline() {
+	timeout := raftElectionTimeout + time.Duration(rand.Int63n(int64(raftElectionTimeout)))
+	n.deadline = time.Now().Add(timeout)
+}
+
+// tick stands for election whenever the election timeout passes.
+func (n *RaftNode) tick() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(raftTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+		}
+
+		n.mutex.Lock()
+		if n.installing {
+			n.resetDeadline()
+		}
+		if _, ok := n.member(n.id); ok && n.role != raftLeader && time.Now().After(n.deadline) {
+			n.campaign()
+		}
+		n.mutex.Unlock()
+	}
+}
+
+// campaign starts an election in the next term.
+func (n *RaftNode) campaign() {
+	n.term++
+	n.role = raftCandidate
+	n.votedFor = n.id
+	n.leader = ""
+	if err := n.saveState(); err != nil {
+		log.Printf("raft: %v", err)
+		return
+	}
+	n.resetDeadline()
+
+	term := n.term
+	request := raftVoteRequest{
+		Term:      term,
+		Candidate: n.id,
+		LastIndex: n.lastIndex(),
+		LastTerm:  n.termAt(n.lastIndex()),
+	}
+	votes := map[string]bool{n.id: true}
+	won := func(id string) bool { return votes[id] }
+
+	for _, m := range n.members {
+		if m.ID == n.id {
+			continue
+		}
+		m, peer := m, n.peer(m)
+		go func() {
+			var response raftVoteResponse
+			if err := peer.call("request_vote", request, &response, raftCallTimeout); err != nil {
+				return
+			}
+			n.mutex.Lock()
+			defer n.mutex.Unlock()
+			if response.Term > n.term {
+				n.becomeFollower(response.Term)
+				return
+			}
+			if n.role != raftCandidate || n.term != term || !response.Granted {
+				return
+			}
+			votes[m.ID] = true
+			if n.quorum(won) {
+				n.becomeLeader()
+			}
+		}()
+	}
+	if n.quorum(won) {
+		n.becomeLeader()
+	}
+}
+
+// becomeFollower follows whoever leads term, which is the node's or a
+// later one.
+func (n *RaftNode) becomeFollower(term uint64) {
+	if term > n.term {
+		n.term, n.votedFor, n.leader = term, "", ""
+		if err := n.saveState(); err != nil {
+			log.Printf("raft: %v", err)
+		}
+	}
+	if n.role == raftLeader {
+		n.stopReplicators()
+		for index, p := range n.pending {
+			p.done <- raftResult{err: ErrLeadershipLost}
+			delete(n.pending, index)
+		}
+	}
+	n.role = raftFollower
+	n.notify()
+}
+
+func (n *RaftNode) becomeLeader() {
+	n.role = raftLeader
+	n.leader = n.id
+	log.Printf("raft: %s leads term %d", n.id, n.term)
+
+	noop := raftEntry{Index: n.lastIndex() + 1, Term: n.term, Kind: raftEntryNoop, Time: time.Now().UnixNano()}
+	if err := n.appendEntries([]raftEntry{noop}); err != nil {
+		log.Printf("raft: %v", err)
+		n.becomeFollower(n.term)
+		return
+	}
+	n.syncReplicators()
+	n.advanceCommit()
+	n.notify()
+}
+
+// syncReplicators starts a replicator for each other member and stops
+// those of nodes that are no longer members.
+func (n *RaftNode) syncReplicators() {
+	for _, m := range n.members {
+		if r, ok := n.replicators[m.ID]; m.ID == n.id || (ok && r.member == m) {
+			continue
+		} else if ok {
+			close(r.stop)
+		}
+		r := &raftReplicator{
+			member:  m,
+			peer:    n.peer(m),
+			next:    n.lastIndex() + 1,
+			trigger: make(chan struct{}, 1),
+			stop:    make(chan struct{}),
+		}
+		n.replicators[m.ID] = r
+		n.wg.Add(1)
+		go n.replicate(r)
+	}
+	for id, r := range n.replicators {
+		if _, ok := n.member(id); !ok {
+			close(r.stop)
+			delete(n.replicators, id)
+		}
+	}
+}
+
+func (n *RaftNode) stopReplicators() {
+	for id, r := range n.replicators {
+		close(r.stop)
+		delete(n.replicators, id)
+	}
+}
+
+func (n *RaftNode) triggerReplicators() {
+	for _, r := range n.replicators {
+		select {
+		case r.trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// advanceCommit commits the latest entry of the leader's term that a
+// majority holds, and with it every entry before.
+func (n *RaftNode) advanceCommit() {
+	for index := n.lastIndex(); index > n.commitIndex && n.termAt(index) == n.term; index-- {
+		held := func(id string) bool {
+			if id == n.id {
+				return true
+			}
+			r, ok := n.replicators[id]
+			return ok && r.match >= index
+		}
+		if n.quorum(held) {
+			n.commitIndex = index
+			n.notify()
+			return
+		}
+	}
+}
+
+// replicate sends the log to r's member, without the lock, until r is
+// stopped.
+func (n *RaftNode) replicate(r *raftReplicator) {
+	defer n.wg.Done()
+	ticker := time.NewTicker(raftHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		more := n.send(r)
+		select {
+		case <-r.stop:
+			return
+		case <-n.done:
+			return
+		default:
+		}
+		if more {
+			continue
+		}
+		select {
+		case <-r.stop:
+			return
+		case <-n.done:
+			return
+		case <-r.trigger:
+		case <-ticker.C:
+		}
+	}
+}
+
+// send sends r's member the next batch of entries, an empty one being a
+// heartbeat, or the snapshot if the log no longer holds what it needs.
+// It reports whether there is more to send straight away. It is called
+// without the lock.
+func (n *RaftNode) send(r *raftReplicator) bool {
+	n.mutex.Lock()
+	if n.role != raftLeader {
+		n.mutex.Unlock()
+		return false
+	}
+	term, round := n.term, n.readRound
+	if r.next <= n.snapshot.Index {
+		meta := n.snapshot
+		n.mutex.Unlock()
+		return n.sendSnapshot(r, term, round, meta)
+	}
+
+	request := raftAppendRequest{
+		Term:      term,
+		Leader:    n.id,
+		PrevIndex: r.next - 1,
+		PrevTerm:  n.termAt(r.next - 1),
+		Commit:    n.commitIndex,
+	}
+	size := 0
+	for index := r.next; index <= n.lastIndex() && (size == 0 || size < raftBatchBytes); index++ {
+		e := n.entry(index)
+		request.Entries = append(request.Entries, e)
+		size += len(e.Data) + 64
+	}
+	n.mutex.Unlock()
+
+	var response raftAppendResponse
+	if err := r.peer.call("append_entries", request, &response, raftCallTimeout); err != nil {
+		return false
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if response.Term > n.term {
+		n.becomeFollower(response.Term)
+		return false
+	}
+	if n.role != raftLeader || n.term != term {
+		return false
+	}
+	n.acknowledge(r, round)
+
+	if !response.Success {
+		next := request.PrevIndex
+		if response.Conflict > 0 && response.Conflict < next {
+			next = response.Conflict
+		}
+		if next < 1 {
+			next = 1
+		}
+		r.next = next
+		return true
+	}
+	match := request.PrevIndex + uint64(len(request.Entries))
+	if match > r.match {
+		r.match = match
+		n.advanceCommit()
+	}
+	r.next = match + 1
+	return r.next <= n.lastIndex()
+}
+
+// sendSnapshot sends r's member the snapshot meta describes, a piece at a
+// time. It is called without the lock.
+func (n *RaftNode) sendSnapshot(r *raftReplicator, term, round uint64, meta raftSnapshotMeta) bool {
+	file, err := os.Open(n.storage.snapshotPath(meta))
+	if err != nil {
+		// a newer snapshot replaced it
+		return true
+	}
+	defer file.Close()
+
+	buf := make([]byte, raftSnapshotChunk)
+	for offset := int64(0); offset < meta.Size; {
+		count, err := io.ReadFull(file, buf)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			log.Printf("raft: reading snapshot: %v", err)
+			return false
+		}
+		request := raftSnapshotRequest{
+			Term:     term,
+			Leader:   n.id,
+			Snapshot: meta,
+			Offset:   offset,
+			Data:     buf[:count],
+			Done:     offset+int64(count) >= meta.Size,
+		}
+		if count == 0 {
+			log.Printf("raft: snapshot %s is shorter than %d bytes", meta.File, meta.Size)
+			return false
+		}
+
+		var response raftSnapshotResponse
+		if err := r.peer.call("install_snapshot", request, &response, raftSnapshotTimeout); err != nil {
+			return false
+		}
+		n.mutex.Lock()
+		if response.Term > n.term {
+			n.becomeFollower(response.Term)
+		}
+		leading := n.role == raftLeader && n.term == term
+		if leading {
+			n.acknowledge(r, round)
+		}
+		n.mutex.Unlock()
+		if !leading || !response.Success {
+			return false
+		}
+		offset += int64(count)
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.role != raftLeader || n.term != term {
+		return false
+	}
+	if meta.Index > r.match {
+		r.match = meta.Index
+		n.advanceCommit()
+	}
+	r.next = meta.Index + 1
+	return r.next <= n.lastIndex()
+}
+
+// acknowledge records that r's member answered a heartbeat sent in round.
+func (n *RaftNode) acknowledge(r *raftReplicator, round uint64) {
+	if round > r.acked {
+		r.acked = round
+		n.notify()
+	}
+}
+
+// follow makes the node follow leader, which leads term.
+func (n *RaftNode) follow(term uint64, leader string) {
+	if term > n.term || n.role != raftFollower {
+		n.becomeFollower(term)
+	}
+	n.leader = leader
+	n.leaderContact = time.Now()
+	n.resetDeadline()
+}
+
+func (n *RaftNode) handleVote(request raftVoteRequest) raftVoteResponse {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	// a node that has heard from its leader within an election timeout
+	// ignores candidates, so one that was removed from the cluster, or
+	// cut off from it for a while, cannot depose a leader that is doing
+	// fine
+	if n.role == raftLeader || (n.leader != "" && time.Since(n.leaderContact) < raftElectionTimeout) {
+		return raftVoteResponse{Term: n.term}
+	}
+	if request.Term < n.term {
+		return raftVoteResponse{Term: n.term}
+	}
+	if request.Term > n.term {
+		n.becomeFollower(request.Term)
+	}
+
+	lastTerm := n.termAt(n.lastIndex())
+	upToDate := request.LastTerm > lastTerm || (request.LastTerm == lastTerm && request.LastIndex >= n.lastIndex())
+	if !upToDate || (n.votedFor != "" && n.votedFor != request.Candidate) {
+		return raftVoteResponse{Term: n.term}
+	}
+	n.votedFor = request.Candidate
+	if err := n.saveState(); err != nil {
+		log.Printf("raft: %v", err)
+		return raftVoteResponse{Term: n.term}
+	}
+	n.resetDeadline()
+	return raftVoteResponse{Term: n.term, Granted: true}
+}
+
+func (n *RaftNode) handleAppend(request raftAppendRequest) raftAppendResponse {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if request.Term < n.term {
+		return raftAppendResponse{Term: n.term}
+	}
+	n.follow(request.Term, request.Leader)
+	response := raftAppendResponse{Term: n.term}
+
+	if request.PrevIndex > n.lastIndex() {
+		response.Conflict = n.lastIndex() + 1
+		return response
+	}
+	// entries up to the snapshot are committed, so they match the
+	// leader's
+	if request.PrevIndex > n.snapshot.Index {
+		if term := n.termAt(request.PrevIndex); term != request.PrevTerm {
+			// skip back over the whole conflicting term at once
+			conflict := request.PrevIndex
+			for conflict > n.snapshot.Index+1 && n.termAt(conflict-1) == term {
+				conflict--
+			}
+			response.Conflict = conflict
+			return response
+		}
+	}
+
+	for i, e := range request.Entries {
+		if e.Index <= n.snapshot.Index {
+			continue
+		}
+		if e.Index <= n.lastIndex() {
+			if n.termAt(e.Index) == e.Term {
+				continue
+			}
+			if err := n.truncate(e.Index); err != nil {
+				log.Printf("raft: %v", err)
+				return response
+			}
+		}
+		if err := n.appendEntries(request.Entries[i:]); err != nil {
+			log.Printf("raft: %v", err)
+			return response
+		}
+		break
+	}
+
+	response.Success = true
+	last := request.PrevIndex + uint64(len(request.Entries))
+	if commit := min(request.Commit, last); commit > n.commitIndex {
+		n.commitIndex = commit
+		n.notify()
+	}
+	return response
+}
+
+func (n *RaftNode) handleSnapshot(request raftSnapshotRequest) raftSnapshotResponse {
+	n.mutex.Lock()
+	if request.Term < n.term {
+		defer n.mutex.Unlock()
+		return raftSnapshotResponse{Term: n.term}
+	}
+	n.follow(request.Term, request.Leader)
+	response := raftSnapshotResponse{Term: n.term}
+
+	if request.Offset == 0 {
+		n.discardIncoming()
+		file, err := os.CreateTemp(n.storage.dir, ".incoming-*")
+		if err != nil {
+			n.mutex.Unlock()
+			log.Printf("raft: %v", err)
+			return response
+		}
+		n.incoming = &raftIncoming{file: file, meta: request.Snapshot}
+	}
+	in := n.incoming
+	if in == nil || in.meta.Index != request.Snapshot.Index || in.meta.Term != request.Snapshot.Term || in.size != request.Offset {
+		n.mutex.Unlock()
+		return response
+	}
+	if _, err := in.file.Write(request.Data); err != nil {
+		n.discardIncoming()
+		n.mutex.Unlock()
+		log.Printf("raft: %v", err)
+		return response
+	}
+	in.size += int64(len(request.Data))
+	if !request.Done {
+		n.mutex.Unlock()
+		response.Success = true
+		return response
+	}
+	n.incoming = nil
+	n.installing = true
+	n.mutex.Unlock()
+
+	err := n.install(in)
+	os.Remove(in.file.Name())
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.installing = false
+	if err != nil {
+		log.Printf("raft: installing snapshot %d: %v", in.meta.Index, err)
+		return response
+	}
+	response.Success = true
+	return response
+}
+
+func (n *RaftNode) discardIncoming() {
+	if n.incoming != nil {
+		n.incoming.file.Close()
+		os.Remove(n.incoming.file.Name())
+		n.incoming = nil
+	}
+}
+
+// install loads a snapshot the leader sent into the database and makes
+// it the node's. It is called without the lock.
+func (n *RaftNode) install(in *raftIncoming) error {
+	defer in.file.Close()
+	if in.size != in.meta.Size {
+		return fmt.Errorf("got %d bytes of %d", in.size, in.meta.Size)
+	}
+	if err := in.file.Sync(); err != nil {
+		return err
+	}
+
+	n.applying.Lock()
+	defer n.applying.Unlock()
+
+	n.mutex.Lock()
+	applied := n.lastApplied
+	n.mutex.Unlock()
+	if in.meta.Index <= applied {
+		return nil
+	}
+
+	if _, err := verifyBackup(in.file, in.size); err != nil {
+		return err
+	}
+	if err := n.load(in.file.Name(), in.meta); err != nil {
+		return err
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.lastApplied = in.meta.Index
+	if n.commitIndex < in.meta.Index {
+		n.commitIndex = in.meta.Index
+	}
+	n.notify()
+	return n.compact(in.file.Name(), in.meta)
+}
+
+// applyLoop applies committed entries to the database, in order, and
+// takes a snapshot every Config.RaftSnapshotEntries of them.
+func (n *RaftNode) applyLoop() {
+	defer n.wg.Done()
+	every := uint64(n.db.Config.RaftSnapshotEntries)
+
+	for {
+		n.mutex.Lock()
+		if n.stopped {
+			n.mutex.Unlock()
+			return
+		}
+		if n.lastApplied >= n.commitIndex {
+			changed := n.changed
+			n.mutex.Unlock()
+			<-changed
+			continue
+		}
+		index := n.lastApplied + 1
+		e := n.entry(index)
+		n.mutex.Unlock()
+
+		n.applying.Lock()
+		n.mutex.Lock()
+		if n.lastApplied+1 != index {
+			// a snapshot was installed meanwhile
+			n.mutex.Unlock()
+			n.applying.Unlock()
+			continue
+		}
+		n.mutex.Unlock()
+
+		// every node stamps the command's writes and expires keys as of
+		// when it was proposed, however late it applies it
+		n.db.clock.set(time.Unix(0, e.Time))
+		var result raftResult
+		if e.Kind == raftEntryCommand {
+			result.response, result.err = n.apply(e.Data)
+		}
+
+		n.mutex.Lock()
+		n.lastApplied = index
+		if p, ok := n.pending[index]; ok {
+			delete(n.pending, index)
+			if p.term != e.Term {
+				result = raftResult{err: ErrLeadershipLost}
+			}
+			p.done <- result
+		}
+		if _, ok := n.member(n.id); !ok && n.role == raftLeader && e.Kind == raftEntryMembers {
+			// a leader that was removed leads until its removal is
+			// committed, then leaves the others to elect a new one
+			log.Printf("raft: %s was removed from the cluster", n.id)
+			n.becomeFollower(n.term)
+		}
+		n.notify()
+		snapshot := every > 0 && n.lastApplied-n.snapshot.Index >= every
+		n.mutex.Unlock()
+
+		if snapshot {
+			if err := n.takeSnapshot(); err != nil {
+				log.Printf("raft: taking snapshot: %v", err)
+			}
+		}
+		n.applying.Unlock()
+	}
+}
+
+// takeSnapshot backs up the database as of the last applied entry and
+// compacts the log up to it. The caller holds applying but not the lock.
+func (n *RaftNode) takeSnapshot() error {
+	n.mutex.Lock()
+	meta := raftSnapshotMeta{Index: n.lastApplied, Term: n.termAt(n.lastApplied), Time: n.db.clock.now().UnixNano(), Members: n.membersAt(n.lastApplied)}
+	n.mutex.Unlock()
+
+	file, err := os.CreateTemp(n.storage.dir, ".snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	if _, err := n.db.backup(writer, false); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	meta.Size = info.Size()
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.compact(file.Name(), meta)
+}
+
+// notLeader returns the error that redirects a client to the leader.
+func (n *RaftNode) notLeader() error {
+	err := &LeaderError{LeaderID: n.leader}
+	if m, ok := n.member(n.leader); ok {
+		err.LeaderAddr = m.ClientAddr
+	}
+	return err
+}
+
+// Propose appends command to the log and waits until the node has
+// applied it, returning what applying it returned. Only the leader
+// accepts commands.
+func (n *RaftNode) Propose(command []byte) (interface{}, error) {
+	return n.propose(raftEntry{Kind: raftEntryCommand, Data: command}, nil)
+}
+
+// propose appends e if check, called with the lock held, allows it.
+// check may fill in e.
+func (n *RaftNode) propose(e raftEntry, check func(e *raftEntry) error) (interface{}, error) {
+	n.mutex.Lock()
+	if n.role != raftLeader {
+		defer n.mutex.Unlock()
+		return nil, n.notLeader()
+	}
+	if check != nil {
+		if err := check(&e); err != nil {
+			n.mutex.Unlock()
+			return nil, err
+		}
+	}
+	e.Index, e.Term, e.Time = n.lastIndex()+1, n.term, time.Now().UnixNano()
+	if err := n.appendEntries([]raftEntry{e}); err != nil {
+		n.mutex.Unlock()
+		return nil, err
+	}
+	p := &raftProposal{term: e.Term, done: make(chan raftResult, 1)}
+	n.pending[e.Index] = p
+	n.triggerReplicators()
+	n.advanceCommit()
+	n.mutex.Unlock()
+
+	timer := time.NewTimer(raftCommitTimeout)
+	defer timer.Stop()
+	select {
+	case result := <-p.done:
+		return result.response, result.err
+	case <-timer.C:
+	case <-n.done:
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.pending[e.Index] == p {
+		delete(n.pending, e.Index)
+	}
+	select {
+	case result := <-p.done:
+		return result.response, result.err
+	default:
+	}
+	if n.stopped {
+		return nil, errRaftStopped
+	}
+	return nil, ErrCommitTimeout
+}
+
+// ReadIndex waits until the node's database reflects every command
+// committed before it was called, so that reads after it are
+// linearizable. Only the leader can tell; it confirms it still leads by a
+// round of heartbeats a majority answers.
+func (n *RaftNode) ReadIndex() error {
+	deadline := time.Now().Add(raftReadTimeout)
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.role != raftLeader {
+		return n.notLeader()
+	}
+	term := n.term
+	leading := func() bool { return n.role == raftLeader && n.term == term }
+
+	// until it commits an entry of its own term, a new leader does not
+	// know how much of its log is committed
+	if !n.wait(deadline, func() bool { return !leading() || n.termAt(n.commitIndex) == term }) {
+		return ErrNoQuorum
+	}
+	if !leading() {
+		return n.notLeader()
+	}
+	index := n.commitIndex
+
+	n.readRound++
+	round := n.readRound
+	n.triggerReplicators()
+	acked := func(id string) bool {
+		if id == n.id {
+			return true
+		}
+		r, ok := n.replicators[id]
+		return ok && r.acked >= round
+	}
+	if !n.wait(deadline, func() bool { return !leading() || n.quorum(acked) }) {
+		return ErrNoQuorum
+	}
+	if !leading() {
+		return n.notLeader()
+	}
+
+	if !n.wait(deadline, func() bool { return n.lastApplied >= index }) {
+		return ErrCommitTimeout
+	}
+	return nil
+}
+
+// AddMember adds member to the cluster. It returns once the change is
+// committed, after which the leader brings the new member up to date.
+func (n *RaftNode) AddMember(username string, member RaftMember) error {
+	if !n.db.AuthManager.HasPermission(username, PermManageCluster) {
+		return ErrPermissionDenied
+	}
+	if member.ID == "" || member.RaftAddr == "" || member.ClientAddr == "" {
+		return Errorf(CodeInvalidArgument, "a member needs an id, a raft address and a client address")
+	}
+	return n.changeMembers(func(members []RaftMember) ([]RaftMember, error) {
+		for _, m := range members {
+			if m.ID == member.ID {
+				return nil, Errorf(CodeAlreadyExists, "node %q is already a member", member.ID)
+			}
+		}
+		return append(members, member), nil
+	})
+}
+
+// RemoveMember removes the node with id from the cluster. A leader that
+// removes itself steps down once the change is committed.
+func (n *RaftNode) RemoveMember(username string, id string) error {
+	if !n.db.AuthManager.HasPermission(username, PermManageCluster) {
+		return ErrPermissionDenied
+	}
+	return n.changeMembers(func(members []RaftMember) ([]RaftMember, error) {
+		for i, m := range members {
+			if m.ID == id {
+				if len(members) == 1 {
+					return nil, Errorf(CodeInvalidArgument, "cannot remove the last member")
+				}
+				return append(members[:i:i], members[i+1:]...), nil
+			}
+		}
+		return nil, Errorf(CodeNotFound, "node %q is not a member", id)
+	})
+}
+
+// changeMembers proposes the members change makes of the current ones.
+// Changes go one at a time, each committed before the next starts, which
+// keeps any majority of the old members overlapping any of the new.
+func (n *RaftNode) changeMembers(change func([]RaftMember) ([]RaftMember, error)) error {
+	_, err := n.propose(raftEntry{Kind: raftEntryMembers}, func(e *raftEntry) error {
+		if n.membersIndex > n.commitIndex {
+			return ErrMembershipChanging
+		}
+		if n.termAt(n.commitIndex) != n.term {
+			return Errorf(CodeUnavailable, "the leader has not committed an entry of its term yet; try again")
+		}
+		members, err := change(append([]RaftMember(nil), n.members...))
+		if err != nil {
+			return err
+		}
+		e.Data, err = json.Marshal(members)
+		return err
+	})
+	return err
+}
+
+// RaftStatus describes a node and, on the leader, how far each other
+// member has caught up.
+type RaftStatus struct {
+	ID            string           `json:"id"`
+	Role          string           `json:"role"`
+	Term          uint64           `json:"term"`
+	Leader        string           `json:"leader,omitempty"`
+	LeaderAddr    string           `json:"leader_addr,omitempty"`
+	CommitIndex   uint64           `json:"commit_index"`
+	AppliedIndex  uint64           `json:"applied_index"`
+	LastIndex     uint64           `json:"last_index"`
+	SnapshotIndex uint64           `json:"snapshot_index"`
+	Members       []RaftMember     `json:"members"`
+	Peers         []RaftPeerStatus `json:"peers,omitempty"`
+}
+
+type RaftPeerStatus struct {
+	ID         string `json:"id"`
+	MatchIndex uint64 `json:"match_index"`
+	LagEntries uint64 `json:"lag_entries"`
+}
+
+func (n *RaftNode) Status(username string) (RaftStatus, error) {
+	if !n.db.AuthManager.HasPermission(username, PermRead) {
+		return RaftStatus{}, ErrPermissionDenied
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	status := RaftStatus{
+		ID:            n.id,
+		Role:          n.role,
+		Term:          n.term,
+		Leader:        n.leader,
+		CommitIndex:   n.commitIndex,
+		AppliedIndex:  n.lastApplied,
+		LastIndex:     n.lastIndex(),
+		SnapshotIndex: n.snapshot.Index,
+		Members:       append([]RaftMember(nil), n.members...),
+	}
+	if m, ok := n.member(n.leader); ok {
+		status.LeaderAddr = m.ClientAddr
+	}
+	for _, m := range n.members {
+		if r, ok := n.replicators[m.ID]; ok {
+			status.Peers = append(status.Peers, RaftPeerStatus{ID: m.ID, MatchIndex: r.match, LagEntries: n.lastIndex() - r.match})
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,256 @@
+package db
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// A Raft node keeps what it must not forget in a directory of its own:
+// state.json holds its term and vote, log holds the entries after its
+// snapshot, and snapshot.json describes the snapshot, a backup archive in
+// a file named after the last entry it reflects.
+const (
+	raftStateFile    = "state.json"
+	raftLogFile      = "log"
+	raftSnapshotFile = "snapshot.json"
+)
+
+type raftEntryKind byte
+
+const (
+	// A leader appends a no-op when it is elected, so that it commits
+	// an entry of its own term, and with it everything before.
+	raftEntryNoop raftEntryKind = iota
+	raftEntryCommand
+	raftEntryMembers
+)
+
+// raftEntry is an entry of a Raft log. Time is when the leader appended
+// it, in Unix nanoseconds. Data is a command for the database, or for a
+// members entry the members of the cluster from then on, as JSON.
+type raftEntry struct {
+	Index uint64        `json:"index"`
+	Term  uint64        `json:"term"`
+	Kind  raftEntryKind `json:"kind"`
+	Time  int64         `json:"time"`
+	Data  []byte        `json:"data,omitempty"`
+}
+
+type raftState struct {
+	Term     uint64 `json:"term"`
+	VotedFor string `json:"voted_for,omitempty"`
+}
+
+// raftSnapshotMeta describes a snapshot: the index, term and time of the
+// last entry it reflects, the members of the cluster as of that entry, and
+// the file in the Raft directory that holds it.
+type raftSnapshotMeta struct {
+	Index   uint64       `json:"index"`
+	Term    uint64       `json:"term"`
+	Time    int64        `json:"time"`
+	Members []RaftMember `json:"members"`
+	File    string       `json:"file,omitempty"`
+	Size    int64        `json:"size"`
+}
+
+type raftStorage struct {
+	dir    string
+	file   *os.File
+	writer *bufio.Writer
+}
+
+func openRaftStorage(dir string) (*raftStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &raftStorage{dir: dir}
+	if err := s.openLog(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *raftStorage) openLog() error {
+	file, err := os.OpenFile(filepath.Join(s.dir, raftLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.file = file
+	s.writer = bufio.NewWriter(file)
+	return nil
+}
+
+func (s *raftStorage) close() error {
+	if err := s.writer.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+func (s *raftStorage) loadState() (raftState, error) {
+	var state raftState
+	data, err := os.ReadFile(filepath.Join(s.dir, raftStateFile))
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("%s: %v", raftStateFile, err)
+	}
+	return state, nil
+}
+
+func (s *raftStorage) saveState(state raftState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.dir, raftStateFile), data)
+}
+
+// loadSnapshot returns the latest snapshot, or nil if there is none.
+func (s *raftStorage) loadSnapshot() (*raftSnapshotMeta, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, raftSnapshotFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var meta raftSnapshotMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("%s: %v", raftSnapshotFile, err)
+	}
+	return &meta, nil
+}
+
+func (s *raftStorage) snapshotPath(meta raftSnapshotMeta) string {
+	return filepath.Join(s.dir, meta.File)
+}
+
+// saveSnapshot makes the file at path the snapshot meta describes, and
+// removes the snapshots before it.
+func (s *raftStorage) saveSnapshot(path string, meta *raftSnapshotMeta) error {
+	meta.File = fmt.Sprintf("snapshot-%020d-%d.tar", meta.Index, meta.Term)
+	if err := os.Rename(path, s.snapshotPath(*meta)); err != nil {
+		return err
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(s.dir, raftSnapshotFile), data); err != nil {
+		return err
+	}
+
+	names, err := filepath.Glob(filepath.Join(s.dir, "snapshot-*.tar"))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if filepath.Base(name) != meta.File {
+			os.Remove(name)
+		}
+	}
+	return nil
+}
+
+// Log records are framed like write-ahead log records: a CRC-32 and
+// length, then the index, term, kind, time and data.
+func appendRaftRecord(buf []byte, e raftEntry) []byte {
+	var payload []byte
+	payload = binary.AppendUvarint(payload, e.Index)
+	payload = binary.AppendUvarint(payload, e.Term)
+	payload = append(payload, byte(e.Kind))
+	payload = binary.AppendVarint(payload, e.Time)
+	payload = binary.AppendUvarint(payload, uint64(len(e.Data)))
+	payload = append(payload, e.Data...)
+
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(payload))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(payload)))
+	return append(buf, payload...)
+}
+
+// loadLog returns the entries that follow the one at index after, up to
+// the first torn or corrupt record or the first that does not follow the
+// one before it.
+func (s *raftStorage) loadLog(after uint64) ([]raftEntry, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, raftLogFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []raftEntry
+	good := 0
+	for len(data)-good >= 8 {
+		checksum := binary.LittleEndian.Uint32(data[good:])
+		length := int(binary.LittleEndian.Uint32(data[good+4:]))
+		if len(data)-good-8 < length {
+			break
+		}
+		payload := data[good+8 : good+8+length]
+		if crc32.ChecksumIEEE(payload) != checksum {
+			break
+		}
+
+		r := &byteReader{buf: payload}
+		var e raftEntry
+		e.Index = r.uvarint()
+		e.Term = r.uvarint()
+		e.Kind = raftEntryKind(r.byte())
+		e.Time = r.varint()
+		e.Data = []byte(r.string())
+		if r.err != nil || (e.Index > after && e.Index != after+uint64(len(entries))+1) {
+			break
+		}
+		if e.Index > after {
+			entries = append(entries, e)
+		}
+		good += 8 + length
+	}
+
+	if good < len(data) {
+		log.Printf("raft log %s: discarding %d bytes of torn records", s.dir, len(data)-good)
+		if err := s.rewrite(entries); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// append writes entries to the end of the log and syncs it.
+func (s *raftStorage) append(entries []raftEntry) error {
+	var buf []byte
+	for _, e := range entries {
+		buf = appendRaftRecord(buf[:0], e)
+		if _, err := s.writer.Write(buf); err != nil {
+			return err
+		}
+	}
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// rewrite replaces the log with entries.
+func (s *raftStorage) rewrite(entries []raftEntry) error {
+	if err := s.close(); err != nil {
+		return err
+	}
+	var buf []byte
+	for _, e := range entries {
+		buf = appendRaftRecord(buf, e)
+	}
+	if err := writeFileAtomic(filepath.Join(s.dir, raftLogFile), buf); err != nil {
+		return err
+	}
+	return s.openLog()
+}
@@ -0,0 +1,358 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestRaftNode returns a node that keeps its log in a temporary
+// directory and talks to no one, for driving its handlers directly.
+func newTestRaftNode(t *testing.T, id string, terms ...uint64) *RaftNode {
+	t.Helper()
+	storage, err := openRaftStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { storage.close() })
+
+	n := &RaftNode{
+		id:          id,
+		storage:     storage,
+		role:        raftFollower,
+		replicators: make(map[string]*raftReplicator),
+		pending:     make(map[uint64]*raftProposal),
+		changed:     make(chan struct{}),
+	}
+	var entries []raftEntry
+	for i, term := range terms {
+		entries = append(entries, raftEntry{Index: uint64(i + 1), Term: term, Kind: raftEntryNoop})
+	}
+	if err := n.appendEntries(entries); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func logTerms(entries []raftEntry) []uint64 {
+	terms := make([]uint64, len(entries))
+	for i, e := range entries {
+		terms[i] = e.Term
+	}
+	return terms
+}
+
+func TestRaftAppendMatchesLeaderLog(t *testing.T) {
+	n := newTestRaftNode(t, "b", 1, 1, 2, 2, 2)
+	entry := func(index, term uint64) raftEntry {
+		return raftEntry{Index: index, Term: term, Kind: raftEntryNoop}
+	}
+
+	// an entry the follower does not have yet
+	response := n.handleAppend(raftAppendRequest{Term: 3, Leader: "a", PrevIndex: 7, PrevTerm: 3})
+	if response.Success || response.Conflict != 6 {
+		t.Fatalf("append past the end answered %+v, want conflict 6", response)
+	}
+
+	// a mismatch skips back over the whole conflicting term
+	response = n.handleAppend(raftAppendRequest{Term: 3, Leader: "a", PrevIndex: 5, PrevTerm: 3})
+	if response.Success || response.Conflict != 3 {
+		t.Fatalf("mismatched append answered %+v, want conflict 3", response)
+	}
+
+	// entries that conflict are replaced by the leader's, and those that
+	// match are kept
+	response = n.handleAppend(raftAppendRequest{
+		Term: 3, Leader: "a", PrevIndex: 2, PrevTerm: 1,
+		Entries: []raftEntry{entry(3, 2), entry(4, 3)},
+		Commit:  4,
+	})
+	if !response.Success {
+		t.Fatalf("matching append answered %+v", response)
+	}
+	if got, want := logTerms(n.entries), []uint64{1, 1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("log terms %v, want %v", got, want)
+	}
+	if n.commitIndex != 4 {
+		t.Fatalf("commit index %d, want 4", n.commitIndex)
+	}
+
+	// a stale append from an earlier leader changes nothing
+	response = n.handleAppend(raftAppendRequest{Term: 2, Leader: "c", PrevIndex: 4, PrevTerm: 3, Entries: []raftEntry{entry(5, 2)}})
+	if response.Success || response.Term != 3 || n.lastIndex() != 4 {
+		t.Fatalf("stale append answered %+v with %d entries", response, n.lastIndex())
+	}
+
+	// the log on disk matches the one in memory
+	stored, err := n.storage.loadLog(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := logTerms(stored), []uint64{1, 1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("stored log terms %v, want %v", got, want)
+	}
+}
+
+func TestRaftCommitsOnlyEntriesOfItsTerm(t *testing.T) {
+	n := newTestRaftNode(t, "a", 1, 2, 2)
+	n.term, n.role = 3, raftLeader
+	n.members = []RaftMember{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+	n.replicators["b"] = &raftReplicator{}
+	n.replicators["c"] = &raftReplicator{}
+
+	// a majority holding entries of an earlier term does not commit them
+	n.replicators["b"].match = 3
+	n.advanceCommit()
+	if n.commitIndex != 0 {
+		t.Fatalf("committed %d entries of earlier terms", n.commitIndex)
+	}
+
+	// an entry of the leader's own term commits with everything before it
+	if err := n.appendEntries([]raftEntry{{Index: 4, Term: 3, Kind: raftEntryNoop}}); err != nil {
+		t.Fatal(err)
+	}
+	n.advanceCommit()
+	if n.commitIndex != 0 {
+		t.Fatalf("committed entry %d held by the leader alone", n.commitIndex)
+	}
+	n.replicators["c"].match = 4
+	n.advanceCommit()
+	if n.commitIndex != 4 {
+		t.Fatalf("commit index %d, want 4", n.commitIndex)
+	}
+}
+
+// testCommand is what the nodes of a test cluster apply.
+type testCommand struct {
+	Op        string `json:"op"`
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	TTLMillis int    `json:"ttl_millis"`
+}
+
+type testRaftNode struct {
+	dir    string
+	member RaftMember
+	db     *Database
+	node   *RaftNode
+}
+
+func (tn *testRaftNode) start(t *testing.T, peers []RaftMember) {
+	t.Helper()
+	database := newTestDatabase(t, tn.dir)
+	database.Config.RaftID = tn.member.ID
+	database.Config.RaftAddr = tn.member.RaftAddr
+	database.Config.RaftSecret = "secret"
+	database.Config.RaftDir = filepath.Join(tn.dir, "raft")
+	database.Config.RaftPeers = peers
+
+	node, err := database.StartRaft(func(command []byte) (interface{}, error) {
+		var c testCommand
+		if err := json.Unmarshal(command, &c); err != nil {
+			return nil, err
+		}
+		if c.Op == "create" {
+			createTestCollection(t, database, TreeTypeAVL, CollectionOptions{HistoryVersions: 10})
+			return nil, nil
+		}
+		collection, err := database.getCollection("p", "s", "c")
+		if err != nil {
+			return nil, err
+		}
+		switch c.Op {
+		case "set":
+			if c.TTLMillis > 0 {
+				return nil, collection.(*TreeCollection).SetWithTTL(c.Key, c.Key, c.Value, time.Duration(c.TTLMillis)*time.Millisecond)
+			}
+			return nil, collection.Set(c.Key, c.Key, c.Value)
+		case "delete":
+			return nil, collection.Delete(c.Key)
+		}
+		return nil, fmt.Errorf("unknown test command %q", c.Op)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tn.db, tn.node = database, node
+}
+
+func (tn *testRaftNode) stop() {
+	tn.db.Close()
+	tn.node = nil
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func startTestCluster(t *testing.T, size int) ([]*testRaftNode, []RaftMember) {
+	t.Helper()
+	var nodes []*testRaftNode
+	var peers []RaftMember
+	for i := 0; i < size; i++ {
+		member := RaftMember{ID: fmt.Sprintf("n%d", i), RaftAddr: freeAddr(t), ClientAddr: fmt.Sprintf("client%d:1", i)}
+		peers = append(peers, member)
+		nodes = append(nodes, &testRaftNode{dir: t.TempDir(), member: member})
+	}
+	for _, tn := range nodes {
+		tn.start(t, peers)
+	}
+	t.Cleanup(func() {
+		for _, tn := range nodes {
+			if tn.node != nil {
+				tn.stop()
+			}
+		}
+	})
+	return nodes, peers
+}
+
+// leaderOf waits until one of nodes leads and has committed an entry of
+// its term.
+func leaderOf(t *testing.T, nodes []*testRaftNode) *testRaftNode {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		for _, tn := range nodes {
+			if tn.node == nil {
+				continue
+			}
+			n := tn.node
+			n.mutex.Lock()
+			leading := n.role == raftLeader && n.termAt(n.commitIndex) == n.term
+			n.mutex.Unlock()
+			if leading {
+				return tn
+			}
+		}
+	}
+	t.Fatal("no node became leader")
+	return nil
+}
+
+// propose has the cluster apply c, retrying while leadership changes.
+func propose(t *testing.T, nodes []*testRaftNode, c testCommand) {
+	t.Helper()
+	data, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for attempt := 0; attempt < 50; attempt++ {
+		_, err = leaderOf(t, nodes).node.Propose(data)
+		if code := CodeOf(err); code != CodeNotLeader && code != CodeUnavailable {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("proposing %+v: %v", c, err)
+	}
+}
+
+// converge waits until every running node has applied the leader's whole
+// log.
+func converge(t *testing.T, nodes []*testRaftNode) {
+	t.Helper()
+	leader := leaderOf(t, nodes)
+	leader.node.mutex.Lock()
+	last := leader.node.lastIndex()
+	leader.node.mutex.Unlock()
+
+	for _, tn := range nodes {
+		if tn.node == nil {
+			continue
+		}
+		n := tn.node
+		n.mutex.Lock()
+		applied := n.wait(time.Now().Add(10*time.Second), func() bool { return n.lastApplied >= last })
+		n.mutex.Unlock()
+		if !applied {
+			t.Fatalf("%s did not apply the log up to %d", tn.member.ID, last)
+		}
+	}
+}
+
+func testHistory(t *testing.T, tn *testRaftNode, key string) []keyVersion {
+	t.Helper()
+	collection, err := tn.db.getCollection("p", "s", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := collection.(*TreeCollection)
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+	return append([]keyVersion(nil), tc.versions.keys[key]...)
+}
+
+func testDeadline(t *testing.T, tn *testRaftNode, key string) int64 {
+	t.Helper()
+	collection, err := tn.db.getCollection("p", "s", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := collection.(*TreeCollection)
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+	deadline, _ := tc.expiry.deadline(key)
+	return deadline
+}
+
+func TestRaftClusterAppliesAlike(t *testing.T) {
+	nodes, peers := startTestCluster(t, 3)
+
+	propose(t, nodes, testCommand{Op: "create"})
+	for i := 0; i < 20; i++ {
+		propose(t, nodes, testCommand{Op: "set", Key: fmt.Sprintf("k%02d", i), Value: fmt.Sprint(i)})
+	}
+	propose(t, nodes, testCommand{Op: "set", Key: "k00", Value: "again"})
+	propose(t, nodes, testCommand{Op: "set", Key: "ttl", Value: "x", TTLMillis: int(time.Hour / time.Millisecond)})
+
+	// the leader goes away; the others elect another and go on
+	old := leaderOf(t, nodes)
+	old.stop()
+	for i := 20; i < 30; i++ {
+		propose(t, nodes, testCommand{Op: "set", Key: fmt.Sprintf("k%02d", i), Value: fmt.Sprint(i)})
+	}
+	propose(t, nodes, testCommand{Op: "delete", Key: "k01"})
+
+	// and comes back, replaying its log and catching up on the rest
+	time.Sleep(10 * time.Millisecond)
+	old.start(t, peers)
+	converge(t, nodes)
+
+	want := testContents(leaderOf(t, nodes).db)
+	if len(want) != 30 || want["k00"] != "again" || want["k01"] != "" {
+		t.Fatalf("leader holds %v", want)
+	}
+	for _, tn := range nodes {
+		if got := testContents(tn.db); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s holds %v, want %v", tn.member.ID, got, want)
+		}
+	}
+
+	// every node stamps versions and deadlines with the proposal times,
+	// however late it applied the entries
+	for _, key := range []string{"k00", "k01", "k25"} {
+		want := testHistory(t, nodes[0], key)
+		for _, tn := range nodes[1:] {
+			if got := testHistory(t, tn, key); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s history of %s is %v, want %v", tn.member.ID, key, got, want)
+			}
+		}
+	}
+	want0 := testDeadline(t, nodes[0], "ttl")
+	for _, tn := range nodes[1:] {
+		if got := testDeadline(t, tn, "ttl"); got != want0 {
+			t.Errorf("%s expires ttl at %d, want %d", tn.member.ID, got, want0)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package db
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Raft nodes talk over connections of their own. A node dials a peer's
+// RaftAddr, sends the cluster's secret, and then sends requests a line of
+// JSON at a time, each of which the peer answers with a line.
+
+type raftHello struct {
+	Secret string `json:"secret"`
+}
+
+type raftRequest struct {
+	Method string          `json:"method"`
+	Body   json.RawMessage `json:"body"`
+}
+
+type raftResponse struct {
+	Error string          `json:"error,omitempty"`
+	Body  json.RawMessage `json:"body,omitempty"`
+}
+
+type raftVoteRequest struct {
+	Term      uint64 `json:"term"`
+	Candidate string `json:"candidate"`
+	LastIndex uint64 `json:"last_index"`
+	LastTerm  uint64 `json:"last_term"`
+}
+
+type raftVoteResponse struct {
+	Term    uint64 `json:"term"`
+	Granted bool   `json:"granted"`
+}
+
+type raftAppendRequest struct {
+	Term      uint64      `json:"term"`
+	Leader    string      `json:"leader"`
+	PrevIndex uint64      `json:"prev_index"`
+	PrevTerm  uint64      `json:"prev_term"`
+	Entries   []raftEntry `json:"entries,omitempty"`
+	Commit    uint64      `json:"commit"`
+}
+
+// raftAppendResponse answers an append. A follower whose log does not
+// match the leader's at PrevIndex sets Conflict to the index the leader
+// should send from next.
+type raftAppendResponse struct {
+	Term     uint64 `json:"term"`
+	Success  bool   `json:"success"`
+	Conflict uint64 `json:"conflict,omitempty"`
+}
+
+// raftSnapshotRequest carries the piece of a snapshot's file at Offset.
+// Done marks the last piece.
+type raftSnapshotRequest struct {
+	Term     uint64           `json:"term"`
+	Leader   string           `json:"leader"`
+	Snapshot raftSnapshotMeta `json:"snapshot"`
+	Offset   int64            `json:"offset"`
+	Data     []byte           `json:"data"`
+	Done     bool             `json:"done"`
+}
+
+type raftSnapshotResponse struct {
+	Term    uint64 `json:"term"`
+	Success bool   `json:"success"`
+}
+
+// raftPeer is a connection to another node, dialled when first needed and
+// again after it fails. It carries one call at a time.
+type raftPeer struct {
+	address string
+	secret  string
+	mutex   sync.Mutex
+	conn    net.Conn
+	reader  *bufio.Reader
+}
+
+func (p *raftPeer) call(method string, request, response interface{}, timeout time.Duration) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.conn == nil {
+		conn, err := net.DialTimeout("tcp", p.address, raftDialTimeout)
+		if err != nil {
+			return err
+		}
+		p.conn = conn
+		p.reader = bufio.NewReader(conn)
+		conn.SetDeadline(time.Now().Add(timeout))
+		if err := json.NewEncoder(conn).Encode(raftHello{Secret: p.secret}); err != nil {
+			p.reset()
+			return err
+		}
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	p.conn.SetDeadline(time.Now().Add(timeout))
+	if err := json.NewEncoder(p.conn).Encode(raftRequest{Method: method, Body: body}); err != nil {
+		p.reset()
+		return err
+	}
+	var reply raftResponse
+	if err := readReplicationLine(p.reader, &reply); err != nil {
+		p.reset()
+		return err
+	}
+	if reply.Error != "" {
+		return errors.New(reply.Error)
+	}
+	return json.Unmarshal(reply.Body, response)
+}
+
+func (p *raftPeer) reset() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
+
+func (p *raftPeer) close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.reset()
+}
+
+// peer returns the connection to member, keeping one per node and
+// address.
+func (n *RaftNode) peer(member RaftMember) *raftPeer {
+	key := member.ID + "@" + member.RaftAddr
+	p, ok := n.conns[key]
+	if !ok {
+		p = &raftPeer{address: member.RaftAddr, secret: n.secret}
+		n.conns[key] = p
+	}
+	return p
+}
+
+// serve accepts connections from the other nodes until the node closes.
+func (n *RaftNode) serve() {
+	defer n.wg.Done()
+	for {
+		conn, err := n.listener.Accept()
+		if err != nil {
+			n.mutex.Lock()
+			stopped := n.stopped
+			n.mutex.Unlock()
+			if stopped {
+				return
+			}
+			log.Printf("raft: accept: %v", err)
+			time.Sleep(raftTick)
+			continue
+		}
+
+		n.mutex.Lock()
+		if n.stopped {
+			n.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		n.accepted[conn] = struct{}{}
+		n.mutex.Unlock()
+
+		go func() {
+			n.serveConn(conn)
+			n.mutex.Lock()
+			delete(n.accepted, conn)
+			n.mutex.Unlock()
+		}()
+	}
+}
+
+func (n *RaftNode) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), n.maxMessage)
+	if !scanner.Scan() {
+		return
+	}
+	var hello raftHello
+	if err := json.Unmarshal(scanner.Bytes(), &hello); err != nil ||
+		subtle.ConstantTimeCompare([]byte(hello.Secret), []byte(n.secret)) != 1 {
+		log.Printf("raft: refused %s: wrong secret", conn.RemoteAddr())
+		return
+	}
+
+	encoder := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var request raftRequest
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			log.Printf("raft: %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+
+		var response raftResponse
+		body, err := n.handle(request)
+		if err == nil {
+			response.Body, err = json.Marshal(body)
+		}
+		if err != nil {
+			response.Error = err.Error()
+		}
+		if err := encoder.Encode(response); err != nil {
+			return
+		}
+	}
+}
+
+func (n *RaftNode) handle(request raftRequest) (interface{}, error) {
+	switch request.Method {
+	case "request_vote":
+		var vote raftVoteRequest
+		if err := json.Unmarshal(request.Body, &vote); err != nil {
+			return nil, err
+		}
+		return n.handleVote(vote), nil
+	case "append_entries":
+		var entries raftAppendRequest
+		if err := json.Unmarshal(request.Body, &entries); err != nil {
+			return nil, err
+		}
+		return n.handleAppend(entries), nil
+	case "install_snapshot":
+		var snapshot raftSnapshotRequest
+		if err := json.Unmarshal(request.Body, &snapshot); err != nil {
+			return nil, err
+		}
+		return n.handleSnapshot(snapshot), nil
+	default:
+		return nil, fmt.Errorf("unknown method %q", request.Method)
+	}
+}
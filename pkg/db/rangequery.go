@@ -0,0 +1,143 @@
+package db
+
+import (
+	"DB_II/pkg/interfaces"
+	"encoding/base64"
+	"fmt"
+)
+
+var ErrInvalidToken = NewError(CodeInvalidArgument, "invalid continuation token")
+
+// Continuation tokens are the direction of the query followed by the last
+// key returned, base64-encoded so clients treat them as opaque.
+const (
+	tokenForward = 'f'
+	tokenReverse = 'r'
+)
+
+func encodeRangeToken(reverse bool, lastKey string) string {
+	direction := byte(tokenForward)
+	if reverse {
+		direction = tokenReverse
+	}
+	return base64.RawURLEncoding.EncodeToString(append([]byte{direction}, lastKey...))
+}
+
+// resume narrows query to the entries after the token's last key.
+func resumeRangeQuery(query *interfaces.RangeQuery) error {
+	raw, err := base64.RawURLEncoding.DecodeString(query.Token)
+	if err != nil || len(raw) < 2 {
+		return ErrInvalidToken
+	}
+
+	direction, lastKey := raw[0], string(raw[1:])
+	switch {
+	case direction == tokenForward && !query.Reverse:
+		query.LeftBound, query.LeftExclusive = lastKey, true
+	case direction == tokenReverse && query.Reverse:
+		query.RightBound, query.RightExclusive = lastKey, true
+	default:
+		return fmt.Errorf("%w: token is for the other direction", ErrInvalidToken)
+	}
+	return nil
+}
+
+func bothOf(a, b func(key string) bool) func(key string) bool {
+	return func(key string) bool { return a(key) && b(key) }
+}
+
+// GetRangePage walks the collection's own ordering from one bound towards
+// the other and stops after Limit entries, so a page costs a seek plus
+// the entries it returns rather than the whole range. A Where predicate is
+// checked on every entry walked, unless it is an eq on an indexed path,
+// which is answered from the index.
+func (tc *TreeCollection) GetRangePage(query interfaces.RangeQuery) (*interfaces.RangePage, error) {
+	return tc.rangePage(query, nil)
+}
+
+// rangePage answers a range query, also stopping at the first key that
+// within rejects unless within is nil.
+func (tc *TreeCollection) rangePage(query interfaces.RangeQuery, within func(key string) bool) (*interfaces.RangePage, error) {
+	if query.Limit < 0 {
+		return nil, Errorf(CodeInvalidArgument, "limit %d cannot be negative", query.Limit)
+	}
+	if query.Token != "" {
+		if err := resumeRangeQuery(&query); err != nil {
+			return nil, err
+		}
+	}
+
+	aboveLeft := func(key string) bool {
+		cmp := tc.compare(key, query.LeftBound)
+		return cmp > 0 || (cmp == 0 && !query.LeftExclusive)
+	}
+	belowRight := func(key string) bool {
+		if query.RightBound == "" {
+			return true
+		}
+		cmp := tc.compare(key, query.RightBound)
+		return cmp < 0 || (cmp == 0 && !query.RightExclusive)
+	}
+	if within != nil {
+		aboveLeft, belowRight = bothOf(aboveLeft, within), bothOf(belowRight, within)
+	}
+
+	var filter *fieldFilter
+	if query.Where != nil {
+		if !tc.isDocument() {
+			return nil, ErrNotDocument
+		}
+		var err error
+		if filter, err = compileFieldPredicate(*query.Where); err != nil {
+			return nil, err
+		}
+		if index := tc.indexes[filter.path.String()]; index != nil && filter.op == "eq" {
+			return tc.indexedRangePage(query, index, filter, func(key string) bool {
+				return aboveLeft(key) && belowRight(key)
+			})
+		}
+	}
+
+	it, err := tc.Iterator()
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var ok bool
+	var inRange func(key string) bool
+	var step func() bool
+
+	if query.Reverse {
+		inRange, step = aboveLeft, it.Prev
+		if query.RightBound == "" {
+			ok = it.SeekLast()
+		} else if ok = it.Seek(query.RightBound); !ok {
+			ok = it.SeekLast()
+		} else if !belowRight(it.Key()) {
+			ok = it.Prev()
+		}
+	} else {
+		inRange, step = belowRight, it.Next
+		if ok = it.Seek(query.LeftBound); ok && !aboveLeft(it.Key()) {
+			ok = it.Next()
+		}
+	}
+
+	page := &interfaces.RangePage{Entries: make([]interfaces.Entry, 0)}
+	for ; ok && inRange(it.Key()); ok = step() {
+		if filter != nil && !filter.matchesValue(it.Value()) {
+			continue
+		}
+		if query.Limit > 0 && len(page.Entries) == query.Limit {
+			page.Token = encodeRangeToken(query.Reverse, page.Entries[len(page.Entries)-1].Key)
+			break
+		}
+		page.Entries = append(page.Entries, interfaces.Entry{Key: it.Key(), Value: it.Value()})
+	}
+
+	if err := it.Close(); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
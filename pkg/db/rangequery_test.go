@@ -0,0 +1,94 @@
+package db
+
+import (
+	"DB_II/pkg/interfaces"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// pageKeys runs query page by page until the range is exhausted and
+// returns the keys of every page.
+func pageKeys(t *testing.T, tc *TreeCollection, query interfaces.RangeQuery) [][]string {
+	t.Helper()
+	var pages [][]string
+	for {
+		page, err := tc.GetRangePage(query)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.TreeType, err)
+		}
+		var keys []string
+		for _, entry := range page.Entries {
+			keys = append(keys, entry.Key)
+		}
+		pages = append(pages, keys)
+		if page.Token == "" {
+			return pages
+		}
+		query.Token = page.Token
+	}
+}
+
+func TestRangePages(t *testing.T) {
+	tests := []struct {
+		query interfaces.RangeQuery
+		want  string
+	}{
+		{interfaces.RangeQuery{LeftBound: "2", RightBound: "7", Limit: 2}, "[[2 3] [4 5] [6 7]]"},
+		{interfaces.RangeQuery{LeftBound: "2", RightBound: "7", LeftExclusive: true, RightExclusive: true}, "[[3 4 5 6]]"},
+		{interfaces.RangeQuery{LeftBound: "6", Limit: 3}, "[[6 7 8] [9]]"},
+		{interfaces.RangeQuery{LeftBound: "2", RightBound: "7", Reverse: true, Limit: 4}, "[[7 6 5 4] [3 2]]"},
+		{interfaces.RangeQuery{LeftBound: "", Reverse: true, Limit: 5, RightExclusive: true, RightBound: "9"}, "[[8 7 6 5 4] [3 2 1 0]]"},
+		{interfaces.RangeQuery{LeftBound: "35", RightBound: "55", Reverse: true}, "[[5 4]]"},
+		{interfaces.RangeQuery{LeftBound: "7", RightBound: "2"}, "[[]]"},
+	}
+	for _, treeType := range []TreeType{TreeTypeAVL, TreeTypeSkipList, TreeTypeHash, TreeTypeLSM, TreeTypePagedBTree} {
+		tc := newTestCollection(t, treeType, testOptions(treeType), t.TempDir())
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprint(i)
+			if err := tc.Set(key, key, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, test := range tests {
+			if got := fmt.Sprint(pageKeys(t, tc, test.query)); got != test.want {
+				t.Errorf("%s: %+v returns pages %s, want %s", treeType, test.query, got, test.want)
+			}
+		}
+	}
+}
+
+func TestRangePageTokens(t *testing.T) {
+	tc := newTestCollection(t, TreeTypeAVL, CollectionOptions{}, "")
+	for _, key := range []string{"a", "b", "c"} {
+		if err := tc.Set(key, key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	page, err := tc.GetRangePage(interfaces.RangeQuery{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Token == "" {
+		t.Fatal("a page that stops early has no token")
+	}
+
+	// a token continues after its last key even if that key is deleted
+	if err := tc.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	next, err := tc.GetRangePage(interfaces.RangeQuery{Limit: 1, Token: page.Token})
+	if err != nil || len(next.Entries) != 1 || next.Entries[0].Key != "b" {
+		t.Errorf("the next page is %v, %v, want b", next, err)
+	}
+
+	if _, err := tc.GetRangePage(interfaces.RangeQuery{Reverse: true, Token: page.Token}); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("a forward token continued a reverse query: %v", err)
+	}
+	if _, err := tc.GetRangePage(interfaces.RangeQuery{Token: "not base64!"}); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("a garbled token returned %v", err)
+	}
+	if _, err := tc.GetRangePage(interfaces.RangeQuery{Limit: -1}); CodeOf(err) != CodeInvalidArgument {
+		t.Errorf("a negative limit returned %v", err)
+	}
+}
@@ -0,0 +1,815 @@
+package db
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// A leader sends a follower at most replicationBatch mutations a
+	// message, and a heartbeat every replicationHeartbeat while it has
+	// none to send.
+	replicationBatch     = 256
+	replicationHeartbeat = time.Second
+
+	// A follower that loses its leader dials it again after
+	// replicationRetry.
+	replicationRetry       = time.Second
+	replicationDialTimeout = 5 * time.Second
+)
+
+var (
+	ErrNotLeader        = NewError(CodeUnsupported, "a follower cannot have followers of its own")
+	errFollowerStopped  = errors.New("follower stopped")
+	errMalformedMessage = errors.New("malformed replication message")
+)
+
+type MutationOp string
+
+const (
+	MutationCreatePool       MutationOp = "create_pool"
+	MutationCreateSchema     MutationOp = "create_schema"
+	MutationCreateCollection MutationOp = "create_collection"
+	MutationPut              MutationOp = "put"
+	MutationDelete           MutationOp = "delete"
+	MutationExpire           MutationOp = "expire"
+)
+
+// Mutation is a change to a database as a leader streams it to its
+// followers. Each sets state outright, a key's value and deadline or just
+// its deadline, and creating what already exists does nothing, so a
+// follower that applies mutations over state already reflecting some of
+// them still ends up where the leader did.
+type Mutation struct {
+	Position uint64 `json:"position"`
+	// Time is when the leader made the mutation, in Unix nanoseconds, which
+	// for a write is the time its version is stamped with.
+	Time       int64              `json:"time"`
+	Op         MutationOp         `json:"op"`
+	Pool       string             `json:"pool"`
+	Schema     string             `json:"schema,omitempty"`
+	Collection string             `json:"collection,omitempty"`
+	TreeType   TreeType           `json:"tree_type,omitempty"`
+	Options    *CollectionOptions `json:"options,omitempty"`
+	Key        string             `json:"key,omitempty"`
+	Value      string             `json:"value,omitempty"`
+	// Deadline is when the key expires, in Unix nanoseconds, or 0.
+	Deadline int64 `json:"deadline,omitempty"`
+}
+
+// size roughly counts the bytes a logged mutation holds on to.
+func (m *Mutation) size() int {
+	return len(m.Pool) + len(m.Schema) + len(m.Collection) + len(m.Key) + len(m.Value) + 128
+}
+
+// ReplicationLog numbers a leader's mutations in the order they happen
+// and keeps the latest of them for its followers, dropping the oldest
+// once they hold more than limit bytes. It records nothing until the
+// first follower connects, since that follower starts from a snapshot
+// anyway. Positions only mean something within the log with the same id,
+// which is new each time the server starts.
+type ReplicationLog struct {
+	id      string
+	limit   int
+	mutex   sync.Mutex
+	started bool
+	entries []Mutation
+	bytes   int
+	last    uint64
+	// changed is closed, and replaced, whenever a mutation is logged.
+	changed   chan struct{}
+	followers map[*ReplicationStream]struct{}
+}
+
+func newReplicationLog(limit int) *ReplicationLog {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return &ReplicationLog{
+		id:        hex.EncodeToString(id),
+		limit:     limit,
+		changed:   make(chan struct{}),
+		followers: make(map[*ReplicationStream]struct{}),
+	}
+}
+
+// append logs m, as made now unless its Time is set. Callers hold whatever
+// lock orders m against the mutations it depends on.
+func (l *ReplicationLog) append(m Mutation) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if !l.started {
+		return
+	}
+	l.last++
+	m.Position = l.last
+	if m.Time == 0 {
+		m.Time = time.Now().UnixNano()
+	}
+	l.entries = append(l.entries, m)
+	l.bytes += m.size()
+
+	drop := 0
+	for l.limit > 0 && l.bytes > l.limit && drop < len(l.entries)-1 {
+		l.bytes -= l.entries[drop].size()
+		l.entries[drop] = Mutation{}
+		drop++
+	}
+	l.entries = l.entries[drop:]
+
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// first returns the position of the oldest mutation kept. The caller
+// holds the lock.
+func (l *ReplicationLog) first() uint64 {
+	return l.last - uint64(len(l.entries)) + 1
+}
+
+// head returns the position of the latest mutation and when it was
+// logged. The caller holds the lock.
+func (l *ReplicationLog) head() (uint64, int64) {
+	if len(l.entries) == 0 {
+		return l.last, 0
+	}
+	return l.last, l.entries[len(l.entries)-1].Time
+}
+
+// read returns up to max mutations after position, and false if the log
+// no longer holds, or never held, the one right after it. It also returns
+// the log's head, and a channel that is closed once more is logged.
+func (l *ReplicationLog) read(position uint64, max int) ([]Mutation, replicationMessage, <-chan struct{}, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var head replicationMessage
+	head.Head, head.HeadTime = l.head()
+	if position > l.last || position+1 < l.first() {
+		return nil, head, l.changed, false
+	}
+	start := int(position + 1 - l.first())
+	end := len(l.entries)
+	if end-start > max {
+		end = start + max
+	}
+	// copied, since dropping entries clears them
+	return append([]Mutation(nil), l.entries[start:end]...), head, l.changed, true
+}
+
+// collectionChanges records the mutations of one collection in its
+// database's replication log.
+type collectionChanges struct {
+	log        *ReplicationLog
+	pool       string
+	schema     string
+	collection string
+}
+
+func (l *ReplicationLog) collection(poolName, schemaName, collectionName string) *collectionChanges {
+	return &collectionChanges{log: l, pool: poolName, schema: schemaName, collection: collectionName}
+}
+
+// record logs m as a mutation of the collection. The caller holds the
+// collection's write lock, so its mutations are logged in the order they
+// were made.
+func (c *collectionChanges) record(m Mutation) {
+	if c == nil {
+		return
+	}
+	m.Pool, m.Schema, m.Collection = c.pool, c.schema, c.collection
+	c.log.append(m)
+}
+
+// ReplicationHandshake answers a follower that asks to replicate.
+type ReplicationHandshake struct {
+	LogID    string `json:"log_id"`
+	Position uint64 `json:"position"`
+	// Snapshot is set when the follower must load a snapshot first,
+	// because it has nothing of this log or has fallen behind it.
+	Snapshot bool `json:"snapshot"`
+}
+
+// replicationMessage is a line a leader streams to a follower after the
+// handshake: a snapshot, a batch of mutations or a heartbeat. Each gives
+// the position of the leader's latest mutation and when it was logged.
+// A snapshot is followed by Size bytes of backup, which reflects every
+// mutation up to Position and was taken at Time.
+type replicationMessage struct {
+	Type      string     `json:"type"`
+	Head      uint64     `json:"head"`
+	HeadTime  int64      `json:"head_time,omitempty"`
+	Position  uint64     `json:"position,omitempty"`
+	Time      int64      `json:"time,omitempty"`
+	Size      int64      `json:"size,omitempty"`
+	Mutations []Mutation `json:"mutations,omitempty"`
+}
+
+// replicationAck tells a leader how much of its log a follower has
+// applied.
+type replicationAck struct {
+	Position uint64 `json:"position"`
+}
+
+// ReplicationStream streams a leader's log to one follower.
+type ReplicationStream struct {
+	db          *Database
+	address     string
+	start       uint64
+	handshake   ReplicationHandshake
+	connectedAt time.Time
+	// acked is guarded by the log's lock.
+	acked uint64
+}
+
+// OpenReplicationStream starts streaming the log to the follower at
+// address, which has applied the log with logID up to position. The
+// first follower to connect starts the log.
+func (db *Database) OpenReplicationStream(username string, address string, logID string, position uint64) (*ReplicationStream, error) {
+	if !db.AuthManager.HasPermission(username, PermReplicate) {
+		return nil, ErrPermissionDenied
+	}
+	if db.following() != nil {
+		return nil, ErrNotLeader
+	}
+
+	l := db.replication
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.started = true
+	s := &ReplicationStream{
+		db:          db,
+		address:     address,
+		start:       position,
+		connectedAt: time.Now(),
+		acked:       position,
+	}
+	s.handshake = ReplicationHandshake{
+		LogID:    l.id,
+		Position: l.last,
+		Snapshot: logID != l.id || position > l.last || position+1 < l.first(),
+	}
+	if s.handshake.Snapshot {
+		s.acked = 0
+	}
+	l.followers[s] = struct{}{}
+	return s, nil
+}
+
+func (s *ReplicationStream) Handshake() ReplicationHandshake {
+	return s.handshake
+}
+
+// Serve streams the log to w, reading the follower's acknowledgements
+// from acks, until the follower goes away. A follower that falls so far
+// behind that the log no longer holds what it needs gets a new snapshot.
+func (s *ReplicationStream) Serve(acks *bufio.Scanner, w io.Writer) error {
+	l := s.db.replication
+	defer func() {
+		l.mutex.Lock()
+		delete(l.followers, s)
+		l.mutex.Unlock()
+	}()
+
+	gone := make(chan error, 1)
+	go func() {
+		for acks.Scan() {
+			var ack replicationAck
+			if err := json.Unmarshal(acks.Bytes(), &ack); err != nil {
+				gone <- fmt.Errorf("%w: %v", errMalformedMessage, err)
+				return
+			}
+			l.mutex.Lock()
+			s.acked = ack.Position
+			l.mutex.Unlock()
+		}
+		gone <- acks.Err()
+	}()
+
+	writer := bufio.NewWriter(w)
+	encoder := json.NewEncoder(writer)
+	send := func(message replicationMessage) error {
+		if err := encoder.Encode(message); err != nil {
+			return err
+		}
+		return writer.Flush()
+	}
+
+	heartbeat := time.NewTicker(replicationHeartbeat)
+	defer heartbeat.Stop()
+
+	position, snapshot := s.start, s.handshake.Snapshot
+	for {
+		if snapshot {
+			var err error
+			if position, err = s.sendSnapshot(writer, send); err != nil {
+				return err
+			}
+		}
+
+		mutations, message, changed, ok := l.read(position, replicationBatch)
+		if snapshot = !ok; snapshot {
+			continue
+		}
+		if len(mutations) > 0 {
+			message.Type = "mutations"
+			message.Mutations = mutations
+			if err := send(message); err != nil {
+				return err
+			}
+			position = mutations[len(mutations)-1].Position
+			continue
+		}
+
+		select {
+		case <-changed:
+		case <-heartbeat.C:
+			message.Type = "heartbeat"
+			if err := send(message); err != nil {
+				return err
+			}
+		case err := <-gone:
+			return err
+		}
+	}
+}
+
+// sendSnapshot sends a backup of the database and returns the position
+// of the log it reflects. Mutations logged while it is taken may show in
+// it too, which the follower's replaying them again cannot undo.
+func (s *ReplicationStream) sendSnapshot(writer *bufio.Writer, send func(replicationMessage) error) (uint64, error) {
+	l := s.db.replication
+	l.mutex.Lock()
+	message := replicationMessage{Type: "snapshot", Position: l.last, Time: time.Now().UnixNano()}
+	message.Head, message.HeadTime = l.head()
+	l.mutex.Unlock()
+
+	if err := os.MkdirAll(s.db.Config.DumpDir, 0o755); err != nil {
+		return 0, err
+	}
+	file, err := os.CreateTemp(s.db.Config.DumpDir, ".snapshot-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	counter := &countingWriter{w: file}
+	buffered := bufio.NewWriter(counter)
+	if _, err := s.db.backup(buffered, false); err != nil {
+		return 0, err
+	}
+	if err := buffered.Flush(); err != nil {
+		return 0, err
+	}
+
+	message.Size = counter.n
+	if err := send(message); err != nil {
+		return 0, err
+	}
+	if _, err := io.Copy(writer, io.NewSectionReader(file, 0, counter.n)); err != nil {
+		return 0, err
+	}
+	return message.Position, writer.Flush()
+}
+
+// ReplicationStatus describes a leader and how far behind it each of its
+// followers is, or a follower and how far behind its leader it is.
+type ReplicationStatus struct {
+	Role      string        `json:"role"`
+	LogID     string        `json:"log_id,omitempty"`
+	Position  uint64        `json:"position"`
+	Followers []FollowerLag `json:"followers,omitempty"`
+	Leader    *LeaderLag    `json:"leader,omitempty"`
+}
+
+// FollowerLag is how far behind a leader a follower has acknowledged.
+// LagMillis is how long the oldest mutation it has yet to acknowledge has
+// been waiting.
+type FollowerLag struct {
+	Address     string    `json:"address"`
+	ConnectedAt time.Time `json:"connected_at"`
+	Position    uint64    `json:"position"`
+	LagEntries  uint64    `json:"lag_entries"`
+	LagMillis   int64     `json:"lag_millis"`
+}
+
+// LeaderLag is how far behind its leader a follower has applied, as of
+// the leader's latest message. LagMillis is how much older the latest
+// mutation the follower applied is than the leader's latest. Error is why
+// the last connection to the leader ended.
+type LeaderLag struct {
+	Address    string `json:"address"`
+	Connected  bool   `json:"connected"`
+	Position   uint64 `json:"position"`
+	LagEntries uint64 `json:"lag_entries"`
+	LagMillis  int64  `json:"lag_millis"`
+	Error      string `json:"error,omitempty"`
+}
+
+func (db *Database) ReplicationStatus(username string) (ReplicationStatus, error) {
+	if !db.AuthManager.HasPermission(username, PermRead) {
+		return ReplicationStatus{}, ErrPermissionDenied
+	}
+	if f := db.following(); f != nil {
+		return f.status(), nil
+	}
+
+	l := db.replication
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	status := ReplicationStatus{Role: "leader", LogID: l.id, Position: l.last, Followers: []FollowerLag{}}
+	now := time.Now().UnixNano()
+	for s := range l.followers {
+		lag := FollowerLag{Address: s.address, ConnectedAt: s.connectedAt.UTC(), Position: s.acked}
+		if s.acked < l.last {
+			lag.LagEntries = l.last - s.acked
+			// the oldest mutation waiting may have left the log, in which
+			// case it has waited at least as long as the oldest kept
+			waiting := s.acked + 1
+			if first := l.first(); waiting < first {
+				waiting = first
+			}
+			if i := int(waiting - l.first()); i < len(l.entries) {
+				lag.LagMillis = (now - l.entries[i].Time) / int64(time.Millisecond)
+			}
+		}
+		status.Followers = append(status.Followers, lag)
+	}
+	sort.Slice(status.Followers, func(i, j int) bool { return status.Followers[i].Address < status.Followers[j].Address })
+	return status, nil
+}
+
+// Follower keeps its database a copy of a leader's by applying the
+// leader's log. It reconnects whenever the connection drops, resuming
+// after the last mutation it applied, and loads a snapshot when the
+// leader can no longer resume it.
+type Follower struct {
+	db       *Database
+	leader   string
+	username string
+	password string
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+
+	mutex     sync.Mutex
+	conn      net.Conn
+	connected bool
+	logID     string
+	applied   uint64
+	appliedAt int64
+	head      uint64
+	headAt    int64
+	err       error
+}
+
+// Follow makes the database follow the leader at address, logging in to
+// it as username. From then on the database should only serve reads, and
+// it cannot have followers of its own.
+func (db *Database) Follow(address string, username string, password string) *Follower {
+	f := &Follower{
+		db:       db,
+		leader:   address,
+		username: username,
+		password: password,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	db.mutex.Lock()
+	db.follower = f
+	db.mutex.Unlock()
+
+	go f.run()
+	return f
+}
+
+func (db *Database) following() *Follower {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return db.follower
+}
+
+// Following returns the address of the leader the database follows, or
+// "" if it follows none.
+func (db *Database) Following() string {
+	if f := db.following(); f != nil {
+		return f.leader
+	}
+	return ""
+}
+
+// Stop disconnects from the leader and waits until the follower is done
+// applying.
+func (f *Follower) Stop() {
+	f.stopOnce.Do(func() {
+		close(f.stop)
+		f.mutex.Lock()
+		if f.conn != nil {
+			f.conn.Close()
+		}
+		f.mutex.Unlock()
+	})
+	<-f.done
+}
+
+func (f *Follower) run() {
+	defer close(f.done)
+
+	for {
+		err := f.session()
+
+		f.mutex.Lock()
+		f.conn, f.connected, f.err = nil, false, err
+		f.mutex.Unlock()
+
+		select {
+		case <-f.stop:
+			return
+		default:
+		}
+		log.Printf("Replication from %s stopped: %v", f.leader, err)
+
+		select {
+		case <-f.stop:
+			return
+		case <-time.After(replicationRetry):
+		}
+	}
+}
+
+// session replicates over one connection to the leader until it fails.
+func (f *Follower) session() error {
+	conn, err := net.DialTimeout("tcp", f.leader, replicationDialTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	f.mutex.Lock()
+	select {
+	case <-f.stop:
+		f.mutex.Unlock()
+		return errFollowerStopped
+	default:
+	}
+	f.conn = conn
+	request := Command{
+		Operation: "replicate",
+		Username:  f.username,
+		Password:  f.password,
+		LogID:     f.logID,
+		Position:  f.applied,
+	}
+	f.mutex.Unlock()
+
+	encoder := json.NewEncoder(conn)
+	if err := encoder.Encode(request); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	var response struct {
+		Status   string               `json:"status"`
+		Error    string               `json:"error"`
+		Response ReplicationHandshake `json:"response"`
+	}
+	if err := readReplicationLine(reader, &response); err != nil {
+		return err
+	}
+	if response.Status != "ok" {
+		return fmt.Errorf("leader refused to replicate: %s", response.Error)
+	}
+	handshake := response.Response
+
+	f.mutex.Lock()
+	f.connected = true
+	f.head = handshake.Position
+	f.mutex.Unlock()
+
+	for {
+		var message replicationMessage
+		if err := readReplicationLine(reader, &message); err != nil {
+			return err
+		}
+
+		switch message.Type {
+		case "snapshot":
+			f.db.clock.set(time.Unix(0, message.Time))
+			if err := f.loadSnapshot(reader, message.Size); err != nil {
+				return fmt.Errorf("loading snapshot: %w", err)
+			}
+			f.mutex.Lock()
+			f.logID, f.applied, f.appliedAt = handshake.LogID, message.Position, message.Time
+			f.mutex.Unlock()
+
+		case "mutations":
+			for _, m := range message.Mutations {
+				// the follower stamps the mutation's writes and expires keys
+				// as of when the leader logged it, so that its history
+				// reads like the leader's
+				f.db.clock.set(time.Unix(0, m.Time))
+				if err := f.db.applyMutation(f.username, m); err != nil {
+					return fmt.Errorf("applying mutation %d: %w", m.Position, err)
+				}
+				f.mutex.Lock()
+				f.applied, f.appliedAt = m.Position, m.Time
+				f.mutex.Unlock()
+			}
+
+		case "heartbeat":
+
+		default:
+			return fmt.Errorf("%w: unknown type %q", errMalformedMessage, message.Type)
+		}
+
+		f.mutex.Lock()
+		f.head, f.headAt = message.Head, message.HeadTime
+		ack := replicationAck{Position: f.applied}
+		f.mutex.Unlock()
+		if err := encoder.Encode(ack); err != nil {
+			return err
+		}
+	}
+}
+
+func readReplicationLine(reader *bufio.Reader, v interface{}) error {
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(line, v); err != nil {
+		return fmt.Errorf("%w: %v", errMalformedMessage, err)
+	}
+	return nil
+}
+
+// loadSnapshot replaces the database with the size bytes of backup that
+// r holds.
+func (f *Follower) loadSnapshot(r io.Reader, size int64) error {
+	if err := os.MkdirAll(f.db.Config.DumpDir, 0o755); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(f.db.Config.DumpDir, ".snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if _, err := io.CopyN(file, r, size); err != nil {
+		return err
+	}
+	if _, err := verifyBackup(file, size); err != nil {
+		return err
+	}
+
+	// until the snapshot is loaded the database matches no position of
+	// any log, so a failure here starts the next connection over
+	f.mutex.Lock()
+	f.logID, f.applied, f.appliedAt = "", 0, 0
+	f.mutex.Unlock()
+
+	if err := f.db.drop(); err != nil {
+		return err
+	}
+	_, err = f.db.Restore(f.username, file, size, RestoreOptions{})
+	return err
+}
+
+// drop removes every pool, closing its collections and deleting their
+// files.
+func (db *Database) drop() error {
+	db.mutex.Lock()
+	pools := db.Pools
+	db.Pools = make(map[string]*DataPool)
+	db.mutex.Unlock()
+
+	for poolName, pool := range pools {
+		pool.mutex.RLock()
+		for _, schema := range pool.Schemas {
+			schema.mutex.RLock()
+			for _, collection := range schema.Collections {
+				if closer, ok := collection.(interface{ Close() error }); ok {
+					if err := closer.Close(); err != nil {
+						log.Printf("Error closing collection in pool %s: %v", poolName, err)
+					}
+				}
+			}
+			schema.mutex.RUnlock()
+		}
+		pool.mutex.RUnlock()
+
+		if err := os.RemoveAll(filepath.Join(db.Config.DataDir, poolName)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Follower) status() ReplicationStatus {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	lag := &LeaderLag{Address: f.leader, Connected: f.connected, Position: f.head}
+	if f.applied < f.head {
+		lag.LagEntries = f.head - f.applied
+		if f.headAt > f.appliedAt {
+			lag.LagMillis = (f.headAt - f.appliedAt) / int64(time.Millisecond)
+		}
+	}
+	if f.err != nil {
+		lag.Error = f.err.Error()
+	}
+	return ReplicationStatus{Role: "follower", LogID: f.logID, Position: f.applied, Leader: lag}
+}
+
+// applyMutation applies a mutation of the leader's, creating pools,
+// schemas and collections as username.
+func (db *Database) applyMutation(username string, m Mutation) error {
+	switch m.Op {
+	case MutationCreatePool:
+		if err := db.CreatePool(username, m.Pool); err != nil && !errors.Is(err, ErrPoolExists) {
+			return err
+		}
+		return nil
+
+	case MutationCreateSchema:
+		if err := db.CreateSchema(username, m.Pool, m.Schema); err != nil && !errors.Is(err, ErrSchemaExists) {
+			return err
+		}
+		return nil
+
+	case MutationCreateCollection:
+		var options CollectionOptions
+		if m.Options != nil {
+			options = *m.Options
+		}
+		err := db.CreateCollection(username, m.Pool, m.Schema, m.Collection, m.TreeType, options)
+		if err != nil && !errors.Is(err, ErrCollectionExists) {
+			return err
+		}
+		return nil
+	}
+
+	collection, err := db.getCollection(m.Pool, m.Schema, m.Collection)
+	if err != nil {
+		return fmt.Errorf("%s/%s/%s: %w", m.Pool, m.Schema, m.Collection, err)
+	}
+	tc, ok := collection.(*TreeCollection)
+	if !ok {
+		return Errorf(CodeUnsupported, "collection %s/%s/%s cannot be replicated", m.Pool, m.Schema, m.Collection)
+	}
+	return tc.apply(m)
+}
+
+// apply applies a put, delete or expire mutation.
+func (tc *TreeCollection) apply(m Mutation) error {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	now := tc.clock.now()
+	switch m.Op {
+	case MutationPut:
+		if m.Deadline == 0 || m.Deadline > now.UnixNano() {
+			return tc.write(m.Key, m.Value, m.Deadline)
+		}
+		// the key expired on its way here
+		fallthrough
+
+	case MutationDelete:
+		if tc.expiry.expired(m.Key, now) {
+			return tc.removeExpired(m.Key)
+		}
+		if err := tc.remove(m.Key); err != nil && !errors.Is(err, ErrKeyNotFound) {
+			return err
+		}
+		return nil
+
+	case MutationExpire:
+		if err := tc.checkLive(m.Key); errors.Is(err, ErrKeyNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if m.Deadline != 0 {
+			tc.startSweeper()
+		}
+		return tc.expiry.set(m.Key, m.Deadline)
+	}
+	return Errorf(CodeInvalidArgument, "unknown mutation %q", m.Op)
+}
@@ -0,0 +1,216 @@
+package db
+
+import (
+	"DB_II/pkg/interfaces"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testLeader serves a database's replication log the way the server
+// does, remembering what each follower asked for.
+type testLeader struct {
+	db       *Database
+	listener net.Listener
+
+	mutex    sync.Mutex
+	conns    []net.Conn
+	requests []Command
+	answers  []ReplicationHandshake
+}
+
+func startTestLeader(t *testing.T, database *Database) *testLeader {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &testLeader{db: database, listener: listener}
+	go l.serve()
+	t.Cleanup(func() {
+		listener.Close()
+		l.disconnect()
+	})
+	return l
+}
+
+func (l *testLeader) serve() {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			return
+		}
+		go l.serveConn(conn)
+	}
+}
+
+func (l *testLeader) serveConn(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	if !scanner.Scan() {
+		return
+	}
+	var cmd Command
+	if err := json.Unmarshal(scanner.Bytes(), &cmd); err != nil {
+		return
+	}
+	stream, err := l.db.OpenReplicationStream(cmd.Username, conn.RemoteAddr().String(), cmd.LogID, cmd.Position)
+	if err != nil {
+		return
+	}
+
+	l.mutex.Lock()
+	l.conns = append(l.conns, conn)
+	l.requests = append(l.requests, cmd)
+	l.answers = append(l.answers, stream.Handshake())
+	l.mutex.Unlock()
+
+	response := map[string]interface{}{"status": "ok", "response": stream.Handshake()}
+	if err := json.NewEncoder(conn).Encode(response); err != nil {
+		return
+	}
+	stream.Serve(scanner, conn)
+}
+
+// disconnect drops every follower's connection.
+func (l *testLeader) disconnect() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, conn := range l.conns {
+		conn.Close()
+	}
+	l.conns = nil
+}
+
+func (l *testLeader) sessions() ([]Command, []ReplicationHandshake) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]Command(nil), l.requests...), append([]ReplicationHandshake(nil), l.answers...)
+}
+
+// waitForCopy waits until follower holds what leader does.
+func waitForCopy(t *testing.T, leader, follower *Database) {
+	t.Helper()
+	want := testContents(leader)
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		if got := testContents(follower); reflect.DeepEqual(got, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("follower holds %d keys, want %d", len(testContents(follower)), len(want))
+		}
+	}
+}
+
+func setTestKeys(t *testing.T, database *Database, from, to int) {
+	t.Helper()
+	collection, err := database.getCollection("p", "s", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := from; i < to; i++ {
+		key := fmt.Sprintf("k%03d", i)
+		if err := collection.Set(key, key, fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFollowerResumesAfterDisconnect(t *testing.T) {
+	leader := newTestDatabase(t, t.TempDir())
+	defer leader.Close()
+	createTestCollection(t, leader, TreeTypeAVL, CollectionOptions{})
+	setTestKeys(t, leader, 0, 10)
+	server := startTestLeader(t, leader)
+
+	follower := newTestDatabase(t, t.TempDir())
+	defer follower.Close()
+	f := follower.Follow(server.listener.Addr().String(), "replicator", "secret")
+	defer f.Stop()
+
+	// a new follower starts from a snapshot, then follows the log
+	waitForCopy(t, leader, follower)
+	setTestKeys(t, leader, 10, 50)
+	waitForCopy(t, leader, follower)
+
+	// the connection drops, and the leader goes on meanwhile
+	server.disconnect()
+	collection, err := leader.getCollection("p", "s", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := collection.Delete("k000"); err != nil {
+		t.Fatal(err)
+	}
+	setTestKeys(t, leader, 50, 100)
+	waitForCopy(t, leader, follower)
+
+	requests, answers := server.sessions()
+	if len(answers) != 2 {
+		t.Fatalf("follower connected %d times, want 2", len(answers))
+	}
+	if !answers[0].Snapshot {
+		t.Error("a new follower was not sent a snapshot")
+	}
+	if requests[1].LogID != answers[0].LogID || requests[1].Position == 0 {
+		t.Errorf("follower reconnected asking for %q at %d", requests[1].LogID, requests[1].Position)
+	}
+	if answers[1].Snapshot {
+		t.Error("a follower that fell behind by less than the log holds was sent a snapshot")
+	}
+	if got := testContents(follower); got["k000"] != "" || got["k099"] != "99" {
+		t.Errorf("follower missed the writes made while it was away: %v", got)
+	}
+}
+
+func TestFollowerHistoryMatchesLeader(t *testing.T) {
+	leader := newTestDatabase(t, t.TempDir())
+	defer leader.Close()
+	createTestCollection(t, leader, TreeTypeAVL, CollectionOptions{HistoryVersions: 10})
+	server := startTestLeader(t, leader)
+
+	follower := newTestDatabase(t, t.TempDir())
+	defer follower.Close()
+	f := follower.Follow(server.listener.Addr().String(), "replicator", "secret")
+	defer f.Stop()
+	waitForCopy(t, leader, follower)
+
+	// every version reaches the follower stamped with the leader's time,
+	// however late it arrives
+	for round := 0; round < 3; round++ {
+		setTestKeys(t, leader, 0, 5)
+		time.Sleep(5 * time.Millisecond)
+	}
+	collection, err := leader.getCollection("p", "s", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := collection.Delete("k001"); err != nil {
+		t.Fatal(err)
+	}
+	waitForCopy(t, leader, follower)
+
+	histories := func(database *Database) [][]interfaces.Version {
+		collection, err := database.getCollection("p", "s", "c")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var all [][]interfaces.Version
+		for _, key := range []string{"k000", "k001", "k004"} {
+			versions, err := collection.(*TreeCollection).GetHistory(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			all = append(all, versions)
+		}
+		return all
+	}
+	if got, want := histories(follower), histories(leader); !reflect.DeepEqual(got, want) {
+		t.Fatalf("follower's history is %v, want the leader's %v", got, want)
+	}
+}
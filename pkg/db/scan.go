@@ -0,0 +1,246 @@
+package db
+
+import (
+	"DB_II/pkg/interfaces"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var ErrInvalidPattern = NewError(CodeInvalidArgument, "invalid pattern")
+
+// scanDeadlineInterval is how many keys a pattern scan visits between
+// looks at the clock.
+const scanDeadlineInterval = 256
+
+// prefixEnd returns the smallest key that sorts after every key starting
+// with prefix, or "" if there is none.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
+
+// prefixQuery is the range holding exactly the keys that start with prefix.
+func prefixQuery(prefix string) interfaces.RangeQuery {
+	return interfaces.RangeQuery{
+		LeftBound:      prefix,
+		RightBound:     prefixEnd(prefix),
+		RightExclusive: true,
+	}
+}
+
+// hasFoldPrefix reports whether key starts with prefix ignoring case, as
+// compareFold does.
+func hasFoldPrefix(key, prefix string) bool {
+	for prefix != "" {
+		if key == "" {
+			return false
+		}
+		r, n := utf8.DecodeRuneInString(key)
+		s, m := utf8.DecodeRuneInString(prefix)
+		if foldRune(r) != foldRune(s) {
+			return false
+		}
+		key, prefix = key[n:], prefix[m:]
+	}
+	return true
+}
+
+// ScanPrefix returns the keys starting with prefix in order. It seeks to
+// the first of them and stops at the first key past them. That takes a key
+// order that keeps them together: bytewise, reverse, or case-insensitive,
+// where the prefix matches in any case. Numeric, natural and locale orders
+// scatter them, so those collections refuse prefix scans and leave it to
+// ScanMatch to visit every key.
+func (tc *TreeCollection) ScanPrefix(prefix string, limit int, token string) (*interfaces.RangePage, error) {
+	switch tc.Options.Comparator {
+	case ComparatorBytewise:
+		query := prefixQuery(prefix)
+		query.Limit = limit
+		query.Token = token
+		return tc.GetRangePage(query)
+
+	case ComparatorReverse:
+		// the reverse of the bytewise range, with an empty bound still
+		// meaning the first key
+		query := prefixQuery(prefix)
+		return tc.GetRangePage(interfaces.RangeQuery{
+			LeftBound:     query.RightBound,
+			LeftExclusive: query.RightBound != "",
+			RightBound:    prefix,
+			Limit:         limit,
+			Token:         token,
+		})
+
+	case ComparatorCaseInsensitive:
+		return tc.rangePage(interfaces.RangeQuery{
+			LeftBound: prefix,
+			Limit:     limit,
+			Token:     token,
+		}, func(key string) bool { return hasFoldPrefix(key, prefix) })
+
+	default:
+		return nil, Errorf(CodeUnsupported, "a %s collection cannot scan by prefix; use scan_match with the pattern %q instead", tc.Options.Comparator, globEscape(prefix)+"*")
+	}
+}
+
+// globEscape quotes the characters a glob treats specially.
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`*?[\`, c) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// globToRegexp translates a glob into an anchored regular expression. It
+// supports *, ?, character classes such as [a-z] and [!0-9], and
+// backslash escapes.
+func globToRegexp(glob string) (string, error) {
+	var b strings.Builder
+	b.WriteString(`^(?s:`)
+
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			b.WriteString(`.*`)
+		case '?':
+			b.WriteString(`.`)
+		case '\\':
+			if i+1 == len(glob) {
+				return "", fmt.Errorf("%w: trailing backslash in %q", ErrInvalidPattern, glob)
+			}
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return "", fmt.Errorf("%w: unterminated character class in %q", ErrInvalidPattern, glob)
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+
+	b.WriteString(`)$`)
+	return b.String(), nil
+}
+
+// compileMatch returns the matcher for a pattern and a literal prefix that
+// every matching key starts with, which narrows the scan.
+func compileMatch(pattern string, isRegex bool) (*regexp.Regexp, string, error) {
+	expr := pattern
+	if !isRegex {
+		var err error
+		if expr, err = globToRegexp(pattern); err != nil {
+			return nil, "", err
+		}
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidPattern, err)
+	}
+
+	// a literal prefix only says where matches start, which is the start
+	// of the key only if the expression is anchored there
+	parsed, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return re, "", nil
+	}
+	prog, err := syntax.Compile(parsed.Simplify())
+	if err != nil || prog.StartCond()&syntax.EmptyBeginText == 0 {
+		return re, "", nil
+	}
+	prefix, _ := re.LiteralPrefix()
+	return re, prefix, nil
+}
+
+// ScanMatch returns the keys matching a glob or regular expression in
+// order. In bytewise collections only keys sharing the pattern's literal
+// prefix are visited. The scan gives up after the query's timeout, which
+// is capped by the server's maximum, so it cannot hold the collection's
+// read lock for long.
+func (tc *TreeCollection) ScanMatch(query interfaces.MatchQuery) (*interfaces.RangePage, error) {
+	if query.Limit < 0 {
+		return nil, Errorf(CodeInvalidArgument, "limit %d cannot be negative", query.Limit)
+	}
+
+	re, prefix, err := compileMatch(query.Pattern, query.Regex)
+	if err != nil {
+		return nil, err
+	}
+
+	if tc.Options.Comparator != ComparatorBytewise {
+		prefix = ""
+	}
+	bounds := prefixQuery(prefix)
+	if query.Token != "" {
+		bounds.Token = query.Token
+		if err := resumeRangeQuery(&bounds); err != nil {
+			return nil, err
+		}
+	}
+
+	timeout := query.Timeout
+	if limit := time.Duration(tc.config.MaxScanMillis) * time.Millisecond; limit > 0 && (timeout <= 0 || timeout > limit) {
+		timeout = limit
+	}
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	it, err := tc.Iterator()
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	ok := it.Seek(bounds.LeftBound)
+	if ok && bounds.LeftExclusive && tc.compare(it.Key(), bounds.LeftBound) == 0 {
+		ok = it.Next()
+	}
+
+	page := &interfaces.RangePage{Entries: make([]interfaces.Entry, 0)}
+	lastKey := ""
+	for visited := 0; ok && (bounds.RightBound == "" || it.Key() < bounds.RightBound); visited++ {
+		if query.Limit > 0 && len(page.Entries) == query.Limit {
+			page.Token = encodeRangeToken(false, lastKey)
+			break
+		}
+		if visited%scanDeadlineInterval == 0 && visited > 0 && !deadline.IsZero() && time.Now().After(deadline) {
+			page.Token = encodeRangeToken(false, lastKey)
+			break
+		}
+
+		if re.MatchString(it.Key()) {
+			page.Entries = append(page.Entries, interfaces.Entry{Key: it.Key(), Value: it.Value()})
+		}
+		lastKey = it.Key()
+		ok = it.Next()
+	}
+
+	if err := it.Close(); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
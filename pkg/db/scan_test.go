@@ -0,0 +1,159 @@
+package db
+
+import (
+	"DB_II/pkg/interfaces"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func entryKeys(entries []interfaces.Entry) string {
+	keys := make([]string, len(entries))
+	for i, entry := range entries {
+		keys[i] = entry.Key
+	}
+	return fmt.Sprint(keys)
+}
+
+func newScanTestCollection(t *testing.T, keys ...string) *TreeCollection {
+	t.Helper()
+	tc := newTestCollection(t, TreeTypeAVL, CollectionOptions{}, "")
+	for _, key := range keys {
+		if err := tc.Set(key, key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tc
+}
+
+func TestScanPrefix(t *testing.T) {
+	tc := newScanTestCollection(t, "user", "user:1", "user:2", "user:3", "user;", "users", "us", "\xff", "\xff\xff", "\xff\xffa")
+
+	page, err := tc.ScanPrefix("user:", 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entryKeys(page.Entries); got != "[user:1 user:2]" || page.Token == "" {
+		t.Fatalf("first page is %s with token %q", got, page.Token)
+	}
+	page, err = tc.ScanPrefix("user:", 2, page.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entryKeys(page.Entries); got != "[user:3]" || page.Token != "" {
+		t.Fatalf("second page is %s with token %q", got, page.Token)
+	}
+
+	// a prefix of 0xff bytes has no end but the last key
+	page, err = tc.ScanPrefix("\xff\xff", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entryKeys(page.Entries); got != "[\xff\xff \xff\xffa]" {
+		t.Fatalf("prefix ff ff holds %q", got)
+	}
+	page, err = tc.ScanPrefix("", 0, "")
+	if err != nil || len(page.Entries) != 10 {
+		t.Fatalf("an empty prefix returns %d keys, %v, want all 10", len(page.Entries), err)
+	}
+}
+
+func TestScanMatch(t *testing.T) {
+	tc := newScanTestCollection(t, "a*b", "a1", "a2", "ab", "abc", "b1", "ba", "xa1")
+	tests := []struct {
+		pattern string
+		regex   bool
+		want    string
+	}{
+		{"a*", false, "[a*b a1 a2 ab abc]"},
+		{"a?", false, "[a1 a2 ab]"},
+		{"[ab][0-9]", false, "[a1 a2 b1]"},
+		{"a[!0-9]*", false, "[a*b ab abc]"},
+		{`a\*b`, false, "[a*b]"},
+		{"*1", false, "[a1 b1 xa1]"},
+		{"a1", true, "[a1 xa1]"},
+		{"^a[0-9]$", true, "[a1 a2]"},
+		{"c$|^ba", true, "[abc ba]"},
+	}
+	for _, test := range tests {
+		page, err := tc.ScanMatch(interfaces.MatchQuery{Pattern: test.pattern, Regex: test.regex})
+		if err != nil {
+			t.Fatalf("%q: %v", test.pattern, err)
+		}
+		if got := entryKeys(page.Entries); got != test.want || page.Token != "" {
+			t.Errorf("%q matches %s with token %q, want %s", test.pattern, got, page.Token, test.want)
+		}
+	}
+
+	for _, pattern := range []string{`a\`, "[ab", "(a"} {
+		if _, err := tc.ScanMatch(interfaces.MatchQuery{Pattern: pattern, Regex: pattern == "(a"}); !errors.Is(err, ErrInvalidPattern) {
+			t.Errorf("%q returned %v, want ErrInvalidPattern", pattern, err)
+		}
+	}
+}
+
+func TestScanMatchLiteralPrefix(t *testing.T) {
+	tests := []struct {
+		pattern string
+		regex   bool
+		want    string
+	}{
+		{"abc*", false, "abc"},
+		{"a?c", false, "a"},
+		{"*bc", false, ""},
+		{"^abc", true, "abc"},
+		// an unanchored expression may match anywhere in the key
+		{"abc", true, ""},
+	}
+	for _, test := range tests {
+		_, prefix, err := compileMatch(test.pattern, test.regex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if prefix != test.want {
+			t.Errorf("%q has literal prefix %q, want %q", test.pattern, prefix, test.want)
+		}
+	}
+}
+
+func TestScanMatchStopsAtLimitAndTimeout(t *testing.T) {
+	tc := newTestCollection(t, TreeTypeAVL, CollectionOptions{}, "")
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprintf("k%04d", i)
+		if err := tc.Set(key, key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a scan that runs out of time returns what it found and a token to
+	// go on from, even without matches; following tokens finds them all
+	query := interfaces.MatchQuery{Pattern: "*5", Timeout: time.Nanosecond}
+	matches, pages := 0, 0
+	for {
+		page, err := tc.ScanMatch(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		matches += len(page.Entries)
+		pages++
+		if page.Token == "" {
+			break
+		}
+		query.Token = page.Token
+	}
+	if matches != 200 || pages < 2 {
+		t.Fatalf("timed out scans found %d keys in %d pages, want 200 in several", matches, pages)
+	}
+
+	page, err := tc.ScanMatch(interfaces.MatchQuery{Pattern: "k1*", Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := entryKeys(page.Entries); got != "[k1000 k1001 k1002]" || page.Token == "" {
+		t.Fatalf("limited scan is %s with token %q", got, page.Token)
+	}
+	if _, err := tc.ScanMatch(interfaces.MatchQuery{Pattern: "*", Limit: -1}); CodeOf(err) != CodeInvalidArgument {
+		t.Errorf("a negative limit returned %v", err)
+	}
+}
@@ -0,0 +1,809 @@
+package db
+
+import (
+	"DB_II/pkg/interfaces"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// A server routes for the collections it shards across other servers,
+// keeping in shards.json in its data directory which servers hold which
+// shard of each. A shard is an ordinary collection on the server holding
+// it, named after the sharded collection and the shard. The router sends
+// each command for a sharded collection, with the client's credentials,
+// to the shards it concerns, so clients and shard servers need not know
+// about sharding at all.
+const shardCatalogFile = "shards.json"
+
+// shardSlots is how many slots hash partitioning divides keys among.
+const shardSlots = 4096
+
+var (
+	ErrShardNotFound = NewError(CodeNotFound, "shard not found")
+	ErrShardBusy     = NewError(CodeConditionFailed, "a shard of the collection is already being split or moved")
+	ErrCrossShard    = NewError(CodeUnsupported, "an atomic batch on a sharded collection must keep to one shard")
+)
+
+// ShardScheme is how a sharded collection divides its keys: by ranges of
+// its key order, or by hash.
+type ShardScheme string
+
+const (
+	ShardByRange ShardScheme = "range"
+	ShardByHash  ShardScheme = "hash"
+)
+
+// ShardSpec asks for a collection to be sharded, one shard on each of
+// Servers. A range-sharded collection is split at SplitKeys, one fewer
+// than the servers and ascending in the collection's key order. A
+// hash-sharded one divides the hash slots evenly.
+type ShardSpec struct {
+	Scheme    ShardScheme `json:"scheme"`
+	Servers   []string    `json:"servers"`
+	SplitKeys []string    `json:"split_keys,omitempty"`
+}
+
+// Shard is a part of a sharded collection, held by the collection named
+// Collection on the server at Server. A range shard holds the keys from
+// Start up to but not including End, an empty Start or End leaving that
+// side open. A hash shard holds the keys whose slot is from FirstSlot up
+// to but not including EndSlot. A Draining shard still has keys it gave
+// up in a split, which the router ignores until they are deleted.
+type Shard struct {
+	ID         string `json:"id"`
+	Server     string `json:"server"`
+	Collection string `json:"collection"`
+	Start      string `json:"start,omitempty"`
+	End        string `json:"end,omitempty"`
+	FirstSlot  int    `json:"first_slot,omitempty"`
+	EndSlot    int    `json:"end_slot,omitempty"`
+	Draining   bool   `json:"draining,omitempty"`
+}
+
+func shardSlot(key string) int {
+	return int(crc32.ChecksumIEEE([]byte(key)) % shardSlots)
+}
+
+// holds reports whether the shard holds key.
+func (s Shard) holds(scheme ShardScheme, compare compareFunc, key string) bool {
+	if scheme == ShardByHash {
+		slot := shardSlot(key)
+		return slot >= s.FirstSlot && slot < s.EndSlot
+	}
+	return (s.Start == "" || compare(key, s.Start) >= 0) && (s.End == "" || compare(key, s.End) < 0)
+}
+
+// ShardedCollection describes a collection sharded across servers, its
+// shards in key order for range sharding and slot order for hash.
+type ShardedCollection struct {
+	Pool       string            `json:"pool"`
+	Schema     string            `json:"schema"`
+	Collection string            `json:"collection"`
+	Scheme     ShardScheme       `json:"scheme"`
+	TreeType   TreeType          `json:"tree_type"`
+	Options    CollectionOptions `json:"options"`
+	Shards     []Shard           `json:"shards"`
+	// NextShard numbers the shards and the collections holding them, so
+	// that no name is used twice.
+	NextShard int `json:"next_shard"`
+}
+
+func (c ShardedCollection) clone() ShardedCollection {
+	c.Shards = append([]Shard(nil), c.Shards...)
+	return c
+}
+
+// newShard names the shard the collection numbers next.
+func (c *ShardedCollection) newShard() (string, string) {
+	c.NextShard++
+	return fmt.Sprintf("s%d", c.NextShard), fmt.Sprintf("%s__s%d", c.Collection, c.NextShard)
+}
+
+// shards lays out the shards spec asks for, yet to be named.
+func (s *ShardSpec) shards(options CollectionOptions) ([]Shard, error) {
+	if len(s.Servers) == 0 {
+		return nil, Errorf(CodeInvalidArgument, "a sharded collection needs at least one server")
+	}
+	for _, server := range s.Servers {
+		if server == "" {
+			return nil, Errorf(CodeInvalidArgument, "shard server addresses cannot be empty")
+		}
+	}
+
+	shards := make([]Shard, len(s.Servers))
+	switch s.Scheme {
+	case ShardByRange:
+		if len(s.SplitKeys) != len(s.Servers)-1 {
+			return nil, Errorf(CodeInvalidArgument, "%d servers need %d split keys, not %d", len(s.Servers), len(s.Servers)-1, len(s.SplitKeys))
+		}
+		compare := options.Comparator.compareFunc()
+		for i, key := range s.SplitKeys {
+			if key == "" {
+				return nil, Errorf(CodeInvalidArgument, "split keys cannot be empty")
+			}
+			if i > 0 && compare(s.SplitKeys[i-1], key) >= 0 {
+				return nil, Errorf(CodeInvalidArgument, "split keys must ascend in the collection's key order")
+			}
+		}
+		for i, server := range s.Servers {
+			shards[i].Server = server
+			if i > 0 {
+				shards[i].Start = s.SplitKeys[i-1]
+			}
+			if i < len(s.SplitKeys) {
+				shards[i].End = s.SplitKeys[i]
+			}
+		}
+
+	case ShardByHash:
+		if len(s.SplitKeys) > 0 {
+			return nil, Errorf(CodeInvalidArgument, "hash sharding takes no split keys")
+		}
+		// keys another comparator treats as equal could hash apart
+		switch options.Comparator {
+		case "", ComparatorBytewise, ComparatorReverse:
+		default:
+			return nil, Errorf(CodeUnsupported, "hash sharding needs a bytewise comparator, not %s", options.Comparator)
+		}
+		if len(s.Servers) > shardSlots {
+			return nil, Errorf(CodeInvalidArgument, "hash sharding takes at most %d servers", shardSlots)
+		}
+		for i, server := range s.Servers {
+			shards[i] = Shard{
+				Server:    server,
+				FirstSlot: i * shardSlots / len(s.Servers),
+				EndSlot:   (i + 1) * shardSlots / len(s.Servers),
+			}
+		}
+
+	default:
+		return nil, Errorf(CodeInvalidArgument, "unknown sharding scheme %q (expected %s or %s)", s.Scheme, ShardByRange, ShardByHash)
+	}
+	return shards, nil
+}
+
+// ShardRouter forwards the commands for sharded collections to the
+// servers holding their shards.
+type ShardRouter struct {
+	db     *Database
+	client *shardClient
+
+	mutex       sync.RWMutex
+	collections map[string]*shardedCollection
+
+	// catalogMutex orders the changes to the catalog and guards catalog,
+	// each collection as last saved.
+	catalogMutex sync.Mutex
+	catalog      map[string]ShardedCollection
+}
+
+type shardedCollection struct {
+	// mutex is held for reading while a command is routed and forwarded,
+	// and for writing while shards change hands, so that no command is
+	// forwarded to a shard that no longer holds its keys.
+	mutex     sync.RWMutex
+	meta      ShardedCollection
+	compare   compareFunc
+	migration *shardMigration
+	// busy is set while a shard is being split or moved.
+	busy bool
+}
+
+func newShardRouter(db *Database) *ShardRouter {
+	return &ShardRouter{
+		db:          db,
+		client:      newShardClient(),
+		collections: make(map[string]*shardedCollection),
+		catalog:     make(map[string]ShardedCollection),
+	}
+}
+
+// Shards returns the router for the database's sharded collections.
+func (db *Database) Shards() *ShardRouter {
+	return db.shards
+}
+
+func shardedName(poolName, schemaName, collectionName string) string {
+	return poolName + "/" + schemaName + "/" + collectionName
+}
+
+func (r *ShardRouter) path() string {
+	return filepath.Join(r.db.Config.DataDir, shardCatalogFile)
+}
+
+// load reads the catalog.
+func (r *ShardRouter) load() error {
+	data, err := os.ReadFile(r.path())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var catalog []ShardedCollection
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return fmt.Errorf("%s: %v", r.path(), err)
+	}
+
+	r.catalogMutex.Lock()
+	defer r.catalogMutex.Unlock()
+	for _, meta := range catalog {
+		r.catalog[shardedName(meta.Pool, meta.Schema, meta.Collection)] = meta.clone()
+		r.add(meta)
+	}
+	return nil
+}
+
+// save records meta in the catalog. The caller holds catalogMutex.
+func (r *ShardRouter) save(meta ShardedCollection) error {
+	name := shardedName(meta.Pool, meta.Schema, meta.Collection)
+	previous, existed := r.catalog[name]
+	r.catalog[name] = meta.clone()
+
+	names := make([]string, 0, len(r.catalog))
+	for name := range r.catalog {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	catalog := make([]ShardedCollection, len(names))
+	for i, name := range names {
+		catalog[i] = r.catalog[name]
+	}
+
+	data, err := json.MarshalIndent(catalog, "", "  ")
+	if err == nil {
+		if err = os.MkdirAll(r.db.Config.DataDir, 0o755); err == nil {
+			err = writeFileAtomic(r.path(), data)
+		}
+	}
+	if err != nil {
+		if existed {
+			r.catalog[name] = previous
+		} else {
+			delete(r.catalog, name)
+		}
+	}
+	return err
+}
+
+// commit saves sc's shards. The caller holds sc.mutex.
+func (r *ShardRouter) commit(sc *shardedCollection) error {
+	r.catalogMutex.Lock()
+	defer r.catalogMutex.Unlock()
+	return r.save(sc.meta)
+}
+
+func (r *ShardRouter) add(meta ShardedCollection) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.collections[shardedName(meta.Pool, meta.Schema, meta.Collection)] = &shardedCollection{
+		meta:    meta.clone(),
+		compare: meta.Options.Comparator.compareFunc(),
+	}
+}
+
+func (r *ShardRouter) lookup(poolName, schemaName, collectionName string) *shardedCollection {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.collections[shardedName(poolName, schemaName, collectionName)]
+}
+
+// Routes reports whether the router rather than the database answers
+// cmd: whether it creates a sharded collection, manages shards, or is
+// for a sharded collection.
+func (r *ShardRouter) Routes(cmd Command) bool {
+	switch cmd.Operation {
+	case "shard_status", "split_shard", "move_shard":
+		return true
+	case "create_collection":
+		if cmd.Sharding != nil {
+			return true
+		}
+	}
+	return r.lookup(cmd.Pool, cmd.Schema, cmd.Collection) != nil
+}
+
+// shardKeyOperations are the operations on a single key, which go to the
+// shard holding it, each marked with whether it writes.
+var shardKeyOperations = map[string]bool{
+	"set":               true,
+	"update":            true,
+	"expire":            true,
+	"persist":           true,
+	"set_if_absent":     true,
+	"update_if_equals":  true,
+	"update_if_version": true,
+	"delete_if_equals":  true,
+	"incr":              true,
+	"decr":              true,
+	"incr_by":           true,
+	"incr_by_float":     true,
+	"patch":             true,
+	"delete":            true,
+	"get":               false,
+	"ttl":               false,
+	"get_version":       false,
+	"get_history":       false,
+	"get_as_of":         false,
+	"get_with_version":  false,
+	"get_field":         false,
+}
+
+// Execute answers a command Routes accepted. Commands on one key go to
+// the shard holding it, batches are split among the shards, and ranges
+// and counts gather what every shard they span returns.
+func (r *ShardRouter) Execute(cmd Command) (interface{}, error) {
+	switch cmd.Operation {
+	case "create_collection":
+		return nil, r.create(cmd)
+	case "shard_status":
+		return r.status(cmd)
+	case "split_shard":
+		return r.split(cmd)
+	case "move_shard":
+		return r.move(cmd)
+	}
+
+	sc := r.lookup(cmd.Pool, cmd.Schema, cmd.Collection)
+	if sc == nil {
+		return nil, ErrCollectionNotFound
+	}
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+
+	switch cmd.Operation {
+	case "mget":
+		return r.multiGet(sc, cmd)
+	case "mset", "mdelete":
+		return r.multiWrite(sc, cmd)
+	case "get_range":
+		return r.getRange(sc, cmd)
+	case "count":
+		return r.count(sc, cmd)
+	}
+
+	writes, ok := shardKeyOperations[cmd.Operation]
+	if !ok {
+		return nil, Errorf(CodeUnsupported, "%s is not supported on sharded collection %s", cmd.Operation, cmd.Collection)
+	}
+	shard := sc.owner(cmd.Key)
+	if writes {
+		sc.touch(shard, cmd.Key)
+	}
+	var response json.RawMessage
+	err := r.forward(shard, cmd, &response)
+	return response, err
+}
+
+// forward sends cmd to shard's collection.
+func (r *ShardRouter) forward(shard Shard, cmd Command, response interface{}) error {
+	cmd.Collection = shard.Collection
+	return r.client.call(shard.Server, cmd, response)
+}
+
+// each calls fn for every shard at once and returns the first error.
+func (r *ShardRouter) each(shards []Shard, fn func(i int, shard Shard) error) error {
+	errs := make([]error, len(shards))
+	var wg sync.WaitGroup
+	for i, shard := range shards {
+		wg.Add(1)
+		go func(i int, shard Shard) {
+			defer wg.Done()
+			errs[i] = fn(i, shard)
+		}(i, shard)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sc *shardedCollection) holds(shard Shard, key string) bool {
+	return shard.holds(sc.meta.Scheme, sc.compare, key)
+}
+
+// owner returns the shard holding key.
+func (sc *shardedCollection) owner(key string) Shard {
+	for _, shard := range sc.meta.Shards {
+		if sc.holds(shard, key) {
+			return shard
+		}
+	}
+	return sc.meta.Shards[len(sc.meta.Shards)-1]
+}
+
+// spanning returns the shards that may hold keys between leftBound and
+// rightBound, which leave their side open when empty.
+func (sc *shardedCollection) spanning(leftBound, rightBound string) []Shard {
+	if sc.meta.Scheme == ShardByHash {
+		return sc.meta.Shards
+	}
+	var shards []Shard
+	for _, shard := range sc.meta.Shards {
+		if shard.End != "" && leftBound != "" && sc.compare(leftBound, shard.End) >= 0 {
+			continue
+		}
+		if shard.Start != "" && rightBound != "" && sc.compare(rightBound, shard.Start) < 0 {
+			continue
+		}
+		shards = append(shards, shard)
+	}
+	return shards
+}
+
+func (sc *shardedCollection) index(id string) int {
+	for i, shard := range sc.meta.Shards {
+		if shard.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// touch notes that key of shard is being written, in case it is being
+// copied out of the shard. The caller holds sc.mutex.
+func (sc *shardedCollection) touch(shard Shard, key string) {
+	if m := sc.migration; m != nil && m.shard == shard.ID {
+		m.touch(key)
+	}
+}
+
+// shardBatch is the part of a batch that goes to one shard, with where
+// its items are in the batch.
+type shardBatch struct {
+	shard     Shard
+	positions []int
+}
+
+func (sc *shardedCollection) split(keys []string) []*shardBatch {
+	batches := make(map[string]*shardBatch)
+	var order []*shardBatch
+	for i, key := range keys {
+		shard := sc.owner(key)
+		batch, ok := batches[shard.ID]
+		if !ok {
+			batch = &shardBatch{shard: shard}
+			batches[shard.ID] = batch
+			order = append(order, batch)
+		}
+		batch.positions = append(batch.positions, i)
+	}
+	return order
+}
+
+type shardResults struct {
+	Results []interfaces.BatchResult `json:"results"`
+}
+
+func (r *ShardRouter) multiGet(sc *shardedCollection, cmd Command) (interface{}, error) {
+	batches := sc.split(cmd.Keys)
+	results := make([]interfaces.BatchResult, len(cmd.Keys))
+	err := r.eachBatch(batches, func(batch *shardBatch) error {
+		part := cmd
+		part.Keys = make([]string, len(batch.positions))
+		for i, position := range batch.positions {
+			part.Keys[i] = cmd.Keys[position]
+		}
+		var response shardResults
+		if err := r.forward(batch.shard, part, &response); err != nil {
+			return err
+		}
+		for i, position := range batch.positions {
+			if i < len(response.Results) {
+				results[position] = response.Results[i]
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return shardResults{Results: results}, nil
+}
+
+// multiWrite splits a batch among the shards. An atomic batch must keep
+// to one shard. Otherwise an item whose shard could not be written fails
+// on its own, like an item the shard refused.
+func (r *ShardRouter) multiWrite(sc *shardedCollection, cmd Command) (interface{}, error) {
+	keys := cmd.Keys
+	if cmd.Operation == "mset" {
+		keys = make([]string, len(cmd.Entries))
+		for i, entry := range cmd.Entries {
+			keys[i] = entry.Key
+		}
+	}
+	batches := sc.split(keys)
+	for _, batch := range batches {
+		for _, position := range batch.positions {
+			sc.touch(batch.shard, keys[position])
+		}
+	}
+
+	if cmd.Atomic {
+		if len(batches) > 1 {
+			return nil, ErrCrossShard
+		}
+		var response json.RawMessage
+		if len(batches) == 0 {
+			return shardResults{Results: []interfaces.BatchResult{}}, nil
+		}
+		err := r.forward(batches[0].shard, cmd, &response)
+		return response, err
+	}
+
+	results := make([]interfaces.BatchResult, len(keys))
+	r.eachBatch(batches, func(batch *shardBatch) error {
+		part := cmd
+		if cmd.Operation == "mset" {
+			part.Entries = make([]interfaces.Entry, len(batch.positions))
+			for i, position := range batch.positions {
+				part.Entries[i] = cmd.Entries[position]
+			}
+		} else {
+			part.Keys = make([]string, len(batch.positions))
+			for i, position := range batch.positions {
+				part.Keys[i] = cmd.Keys[position]
+			}
+		}
+		var response shardResults
+		err := r.forward(batch.shard, part, &response)
+		for i, position := range batch.positions {
+			if err != nil {
+				results[position] = batchResult(keys[position], err)
+			} else if i < len(response.Results) {
+				results[position] = response.Results[i]
+			}
+		}
+		return nil
+	})
+	return shardResults{Results: results}, nil
+}
+
+func (r *ShardRouter) eachBatch(batches []*shardBatch, fn func(batch *shardBatch) error) error {
+	shards := make([]Shard, len(batches))
+	for i, batch := range batches {
+		shards[i] = batch.shard
+	}
+	return r.each(shards, func(i int, _ Shard) error {
+		return fn(batches[i])
+	})
+}
+
+type shardPage struct {
+	Entries []interfaces.Entry `json:"entries"`
+	Token   string             `json:"token"`
+}
+
+// getRange gathers a range from the shards it spans. A page merges the
+// shards' pages in the collection's order, and its token, which every
+// shard reads the same way, resumes each of them after the page.
+func (r *ShardRouter) getRange(sc *shardedCollection, cmd Command) (interface{}, error) {
+	shards := sc.spanning(cmd.LeftBound, cmd.RightBound)
+
+	if !cmd.LeftExclusive && !cmd.RightExclusive && !cmd.Reverse && cmd.Limit == 0 && cmd.Token == "" && cmd.Where == nil {
+		ranges := make([]map[string]string, len(shards))
+		err := r.each(shards, func(i int, shard Shard) error {
+			return r.forward(shard, cmd, &ranges[i])
+		})
+		if err != nil {
+			return nil, err
+		}
+		result := make(map[string]string)
+		for i, entries := range ranges {
+			for key, value := range entries {
+				if sc.holds(shards[i], key) {
+					result[key] = value
+				}
+			}
+		}
+		return result, nil
+	}
+
+	if cmd.Limit < 0 {
+		return nil, Errorf(CodeInvalidArgument, "limit %d cannot be negative", cmd.Limit)
+	}
+	pages := make([]shardPage, len(shards))
+	err := r.each(shards, func(i int, shard Shard) error {
+		return r.forward(shard, cmd, &pages[i])
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sc.merge(shards, pages, cmd.Reverse, cmd.Limit), nil
+}
+
+// merge combines the pages shards returned for one query. A shard with
+// more entries than its page held may have some before any other shard's
+// later entries, so the merged page stops at the nearest key at which
+// such a page stops.
+func (sc *shardedCollection) merge(shards []Shard, pages []shardPage, reverse bool, limit int) shardPage {
+	before := func(a, b string) bool {
+		if reverse {
+			return sc.compare(a, b) > 0
+		}
+		return sc.compare(a, b) < 0
+	}
+
+	var frontier string
+	bounded := false
+	entries := make([]interfaces.Entry, 0)
+	for i, page := range pages {
+		if page.Token != "" && len(page.Entries) > 0 {
+			last := page.Entries[len(page.Entries)-1].Key
+			if !bounded || before(last, frontier) {
+				frontier, bounded = last, true
+			}
+		}
+		for _, entry := range page.Entries {
+			if sc.holds(shards[i], entry.Key) {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return before(entries[i].Key, entries[j].Key) })
+
+	page := shardPage{Entries: entries}
+	if bounded {
+		page.Entries = entries[:sort.Search(len(entries), func(i int) bool { return before(frontier, entries[i].Key) })]
+		page.Token = encodeRangeToken(reverse, frontier)
+	}
+	if limit > 0 && len(page.Entries) > limit {
+		page.Entries = page.Entries[:limit]
+		page.Token = encodeRangeToken(reverse, page.Entries[limit-1].Key)
+	}
+	return page
+}
+
+type shardCount struct {
+	Count int `json:"count"`
+}
+
+// count adds up what the shards a range spans count. A draining shard
+// still counts keys it gave up, so its keys are counted one by one.
+func (r *ShardRouter) count(sc *shardedCollection, cmd Command) (interface{}, error) {
+	shards := sc.spanning(cmd.LeftBound, cmd.RightBound)
+	counts := make([]int, len(shards))
+	err := r.each(shards, func(i int, shard Shard) error {
+		if shard.Draining {
+			var err error
+			counts[i], err = r.countHeld(sc, shard, cmd)
+			return err
+		}
+		var response shardCount
+		err := r.forward(shard, cmd, &response)
+		counts[i] = response.Count
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	return shardCount{Count: total}, nil
+}
+
+func (r *ShardRouter) countHeld(sc *shardedCollection, shard Shard, cmd Command) (int, error) {
+	query := cmd
+	query.Operation = "get_range"
+	query.Limit = shardPageSize
+	n := 0
+	for {
+		var page shardPage
+		if err := r.forward(shard, query, &page); err != nil {
+			return 0, err
+		}
+		for _, entry := range page.Entries {
+			if sc.holds(shard, entry.Key) {
+				n++
+			}
+		}
+		if page.Token == "" {
+			return n, nil
+		}
+		query.Token = page.Token
+	}
+}
+
+// create creates a sharded collection and the collections holding its
+// shards.
+func (r *ShardRouter) create(cmd Command) error {
+	if !r.db.AuthManager.HasPermission(cmd.Username, PermCreateCollection) {
+		return ErrPermissionDenied
+	}
+	if cmd.Sharding == nil {
+		return ErrCollectionExists
+	}
+	for _, name := range [][2]string{{"pool", cmd.Pool}, {"schema", cmd.Schema}, {"collection", cmd.Collection}} {
+		if err := r.db.Config.validateName(name[0], name[1]); err != nil {
+			return err
+		}
+	}
+
+	meta := ShardedCollection{
+		Pool:       cmd.Pool,
+		Schema:     cmd.Schema,
+		Collection: cmd.Collection,
+		Scheme:     cmd.Sharding.Scheme,
+		TreeType:   cmd.TreeType,
+		Options:    cmd.Options,
+	}
+	if meta.TreeType == "" {
+		meta.TreeType = r.db.Config.DefaultTreeType
+	}
+	shards, err := cmd.Sharding.shards(meta.Options)
+	if err != nil {
+		return err
+	}
+	for i := range shards {
+		shards[i].ID, shards[i].Collection = meta.newShard()
+		if err := r.db.Config.validateName("shard collection", shards[i].Collection); err != nil {
+			return err
+		}
+	}
+	meta.Shards = shards
+
+	r.catalogMutex.Lock()
+	defer r.catalogMutex.Unlock()
+
+	if r.lookup(cmd.Pool, cmd.Schema, cmd.Collection) != nil {
+		return ErrCollectionExists
+	}
+	if _, err := r.db.getCollection(cmd.Pool, cmd.Schema, cmd.Collection); err == nil {
+		return ErrCollectionExists
+	}
+	for _, shard := range shards {
+		if err := r.createShard(cmd, meta, shard); err != nil {
+			return err
+		}
+	}
+	if err := r.save(meta); err != nil {
+		return err
+	}
+	r.add(meta)
+	return nil
+}
+
+// createShard creates the collection holding shard on its server, and
+// the pool and schema it is in if they are missing.
+func (r *ShardRouter) createShard(cmd Command, meta ShardedCollection, shard Shard) error {
+	steps := []Command{
+		{Operation: "create_pool", Pool: meta.Pool},
+		{Operation: "create_schema", Pool: meta.Pool, Schema: meta.Schema},
+		{Operation: "create_collection", Pool: meta.Pool, Schema: meta.Schema, Collection: shard.Collection, TreeType: meta.TreeType, Options: meta.Options},
+	}
+	for i, step := range steps {
+		step.Username, step.Password = cmd.Username, cmd.Password
+		err := r.client.call(shard.Server, step, nil)
+		if err != nil && (i == len(steps)-1 || CodeOf(err) != CodeAlreadyExists) {
+			return fmt.Errorf("shard %s on %s: %w", shard.ID, shard.Server, err)
+		}
+	}
+	return nil
+}
+
+// status describes a sharded collection.
+func (r *ShardRouter) status(cmd Command) (interface{}, error) {
+	if !r.db.AuthManager.HasPermission(cmd.Username, PermRead) {
+		return nil, ErrPermissionDenied
+	}
+	sc := r.lookup(cmd.Pool, cmd.Schema, cmd.Collection)
+	if sc == nil {
+		return nil, ErrCollectionNotFound
+	}
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+	return sc.meta.clone(), nil
+}
+
+func (r *ShardRouter) close() {
+	r.client.close()
+}
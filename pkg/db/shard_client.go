@@ -0,0 +1,170 @@
+package db
+
+import (
+	"DB_II/pkg/interfaces"
+	"bufio"
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	shardDialTimeout = 5 * time.Second
+	shardCallTimeout = time.Minute
+
+	// A router follows a shard server that is a cluster node to its
+	// leader at most shardMaxRedirects times a command.
+	shardMaxRedirects = 3
+)
+
+// shardClient carries the commands a router forwards to the servers that
+// hold its shards, over connections it keeps open between commands.
+type shardClient struct {
+	mutex  sync.Mutex
+	idle   map[string][]*shardConn
+	closed bool
+}
+
+type shardConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newShardClient() *shardClient {
+	return &shardClient{idle: make(map[string][]*shardConn)}
+}
+
+// shardReply is a server's answer to a command, as its clients read it.
+type shardReply struct {
+	Status   string                   `json:"status"`
+	Code     ErrorCode                `json:"code"`
+	Error    string                   `json:"error"`
+	Leader   string                   `json:"leader"`
+	Current  *ConditionError          `json:"current"`
+	Results  []interfaces.BatchResult `json:"results"`
+	Response json.RawMessage          `json:"response"`
+}
+
+// err rebuilds the error a server reported, keeping the details a
+// failed conditional write or an aborted batch carry.
+func (r *shardReply) err() error {
+	if r.Current != nil {
+		return r.Current
+	}
+	if r.Results != nil {
+		for i, result := range r.Results {
+			if result.Error != "" && result.Error != ErrBatchAborted.Message {
+				return &BatchError{Results: r.Results, failed: i}
+			}
+		}
+	}
+	code := r.Code
+	if code == "" {
+		code = CodeInternal
+	}
+	return NewError(code, r.Error)
+}
+
+// call sends cmd to the server at address and decodes the response into
+// response unless it is nil.
+func (c *shardClient) call(address string, cmd Command, response interface{}) error {
+	for redirects := 0; ; redirects++ {
+		reply, err := c.roundTrip(address, cmd)
+		if err != nil {
+			return Errorf(CodeUnavailable, "shard server %s: %v", address, err)
+		}
+		if reply.Status == "ok" {
+			if response == nil || len(reply.Response) == 0 {
+				return nil
+			}
+			return json.Unmarshal(reply.Response, response)
+		}
+		if reply.Code == CodeNotLeader && reply.Leader != "" && redirects < shardMaxRedirects {
+			address = reply.Leader
+			continue
+		}
+		return reply.err()
+	}
+}
+
+// roundTrip sends cmd and reads the reply. A connection that sat idle
+// may have been closed by the server since, so a command that fails on
+// one is sent again on a new connection.
+func (c *shardClient) roundTrip(address string, cmd Command) (*shardReply, error) {
+	sc, reused, err := c.get(address)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.exchange(sc, cmd)
+	if err != nil && reused {
+		if sc, _, err = c.dial(address); err != nil {
+			return nil, err
+		}
+		reply, err = c.exchange(sc, cmd)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.put(address, sc)
+	return reply, nil
+}
+
+func (c *shardClient) exchange(sc *shardConn, cmd Command) (*shardReply, error) {
+	sc.conn.SetDeadline(time.Now().Add(shardCallTimeout))
+	var reply shardReply
+	if err := json.NewEncoder(sc.conn).Encode(cmd); err != nil {
+		sc.conn.Close()
+		return nil, err
+	}
+	if err := readReplicationLine(sc.reader, &reply); err != nil {
+		sc.conn.Close()
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// get returns an idle connection to address, reporting that it is one,
+// or dials a new one.
+func (c *shardClient) get(address string) (*shardConn, bool, error) {
+	c.mutex.Lock()
+	if conns := c.idle[address]; len(conns) > 0 {
+		sc := conns[len(conns)-1]
+		c.idle[address] = conns[:len(conns)-1]
+		c.mutex.Unlock()
+		return sc, true, nil
+	}
+	c.mutex.Unlock()
+	return c.dial(address)
+}
+
+func (c *shardClient) dial(address string) (*shardConn, bool, error) {
+	conn, err := net.DialTimeout("tcp", address, shardDialTimeout)
+	if err != nil {
+		return nil, false, err
+	}
+	return &shardConn{conn: conn, reader: bufio.NewReader(conn)}, false, nil
+}
+
+func (c *shardClient) put(address string, sc *shardConn) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		sc.conn.Close()
+		return
+	}
+	c.idle[address] = append(c.idle[address], sc)
+}
+
+// close closes the idle connections, and the others as they come back.
+func (c *shardClient) close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed = true
+	for address, conns := range c.idle {
+		for _, sc := range conns {
+			sc.conn.Close()
+		}
+		delete(c.idle, address)
+	}
+}
@@ -0,0 +1,520 @@
+package db
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// Shards are copied shardPageSize entries at a time.
+	shardPageSize = 256
+
+	// Keys written while a shard is copied are copied again, for at most
+	// shardCatchUpRounds rounds before the shard's writes are held for
+	// the last.
+	shardCatchUpRounds = 3
+)
+
+// ShardEntry is an entry as it moves between shards: its key, its value
+// and the deadline at which it expires, in Unix nanoseconds, or 0.
+type ShardEntry struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Deadline int64  `json:"deadline,omitempty"`
+}
+
+func (db *Database) treeCollection(username string, permission Permission, poolName, schemaName, collectionName string) (*TreeCollection, error) {
+	collection, err := db.GetCollection(username, permission, poolName, schemaName, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	tc, ok := collection.(*TreeCollection)
+	if !ok {
+		return nil, Errorf(CodeUnsupported, "collection %s cannot hold a shard", collectionName)
+	}
+	return tc, nil
+}
+
+// ReadEntries returns live entries of a collection with their deadlines,
+// for a router copying them to another shard: those under keys if any
+// are given, and otherwise up to limit after the key after, or from the
+// first key if after is empty. It reports whether entries are left after
+// the last it returns.
+func (db *Database) ReadEntries(username, poolName, schemaName, collectionName string, keys []string, after string, limit int) ([]ShardEntry, bool, error) {
+	tc, err := db.treeCollection(username, PermRead, poolName, schemaName, collectionName)
+	if err != nil {
+		return nil, false, err
+	}
+	return tc.readEntries(keys, after, limit)
+}
+
+// WriteEntries writes entries a router copies to a collection, each to
+// expire at its deadline unless that is 0. Those already expired are
+// skipped.
+func (db *Database) WriteEntries(username, poolName, schemaName, collectionName string, entries []ShardEntry) error {
+	tc, err := db.treeCollection(username, PermWrite, poolName, schemaName, collectionName)
+	if err != nil {
+		return err
+	}
+	loaded := make([]importEntry, len(entries))
+	for i, entry := range entries {
+		loaded[i] = importEntry{key: entry.Key, value: entry.Value, deadline: entry.Deadline}
+	}
+	return tc.load(loaded)
+}
+
+func (tc *TreeCollection) readEntries(keys []string, after string, limit int) ([]ShardEntry, bool, error) {
+	tc.mutex.RLock()
+	defer tc.mutex.RUnlock()
+
+	now := time.Now()
+	entries := make([]ShardEntry, 0)
+	if len(keys) > 0 {
+		for _, key := range keys {
+			storedKey, value, found, err := tc.stored(key)
+			if err != nil {
+				return nil, false, err
+			}
+			if !found || tc.expiry.expired(storedKey, now) {
+				continue
+			}
+			deadline, _ := tc.expiry.deadline(storedKey)
+			entries = append(entries, ShardEntry{Key: storedKey, Value: value, Deadline: deadline})
+		}
+		return entries, false, nil
+	}
+
+	cursor, err := tc.tree.cursor()
+	if err != nil {
+		return nil, false, err
+	}
+	more := false
+	err = cursor.seek(after)
+	if err == nil && after != "" && cursor.valid() && tc.compare(cursor.key(), after) == 0 {
+		err = cursor.next()
+	}
+	for ; err == nil && cursor.valid(); err = cursor.next() {
+		if tc.expiry.expired(cursor.key(), now) {
+			continue
+		}
+		if limit > 0 && len(entries) == limit {
+			more = true
+			break
+		}
+		deadline, _ := tc.expiry.deadline(cursor.key())
+		entries = append(entries, ShardEntry{Key: cursor.key(), Value: cursor.value(), Deadline: deadline})
+	}
+	if closer, ok := cursor.(io.Closer); ok {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return entries, more, nil
+}
+
+// shardMigration tracks the keys written to a shard while the keys moves
+// selects are copied out of it, so that they can be copied again.
+type shardMigration struct {
+	shard string
+	moves func(key string) bool
+	mutex sync.Mutex
+	dirty map[string]struct{}
+}
+
+func (m *shardMigration) touch(key string) {
+	if !m.moves(key) {
+		return
+	}
+	m.mutex.Lock()
+	m.dirty[key] = struct{}{}
+	m.mutex.Unlock()
+}
+
+// take returns the keys written since it was last called.
+func (m *shardMigration) take() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	keys := make([]string, 0, len(m.dirty))
+	for key := range m.dirty {
+		keys = append(keys, key)
+	}
+	m.dirty = make(map[string]struct{})
+	return keys
+}
+
+type shardEntries struct {
+	Entries []ShardEntry `json:"entries"`
+	More    bool         `json:"more"`
+}
+
+// readEntries reads a page of the entries of shard's collection, or its
+// entries under keys.
+func (r *ShardRouter) readEntries(cmd Command, meta ShardedCollection, shard Shard, keys []string, after string) (shardEntries, error) {
+	var page shardEntries
+	err := r.client.call(shard.Server, Command{
+		Username:   cmd.Username,
+		Password:   cmd.Password,
+		Operation:  "read_entries",
+		Pool:       meta.Pool,
+		Schema:     meta.Schema,
+		Collection: shard.Collection,
+		Keys:       keys,
+		LeftBound:  after,
+		Limit:      shardPageSize,
+	}, &page)
+	return page, err
+}
+
+// writeEntries writes entries to shard's collection, in commands that
+// stay well within the size a server accepts.
+func (r *ShardRouter) writeEntries(cmd Command, meta ShardedCollection, shard Shard, entries []ShardEntry) error {
+	limit := r.db.Config.MaxCommandSize / 2
+	for len(entries) > 0 {
+		n, size := 0, 0
+		for n < len(entries) && (n == 0 || size+len(entries[n].Key)+len(entries[n].Value) < limit) {
+			size += len(entries[n].Key) + len(entries[n].Value)
+			n++
+		}
+		err := r.client.call(shard.Server, Command{
+			Username:   cmd.Username,
+			Password:   cmd.Password,
+			Operation:  "write_entries",
+			Pool:       meta.Pool,
+			Schema:     meta.Schema,
+			Collection: shard.Collection,
+			Records:    entries[:n],
+		}, nil)
+		if err != nil {
+			return err
+		}
+		entries = entries[n:]
+	}
+	return nil
+}
+
+// deleteKeys deletes keys from shard's collection, those already gone
+// included.
+func (r *ShardRouter) deleteKeys(cmd Command, meta ShardedCollection, shard Shard, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return r.client.call(shard.Server, Command{
+		Username:   cmd.Username,
+		Password:   cmd.Password,
+		Operation:  "mdelete",
+		Pool:       meta.Pool,
+		Schema:     meta.Schema,
+		Collection: shard.Collection,
+		Keys:       keys,
+	}, nil)
+}
+
+// resync copies keys from source to target again, deleting from target
+// those source no longer has.
+func (r *ShardRouter) resync(cmd Command, meta ShardedCollection, source, target Shard, keys []string) error {
+	compare := meta.Options.Comparator.compareFunc()
+	for len(keys) > 0 {
+		n := min(len(keys), shardPageSize)
+		page, err := r.readEntries(cmd, meta, source, keys[:n], "")
+		if err != nil {
+			return err
+		}
+		var gone []string
+		for _, key := range keys[:n] {
+			found := false
+			for _, entry := range page.Entries {
+				if compare(entry.Key, key) == 0 {
+					found = true
+					break
+				}
+			}
+			if !found {
+				gone = append(gone, key)
+			}
+		}
+		if err := r.writeEntries(cmd, meta, target, page.Entries); err != nil {
+			return err
+		}
+		if err := r.deleteKeys(cmd, meta, target, gone); err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+// drain deletes from shard's collection the keys keep rejects.
+func (r *ShardRouter) drain(cmd Command, meta ShardedCollection, shard Shard, keep func(key string) bool) error {
+	after := ""
+	for {
+		page, err := r.readEntries(cmd, meta, shard, nil, after)
+		if err != nil {
+			return err
+		}
+		var doomed []string
+		for _, entry := range page.Entries {
+			if !keep(entry.Key) {
+				doomed = append(doomed, entry.Key)
+			}
+		}
+		if err := r.deleteKeys(cmd, meta, shard, doomed); err != nil {
+			return err
+		}
+		if !page.More {
+			return nil
+		}
+		after = page.Entries[len(page.Entries)-1].Key
+	}
+}
+
+// migrate copies the keys moves selects from source to the new shard
+// target while source goes on taking writes, then holds the collection's
+// writes while it copies the keys written meanwhile and cutover hands the
+// keys to target. The caller has marked the collection busy.
+func (r *ShardRouter) migrate(cmd Command, sc *shardedCollection, source, target Shard, moves func(key string) bool, cutover func()) error {
+	sc.mutex.Lock()
+	m := &shardMigration{shard: source.ID, moves: moves, dirty: make(map[string]struct{})}
+	sc.migration = m
+	meta := sc.meta.clone()
+	sc.mutex.Unlock()
+
+	err := r.copyShard(cmd, meta, source, target, m)
+	if err == nil {
+		sc.mutex.Lock()
+		if err = r.resync(cmd, meta, source, target, m.take()); err == nil {
+			previous := append([]Shard(nil), sc.meta.Shards...)
+			cutover()
+			if err = r.commit(sc); err != nil {
+				sc.meta.Shards = previous
+			}
+		}
+		sc.migration = nil
+		sc.mutex.Unlock()
+	} else {
+		sc.mutex.Lock()
+		sc.migration = nil
+		sc.mutex.Unlock()
+	}
+
+	if err != nil {
+		// what was copied is of no use; the collection stays, emptied
+		if drainErr := r.drain(cmd, meta, target, func(string) bool { return false }); drainErr != nil {
+			log.Printf("shard %s: clearing %s on %s: %v", source.ID, target.Collection, target.Server, drainErr)
+		}
+	}
+	return err
+}
+
+func (r *ShardRouter) copyShard(cmd Command, meta ShardedCollection, source, target Shard, m *shardMigration) error {
+	if err := r.createShard(cmd, meta, target); err != nil {
+		return err
+	}
+
+	after := ""
+	for {
+		page, err := r.readEntries(cmd, meta, source, nil, after)
+		if err != nil {
+			return err
+		}
+		moved := make([]ShardEntry, 0, len(page.Entries))
+		for _, entry := range page.Entries {
+			if m.moves(entry.Key) {
+				moved = append(moved, entry)
+			}
+		}
+		if err := r.writeEntries(cmd, meta, target, moved); err != nil {
+			return err
+		}
+		if !page.More {
+			break
+		}
+		after = page.Entries[len(page.Entries)-1].Key
+	}
+
+	for round := 0; round < shardCatchUpRounds; round++ {
+		keys := m.take()
+		if len(keys) == 0 {
+			break
+		}
+		if err := r.resync(cmd, meta, source, target, keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reserve marks sc busy and returns the shard with the given ID, after
+// draining any shard that a split left draining.
+func (r *ShardRouter) reserve(cmd Command, sc *shardedCollection, id string) (Shard, error) {
+	if !r.db.AuthManager.HasPermission(cmd.Username, PermManageCluster) {
+		return Shard{}, ErrPermissionDenied
+	}
+
+	sc.mutex.Lock()
+	if sc.busy {
+		sc.mutex.Unlock()
+		return Shard{}, ErrShardBusy
+	}
+	if sc.index(id) < 0 {
+		sc.mutex.Unlock()
+		return Shard{}, ErrShardNotFound
+	}
+	sc.busy = true
+	var draining []string
+	for _, shard := range sc.meta.Shards {
+		if shard.Draining {
+			draining = append(draining, shard.ID)
+		}
+	}
+	sc.mutex.Unlock()
+
+	for _, drainingID := range draining {
+		if err := r.settle(cmd, sc, drainingID); err != nil {
+			r.release(sc)
+			return Shard{}, err
+		}
+	}
+
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+	return sc.meta.Shards[sc.index(id)], nil
+}
+
+func (r *ShardRouter) release(sc *shardedCollection) {
+	sc.mutex.Lock()
+	sc.busy = false
+	sc.mutex.Unlock()
+}
+
+// settle deletes from a draining shard the keys it gave up, and marks it
+// drained.
+func (r *ShardRouter) settle(cmd Command, sc *shardedCollection, id string) error {
+	sc.mutex.RLock()
+	meta := sc.meta.clone()
+	shard := meta.Shards[sc.index(id)]
+	sc.mutex.RUnlock()
+
+	err := r.drain(cmd, meta, shard, func(key string) bool { return sc.holds(shard, key) })
+	if err != nil {
+		return fmt.Errorf("draining shard %s: %w", id, err)
+	}
+
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.meta.Shards[sc.index(id)].Draining = false
+	return r.commit(sc)
+}
+
+// nextShard names a new shard of sc and saves the name as used.
+func (r *ShardRouter) nextShard(sc *shardedCollection) (string, string, error) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	id, collection := sc.meta.newShard()
+	if err := r.db.Config.validateName("shard collection", collection); err != nil {
+		return "", "", err
+	}
+	return id, collection, r.commit(sc)
+}
+
+// split splits a shard in two while it goes on serving, moving the upper
+// part to a new shard on the given server, or on the same one. A range
+// shard is split at the given key and a hash shard halves its slots.
+func (r *ShardRouter) split(cmd Command) (interface{}, error) {
+	sc := r.lookup(cmd.Pool, cmd.Schema, cmd.Collection)
+	if sc == nil {
+		return nil, ErrCollectionNotFound
+	}
+	source, err := r.reserve(cmd, sc, cmd.Shard)
+	if err != nil {
+		return nil, err
+	}
+	defer r.release(sc)
+
+	part := source
+	var narrow func(shard *Shard)
+	if sc.meta.Scheme == ShardByHash {
+		if source.EndSlot-source.FirstSlot < 2 {
+			return nil, Errorf(CodeInvalidArgument, "shard %s has too few slots to split", source.ID)
+		}
+		middle := (source.FirstSlot + source.EndSlot) / 2
+		part.FirstSlot = middle
+		narrow = func(shard *Shard) { shard.EndSlot = middle }
+	} else {
+		if cmd.Key == "" || !sc.holds(source, cmd.Key) || (source.Start != "" && sc.compare(cmd.Key, source.Start) == 0) {
+			return nil, Errorf(CodeInvalidArgument, "split key %q is not inside shard %s", cmd.Key, source.ID)
+		}
+		part.Start = cmd.Key
+		narrow = func(shard *Shard) { shard.End = cmd.Key }
+	}
+	if cmd.Server != "" {
+		part.Server = cmd.Server
+	}
+	if part.ID, part.Collection, err = r.nextShard(sc); err != nil {
+		return nil, err
+	}
+
+	moves := func(key string) bool { return sc.holds(part, key) }
+	err = r.migrate(cmd, sc, source, part, moves, func() {
+		i := sc.index(source.ID)
+		narrow(&sc.meta.Shards[i])
+		sc.meta.Shards[i].Draining = true
+		shards := append([]Shard(nil), sc.meta.Shards[:i+1]...)
+		shards = append(shards, part)
+		sc.meta.Shards = append(shards, sc.meta.Shards[i+1:]...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := r.settle(cmd, sc, source.ID); err != nil {
+		return nil, fmt.Errorf("shard %s was split, but %w", source.ID, err)
+	}
+	return r.status(cmd)
+}
+
+// move moves a shard to another server while it goes on serving. The
+// collection that held it is left empty.
+func (r *ShardRouter) move(cmd Command) (interface{}, error) {
+	sc := r.lookup(cmd.Pool, cmd.Schema, cmd.Collection)
+	if sc == nil {
+		return nil, ErrCollectionNotFound
+	}
+	if cmd.Server == "" {
+		return nil, Errorf(CodeInvalidArgument, "moving a shard needs the server to move it to")
+	}
+	source, err := r.reserve(cmd, sc, cmd.Shard)
+	if err != nil {
+		return nil, err
+	}
+	defer r.release(sc)
+
+	if cmd.Server == source.Server {
+		return nil, Errorf(CodeInvalidArgument, "shard %s is already on %s", source.ID, cmd.Server)
+	}
+	target := source
+	target.Server = cmd.Server
+	if _, target.Collection, err = r.nextShard(sc); err != nil {
+		return nil, err
+	}
+
+	moves := func(key string) bool { return sc.holds(source, key) }
+	err = r.migrate(cmd, sc, source, target, moves, func() {
+		sc.meta.Shards[sc.index(source.ID)] = target
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sc.mutex.RLock()
+	meta := sc.meta.clone()
+	sc.mutex.RUnlock()
+	if err := r.drain(cmd, meta, source, func(string) bool { return false }); err != nil {
+		return nil, fmt.Errorf("shard %s was moved, but clearing %s on %s failed: %w", source.ID, source.Collection, source.Server, err)
+	}
+	return r.status(cmd)
+}
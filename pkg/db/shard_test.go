@@ -0,0 +1,462 @@
+package db
+
+import (
+	"DB_II/pkg/interfaces"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// testShardServer answers the commands a router forwards to the servers
+// holding its shards the way the server does, from a database of its own.
+type testShardServer struct {
+	db       *Database
+	listener net.Listener
+}
+
+func startTestShardServer(t *testing.T) *testShardServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testShardServer{db: newTestDatabase(t, t.TempDir()), listener: listener}
+	go s.serve()
+	t.Cleanup(func() {
+		listener.Close()
+		s.db.Close()
+	})
+	return s
+}
+
+func (s *testShardServer) address() string {
+	return s.listener.Addr().String()
+}
+
+func (s *testShardServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.serveConn(conn)
+	}
+}
+
+func (s *testShardServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(nil, s.db.Config.MaxCommandSize)
+	encoder := json.NewEncoder(conn)
+	for scanner.Scan() {
+		var cmd Command
+		if err := json.Unmarshal(scanner.Bytes(), &cmd); err != nil {
+			return
+		}
+		response, err := s.execute(cmd)
+		if err == nil {
+			encoder.Encode(map[string]interface{}{"status": "ok", "response": response})
+			continue
+		}
+		failure := map[string]interface{}{"status": "error", "code": CodeOf(err), "error": err.Error()}
+		var conditionErr *ConditionError
+		if errors.As(err, &conditionErr) {
+			failure["current"] = conditionErr
+		}
+		var batchErr *BatchError
+		if errors.As(err, &batchErr) {
+			failure["results"] = batchErr.Results
+		}
+		encoder.Encode(failure)
+	}
+}
+
+func (s *testShardServer) execute(cmd Command) (interface{}, error) {
+	switch cmd.Operation {
+	case "create_pool":
+		return nil, s.db.CreatePool(cmd.Username, cmd.Pool)
+	case "create_schema":
+		return nil, s.db.CreateSchema(cmd.Username, cmd.Pool, cmd.Schema)
+	case "create_collection":
+		return nil, s.db.CreateCollection(cmd.Username, cmd.Pool, cmd.Schema, cmd.Collection, cmd.TreeType, cmd.Options)
+	case "read_entries":
+		entries, more, err := s.db.ReadEntries(cmd.Username, cmd.Pool, cmd.Schema, cmd.Collection, cmd.Keys, cmd.LeftBound, cmd.Limit)
+		return shardEntries{Entries: entries, More: more}, err
+	case "write_entries":
+		return nil, s.db.WriteEntries(cmd.Username, cmd.Pool, cmd.Schema, cmd.Collection, cmd.Records)
+	}
+
+	collection, err := s.db.GetCollection(cmd.Username, PermWrite, cmd.Pool, cmd.Schema, cmd.Collection)
+	if err != nil {
+		return nil, err
+	}
+	tc := collection.(*TreeCollection)
+	switch cmd.Operation {
+	case "set":
+		return nil, tc.Set(cmd.Key, cmd.SecondaryKey, cmd.Value)
+	case "delete":
+		return nil, tc.Delete(cmd.Key)
+	case "get":
+		value, err := tc.Get(cmd.Key)
+		return map[string]string{"value": value}, err
+	case "mget":
+		results, err := tc.MultiGet(cmd.Keys)
+		return shardResults{Results: results}, err
+	case "mset":
+		results, err := tc.MultiSet(cmd.Entries, cmd.Atomic)
+		return shardResults{Results: results}, err
+	case "mdelete":
+		results, err := tc.MultiDelete(cmd.Keys, cmd.Atomic)
+		return shardResults{Results: results}, err
+	case "count":
+		count, err := tc.Count(cmd.LeftBound, cmd.RightBound)
+		return shardCount{Count: count}, err
+	case "get_range":
+		if !cmd.LeftExclusive && !cmd.RightExclusive && !cmd.Reverse && cmd.Limit == 0 && cmd.Token == "" && cmd.Where == nil {
+			return tc.GetRange(cmd.LeftBound, cmd.RightBound)
+		}
+		page, err := tc.GetRangePage(interfaces.RangeQuery{
+			LeftBound:      cmd.LeftBound,
+			RightBound:     cmd.RightBound,
+			LeftExclusive:  cmd.LeftExclusive,
+			RightExclusive: cmd.RightExclusive,
+			Reverse:        cmd.Reverse,
+			Limit:          cmd.Limit,
+			Token:          cmd.Token,
+		})
+		return shardPage{Entries: page.Entries, Token: page.Token}, err
+	}
+	return nil, Errorf(CodeInvalidArgument, "unknown operation: %s", cmd.Operation)
+}
+
+// held returns what the collection holding a shard holds, or nil if the
+// server has no such collection.
+func (s *testShardServer) held(collectionName string) map[string]string {
+	collection, err := s.db.getCollection("p", "s", collectionName)
+	if err != nil {
+		return nil
+	}
+	contents, err := collection.GetRange("", "\xff")
+	if err != nil {
+		return nil
+	}
+	return *contents
+}
+
+// route has the router execute cmd for p.s.c and decodes what it answers
+// into response unless that is nil.
+func route(r *ShardRouter, cmd Command, response interface{}) error {
+	cmd.Username, cmd.Pool, cmd.Schema, cmd.Collection = "admin", "p", "s", "c"
+	result, err := r.Execute(cmd)
+	if err != nil || response == nil {
+		return err
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, response)
+}
+
+// newShardedTestCollection shards p.s.c across the servers as spec asks
+// and writes keys k000 to k099 through the router.
+func newShardedTestCollection(t *testing.T, spec ShardSpec) *Database {
+	t.Helper()
+	router := newTestDatabase(t, t.TempDir())
+	t.Cleanup(func() { router.Close() })
+	if err := route(router.Shards(), Command{Operation: "create_collection", TreeType: TreeTypeAVL, Sharding: &spec}, nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("k%03d", i)
+		if err := route(router.Shards(), Command{Operation: "set", Key: key, SecondaryKey: key, Value: fmt.Sprint(i)}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return router
+}
+
+// testShardKeys returns what keys k<from> up to k<to> hold in
+// newShardedTestCollection.
+func testShardKeys(from, to int) map[string]string {
+	keys := make(map[string]string)
+	for i := from; i < to; i++ {
+		keys[fmt.Sprintf("k%03d", i)] = fmt.Sprint(i)
+	}
+	return keys
+}
+
+func TestShardSpecLayout(t *testing.T) {
+	spec := ShardSpec{Scheme: ShardByRange, Servers: []string{"a", "b", "c"}, SplitKeys: []string{"g", "p"}}
+	shards, err := spec.shards(CollectionOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Shard{{Server: "a", End: "g"}, {Server: "b", Start: "g", End: "p"}, {Server: "c", Start: "p"}}
+	if !reflect.DeepEqual(shards, want) {
+		t.Errorf("range shards are %+v, want %+v", shards, want)
+	}
+
+	spec = ShardSpec{Scheme: ShardByHash, Servers: []string{"a", "b", "c"}}
+	if shards, err = spec.shards(CollectionOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for i, shard := range shards {
+		if i > 0 && shard.FirstSlot != shards[i-1].EndSlot {
+			t.Errorf("hash shard %d starts at slot %d, not where the one before ends", i, shard.FirstSlot)
+		}
+	}
+	if shards[0].FirstSlot != 0 || shards[2].EndSlot != shardSlots {
+		t.Errorf("hash shards cover slots %d to %d", shards[0].FirstSlot, shards[2].EndSlot)
+	}
+
+	for _, test := range []struct {
+		spec    ShardSpec
+		options CollectionOptions
+	}{
+		{ShardSpec{Scheme: ShardByRange}, CollectionOptions{}},
+		{ShardSpec{Scheme: ShardByRange, Servers: []string{"a", ""}, SplitKeys: []string{"g"}}, CollectionOptions{}},
+		{ShardSpec{Scheme: ShardByRange, Servers: []string{"a", "b"}}, CollectionOptions{}},
+		{ShardSpec{Scheme: ShardByRange, Servers: []string{"a", "b", "c"}, SplitKeys: []string{"p", "g"}}, CollectionOptions{}},
+		{ShardSpec{Scheme: ShardByRange, Servers: []string{"a", "b", "c"}, SplitKeys: []string{"g", "p"}}, CollectionOptions{Comparator: ComparatorReverse}},
+		{ShardSpec{Scheme: ShardByHash, Servers: []string{"a", "b"}, SplitKeys: []string{"g"}}, CollectionOptions{}},
+		{ShardSpec{Scheme: "list", Servers: []string{"a"}}, CollectionOptions{}},
+	} {
+		if _, err := test.spec.shards(test.options); CodeOf(err) != CodeInvalidArgument {
+			t.Errorf("%+v with %+v returned %v", test.spec, test.options, err)
+		}
+	}
+	spec = ShardSpec{Scheme: ShardByHash, Servers: []string{"a", "b"}}
+	if _, err := spec.shards(CollectionOptions{Comparator: ComparatorCaseInsensitive}); CodeOf(err) != CodeUnsupported {
+		t.Errorf("hash sharding a case-insensitive collection returned %v", err)
+	}
+}
+
+func TestRangeShardedCollection(t *testing.T) {
+	a, b := startTestShardServer(t), startTestShardServer(t)
+	router := newShardedTestCollection(t, ShardSpec{Scheme: ShardByRange, Servers: []string{a.address(), b.address()}, SplitKeys: []string{"k050"}})
+	r := router.Shards()
+
+	// each shard is an ordinary collection on its server
+	if got := a.held("c__s1"); !reflect.DeepEqual(got, testShardKeys(0, 50)) {
+		t.Errorf("first shard holds %d keys, want k000 to k049", len(got))
+	}
+	if got := b.held("c__s2"); !reflect.DeepEqual(got, testShardKeys(50, 100)) {
+		t.Errorf("second shard holds %d keys, want k050 to k099", len(got))
+	}
+
+	var value map[string]string
+	if err := route(r, Command{Operation: "get", Key: "k070"}, &value); err != nil || value["value"] != "70" {
+		t.Errorf("k070 is %v, %v", value, err)
+	}
+	var results shardResults
+	if err := route(r, Command{Operation: "mget", Keys: []string{"k099", "k000", "x"}}, &results); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprintf("%s %s %s", results.Results[0].Value, results.Results[1].Value, results.Results[2].Code); got != "99 0 NOT_FOUND" {
+		t.Errorf("mget answered %+v", results.Results)
+	}
+
+	var contents map[string]string
+	if err := route(r, Command{Operation: "get_range", LeftBound: "k040", RightBound: "k059"}, &contents); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(contents, testShardKeys(40, 60)) {
+		t.Errorf("k040 to k059 hold %v", contents)
+	}
+	var count shardCount
+	if err := route(r, Command{Operation: "count", LeftBound: "k040", RightBound: "k059"}, &count); err != nil || count.Count != 20 {
+		t.Errorf("k040 to k059 count %d, %v, want 20", count.Count, err)
+	}
+
+	// pages merge both shards in order, in either direction
+	for _, reverse := range []bool{false, true} {
+		var keys []string
+		query := Command{Operation: "get_range", Reverse: reverse, Limit: 30}
+		for {
+			var page shardPage
+			if err := route(r, query, &page); err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range page.Entries {
+				keys = append(keys, entry.Key)
+			}
+			if page.Token == "" {
+				break
+			}
+			query.Token = page.Token
+		}
+		if len(keys) != 100 {
+			t.Fatalf("reverse %v: pages hold %d keys, want 100", reverse, len(keys))
+		}
+		for i := 1; i < len(keys); i++ {
+			if (keys[i-1] < keys[i]) == reverse {
+				t.Fatalf("reverse %v: %s comes after %s", reverse, keys[i], keys[i-1])
+			}
+		}
+	}
+
+	// batches are split among the shards, unless they must be atomic
+	entries := []interfaces.Entry{{Key: "k001", Value: "a"}, {Key: "k098", Value: "b"}}
+	if err := route(r, Command{Operation: "mset", Entries: entries, Atomic: true}, nil); !errors.Is(err, ErrCrossShard) {
+		t.Errorf("an atomic batch across shards returned %v, want ErrCrossShard", err)
+	}
+	if err := route(r, Command{Operation: "mset", Entries: entries}, &results); err != nil {
+		t.Fatal(err)
+	}
+	if a.held("c__s1")["k001"] != "a" || b.held("c__s2")["k098"] != "b" {
+		t.Error("a batch across shards did not reach both")
+	}
+	if err := route(r, Command{Operation: "mdelete", Keys: []string{"k001", "k098"}}, &results); err != nil {
+		t.Fatal(err)
+	}
+	if err := route(r, Command{Operation: "count"}, &count); err != nil || count.Count != 98 {
+		t.Errorf("collection counts %d keys, %v, want 98", count.Count, err)
+	}
+
+	if err := route(r, Command{Operation: "rank", Key: "k001"}, nil); CodeOf(err) != CodeUnsupported {
+		t.Errorf("rank on a sharded collection returned %v", err)
+	}
+	spec := ShardSpec{Scheme: ShardByRange, Servers: []string{a.address()}}
+	if err := route(r, Command{Operation: "create_collection", Sharding: &spec}, nil); !errors.Is(err, ErrCollectionExists) {
+		t.Errorf("creating the collection again returned %v", err)
+	}
+}
+
+func TestShardSplitAndMove(t *testing.T) {
+	a, b, c := startTestShardServer(t), startTestShardServer(t), startTestShardServer(t)
+	router := newShardedTestCollection(t, ShardSpec{Scheme: ShardByRange, Servers: []string{a.address(), b.address()}, SplitKeys: []string{"k050"}})
+	r := router.Shards()
+
+	// writes go on while the shard is split, and none is lost
+	want := testShardKeys(0, 100)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for round := 0; ; round++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			key := fmt.Sprintf("k%03d", 50+round%50)
+			value := fmt.Sprint("w", round)
+			if err := route(r, Command{Operation: "set", Key: key, SecondaryKey: key, Value: value}, nil); err != nil {
+				t.Error(err)
+				return
+			}
+			want[key] = value
+		}
+	}()
+	var status ShardedCollection
+	err := route(r, Command{Operation: "split_shard", Shard: "s2", Key: "k075", Server: c.address()}, &status)
+	close(done)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(status.Shards) != 3 {
+		t.Fatalf("collection has shards %+v", status.Shards)
+	}
+	upper := status.Shards[2]
+	if status.Shards[1].End != "k075" || status.Shards[1].Draining || upper.ID != "s3" || upper.Start != "k075" || upper.Server != c.address() {
+		t.Errorf("split left shards %+v", status.Shards)
+	}
+	var contents map[string]string
+	if err := route(r, Command{Operation: "get_range", RightBound: "\xff"}, &contents); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(contents, want) {
+		t.Errorf("collection holds %d keys after the split, want %d as written", len(contents), len(want))
+	}
+	if held := b.held("c__s2"); len(held) != 25 {
+		t.Errorf("split shard still holds %d keys, want 25", len(held))
+	}
+	if held := c.held("c__s3"); len(held) != 25 {
+		t.Errorf("new shard holds %d keys, want 25", len(held))
+	}
+
+	if err := route(r, Command{Operation: "move_shard", Shard: "s1", Server: c.address()}, &status); err != nil {
+		t.Fatal(err)
+	}
+	if moved := status.Shards[0]; moved.ID != "s1" || moved.Server != c.address() || moved.Collection != "c__s4" {
+		t.Errorf("moved shard is %+v", moved)
+	}
+	if held := a.held("c__s1"); len(held) != 0 {
+		t.Errorf("the server the shard left still holds %d keys", len(held))
+	}
+	if held := c.held("c__s4"); !reflect.DeepEqual(held, testShardKeys(0, 50)) {
+		t.Errorf("moved shard holds %d keys, want k000 to k049", len(held))
+	}
+
+	for _, test := range []struct {
+		cmd  Command
+		code ErrorCode
+	}{
+		{Command{Operation: "split_shard", Shard: "s9", Key: "k010"}, CodeNotFound},
+		{Command{Operation: "split_shard", Shard: "s1", Key: "k060"}, CodeInvalidArgument},
+		{Command{Operation: "split_shard", Shard: "s1", Key: ""}, CodeInvalidArgument},
+		{Command{Operation: "move_shard", Shard: "s1", Server: c.address()}, CodeInvalidArgument},
+		{Command{Operation: "move_shard", Shard: "s1"}, CodeInvalidArgument},
+	} {
+		if err := route(r, test.cmd, nil); CodeOf(err) != test.code {
+			t.Errorf("%s of %s returned %v, want %s", test.cmd.Operation, test.cmd.Shard, err, test.code)
+		}
+	}
+
+	// the catalog survives restarting the router
+	dir := router.Config.DataDir
+	reopened := newTestDatabase(t, t.TempDir())
+	defer reopened.Close()
+	reopened.Config.DataDir = dir
+	if err := reopened.LoadCollections(); err != nil {
+		t.Fatal(err)
+	}
+	var reloaded ShardedCollection
+	if err := route(reopened.Shards(), Command{Operation: "shard_status"}, &reloaded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reloaded.Shards, status.Shards) {
+		t.Errorf("reloaded shards are %+v, want %+v", reloaded.Shards, status.Shards)
+	}
+}
+
+func TestHashShardedCollection(t *testing.T) {
+	a, b := startTestShardServer(t), startTestShardServer(t)
+	router := newShardedTestCollection(t, ShardSpec{Scheme: ShardByHash, Servers: []string{a.address(), b.address()}})
+	r := router.Shards()
+
+	first, second := a.held("c__s1"), b.held("c__s2")
+	if len(first) == 0 || len(second) == 0 || len(first)+len(second) != 100 {
+		t.Fatalf("shards hold %d and %d keys, want 100 between them", len(first), len(second))
+	}
+	for key := range first {
+		if slot := shardSlot(key); slot >= shardSlots/2 {
+			t.Errorf("%s in slot %d is on the first shard", key, slot)
+		}
+	}
+
+	var status ShardedCollection
+	if err := route(r, Command{Operation: "split_shard", Shard: "s1"}, &status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Shards) != 3 || status.Shards[0].EndSlot != shardSlots/4 || status.Shards[1].FirstSlot != shardSlots/4 || status.Shards[1].Server != a.address() {
+		t.Errorf("split left shards %+v", status.Shards)
+	}
+	var count shardCount
+	if err := route(r, Command{Operation: "count"}, &count); err != nil || count.Count != 100 {
+		t.Errorf("collection counts %d keys, %v, want 100", count.Count, err)
+	}
+	var contents map[string]string
+	if err := route(r, Command{Operation: "get_range", RightBound: "\xff"}, &contents); err != nil || !reflect.DeepEqual(contents, testShardKeys(0, 100)) {
+		t.Errorf("collection holds %d keys, %v, want 100", len(contents), err)
+	}
+}
@@ -0,0 +1,191 @@
+package db
+
+import (
+	"math/rand"
+	"time"
+)
+
+// skipListP is the probability that a node reaching level i also reaches
+// level i+1.
+const skipListP = 0.25
+
+type SkipListNode struct {
+	Key   string
+	Value string
+	Next  []*SkipListNode
+}
+
+type SkipList struct {
+	head     *SkipListNode
+	level    int
+	MaxLevel int
+	rnd      *rand.Rand
+}
+
+func NewSkipList(maxLevel int) *SkipList {
+	return &SkipList{
+		head:     &SkipListNode{Next: make([]*SkipListNode, maxLevel)},
+		level:    1,
+		MaxLevel: maxLevel,
+		rnd:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (s *SkipList) randomLevel() int {
+	level := 1
+	for level < s.MaxLevel && s.rnd.Float64() < skipListP {
+		level++
+	}
+	return level
+}
+
+// findPredecessors fills update[i] with the last node on level i whose key
+// is less than key and returns the level-0 successor of that position.
+func (s *SkipList) findPredecessors(key string, update []*SkipListNode) *SkipListNode {
+	x := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for x.Next[i] != nil && x.Next[i].Key < key {
+			x = x.Next[i]
+		}
+		if update != nil {
+			update[i] = x
+		}
+	}
+	return x.Next[0]
+}
+
+func (s *SkipList) Search(key string) (string, bool) {
+	x := s.findPredecessors(key, nil)
+	if x != nil && x.Key == key {
+		return x.Value, true
+	}
+	return "", false
+}
+
+func (s *SkipList) Insert(key string, value string) {
+	update := make([]*SkipListNode, s.MaxLevel)
+	x := s.findPredecessors(key, update)
+
+	if x != nil && x.Key == key {
+		x.Value = value
+		return
+	}
+
+	level := s.randomLevel()
+	if level > s.level {
+		for i := s.level; i < level; i++ {
+			update[i] = s.head
+		}
+		s.level = level
+	}
+
+	node := &SkipListNode{Key: key, Value: value, Next: make([]*SkipListNode, level)}
+	for i := 0; i < level; i++ {
+		node.Next[i] = update[i].Next[i]
+		update[i].Next[i] = node
+	}
+}
+
+func (s *SkipList) Delete(key string) bool {
+	update := make([]*SkipListNode, s.MaxLevel)
+	x := s.findPredecessors(key, update)
+
+	if x == nil || x.Key != key {
+		return false
+	}
+
+	for i := 0; i < len(x.Next); i++ {
+		update[i].Next[i] = x.Next[i]
+	}
+
+	for s.level > 1 && s.head.Next[s.level-1] == nil {
+		s.level--
+	}
+	return true
+}
+
+// searchRange seeks to leftBound through the upper levels and then walks
+// level 0 until rightBound.
+func (s *SkipList) searchRange(leftBound, rightBound string, result *map[string]string) {
+	for x := s.findPredecessors(leftBound, nil); x != nil && x.Key <= rightBound; x = x.Next[0] {
+		(*result)[x.Key] = x.Value
+	}
+}
+
+func (s *SkipList) put(key string, value string) error {
+	s.Insert(key, value)
+	return nil
+}
+
+func (s *SkipList) get(key string) (string, bool, error) {
+	value, found := s.Search(key)
+	return value, found, nil
+}
+
+func (s *SkipList) remove(key string) (bool, error) {
+	return s.Delete(key), nil
+}
+
+func (s *SkipList) rangeInto(leftBound string, rightBound string, result *map[string]string) error {
+	s.searchRange(leftBound, rightBound, result)
+	return nil
+}
+
+func (s *SkipList) cursor() (treeCursor, error) {
+	return &skipListCursor{s: s}, nil
+}
+
+// skipListCursor walks level 0 forwards. Nodes have no back pointers, so
+// prev searches again from the head for the predecessor.
+type skipListCursor struct {
+	s    *SkipList
+	node *SkipListNode
+}
+
+func (c *skipListCursor) seek(key string) error {
+	c.node = c.s.findPredecessors(key, nil)
+	return nil
+}
+
+func (c *skipListCursor) last() error {
+	x := c.s.head
+	for i := c.s.level - 1; i >= 0; i-- {
+		for x.Next[i] != nil {
+			x = x.Next[i]
+		}
+	}
+	c.node = x
+	if x == c.s.head {
+		c.node = nil
+	}
+	return nil
+}
+
+func (c *skipListCursor) next() error {
+	if c.valid() {
+		c.node = c.node.Next[0]
+	}
+	return nil
+}
+
+func (c *skipListCursor) prev() error {
+	if !c.valid() {
+		return nil
+	}
+
+	x := c.s.head
+	for i := c.s.level - 1; i >= 0; i-- {
+		for x.Next[i] != nil && x.Next[i].Key < c.node.Key {
+			x = x.Next[i]
+		}
+	}
+	c.node = x
+	if x == c.s.head {
+		c.node = nil
+	}
+	return nil
+}
+
+func (c *skipListCursor) valid() bool   { return c.node != nil }
+func (c *skipListCursor) key() string   { return c.node.Key }
+func (c *skipListCursor) value() string { return c.node.Value }
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// checkSkipList fails unless every level of s is sorted and only holds
+// nodes of the level below it, and level 0 holds exactly want.
+func checkSkipList(t *testing.T, s *SkipList, want map[string]string) {
+	t.Helper()
+	below := make(map[*SkipListNode]bool)
+	for x := s.head.Next[0]; x != nil; x = x.Next[0] {
+		below[x] = true
+		if value, ok := want[x.Key]; !ok || value != x.Value {
+			t.Fatalf("skip list holds %s=%q, want %q", x.Key, x.Value, value)
+		}
+		if x.Next[0] != nil && x.Next[0].Key <= x.Key {
+			t.Fatalf("level 0 has %s before %s", x.Key, x.Next[0].Key)
+		}
+	}
+	if len(below) != len(want) {
+		t.Fatalf("skip list holds %d keys, want %d", len(below), len(want))
+	}
+	for i := 1; i < s.MaxLevel; i++ {
+		if i >= s.level && s.head.Next[i] != nil {
+			t.Fatalf("level %d is above the list's level %d but not empty", i, s.level)
+		}
+		level := make(map[*SkipListNode]bool)
+		for x := s.head.Next[i]; x != nil; x = x.Next[i] {
+			if !below[x] {
+				t.Fatalf("level %d holds %s, which level %d does not", i, x.Key, i-1)
+			}
+			if x.Next[i] != nil && x.Next[i].Key <= x.Key {
+				t.Fatalf("level %d has %s before %s", i, x.Key, x.Next[i].Key)
+			}
+			level[x] = true
+		}
+		below = level
+	}
+}
+
+func TestSkipListInsertAndDelete(t *testing.T) {
+	for _, maxLevel := range []int{1, 4, DefaultSkipListMaxLevel} {
+		s := NewSkipList(maxLevel)
+		s.rnd = rand.New(rand.NewSource(1))
+		want := make(map[string]string)
+		for _, i := range rand.New(rand.NewSource(2)).Perm(1000) {
+			key := fmt.Sprintf("k%04d", i)
+			s.Insert(key, key)
+			want[key] = key
+		}
+		s.Insert("k0500", "replaced")
+		want["k0500"] = "replaced"
+		checkSkipList(t, s, want)
+
+		for i := 0; i < 1000; i += 2 {
+			key := fmt.Sprintf("k%04d", i)
+			if !s.Delete(key) {
+				t.Fatalf("max level %d: deleting %s failed", maxLevel, key)
+			}
+			delete(want, key)
+		}
+		if s.Delete("k0000") {
+			t.Fatalf("max level %d: deleted k0000 twice", maxLevel)
+		}
+		checkSkipList(t, s, want)
+		if value, found := s.Search("k0501"); !found || value != "k0501" {
+			t.Fatalf("max level %d: k0501 is %q, %v", maxLevel, value, found)
+		}
+
+		for key := range want {
+			s.Delete(key)
+		}
+		if s.level != 1 || s.head.Next[0] != nil {
+			t.Fatalf("max level %d: empty skip list is at level %d", maxLevel, s.level)
+		}
+	}
+}
+
+func TestSkipListCursor(t *testing.T) {
+	s := NewSkipList(DefaultSkipListMaxLevel)
+	for i := 0; i < 100; i += 2 {
+		s.Insert(fmt.Sprintf("k%03d", i), fmt.Sprint(i))
+	}
+	c, _ := s.cursor()
+
+	// seek lands on the first key at or after the one sought
+	c.seek("k011")
+	if !c.valid() || c.key() != "k012" {
+		t.Fatal("seek(k011) is not on k012")
+	}
+	c.prev()
+	if !c.valid() || c.key() != "k010" {
+		t.Fatal("prev from k012 is not on k010")
+	}
+
+	n := 0
+	for c.last(); c.valid(); c.prev() {
+		n++
+	}
+	if n != 50 {
+		t.Fatalf("walking backwards from the last key visits %d keys, want 50", n)
+	}
+	c.seek("k099")
+	if c.valid() {
+		t.Fatalf("seek past the last key is on %s", c.key())
+	}
+}
@@ -0,0 +1,471 @@
+package db
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Sorted string table layout:
+//
+//	data blocks  entries (uvarint key length, key, uvarint value length,
+//	             value) followed by a CRC-32 of the block
+//	index        uvarint block count, then per block its first key,
+//	             offset and length; then the largest key and entry count
+//	bloom        bloomFilter.encode()
+//	footer       index offset, index length, bloom offset, bloom length
+//	             and magic, each a little-endian uint64
+const (
+	sstBlockSize   = 4096
+	sstFooterSize  = 40
+	sstMagic       = 0x4442494953535431 // "DBIISST1"
+	sstBloomBits   = 10
+	sstTargetBytes = 2 << 20
+)
+
+var ErrCorruptTable = NewError(CodeInternal, "corrupt table file")
+
+type sstEntry struct {
+	key   string
+	value string
+}
+
+type sstIndexEntry struct {
+	firstKey string
+	offset   uint64
+	length   uint64
+}
+
+type sstWriter struct {
+	path    string
+	file    *os.File
+	w       *bufio.Writer
+	offset  uint64
+	block   []byte
+	first   string
+	last    string
+	index   []sstIndexEntry
+	keys    []string
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func newSSTWriter(path string) (*sstWriter, error) {
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &sstWriter{path: path, file: file, w: bufio.NewWriter(file)}, nil
+}
+
+func (w *sstWriter) appendUvarint(buf []byte, v uint64) []byte {
+	n := binary.PutUvarint(w.scratch[:], v)
+	return append(buf, w.scratch[:n]...)
+}
+
+func (w *sstWriter) appendString(buf []byte, s string) []byte {
+	buf = w.appendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// add appends an entry; keys must arrive in ascending order.
+func (w *sstWriter) add(key string, value string) error {
+	if len(w.block) == 0 {
+		w.first = key
+	}
+	w.block = w.appendString(w.block, key)
+	w.block = w.appendString(w.block, value)
+	w.last = key
+	w.keys = append(w.keys, key)
+
+	if len(w.block) >= sstBlockSize {
+		return w.finishBlock()
+	}
+	return nil
+}
+
+func (w *sstWriter) size() uint64 {
+	return w.offset + uint64(len(w.block))
+}
+
+func (w *sstWriter) finishBlock() error {
+	if len(w.block) == 0 {
+		return nil
+	}
+
+	w.block = binary.LittleEndian.AppendUint32(w.block, crc32.ChecksumIEEE(w.block))
+	if _, err := w.w.Write(w.block); err != nil {
+		return err
+	}
+
+	w.index = append(w.index, sstIndexEntry{
+		firstKey: w.first,
+		offset:   w.offset,
+		length:   uint64(len(w.block)),
+	})
+	w.offset += uint64(len(w.block))
+	w.block = w.block[:0]
+	return nil
+}
+
+// finish writes the index, bloom filter and footer and atomically moves
+// the table into place.
+func (w *sstWriter) finish() error {
+	if err := w.finishBlock(); err != nil {
+		w.abort()
+		return err
+	}
+
+	var index []byte
+	index = w.appendUvarint(index, uint64(len(w.index)))
+	for _, e := range w.index {
+		index = w.appendString(index, e.firstKey)
+		index = w.appendUvarint(index, e.offset)
+		index = w.appendUvarint(index, e.length)
+	}
+	index = w.appendString(index, w.last)
+	index = w.appendUvarint(index, uint64(len(w.keys)))
+
+	bloom := newBloomFilter(len(w.keys), sstBloomBits)
+	for _, key := range w.keys {
+		bloom.add(key)
+	}
+	bloomData := bloom.encode()
+
+	var footer []byte
+	footer = binary.LittleEndian.AppendUint64(footer, w.offset)
+	footer = binary.LittleEndian.AppendUint64(footer, uint64(len(index)))
+	footer = binary.LittleEndian.AppendUint64(footer, w.offset+uint64(len(index)))
+	footer = binary.LittleEndian.AppendUint64(footer, uint64(len(bloomData)))
+	footer = binary.LittleEndian.AppendUint64(footer, sstMagic)
+
+	for _, part := range [][]byte{index, bloomData, footer} {
+		if _, err := w.w.Write(part); err != nil {
+			w.abort()
+			return err
+		}
+	}
+
+	if err := w.w.Flush(); err != nil {
+		w.abort()
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		w.abort()
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		os.Remove(w.path + ".tmp")
+		return err
+	}
+	return os.Rename(w.path+".tmp", w.path)
+}
+
+func (w *sstWriter) abort() {
+	w.file.Close()
+	os.Remove(w.path + ".tmp")
+}
+
+type sstable struct {
+	num      uint64
+	path     string
+	file     *os.File
+	index    []sstIndexEntry
+	smallest string
+	largest  string
+	count    uint64
+	size     int64
+	bloom    *bloomFilter
+}
+
+func openSSTable(path string, num uint64) (*sstable, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := loadSSTable(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	t.num = num
+	t.path = path
+	return t, nil
+}
+
+func loadSSTable(file *os.File) (*sstable, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < sstFooterSize {
+		return nil, ErrCorruptTable
+	}
+
+	footer := make([]byte, sstFooterSize)
+	if _, err := file.ReadAt(footer, info.Size()-sstFooterSize); err != nil {
+		return nil, err
+	}
+	if binary.LittleEndian.Uint64(footer[32:]) != sstMagic {
+		return nil, ErrCorruptTable
+	}
+
+	indexOffset := binary.LittleEndian.Uint64(footer[0:])
+	indexLen := binary.LittleEndian.Uint64(footer[8:])
+	bloomOffset := binary.LittleEndian.Uint64(footer[16:])
+	bloomLen := binary.LittleEndian.Uint64(footer[24:])
+	if bloomOffset+bloomLen > uint64(info.Size()) || indexOffset+indexLen > bloomOffset {
+		return nil, ErrCorruptTable
+	}
+
+	meta := make([]byte, indexLen+bloomLen)
+	if _, err := file.ReadAt(meta, int64(indexOffset)); err != nil {
+		return nil, err
+	}
+
+	t := &sstable{file: file, size: info.Size()}
+	r := &byteReader{buf: meta[:indexLen]}
+	n := r.uvarint()
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		e := sstIndexEntry{firstKey: r.string()}
+		e.offset = r.uvarint()
+		e.length = r.uvarint()
+		t.index = append(t.index, e)
+	}
+	t.largest = r.string()
+	t.count = r.uvarint()
+	if r.err != nil || len(t.index) == 0 {
+		return nil, ErrCorruptTable
+	}
+	t.smallest = t.index[0].firstKey
+
+	t.bloom = decodeBloomFilter(meta[indexLen:])
+	if t.bloom == nil {
+		return nil, ErrCorruptTable
+	}
+	return t, nil
+}
+
+func (t *sstable) close() error {
+	return t.file.Close()
+}
+
+func (t *sstable) overlaps(smallest, largest string) bool {
+	return t.smallest <= largest && smallest <= t.largest
+}
+
+func (t *sstable) readBlock(i int) ([]sstEntry, error) {
+	e := t.index[i]
+	data := make([]byte, e.length)
+	if _, err := t.file.ReadAt(data, int64(e.offset)); err != nil {
+		return nil, err
+	}
+	if len(data) < 4 {
+		return nil, ErrCorruptTable
+	}
+
+	body := data[:len(data)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(data[len(data)-4:]) {
+		return nil, fmt.Errorf("%s block %d: %w", t.path, i, ErrCorruptTable)
+	}
+
+	var entries []sstEntry
+	r := &byteReader{buf: body}
+	for len(r.buf) > 0 && r.err == nil {
+		key := r.string()
+		value := r.string()
+		entries = append(entries, sstEntry{key: key, value: value})
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("%s block %d: %w", t.path, i, ErrCorruptTable)
+	}
+	return entries, nil
+}
+
+// findBlock returns the last block whose first key is <= key, or -1.
+func (t *sstable) findBlock(key string) int {
+	lo, hi := 0, len(t.index)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if t.index[mid].firstKey <= key {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo - 1
+}
+
+// get returns the encoded value stored for key.
+func (t *sstable) get(key string) (string, bool, error) {
+	if key < t.smallest || key > t.largest || !t.bloom.mayContain(key) {
+		return "", false, nil
+	}
+
+	b := t.findBlock(key)
+	if b < 0 {
+		return "", false, nil
+	}
+
+	entries, err := t.readBlock(b)
+	if err != nil {
+		return "", false, err
+	}
+	for _, e := range entries {
+		if e.key == key {
+			return e.value, true, nil
+		}
+		if e.key > key {
+			break
+		}
+	}
+	return "", false, nil
+}
+
+// before returns the last entry whose key is below key, or the last entry
+// of all when bounded is false.
+func (t *sstable) before(key string, bounded bool) (string, string, bool, error) {
+	b := len(t.index) - 1
+	if bounded {
+		if key <= t.smallest {
+			return "", "", false, nil
+		}
+		b = t.findBlock(key)
+	}
+
+	for ; b >= 0; b-- {
+		entries, err := t.readBlock(b)
+		if err != nil {
+			return "", "", false, err
+		}
+		for i := len(entries) - 1; i >= 0; i-- {
+			if !bounded || entries[i].key < key {
+				return entries[i].key, entries[i].value, true, nil
+			}
+		}
+	}
+	return "", "", false, nil
+}
+
+type sstIterator struct {
+	t       *sstable
+	block   int
+	entries []sstEntry
+	pos     int
+}
+
+func (it *sstIterator) loadBlock(i int) error {
+	it.block = i
+	it.pos = 0
+	it.entries = nil
+	if i >= len(it.t.index) {
+		return nil
+	}
+
+	entries, err := it.t.readBlock(i)
+	if err != nil {
+		return err
+	}
+	it.entries = entries
+	return nil
+}
+
+func (it *sstIterator) seek(key string) error {
+	b := it.t.findBlock(key)
+	if b < 0 {
+		b = 0
+	}
+	if err := it.loadBlock(b); err != nil {
+		return err
+	}
+	for it.pos < len(it.entries) && it.entries[it.pos].key < key {
+		it.pos++
+	}
+	if it.pos == len(it.entries) {
+		return it.loadBlock(b + 1)
+	}
+	return nil
+}
+
+func (it *sstIterator) valid() bool {
+	return it.pos < len(it.entries)
+}
+
+func (it *sstIterator) key() string {
+	return it.entries[it.pos].key
+}
+
+func (it *sstIterator) value() string {
+	return it.entries[it.pos].value
+}
+
+func (it *sstIterator) next() error {
+	it.pos++
+	if it.pos < len(it.entries) {
+		return nil
+	}
+	return it.loadBlock(it.block + 1)
+}
+
+// byteReader decodes the varint framing used by tables and logs and
+// remembers the first error.
+type byteReader struct {
+	buf []byte
+	err error
+}
+
+func (r *byteReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *byteReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *byteReader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.buf) == 0 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b
+}
+
+func (r *byteReader) string() string {
+	n := r.uvarint()
+	if r.err != nil {
+		return ""
+	}
+	if uint64(len(r.buf)) < n {
+		r.err = io.ErrUnexpectedEOF
+		return ""
+	}
+	s := string(r.buf[:n])
+	r.buf = r.buf[n:]
+	return s
+}
@@ -1,27 +1,24 @@
 package db
 
-type TreeType string
-
-const (
-	TreeTypeAVL      TreeType = "avl"
-	TreeTypeRedBlack TreeType = "redblack"
-	TreeTypeBTree    TreeType = "btree"
-)
+import "strings"
 
 type AVLNode struct {
 	Key    string
 	Value  string
 	Height int
-	Left   *AVLNode
-	Right  *AVLNode
+	// Size is the number of nodes in the subtree rooted here.
+	Size  int
+	Left  *AVLNode
+	Right *AVLNode
 }
 
 type AVLTree struct {
-	Root *AVLNode
+	Root    *AVLNode
+	compare compareFunc
 }
 
 func NewAVLTree() *AVLTree {
-	return &AVLTree{}
+	return &AVLTree{compare: strings.Compare}
 }
 
 func height(node *AVLNode) int {
@@ -31,6 +28,19 @@ func height(node *AVLNode) int {
 	return node.Height
 }
 
+func size(node *AVLNode) int {
+	if node == nil {
+		return 0
+	}
+	return node.Size
+}
+
+// update recomputes the height and size of node from its children.
+func (node *AVLNode) update() {
+	node.Height = max(height(node.Left), height(node.Right)) + 1
+	node.Size = size(node.Left) + size(node.Right) + 1
+}
+
 func max(a, b int) int {
 	if a > b {
 		return a
@@ -52,8 +62,8 @@ func (t *AVLTree) rightRotate(y *AVLNode) *AVLNode {
 	x.Right = y
 	y.Left = T2
 
-	y.Height = max(height(y.Left), height(y.Right)) + 1
-	x.Height = max(height(x.Left), height(x.Right)) + 1
+	y.update()
+	x.update()
 
 	return x
 }
@@ -65,44 +75,44 @@ func (t *AVLTree) leftRotate(x *AVLNode) *AVLNode {
 	y.Left = x
 	x.Right = T2
 
-	x.Height = max(height(x.Left), height(x.Right)) + 1
-	y.Height = max(height(y.Left), height(y.Right)) + 1
+	x.update()
+	y.update()
 
 	return y
 }
 
 func (t *AVLTree) insert(node *AVLNode, key string, value string) *AVLNode {
 	if node == nil {
-		return &AVLNode{Key: key, Value: value, Height: 1}
+		return &AVLNode{Key: key, Value: value, Height: 1, Size: 1}
 	}
 
-	if key < node.Key {
+	if cmp := t.compare(key, node.Key); cmp < 0 {
 		node.Left = t.insert(node.Left, key, value)
-	} else if key > node.Key {
+	} else if cmp > 0 {
 		node.Right = t.insert(node.Right, key, value)
 	} else {
 		node.Value = value
 		return node
 	}
 
-	node.Height = max(height(node.Left), height(node.Right)) + 1
+	node.update()
 
 	balance := getBalance(node)
 
-	if balance > 1 && key < node.Left.Key {
+	if balance > 1 && t.compare(key, node.Left.Key) < 0 {
 		return t.rightRotate(node)
 	}
 
-	if balance < -1 && key > node.Right.Key {
+	if balance < -1 && t.compare(key, node.Right.Key) > 0 {
 		return t.leftRotate(node)
 	}
 
-	if balance > 1 && key > node.Left.Key {
+	if balance > 1 && t.compare(key, node.Left.Key) > 0 {
 		node.Left = t.leftRotate(node.Left)
 		return t.rightRotate(node)
 	}
 
-	if balance < -1 && key < node.Right.Key {
+	if balance < -1 && t.compare(key, node.Right.Key) < 0 {
 		node.Right = t.rightRotate(node.Right)
 		return t.leftRotate(node)
 	}
@@ -111,11 +121,11 @@ func (t *AVLTree) insert(node *AVLNode, key string, value string) *AVLNode {
 }
 
 func (t *AVLTree) search(node *AVLNode, key string) *AVLNode {
-	if node == nil || node.Key == key {
+	if node == nil || t.compare(node.Key, key) == 0 {
 		return node
 	}
 
-	if key < node.Key {
+	if t.compare(key, node.Key) < 0 {
 		return t.search(node.Left, key)
 	}
 	return t.search(node.Right, key)
@@ -126,15 +136,15 @@ func (t *AVLTree) searchRange(node *AVLNode, leftBound string, rightBound string
 		return
 	}
 
-	if leftBound < node.Key {
+	if t.compare(leftBound, node.Key) < 0 {
 		t.searchRange(node.Left, leftBound, rightBound, result)
 	}
 
-	if leftBound <= node.Key && node.Key <= rightBound {
+	if t.compare(leftBound, node.Key) <= 0 && t.compare(node.Key, rightBound) <= 0 {
 		(*result)[node.Key] = node.Value
 	}
 
-	if rightBound > node.Key {
+	if t.compare(rightBound, node.Key) > 0 {
 		t.searchRange(node.Right, leftBound, rightBound, result)
 	}
 }
@@ -152,9 +162,9 @@ func (t *AVLTree) delete(node *AVLNode, key string) *AVLNode {
 		return node
 	}
 
-	if key < node.Key {
+	if cmp := t.compare(key, node.Key); cmp < 0 {
 		node.Left = t.delete(node.Left, key)
-	} else if key > node.Key {
+	} else if cmp > 0 {
 		node.Right = t.delete(node.Right, key)
 	} else {
 		if node.Left == nil {
@@ -173,7 +183,7 @@ func (t *AVLTree) delete(node *AVLNode, key string) *AVLNode {
 		return node
 	}
 
-	node.Height = max(height(node.Left), height(node.Right)) + 1
+	node.update()
 
 	balance := getBalance(node)
 
@@ -198,6 +208,153 @@ func (t *AVLTree) delete(node *AVLNode, key string) *AVLNode {
 	return node
 }
 
+func (t *AVLTree) put(key string, value string) error {
+	t.Root = t.insert(t.Root, key, value)
+	return nil
+}
+
+func (t *AVLTree) get(key string) (string, bool, error) {
+	node := t.search(t.Root, key)
+	if node == nil {
+		return "", false, nil
+	}
+	return node.Value, true, nil
+}
+
+func (t *AVLTree) remove(key string) (bool, error) {
+	if t.search(t.Root, key) == nil {
+		return false, nil
+	}
+	t.Root = t.delete(t.Root, key)
+	return true, nil
+}
+
+func (t *AVLTree) rangeInto(leftBound string, rightBound string, result *map[string]string) error {
+	t.searchRange(t.Root, leftBound, rightBound, result)
+	return nil
+}
+
+func (t *AVLTree) cursor() (treeCursor, error) {
+	return &avlCursor{root: t.Root, compare: t.compare}, nil
+}
+
+func (t *AVLTree) count() int {
+	return size(t.Root)
+}
+
+func (t *AVLTree) rank(key string, inclusive bool) int {
+	rank := 0
+	for node := t.Root; node != nil; {
+		if cmp := t.compare(node.Key, key); cmp < 0 || (inclusive && cmp == 0) {
+			rank += size(node.Left) + 1
+			node = node.Right
+		} else {
+			node = node.Left
+		}
+	}
+	return rank
+}
+
+func (t *AVLTree) selectKth(k int) (string, string, bool) {
+	for node := t.Root; node != nil; {
+		left := size(node.Left)
+		switch {
+		case k < left:
+			node = node.Left
+		case k == left:
+			return node.Key, node.Value, true
+		default:
+			k -= left + 1
+			node = node.Right
+		}
+	}
+	return "", "", false
+}
+
+// avlCursor keeps the path from the root to the current node, since AVL
+// nodes have no parent pointers.
+type avlCursor struct {
+	root    *AVLNode
+	compare compareFunc
+	path    []*AVLNode
+}
+
+func (c *avlCursor) seek(key string) error {
+	c.path = c.path[:0]
+	keep := 0
+	for node := c.root; node != nil; {
+		c.path = append(c.path, node)
+		cmp := c.compare(key, node.Key)
+		if cmp == 0 {
+			return nil
+		}
+		if cmp < 0 {
+			keep = len(c.path)
+			node = node.Left
+		} else {
+			node = node.Right
+		}
+	}
+	c.path = c.path[:keep]
+	return nil
+}
+
+func (c *avlCursor) last() error {
+	c.path = c.path[:0]
+	for node := c.root; node != nil; node = node.Right {
+		c.path = append(c.path, node)
+	}
+	return nil
+}
+
+func (c *avlCursor) next() error {
+	if !c.valid() {
+		return nil
+	}
+
+	node := c.path[len(c.path)-1]
+	if node.Right != nil {
+		for node = node.Right; node != nil; node = node.Left {
+			c.path = append(c.path, node)
+		}
+		return nil
+	}
+
+	for {
+		child := c.path[len(c.path)-1]
+		c.path = c.path[:len(c.path)-1]
+		if len(c.path) == 0 || c.path[len(c.path)-1].Left == child {
+			return nil
+		}
+	}
+}
+
+func (c *avlCursor) prev() error {
+	if !c.valid() {
+		return nil
+	}
+
+	node := c.path[len(c.path)-1]
+	if node.Left != nil {
+		for node = node.Left; node != nil; node = node.Right {
+			c.path = append(c.path, node)
+		}
+		return nil
+	}
+
+	for {
+		child := c.path[len(c.path)-1]
+		c.path = c.path[:len(c.path)-1]
+		if len(c.path) == 0 || c.path[len(c.path)-1].Right == child {
+			return nil
+		}
+	}
+}
+
+func (c *avlCursor) valid() bool   { return len(c.path) > 0 }
+func (c *avlCursor) key() string   { return c.path[len(c.path)-1].Key }
+func (c *avlCursor) value() string { return c.path[len(c.path)-1].Value }
+
 type Color bool
 
 const (
@@ -206,24 +363,29 @@ const (
 )
 
 type RBNode struct {
-	Key    string
-	Value  string
-	Color  Color
+	Key   string
+	Value string
+	Color Color
+	// Size is the number of nodes in the subtree rooted here; it is 0 for
+	// the NIL sentinel.
+	Size   int
 	Left   *RBNode
 	Right  *RBNode
 	Parent *RBNode
 }
 
 type RedBlackTree struct {
-	Root *RBNode
-	NIL  *RBNode
+	Root    *RBNode
+	NIL     *RBNode
+	compare compareFunc
 }
 
 func NewRedBlackTree() *RedBlackTree {
 	nil_node := &RBNode{Color: BLACK}
 	return &RedBlackTree{
-		NIL:  nil_node,
-		Root: nil_node,
+		NIL:     nil_node,
+		Root:    nil_node,
+		compare: strings.Compare,
 	}
 }
 
@@ -247,6 +409,9 @@ func (t *RedBlackTree) leftRotate(x *RBNode) {
 
 	y.Left = x
 	x.Parent = y
+
+	y.Size = x.Size
+	x.Size = x.Left.Size + x.Right.Size + 1
 }
 
 func (t *RedBlackTree) rightRotate(y *RBNode) {
@@ -269,6 +434,9 @@ func (t *RedBlackTree) rightRotate(y *RBNode) {
 
 	x.Right = y
 	y.Parent = x
+
+	x.Size = y.Size
+	y.Size = y.Left.Size + y.Right.Size + 1
 }
 
 func (t *RedBlackTree) insertFixup(z *RBNode) {
@@ -319,6 +487,7 @@ func (t *RedBlackTree) insert(key string, value string) {
 		Key:    key,
 		Value:  value,
 		Color:  RED,
+		Size:   1,
 		Left:   t.NIL,
 		Right:  t.NIL,
 		Parent: t.NIL,
@@ -329,9 +498,9 @@ func (t *RedBlackTree) insert(key string, value string) {
 
 	for x != t.NIL {
 		y = x
-		if z.Key < x.Key {
+		if cmp := t.compare(z.Key, x.Key); cmp < 0 {
 			x = x.Left
-		} else if z.Key > x.Key {
+		} else if cmp > 0 {
 			x = x.Right
 		} else {
 			x.Value = value
@@ -339,10 +508,14 @@ func (t *RedBlackTree) insert(key string, value string) {
 		}
 	}
 
+	for node := y; node != t.NIL; node = node.Parent {
+		node.Size++
+	}
+
 	z.Parent = y
 	if y == t.NIL {
 		t.Root = z
-	} else if z.Key < y.Key {
+	} else if t.compare(z.Key, y.Key) < 0 {
 		y.Left = z
 	} else {
 		y.Right = z
@@ -353,8 +526,8 @@ func (t *RedBlackTree) insert(key string, value string) {
 
 func (t *RedBlackTree) search(key string) *RBNode {
 	x := t.Root
-	for x != t.NIL && x.Key != key {
-		if key < x.Key {
+	for x != t.NIL && t.compare(x.Key, key) != 0 {
+		if t.compare(key, x.Key) < 0 {
 			x = x.Left
 		} else {
 			x = x.Right
@@ -373,15 +546,15 @@ func (t *RedBlackTree) searchRange(leftBound string, rightBound string, result *
 			return
 		}
 
-		if leftBound < node.Key {
+		if t.compare(leftBound, node.Key) < 0 {
 			inorderTraversal(node.Left)
 		}
 
-		if leftBound <= node.Key && node.Key <= rightBound {
+		if t.compare(leftBound, node.Key) <= 0 && t.compare(node.Key, rightBound) <= 0 {
 			(*result)[node.Key] = node.Value
 		}
 
-		if rightBound > node.Key {
+		if t.compare(rightBound, node.Key) > 0 {
 			inorderTraversal(node.Right)
 		}
 	}
@@ -389,6 +562,26 @@ func (t *RedBlackTree) searchRange(leftBound string, rightBound string, result *
 	inorderTraversal(t.Root)
 }
 
+// ascend calls fn in key order for every node with key >= from until fn
+// returns false.
+func (t *RedBlackTree) ascend(from string, fn func(node *RBNode) bool) {
+	var walk func(*RBNode) bool
+	walk = func(node *RBNode) bool {
+		if node == t.NIL {
+			return true
+		}
+		if t.compare(from, node.Key) < 0 && !walk(node.Left) {
+			return false
+		}
+		if t.compare(from, node.Key) <= 0 && !fn(node) {
+			return false
+		}
+		return walk(node.Right)
+	}
+
+	walk(t.Root)
+}
+
 func (t *RedBlackTree) transplant(u *RBNode, v *RBNode) {
 	if u.Parent == t.NIL {
 		t.Root = v
@@ -472,6 +665,16 @@ func (t *RedBlackTree) delete(key string) bool {
 	y_original_color := y.Color
 	var x *RBNode
 
+	// the node leaving its position is z itself, or z's successor when z
+	// has two children; every ancestor of that position loses one node
+	gone := z
+	if z.Left != t.NIL && z.Right != t.NIL {
+		gone = t.minimum(z.Right)
+	}
+	for node := gone.Parent; node != t.NIL; node = node.Parent {
+		node.Size--
+	}
+
 	if z.Left == t.NIL {
 		x = z.Right
 		t.transplant(z, z.Right)
@@ -495,6 +698,7 @@ func (t *RedBlackTree) delete(key string) bool {
 		y.Left = z.Left
 		y.Left.Parent = y
 		y.Color = z.Color
+		y.Size = z.Size
 	}
 
 	if y_original_color == BLACK {
@@ -504,22 +708,155 @@ func (t *RedBlackTree) delete(key string) bool {
 	return true
 }
 
+func (t *RedBlackTree) put(key string, value string) error {
+	t.insert(key, value)
+	return nil
+}
+
+func (t *RedBlackTree) get(key string) (string, bool, error) {
+	node := t.search(key)
+	if node == nil {
+		return "", false, nil
+	}
+	return node.Value, true, nil
+}
+
+func (t *RedBlackTree) remove(key string) (bool, error) {
+	return t.delete(key), nil
+}
+
+func (t *RedBlackTree) rangeInto(leftBound string, rightBound string, result *map[string]string) error {
+	t.searchRange(leftBound, rightBound, result)
+	return nil
+}
+
+func (t *RedBlackTree) cursor() (treeCursor, error) {
+	return &rbCursor{t: t, node: t.NIL}, nil
+}
+
+func (t *RedBlackTree) count() int {
+	return t.Root.Size
+}
+
+func (t *RedBlackTree) rank(key string, inclusive bool) int {
+	rank := 0
+	for node := t.Root; node != t.NIL; {
+		if cmp := t.compare(node.Key, key); cmp < 0 || (inclusive && cmp == 0) {
+			rank += node.Left.Size + 1
+			node = node.Right
+		} else {
+			node = node.Left
+		}
+	}
+	return rank
+}
+
+func (t *RedBlackTree) selectKth(k int) (string, string, bool) {
+	for node := t.Root; node != t.NIL; {
+		switch {
+		case k < node.Left.Size:
+			node = node.Left
+		case k == node.Left.Size:
+			return node.Key, node.Value, true
+		default:
+			k -= node.Left.Size + 1
+			node = node.Right
+		}
+	}
+	return "", "", false
+}
+
+type rbCursor struct {
+	t    *RedBlackTree
+	node *RBNode
+}
+
+func (c *rbCursor) seek(key string) error {
+	c.node = c.t.NIL
+	for node := c.t.Root; node != c.t.NIL; {
+		cmp := c.t.compare(node.Key, key)
+		if cmp < 0 {
+			node = node.Right
+			continue
+		}
+		c.node = node
+		if cmp == 0 {
+			break
+		}
+		node = node.Left
+	}
+	return nil
+}
+
+func (c *rbCursor) last() error {
+	c.node = c.t.Root
+	for c.node != c.t.NIL && c.node.Right != c.t.NIL {
+		c.node = c.node.Right
+	}
+	return nil
+}
+
+func (c *rbCursor) next() error {
+	if !c.valid() {
+		return nil
+	}
+	if c.node.Right != c.t.NIL {
+		c.node = c.t.minimum(c.node.Right)
+		return nil
+	}
+
+	parent := c.node.Parent
+	for parent != c.t.NIL && c.node == parent.Right {
+		c.node, parent = parent, parent.Parent
+	}
+	c.node = parent
+	return nil
+}
+
+func (c *rbCursor) prev() error {
+	if !c.valid() {
+		return nil
+	}
+	if c.node.Left != c.t.NIL {
+		c.node = c.node.Left
+		for c.node.Right != c.t.NIL {
+			c.node = c.node.Right
+		}
+		return nil
+	}
+
+	parent := c.node.Parent
+	for parent != c.t.NIL && c.node == parent.Left {
+		c.node, parent = parent, parent.Parent
+	}
+	c.node = parent
+	return nil
+}
+
+func (c *rbCursor) valid() bool   { return c.node != c.t.NIL }
+func (c *rbCursor) key() string   { return c.node.Key }
+func (c *rbCursor) value() string { return c.node.Value }
+
 type BTreeNode struct {
 	Keys     []string
 	Values   []string
 	Children []*BTreeNode
 	Leaf     bool
 	n        int
+	// count is the number of keys in the subtree rooted here.
+	count int
 }
 
 type BTree struct {
-	Root   *BTreeNode
-	MinDeg int
+	Root    *BTreeNode
+	MinDeg  int
+	compare compareFunc
 }
 
 func NewBTree(degree int) *BTree {
 	return &BTree{
-		MinDeg: degree,
+		MinDeg:  degree,
+		compare: strings.Compare,
 		Root: &BTreeNode{
 			Leaf:     true,
 			Keys:     make([]string, 2*degree-1),
@@ -539,13 +876,13 @@ func NewBTreeNode(t int, leaf bool) *BTreeNode {
 	}
 }
 
-func (node *BTreeNode) search(key string) (string, bool) {
+func (node *BTreeNode) search(key string, compare compareFunc) (string, bool) {
 	i := 0
-	for i < node.n && key > node.Keys[i] {
+	for i < node.n && compare(key, node.Keys[i]) > 0 {
 		i++
 	}
 
-	if i < node.n && key == node.Keys[i] {
+	if i < node.n && compare(key, node.Keys[i]) == 0 {
 		return node.Values[i], true
 	}
 
@@ -553,36 +890,40 @@ func (node *BTreeNode) search(key string) (string, bool) {
 		return "", false
 	}
 
-	return node.Children[i].search(key)
+	return node.Children[i].search(key, compare)
 }
 
 func (t *BTree) Search(key string) (string, bool) {
 	if t.Root == nil {
 		return "", false
 	}
-	return t.Root.search(key)
+	return t.Root.search(key, t.compare)
 }
 
-func (node *BTreeNode) searchRange(leftBound, rightBound string, result *map[string]string) {
+func (node *BTreeNode) searchRange(leftBound, rightBound string, compare compareFunc, result *map[string]string) {
 	i := 0
 
-	for i < node.n && node.Keys[i] < leftBound {
+	for i < node.n && compare(node.Keys[i], leftBound) < 0 {
 		i++
 	}
 
-	for i < node.n && node.Keys[i] <= rightBound {
-		if node.Keys[i] >= leftBound {
+	for i < node.n && compare(node.Keys[i], rightBound) <= 0 {
+		if compare(node.Keys[i], leftBound) >= 0 {
 			(*result)[node.Keys[i]] = node.Values[i]
 		}
 		i++
 	}
 
 	if !node.Leaf {
-		for j := 0; j < node.n+1 && j <= i; j++ {
-			if j < node.n && node.Keys[j] > rightBound {
+		// child j holds the keys between Keys[j-1] and Keys[j]
+		for j := 0; j <= node.n; j++ {
+			if j < node.n && compare(node.Keys[j], leftBound) < 0 {
+				continue
+			}
+			if j > 0 && compare(node.Keys[j-1], rightBound) > 0 {
 				break
 			}
-			node.Children[j].searchRange(leftBound, rightBound, result)
+			node.Children[j].searchRange(leftBound, rightBound, compare, result)
 		}
 	}
 }
@@ -591,7 +932,7 @@ func (t *BTree) insertNonFull(node *BTreeNode, key string, value string) {
 	i := node.n - 1
 
 	if node.Leaf {
-		for i >= 0 && key < node.Keys[i] {
+		for i >= 0 && t.compare(key, node.Keys[i]) < 0 {
 			node.Keys[i+1] = node.Keys[i]
 			node.Values[i+1] = node.Values[i]
 			i--
@@ -599,8 +940,9 @@ func (t *BTree) insertNonFull(node *BTreeNode, key string, value string) {
 		node.Keys[i+1] = key
 		node.Values[i+1] = value
 		node.n++
+		node.count++
 	} else {
-		for i >= 0 && key < node.Keys[i] {
+		for i >= 0 && t.compare(key, node.Keys[i]) < 0 {
 			i--
 		}
 		i++
@@ -608,11 +950,12 @@ func (t *BTree) insertNonFull(node *BTreeNode, key string, value string) {
 		if node.Children[i].n == 2*t.MinDeg-1 {
 			t.splitChild(node, i)
 
-			if key > node.Keys[i] {
+			if t.compare(key, node.Keys[i]) > 0 {
 				i++
 			}
 		}
 		t.insertNonFull(node.Children[i], key, value)
+		node.count++
 	}
 }
 
@@ -649,9 +992,46 @@ func (t *BTree) splitChild(parent *BTreeNode, i int) {
 	parent.Keys[i] = child.Keys[minDeg-1]
 	parent.Values[i] = child.Values[minDeg-1]
 	parent.n++
+
+	child.recount()
+	newNode.recount()
+	parent.recount()
+}
+
+// recount recomputes node.count from its own keys and its children's
+// counts.
+func (node *BTreeNode) recount() {
+	node.count = node.n
+	if !node.Leaf {
+		for i := 0; i <= node.n; i++ {
+			node.count += node.Children[i].count
+		}
+	}
+}
+
+func (node *BTreeNode) replace(key string, value string, compare compareFunc) bool {
+	i := 0
+	for i < node.n && compare(key, node.Keys[i]) > 0 {
+		i++
+	}
+
+	if i < node.n && compare(key, node.Keys[i]) == 0 {
+		node.Values[i] = value
+		return true
+	}
+
+	if node.Leaf {
+		return false
+	}
+
+	return node.Children[i].replace(key, value, compare)
 }
 
 func (t *BTree) Insert(key string, value string) {
+	if t.Root.replace(key, value, t.compare) {
+		return
+	}
+
 	root := t.Root
 
 	if root.n == 2*t.MinDeg-1 {
@@ -665,9 +1045,9 @@ func (t *BTree) Insert(key string, value string) {
 	}
 }
 
-func (node *BTreeNode) findKey(key string) int {
+func (node *BTreeNode) findKey(key string, compare compareFunc) int {
 	idx := 0
-	for idx < node.n && node.Keys[idx] < key {
+	for idx < node.n && compare(node.Keys[idx], key) < 0 {
 		idx++
 	}
 	return idx
@@ -687,9 +1067,11 @@ func (t *BTree) Delete(key string) bool {
 }
 
 func (t *BTree) delete(node *BTreeNode, key string) {
-	idx := node.findKey(key)
+	defer node.recount()
 
-	if idx < node.n && node.Keys[idx] == key {
+	idx := node.findKey(key, t.compare)
+
+	if idx < node.n && t.compare(node.Keys[idx], key) == 0 {
 		if node.Leaf {
 			//  node is leaf(remove the key)
 			for i := idx + 1; i < node.n; i++ {
@@ -796,6 +1178,9 @@ func (t *BTree) borrowFromPrev(node *BTreeNode, idx int) {
 
 	child.n++
 	sibling.n--
+
+	child.recount()
+	sibling.recount()
 }
 
 func (t *BTree) borrowFromNext(node *BTreeNode, idx int) {
@@ -825,6 +1210,9 @@ func (t *BTree) borrowFromNext(node *BTreeNode, idx int) {
 
 	child.n++
 	sibling.n--
+
+	child.recount()
+	sibling.recount()
 }
 
 func (t *BTree) merge(node *BTreeNode, idx int) {
@@ -856,105 +1244,271 @@ func (t *BTree) merge(node *BTreeNode, idx int) {
 
 	child.n += sibling.n + 1
 	node.n--
+
+	child.recount()
 }
 
-type TreeCollection struct {
-	TreeType TreeType
-	AVL      *AVLTree
-	RB       *RedBlackTree
-	BT       *BTree
+func (t *BTree) put(key string, value string) error {
+	t.Insert(key, value)
+	return nil
 }
 
-func NewTreeCollection(treeType TreeType) *TreeCollection {
-	tc := &TreeCollection{TreeType: treeType}
-	switch treeType {
-	case TreeTypeAVL:
-		tc.AVL = NewAVLTree()
-	case TreeTypeRedBlack:
-		tc.RB = NewRedBlackTree()
-	case TreeTypeBTree:
-		tc.BT = NewBTree(3)
+func (t *BTree) get(key string) (string, bool, error) {
+	value, found := t.Search(key)
+	return value, found, nil
+}
+
+func (t *BTree) remove(key string) (bool, error) {
+	if _, found := t.Search(key); !found {
+		return false, nil
 	}
-	return tc
+	return t.Delete(key), nil
+}
+
+func (t *BTree) rangeInto(leftBound string, rightBound string, result *map[string]string) error {
+	t.Root.searchRange(leftBound, rightBound, t.compare, result)
+	return nil
 }
 
-func (tc *TreeCollection) Set(key string, secondaryKey string, value string) string {
-	switch tc.TreeType {
-	case TreeTypeAVL:
-		tc.AVL.Root = tc.AVL.insert(tc.AVL.Root, key, value)
-		return "ok"
-	case TreeTypeRedBlack:
-		tc.RB.insert(key, value)
-		return "ok"
-	case TreeTypeBTree:
-		tc.BT.Insert(key, value)
-		return "ok"
+func (t *BTree) cursor() (treeCursor, error) {
+	return &btreeCursor{
+		compare: t.compare,
+		root: func() (btreeCursorNode, error) {
+			return t.Root, nil
+		},
+		child: func(node btreeCursorNode, i int) (btreeCursorNode, error) {
+			return node.(*BTreeNode).Children[i], nil
+		},
+		entry: func(node btreeCursorNode, i int) (string, error) {
+			return node.(*BTreeNode).Values[i], nil
+		},
+	}, nil
+}
+
+func (node *BTreeNode) size() int          { return node.n }
+func (node *BTreeNode) keyAt(i int) string { return node.Keys[i] }
+func (node *BTreeNode) isLeaf() bool       { return node.Leaf }
+
+func (t *BTree) count() int {
+	return t.Root.count
+}
+
+// rank adds up, level by level, the keys before the descent point and the
+// subtrees to their left.
+func (t *BTree) rank(key string, inclusive bool) int {
+	rank := 0
+	node := t.Root
+	for {
+		i := 0
+		for i < node.n {
+			if cmp := t.compare(node.Keys[i], key); cmp > 0 || (!inclusive && cmp == 0) {
+				break
+			}
+			if !node.Leaf {
+				rank += node.Children[i].count
+			}
+			rank++
+			i++
+		}
+		if node.Leaf {
+			return rank
+		}
+		node = node.Children[i]
 	}
-	return "error: unknown tree type"
 }
 
-func (tc *TreeCollection) Update(key string, value string) string {
-	return tc.Set(key, key, value)
+func (t *BTree) selectKth(k int) (string, string, bool) {
+	if k < 0 || k >= t.Root.count {
+		return "", "", false
+	}
+
+	node := t.Root
+	for {
+		i := 0
+		for ; i < node.n; i++ {
+			if !node.Leaf {
+				c := node.Children[i].count
+				if k < c {
+					break
+				}
+				k -= c
+			}
+			if k == 0 {
+				return node.Keys[i], node.Values[i], true
+			}
+			k--
+		}
+		node = node.Children[i]
+	}
+}
+
+// btreeCursorNode is the view of a B-tree node that btreeCursor needs, so
+// the in-memory and paged B-trees can share it.
+type btreeCursorNode interface {
+	size() int
+	keyAt(i int) string
+	isLeaf() bool
+}
+
+type btreeFrame struct {
+	node btreeCursorNode
+	i    int
+}
+
+// btreeCursor keeps a stack of frames from the root down. The top frame is
+// the current entry; in every frame below it, i is the child that was
+// descended into, so key i is the next entry after that subtree and key
+// i-1 the one before it.
+type btreeCursor struct {
+	compare compareFunc
+	root    func() (btreeCursorNode, error)
+	child   func(node btreeCursorNode, i int) (btreeCursorNode, error)
+	entry   func(node btreeCursorNode, i int) (string, error)
+	stack   []btreeFrame
+	k, v    string
+}
+
+func (c *btreeCursor) push(node btreeCursorNode, i int) {
+	c.stack = append(c.stack, btreeFrame{node: node, i: i})
+}
+
+func (c *btreeCursor) top() *btreeFrame {
+	return &c.stack[len(c.stack)-1]
+}
+
+// load reads the entry under the top frame.
+func (c *btreeCursor) load() error {
+	if !c.valid() {
+		return nil
+	}
+	top := c.top()
+	c.k = top.node.keyAt(top.i)
+	value, err := c.entry(top.node, top.i)
+	if err != nil {
+		c.stack = c.stack[:0]
+		return err
+	}
+	c.v = value
+	return nil
 }
 
-func (tc *TreeCollection) Get(key string) (string, string) {
-	switch tc.TreeType {
-	case TreeTypeAVL:
-		node := tc.AVL.search(tc.AVL.Root, key)
-		if node == nil {
-			return "", "error: not found"
+// descend pushes the path from node to its first (or last) entry.
+func (c *btreeCursor) descend(node btreeCursorNode, first bool) error {
+	var err error
+	for {
+		i := 0
+		if !first {
+			i = node.size()
 		}
-		return node.Value, "ok"
-	case TreeTypeRedBlack:
-		node := tc.RB.search(key)
-		if node == nil {
-			return "", "error: not found"
+		if node.isLeaf() {
+			if !first {
+				i--
+			}
+			c.push(node, i)
+			return nil
 		}
-		return node.Value, "ok"
-	case TreeTypeBTree:
-		if value, found := tc.BT.Search(key); found {
-			return value, "ok"
+		c.push(node, i)
+		if node, err = c.child(node, i); err != nil {
+			return err
 		}
-		return "", "error: not found"
 	}
-	return "", "error: unknown tree type"
 }
 
-func (tc *TreeCollection) GetRange(leftBound string, rightBound string) (*map[string]string, string) {
-	result := make(map[string]string)
-	switch tc.TreeType {
-	case TreeTypeAVL:
-		tc.AVL.searchRange(tc.AVL.Root, leftBound, rightBound, &result)
-		return &result, "ok"
-	case TreeTypeRedBlack:
-		tc.RB.searchRange(leftBound, rightBound, &result)
-		return &result, "ok"
-	case TreeTypeBTree:
-		tc.BT.Root.searchRange(leftBound, rightBound, &result)
-		return &result, "ok"
+// climb pops frames until one has an entry at its index.
+func (c *btreeCursor) climb() {
+	for c.valid() && c.top().i >= c.top().node.size() {
+		c.stack = c.stack[:len(c.stack)-1]
 	}
-	return &result, "error: unknown tree type"
 }
 
-func (tc *TreeCollection) Delete(key string) string {
-	switch tc.TreeType {
-	case TreeTypeAVL:
-		node := tc.AVL.search(tc.AVL.Root, key)
-		if node == nil {
-			return "error: not found"
+func (c *btreeCursor) seek(key string) error {
+	c.stack = c.stack[:0]
+	node, err := c.root()
+	for err == nil {
+		i := 0
+		for i < node.size() && c.compare(node.keyAt(i), key) < 0 {
+			i++
 		}
-		tc.AVL.Root = tc.AVL.delete(tc.AVL.Root, key)
-		return "ok"
-	case TreeTypeRedBlack:
-		if tc.RB.delete(key) {
-			return "ok"
+		c.push(node, i)
+		if (i < node.size() && c.compare(node.keyAt(i), key) == 0) || node.isLeaf() {
+			break
 		}
-		return "error: not found"
-	case TreeTypeBTree:
-		if tc.BT.Delete(key) {
-			return "ok"
+		node, err = c.child(node, i)
+	}
+	if err != nil {
+		c.stack = c.stack[:0]
+		return err
+	}
+
+	c.climb()
+	return c.load()
+}
+
+func (c *btreeCursor) last() error {
+	c.stack = c.stack[:0]
+	root, err := c.root()
+	if err == nil {
+		err = c.descend(root, false)
+	}
+	if err != nil || c.top().i < 0 {
+		c.stack = c.stack[:0]
+		return err
+	}
+	return c.load()
+}
+
+func (c *btreeCursor) next() error {
+	if !c.valid() {
+		return nil
+	}
+
+	top := c.top()
+	top.i++
+	if !top.node.isLeaf() {
+		child, err := c.child(top.node, top.i)
+		if err == nil {
+			err = c.descend(child, true)
 		}
-		return "error: not found"
+		if err != nil {
+			c.stack = c.stack[:0]
+			return err
+		}
+		return c.load()
+	}
+
+	c.climb()
+	return c.load()
+}
+
+func (c *btreeCursor) prev() error {
+	if !c.valid() {
+		return nil
+	}
+
+	top := c.top()
+	if !top.node.isLeaf() {
+		child, err := c.child(top.node, top.i)
+		if err == nil {
+			err = c.descend(child, false)
+		}
+		if err != nil {
+			c.stack = c.stack[:0]
+			return err
+		}
+		return c.load()
 	}
-	return "error: unknown tree type"
+
+	top.i--
+	for c.top().i < 0 {
+		c.stack = c.stack[:len(c.stack)-1]
+		if !c.valid() {
+			return nil
+		}
+		c.top().i--
+	}
+	return c.load()
 }
+
+func (c *btreeCursor) valid() bool   { return len(c.stack) > 0 }
+func (c *btreeCursor) key() string   { return c.k }
+func (c *btreeCursor) value() string { return c.v }
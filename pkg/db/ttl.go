@@ -0,0 +1,433 @@
+package db
+
+import (
+	"DB_II/pkg/interfaces"
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"hash/crc32"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	expiryLogFile = "expiry.log"
+
+	// The sweeper wakes up every expirySweepInterval and deletes expired
+	// keys in batches of expirySweepBatch, releasing the collection's lock
+	// between batches.
+	expirySweepInterval = time.Second
+	expirySweepBatch    = 128
+)
+
+var ErrInvalidTTL = NewError(CodeInvalidArgument, "time to live must be positive")
+
+var _ interfaces.ExpiringCollection = (*TreeCollection)(nil)
+
+type expiryItem struct {
+	deadline int64
+	key      string
+}
+
+// expiryQueue is a min-heap of deadlines. Entries are not removed when a
+// deadline changes; the sweeper skips those that no longer match.
+type expiryQueue []expiryItem
+
+func (q expiryQueue) Len() int            { return len(q) }
+func (q expiryQueue) Less(i, j int) bool  { return q[i].deadline < q[j].deadline }
+func (q expiryQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *expiryQueue) Push(x interface{}) { *q = append(*q, x.(expiryItem)) }
+func (q *expiryQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// expiryTable holds the deadlines of a collection's keys as Unix
+// nanoseconds in a tree ordered like the collection, so that keys its
+// comparator considers equal share a deadline. Disk-backed collections
+// also append every change to an expiry log, which is compacted when the
+// collection is opened. The collection's lock guards the table.
+type expiryTable struct {
+	deadlines *AVLTree
+	queue     expiryQueue
+	file      *os.File
+	writer    *bufio.Writer
+}
+
+func openExpiryTable(compare compareFunc, dir string, persistent bool) (*expiryTable, error) {
+	t := &expiryTable{deadlines: NewAVLTree()}
+	t.deadlines.compare = compare
+	if !persistent {
+		return t, nil
+	}
+
+	path := filepath.Join(dir, expiryLogFile)
+	if err := t.replay(path); err != nil {
+		return nil, err
+	}
+
+	// rewrite the log with only the deadlines still set
+	var compacted []byte
+	cursor, _ := t.deadlines.cursor()
+	for cursor.seek(""); cursor.valid(); cursor.next() {
+		deadline, _ := strconv.ParseInt(cursor.value(), 10, 64)
+		compacted = appendExpiryRecord(compacted, cursor.key(), deadline)
+	}
+	if err := writeFileAtomic(path, compacted); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	t.file = file
+	t.writer = bufio.NewWriter(file)
+	return t, nil
+}
+
+// Expiry log records are framed like write-ahead log records: a CRC-32
+// and length, then the key and the deadline, where 0 clears it.
+func appendExpiryRecord(buf []byte, key string, deadline int64) []byte {
+	var payload []byte
+	payload = binary.AppendUvarint(payload, uint64(len(key)))
+	payload = append(payload, key...)
+	payload = binary.AppendVarint(payload, deadline)
+
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(payload))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(payload)))
+	return append(buf, payload...)
+}
+
+// replay loads the log, stopping at the first torn or corrupt record.
+func (t *expiryTable) replay(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	good := 0
+	for len(data)-good >= 8 {
+		checksum := binary.LittleEndian.Uint32(data[good:])
+		length := int(binary.LittleEndian.Uint32(data[good+4:]))
+		if len(data)-good-8 < length {
+			break
+		}
+		payload := data[good+8 : good+8+length]
+		if crc32.ChecksumIEEE(payload) != checksum {
+			break
+		}
+
+		r := &byteReader{buf: payload}
+		key := r.string()
+		deadline, n := binary.Varint(r.buf)
+		if r.err != nil || n <= 0 {
+			break
+		}
+		t.apply(key, deadline)
+		good += 8 + length
+	}
+
+	if good < len(data) {
+		log.Printf("expiry log %s: discarding %d bytes of torn records", path, len(data)-good)
+	}
+	return nil
+}
+
+func (t *expiryTable) apply(key string, deadline int64) {
+	if deadline == 0 {
+		t.deadlines.remove(key)
+		return
+	}
+	t.deadlines.put(key, strconv.FormatInt(deadline, 10))
+	heap.Push(&t.queue, expiryItem{deadline: deadline, key: key})
+}
+
+// set records a deadline for key, or clears it if deadline is 0. Leaving
+// the deadline as it was writes nothing.
+func (t *expiryTable) set(key string, deadline int64) error {
+	if current, _ := t.deadline(key); current == deadline {
+		return nil
+	}
+	if t.writer != nil {
+		if _, err := t.writer.Write(appendExpiryRecord(nil, key, deadline)); err != nil {
+			return err
+		}
+		if err := t.writer.Flush(); err != nil {
+			return err
+		}
+	}
+	t.apply(key, deadline)
+	return nil
+}
+
+func (t *expiryTable) deadline(key string) (int64, bool) {
+	if t.deadlines.Root == nil {
+		return 0, false
+	}
+	raw, found, _ := t.deadlines.get(key)
+	if !found {
+		return 0, false
+	}
+	deadline, _ := strconv.ParseInt(raw, 10, 64)
+	return deadline, true
+}
+
+func (t *expiryTable) expired(key string, now time.Time) bool {
+	deadline, found := t.deadline(key)
+	return found && deadline <= now.UnixNano()
+}
+
+// popExpired returns a key whose deadline has passed, skipping queue
+// entries for deadlines that have since changed.
+func (t *expiryTable) popExpired(now time.Time) (string, bool) {
+	for len(t.queue) > 0 && t.queue[0].deadline <= now.UnixNano() {
+		item := heap.Pop(&t.queue).(expiryItem)
+		if deadline, found := t.deadline(item.key); found && deadline == item.deadline {
+			return item.key, true
+		}
+	}
+	return "", false
+}
+
+func (t *expiryTable) close() error {
+	if t.file == nil {
+		return nil
+	}
+	if err := t.writer.Flush(); err != nil {
+		t.file.Close()
+		return err
+	}
+	return t.file.Close()
+}
+
+// expirySweeper deletes a collection's expired keys in the background.
+type expirySweeper struct {
+	start sync.Once
+	stop  sync.Once
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// startSweeper starts the sweeper the first time a deadline is set.
+func (tc *TreeCollection) startSweeper() {
+	tc.sweeper.start.Do(func() {
+		tc.sweeper.wg.Add(1)
+		go tc.sweepLoop()
+	})
+}
+
+// stopSweeper stops the sweeper for good. The caller must not hold the
+// collection's lock, which the sweeper may be waiting for.
+func (tc *TreeCollection) stopSweeper() {
+	tc.sweeper.start.Do(func() {})
+	tc.sweeper.stop.Do(func() { close(tc.sweeper.done) })
+	tc.sweeper.wg.Wait()
+}
+
+func (tc *TreeCollection) sweepLoop() {
+	defer tc.sweeper.wg.Done()
+
+	ticker := time.NewTicker(expirySweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-tc.sweeper.done:
+			return
+		case <-ticker.C:
+		}
+
+		for tc.sweepOnce() {
+			select {
+			case <-tc.sweeper.done:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// sweepOnce deletes up to a batch of expired keys under one write lock and
+// reports whether more may be waiting.
+func (tc *TreeCollection) sweepOnce() bool {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	now := tc.clock.now()
+	for n := 0; n < expirySweepBatch; n++ {
+		key, ok := tc.expiry.popExpired(now)
+		if !ok {
+			return false
+		}
+		if err := tc.removeExpired(key); err != nil {
+			log.Printf("collection: expiring %q failed: %v", key, err)
+			return false
+		}
+	}
+	return true
+}
+
+// removeExpired deletes an expired key through the tree like any other
+// delete, so disk-backed trees log it and its history records it. The
+// caller holds the write lock.
+func (tc *TreeCollection) removeExpired(key string) error {
+	storedKey, oldValue := key, ""
+	if len(tc.indexes) > 0 {
+		var err error
+		if storedKey, oldValue, _, err = tc.stored(key); err != nil {
+			return err
+		}
+	}
+	found, err := tc.tree.remove(key)
+	if err != nil {
+		return err
+	}
+	tc.reindex(storedKey, oldValue, "")
+
+	// the key's history shows it deleted when it expired, not when it was
+	// noticed
+	if deadline, ok := tc.expiry.deadline(key); ok && found {
+		if err := tc.versions.record(key, "", true, deadline); err != nil {
+			return err
+		}
+	}
+	if err := tc.expiry.set(key, 0); err != nil {
+		return err
+	}
+	if found {
+		tc.changes.record(Mutation{Op: MutationDelete, Key: key})
+	}
+	return nil
+}
+
+// expireKeys deletes those of keys that a reader found expired, unless
+// they were written again in the meantime.
+func (tc *TreeCollection) expireKeys(keys []string) error {
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	now := tc.clock.now()
+	for _, key := range keys {
+		if tc.expiry.expired(key, now) {
+			if err := tc.removeExpired(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// expireAll deletes every key whose deadline has passed. The caller holds
+// the write lock.
+func (tc *TreeCollection) expireAll() error {
+	now := tc.clock.now()
+	for {
+		key, ok := tc.expiry.popExpired(now)
+		if !ok {
+			return nil
+		}
+		if err := tc.removeExpired(key); err != nil {
+			return err
+		}
+	}
+}
+
+// SetWithTTL sets key like Set and deletes it once ttl has passed.
+func (tc *TreeCollection) SetWithTTL(key string, secondaryKey string, value string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	return tc.set(key, value, ttl)
+}
+
+// Expire gives an existing key a new time to live.
+func (tc *TreeCollection) Expire(key string, ttl time.Duration) error {
+	if err := tc.config.validateKey(key); err != nil {
+		return err
+	}
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	if err := tc.checkLive(key); err != nil {
+		return err
+	}
+	deadline := tc.clock.now().Add(ttl).UnixNano()
+	if err := tc.expiry.set(key, deadline); err != nil {
+		return err
+	}
+	tc.startSweeper()
+	tc.changes.record(Mutation{Op: MutationExpire, Key: key, Deadline: deadline})
+	return nil
+}
+
+// TTL returns how long key has left to live, and false if it never
+// expires.
+func (tc *TreeCollection) TTL(key string) (time.Duration, bool, error) {
+	if err := tc.config.validateKey(key); err != nil {
+		return 0, false, err
+	}
+
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	if err := tc.checkLive(key); err != nil {
+		return 0, false, err
+	}
+	deadline, found := tc.expiry.deadline(key)
+	if !found {
+		return 0, false, nil
+	}
+	return time.Until(time.Unix(0, deadline)), true, nil
+}
+
+// Persist removes the time to live of an existing key.
+func (tc *TreeCollection) Persist(key string) error {
+	if err := tc.config.validateKey(key); err != nil {
+		return err
+	}
+
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+
+	if err := tc.checkLive(key); err != nil {
+		return err
+	}
+	if err := tc.expiry.set(key, 0); err != nil {
+		return err
+	}
+	tc.changes.record(Mutation{Op: MutationExpire, Key: key})
+	return nil
+}
+
+// checkLive returns ErrKeyNotFound unless key is present and unexpired,
+// deleting it if it has expired. The caller holds the write lock.
+func (tc *TreeCollection) checkLive(key string) error {
+	if tc.expiry.expired(key, tc.clock.now()) {
+		if err := tc.removeExpired(key); err != nil {
+			return err
+		}
+		return ErrKeyNotFound
+	}
+
+	_, found, err := tc.tree.get(key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrKeyNotFound
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestExpiredKeysAreGone(t *testing.T) {
+	for _, treeType := range []TreeType{TreeTypeAVL, TreeTypeLSM, TreeTypePagedBTree} {
+		tc := newTestCollection(t, treeType, CollectionOptions{}, t.TempDir())
+		// writes stamped an hour ago: a minute's TTL has passed, two hours'
+		// have not
+		tc.clock.set(time.Now().Add(-time.Hour))
+		if err := tc.SetWithTTL("a", "a", "1", time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		if err := tc.SetWithTTL("b", "b", "2", 2*time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if err := tc.Set("c", "c", "3"); err != nil {
+			t.Fatal(err)
+		}
+		tc.clock.set(time.Now())
+
+		if _, err := tc.Get("a"); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("%s: expired a is still found: %v", treeType, err)
+		}
+		contents, err := tc.GetRange("", "\xff")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*contents) != 2 || (*contents)["b"] != "2" || (*contents)["c"] != "3" {
+			t.Errorf("%s: collection holds %v, want b and c", treeType, *contents)
+		}
+		if ttl, expires, err := tc.TTL("b"); err != nil || !expires || ttl <= 59*time.Minute || ttl > time.Hour {
+			t.Errorf("%s: b has %v left, %v, %v, want about an hour", treeType, ttl, expires, err)
+		}
+		if _, _, err := tc.TTL("a"); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("%s: TTL of expired a returned %v", treeType, err)
+		}
+		// a key written again after expiring starts afresh
+		if err := tc.Set("a", "a", "again"); err != nil {
+			t.Fatal(err)
+		}
+		if _, expires, err := tc.TTL("a"); err != nil || expires {
+			t.Errorf("%s: rewritten a still expires: %v", treeType, err)
+		}
+	}
+}
+
+func TestExpireAndPersist(t *testing.T) {
+	tc := newTestCollection(t, TreeTypeAVL, CollectionOptions{}, "")
+	if err := tc.Set("k", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if _, expires, err := tc.TTL("k"); err != nil || expires {
+		t.Fatalf("a plain key expires: %v", err)
+	}
+	if err := tc.Expire("k", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, expires, err := tc.TTL("k"); err != nil || !expires || ttl <= 59*time.Minute {
+		t.Fatalf("k has %v left, %v, %v, want about an hour", ttl, expires, err)
+	}
+	// writing a key again keeps no earlier time to live
+	if err := tc.Update("k", "w"); err != nil {
+		t.Fatal(err)
+	}
+	if _, expires, err := tc.TTL("k"); err != nil || expires {
+		t.Fatalf("an updated key still expires: %v", err)
+	}
+	if err := tc.Expire("k", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Persist("k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, expires, err := tc.TTL("k"); err != nil || expires {
+		t.Fatalf("a persisted key still expires: %v", err)
+	}
+
+	if err := tc.Expire("missing", time.Hour); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expire of a missing key returned %v", err)
+	}
+	if err := tc.Persist("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Persist of a missing key returned %v", err)
+	}
+	if err := tc.Expire("k", 0); !errors.Is(err, ErrInvalidTTL) {
+		t.Errorf("Expire with no time to live returned %v", err)
+	}
+	if err := tc.SetWithTTL("k", "k", "v", -time.Second); !errors.Is(err, ErrInvalidTTL) {
+		t.Errorf("SetWithTTL with a negative time to live returned %v", err)
+	}
+}
+
+func TestSweeperDeletesExpiredKeys(t *testing.T) {
+	tc := newTestCollection(t, TreeTypeAVL, CollectionOptions{HistoryVersions: 2}, "")
+	start := time.Now()
+	tc.clock.set(start)
+	n := 2*expirySweepBatch + 10
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("k%03d", i)
+		if err := tc.SetWithTTL(key, key, "v", time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tc.Set("stays", "stays", "v"); err != nil {
+		t.Fatal(err)
+	}
+
+	tc.clock.set(start.Add(time.Hour))
+	batches := 1
+	for tc.sweepOnce() {
+		batches++
+	}
+	if batches != 3 {
+		t.Errorf("sweeping took %d batches, want 3", batches)
+	}
+	if count := tc.tree.(orderStatistics).count(); count != 1 {
+		t.Errorf("tree holds %d keys after sweeping, want 1", count)
+	}
+	// expiry is recorded in the key's history like a delete
+	if history, err := tc.GetHistory("k000"); err != nil || len(history) != 2 || !history[1].Deleted {
+		t.Errorf("k000 has history %v, %v, want a write and a deletion", history, err)
+	}
+}
+
+func TestDeadlinesSurviveReopening(t *testing.T) {
+	dir := t.TempDir()
+	tc, err := NewTreeCollection(TreeTypeLSM, CollectionOptions{}, DefaultConfig(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.SetWithTTL("a", "a", "1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.SetWithTTL("b", "b", "2", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Persist("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tc = newTestCollection(t, TreeTypeLSM, CollectionOptions{}, dir)
+	if ttl, expires, err := tc.TTL("a"); err != nil || !expires || ttl <= 59*time.Minute {
+		t.Errorf("a has %v left, %v, %v after reopening, want about an hour", ttl, expires, err)
+	}
+	if _, expires, err := tc.TTL("b"); err != nil || expires {
+		t.Errorf("persisted b expires after reopening: %v", err)
+	}
+}
+
+func TestIteratorSkipsExpiredKeys(t *testing.T) {
+	tc := newTestCollection(t, TreeTypeAVL, CollectionOptions{}, "")
+	for i := 1; i <= 5; i++ {
+		key := fmt.Sprint(i)
+		if err := tc.Set(key, key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// deadlines set an hour ago have passed, although the sweeper has not
+	// yet deleted the keys
+	tc.clock.set(time.Now().Add(-time.Hour))
+	for _, key := range []string{"1", "3", "5"} {
+		if err := tc.Expire(key, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	it, err := tc.Iterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	if got := fmt.Sprint(walk(it, it.Seek(""), it.Next)); got != "[2 4]" {
+		t.Errorf("forwards visits %s", got)
+	}
+	if got := fmt.Sprint(walk(it, it.SeekLast(), it.Prev)); got != "[4 2]" {
+		t.Errorf("backwards visits %s", got)
+	}
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// ValueType is the type every value of a collection must have. The empty
+// type accepts any string.
+type ValueType string
+
+const (
+	ValueTypeAny       ValueType = ""
+	ValueTypeInt       ValueType = "int"
+	ValueTypeFloat     ValueType = "float"
+	ValueTypeBool      ValueType = "bool"
+	ValueTypeTimestamp ValueType = "timestamp"
+	ValueTypeBytes     ValueType = "bytes"
+	ValueTypeJSON      ValueType = "json"
+)
+
+var ErrInvalidValue = NewError(CodeInvalidArgument, "value does not match the collection's value type")
+
+var valueTypes = []ValueType{ValueTypeInt, ValueTypeFloat, ValueTypeBool, ValueTypeTimestamp, ValueTypeBytes, ValueTypeJSON}
+
+func (t ValueType) validate() error {
+	if t == ValueTypeAny {
+		return nil
+	}
+	for _, known := range valueTypes {
+		if t == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unknown value type %q (expected one of %v)", ErrInvalidOptions, string(t), valueTypes)
+}
+
+// check reports whether value is written as the type: a base-10 int64, a
+// finite float64, true or false, an RFC 3339 timestamp, standard base64,
+// or a JSON object holding every required field. Required fields are
+// field paths, so they may name nested fields such as address.city.
+func (t ValueType) check(value string, required []string) error {
+	var err error
+	switch t {
+	case ValueTypeAny:
+		return nil
+	case ValueTypeInt:
+		_, err = strconv.ParseInt(value, 10, 64)
+	case ValueTypeFloat:
+		var f float64
+		if f, err = strconv.ParseFloat(value, 64); err == nil && (math.IsInf(f, 0) || math.IsNaN(f)) {
+			err = fmt.Errorf("not a finite number")
+		}
+	case ValueTypeBool:
+		_, err = strconv.ParseBool(value)
+	case ValueTypeTimestamp:
+		_, err = time.Parse(time.RFC3339Nano, value)
+	case ValueTypeBytes:
+		_, err = base64.StdEncoding.DecodeString(value)
+	case ValueTypeJSON:
+		return checkDocument(value, required)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: expected %s", ErrInvalidValue, t)
+	}
+	return nil
+}
+
+func checkDocument(value string, required []string) error {
+	document, err := parseDocument(value)
+	if err != nil {
+		return err
+	}
+
+	for _, raw := range required {
+		path, err := parseFieldPath(raw)
+		if err != nil {
+			return err
+		}
+		if _, found := path.lookup(document); !found {
+			return fmt.Errorf("%w: missing required field %s", ErrInvalidValue, path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package db
+
+import (
+	"DB_II/pkg/interfaces"
+	"errors"
+	"testing"
+)
+
+func TestValueTypeCheck(t *testing.T) {
+	tests := []struct {
+		valueType ValueType
+		valid     []string
+		invalid   []string
+	}{
+		{ValueTypeAny, []string{"", "anything"}, nil},
+		{ValueTypeInt, []string{"0", "-42", "9223372036854775807"}, []string{"", "1.5", "9223372036854775808", "0x10", " 1"}},
+		{ValueTypeFloat, []string{"0", "-1.5", "1e300"}, []string{"", "NaN", "Inf", "1e400", "one"}},
+		{ValueTypeBool, []string{"true", "false"}, []string{"", "yes", "maybe"}},
+		{ValueTypeTimestamp, []string{"2024-02-29T12:00:00Z", "2024-02-29T12:00:00.5+02:00"}, []string{"", "2024-02-29", "yesterday"}},
+		{ValueTypeBytes, []string{"", "aGVsbG8="}, []string{"aGVsbG8", "not base64!"}},
+		{ValueTypeJSON, []string{`{}`, `{"a":1}`}, []string{"", `[1]`, `"a"`, `{"a":`}},
+	}
+	for _, test := range tests {
+		for _, value := range test.valid {
+			if err := test.valueType.check(value, nil); err != nil {
+				t.Errorf("%s rejects %q: %v", test.valueType, value, err)
+			}
+		}
+		for _, value := range test.invalid {
+			if err := test.valueType.check(value, nil); CodeOf(err) != CodeInvalidArgument {
+				t.Errorf("%s accepts %q: %v", test.valueType, value, err)
+			}
+		}
+	}
+
+	if err := ValueType("decimal").validate(); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("an unknown value type returned %v, want ErrInvalidOptions", err)
+	}
+}
+
+func TestTypedCollectionRejectsOtherValues(t *testing.T) {
+	tc := newTestCollection(t, TreeTypeAVL, CollectionOptions{ValueType: ValueTypeInt}, "")
+	if err := tc.Set("a", "a", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Set("a", "a", "one"); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("Set returned %v, want ErrInvalidValue", err)
+	}
+	if err := tc.Update("b", "2.5"); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("Update returned %v, want ErrInvalidValue", err)
+	}
+	results, err := tc.MultiSet([]interfaces.Entry{{Key: "c", Value: "3"}, {Key: "d", Value: "four"}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Code != "" || results[1].Code != string(CodeInvalidArgument) {
+		t.Errorf("MultiSet results are %+v", results)
+	}
+
+	contents, err := tc.GetRange("", "\xff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*contents) != 2 || (*contents)["a"] != "1" || (*contents)["c"] != "3" {
+		t.Errorf("collection holds %v, want a=1 and c=3", *contents)
+	}
+}
+
+func TestRequiredFields(t *testing.T) {
+	tc := newTestCollection(t, TreeTypeAVL, CollectionOptions{ValueType: ValueTypeJSON, RequiredFields: []string{"name", "address.city"}}, "")
+	if err := tc.Set("a", "a", `{"name":"Ann","address":{"city":"Oslo"}}`); err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{`{"name":"Ann"}`, `{"name":"Ann","address":"Oslo"}`, `{"address":{"city":"Oslo"}}`} {
+		if err := tc.Set("b", "b", value); !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("Set(%s) returned %v, want ErrInvalidValue", value, err)
+		}
+	}
+
+	options := CollectionOptions{RequiredFields: []string{"name"}}
+	if err := options.normalize(TreeTypeAVL); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("required fields without json values returned %v", err)
+	}
+}
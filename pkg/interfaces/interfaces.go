@@ -1,9 +1,170 @@
 package interfaces
 
+import "time"
+
+// CollectionInterface is what every collection supports. A collection may
+// offer more through the interfaces that follow it, which callers check
+// for with a type assertion.
 type CollectionInterface interface {
-	Set(key string, secondaryKey string, value string) string
-	Update(key string, value string) string
-	Get(key string) (string, string)
-	GetRange(leftBound string, rightBound string) (*map[string]string, string)
-	Delete(key string) string
+	Set(key string, secondaryKey string, value string) error
+	Update(key string, value string) error
+	Get(key string) (string, error)
+	GetRange(leftBound string, rightBound string) (*map[string]string, error)
+	GetRangePage(query RangeQuery) (*RangePage, error)
+	ScanPrefix(prefix string, limit int, token string) (*RangePage, error)
+	ScanMatch(query MatchQuery) (*RangePage, error)
+	Delete(key string) error
+	Iterator() (Iterator, error)
+}
+
+// OrderStatisticCollection answers queries by position in key order.
+type OrderStatisticCollection interface {
+	Count(leftBound string, rightBound string) (int, error)
+	Rank(key string) (int, error)
+	Select(k int) (Entry, error)
+	Min() (Entry, error)
+	Max() (Entry, error)
+}
+
+// DocumentCollection reads and patches fields of JSON document values.
+type DocumentCollection interface {
+	GetField(key string, path string) (string, error)
+	Patch(key string, patch string) error
+}
+
+// ExpiringCollection gives keys a time to live.
+type ExpiringCollection interface {
+	SetWithTTL(key string, secondaryKey string, value string, ttl time.Duration) error
+	Expire(key string, ttl time.Duration) error
+	TTL(key string) (time.Duration, bool, error)
+	Persist(key string) error
+}
+
+// HistoryCollection reads the versions a key has held.
+type HistoryCollection interface {
+	GetVersion(key string, version int64) (Version, error)
+	GetHistory(key string) ([]Version, error)
+	GetAsOf(key string, at time.Time) (Version, error)
+}
+
+// ConditionalCollection writes a key only if its value or version is as
+// expected.
+type ConditionalCollection interface {
+	GetVersioned(key string) (string, int64, error)
+	SetIfAbsent(key string, value string) (int64, error)
+	UpdateIfEquals(key string, expected string, value string) (int64, error)
+	UpdateIfVersion(key string, version int64, value string) (int64, error)
+	DeleteIfEquals(key string, expected string) error
+}
+
+// CounterCollection adds to numeric values in place.
+type CounterCollection interface {
+	IncrBy(key string, delta int64, bounds IntBounds) (int64, error)
+	IncrByFloat(key string, delta float64, bounds FloatBounds) (float64, error)
+}
+
+// BatchCollection reads and writes many keys in one call.
+type BatchCollection interface {
+	MultiGet(keys []string) ([]BatchResult, error)
+	MultiSet(entries []Entry, atomic bool) ([]BatchResult, error)
+	MultiDelete(keys []string, atomic bool) ([]BatchResult, error)
+}
+
+// Iterator walks a collection in key order. Seek, SeekLast, Next and Prev
+// report whether the iterator is positioned on an entry afterwards; once it
+// has moved past either end it stays invalid until the next seek. Err
+// returns the first error encountered, which also ends the iteration.
+// Close must always be called and releases whatever the iterator holds.
+type Iterator interface {
+	Seek(key string) bool
+	SeekLast() bool
+	Next() bool
+	Prev() bool
+	Key() string
+	Value() string
+	Err() error
+	Close() error
+}
+
+type Entry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Version is one value a key has held, numbered from 1 in the order they
+// were written. A deleted version marks when the key was deleted or
+// expired and has no value.
+type Version struct {
+	Version int64     `json:"version"`
+	Time    time.Time `json:"time"`
+	Value   string    `json:"value"`
+	Deleted bool      `json:"deleted"`
+}
+
+// BatchResult is the outcome of one item of a batch, in the batch's order.
+// Value is only set by MultiGet. Code and Error are empty if the item
+// succeeded.
+type BatchResult struct {
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+	Code  string `json:"code,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// IntBounds and FloatBounds clamp the result of an increment. A nil bound
+// leaves that side open.
+type IntBounds struct {
+	Min *int64
+	Max *int64
+}
+
+type FloatBounds struct {
+	Min *float64
+	Max *float64
+}
+
+// RangeQuery selects keys between two bounds. Bounds are inclusive unless
+// marked exclusive, and an empty RightBound leaves the range open upwards.
+// A Limit of 0 returns every matching entry. Token continues a previous
+// query, which must otherwise be repeated unchanged. Where, which only
+// applies to document collections, keeps just the entries whose field
+// satisfies it.
+type RangeQuery struct {
+	LeftBound      string
+	RightBound     string
+	LeftExclusive  bool
+	RightExclusive bool
+	Reverse        bool
+	Limit          int
+	Token          string
+	Where          *FieldPredicate
+}
+
+// FieldPredicate compares the field at Path, such as $.address.city, with
+// Value, which is JSON. Op is eq, ne, lt, le, gt, ge, or exists, which
+// ignores Value.
+type FieldPredicate struct {
+	Path  string
+	Op    string
+	Value string
+}
+
+// RangePage holds the entries of one page in the query's order. Token is
+// empty when the range is exhausted.
+type RangePage struct {
+	Entries []Entry
+	Token   string
+}
+
+// MatchQuery selects the keys matching a glob, which must match the whole
+// key, or a regular expression, which may match anywhere in it. A scan
+// that reaches Limit matches or runs out of time returns a page with a
+// Token to continue from, even if no further keys match. A zero Timeout
+// uses the server's maximum.
+type MatchQuery struct {
+	Pattern string
+	Regex   bool
+	Limit   int
+	Timeout time.Duration
+	Token   string
 }